@@ -0,0 +1,206 @@
+package xauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+const (
+	// CodeAPIKeyMissing is the error code of requests without an API key
+	CodeAPIKeyMissing = "api_key_missing"
+	// CodeAPIKeyInvalid is the error code of unknown, revoked or expired API keys
+	CodeAPIKeyInvalid = "api_key_invalid"
+	// CodeInsufficientScope is the error code of keys or tokens lacking a
+	// required scope or permission
+	CodeInsufficientScope = "insufficient_scope"
+)
+
+// APIKey describes the owner and rights of an API key
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Scopes are the rights of the key, "*" granting every scope
+	Scopes []string `json:"scopes,omitempty"`
+	// ExpiresAt is the zero time for keys that do not expire
+	ExpiresAt time.Time         `json:"expires_at,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// HasScope reports whether the key grants scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key expired
+func (k *APIKey) Expired() bool {
+	return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+// KeyStore looks up API keys by their hash, see HashAPIKey, so stores never
+// hold usable keys. Lookup returns nil and no error for unknown keys.
+type KeyStore interface {
+	Lookup(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// KeyStoreFunc adapts a function to KeyStore, eg. a database query
+type KeyStoreFunc func(ctx context.Context, hash string) (*APIKey, error)
+
+// Lookup calls f
+func (f KeyStoreFunc) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	return f(ctx, hash)
+}
+
+// StaticKeys is a KeyStore of keys known at startup, eg. from the
+// configuration, mapped by key hash
+type StaticKeys map[string]APIKey
+
+// NewStaticKeys creates the store of keys mapped by plain key
+func NewStaticKeys(keys map[string]APIKey) StaticKeys {
+	store := make(StaticKeys, len(keys))
+	for key, apiKey := range keys {
+		store[HashAPIKey(key)] = apiKey
+	}
+	return store
+}
+
+// Lookup returns the key of hash
+func (s StaticKeys) Lookup(_ context.Context, hash string) (*APIKey, error) {
+	apiKey, ok := s[hash]
+	if !ok {
+		return nil, nil
+	}
+	return &apiKey, nil
+}
+
+// HashAPIKey returns the hash API keys are stored by. API keys being long
+// random strings, a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random key, starting with prefix and an
+// underscore when prefix is not empty, and its hash to store
+func GenerateAPIKey(prefix string) (key string, hash string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot generate the API key")
+	}
+	key = base64.RawURLEncoding.EncodeToString(random)
+	if prefix != "" {
+		key = prefix + "_" + key
+	}
+	return key, HashAPIKey(key), nil
+}
+
+// APIKeyOptions configures APIKeyMiddleware
+type APIKeyOptions struct {
+	Store KeyStore
+	// Header is the header holding the key, X-API-Key by default
+	Header string
+	// Query is the query parameter holding the key when the header is
+	// missing, unchecked when empty. Query parameters end up in access logs,
+	// prefer the header.
+	Query string
+	// Scopes are required of every key
+	Scopes []string
+}
+
+type apiKeyKey struct{}
+
+// APIKeyMiddleware authenticates the requests by API key, storing the
+// APIKey in the request context. Missing, unknown and expired keys are
+// rejected with a 401, keys lacking a scope with a 403.
+func APIKeyMiddleware(opts APIKeyOptions) func(http.Handler) http.Handler {
+	if opts.Header == "" {
+		opts.Header = "X-API-Key"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey, err := authenticateAPIKey(r, opts)
+			if err == nil {
+				err = requireScopes(apiKey.HasScope, opts.Scopes)
+			}
+			if err != nil {
+				xerrors.WriteJSON(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, apiKey)))
+		})
+	}
+}
+
+// APIKeyFrom returns the API key stored by APIKeyMiddleware
+func APIKeyFrom(ctx context.Context) (*APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyKey{}).(*APIKey)
+	return apiKey, ok
+}
+
+// RequireScopes rejects with a 403 the requests whose API key lacks one of
+// scopes, for routes needing more than APIKeyOptions.Scopes. It must run
+// after APIKeyMiddleware.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey, ok := APIKeyFrom(r.Context())
+			if !ok {
+				xerrors.WriteJSON(w, unauthorized(CodeAPIKeyMissing, "missing API key", nil))
+				return
+			}
+			if err := requireScopes(apiKey.HasScope, scopes); err != nil {
+				xerrors.WriteJSON(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func authenticateAPIKey(r *http.Request, opts APIKeyOptions) (*APIKey, error) {
+	key := strings.TrimSpace(r.Header.Get(opts.Header))
+	if key == "" && opts.Query != "" {
+		key = r.URL.Query().Get(opts.Query)
+	}
+	if key == "" {
+		return nil, unauthorized(CodeAPIKeyMissing, "missing API key", nil)
+	}
+	apiKey, err := opts.Store.Lookup(r.Context(), HashAPIKey(key))
+	if err != nil {
+		if xerrors.StatusOf(err) >= http.StatusInternalServerError {
+			return nil, err
+		}
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot look up the API key")
+	}
+	if apiKey == nil || apiKey.Expired() {
+		return nil, unauthorized(CodeAPIKeyInvalid, "invalid API key", nil)
+	}
+	return apiKey, nil
+}
+
+// requireScopes fails with a 403 unless has grants every scope
+func requireScopes(has func(scope string) bool, scopes []string) error {
+	for _, scope := range scopes {
+		if !has(scope) {
+			return xerrors.New().
+				Status(http.StatusForbidden).
+				Code(CodeInsufficientScope).
+				Message(fmt.Sprintf("missing the %s scope", scope)).
+				Build()
+		}
+	}
+	return nil
+}
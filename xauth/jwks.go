@@ -0,0 +1,244 @@
+package xauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// JWK is a public key of a JSON Web Key Set, RSA or P-256 EC
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK creates the JWK of an *rsa.PublicKey or a P-256 *ecdsa.PublicKey
+func NewJWK(kid string, publicKey interface{}) (JWK, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: RS256,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			break
+		}
+		point, err := key.ECDH()
+		if err != nil {
+			return JWK{}, xerrors.Wrap(err, http.StatusInternalServerError, "invalid EC public key")
+		}
+		// point is 0x04 followed by the X and Y coordinates
+		raw := point.Bytes()[1:]
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: ES256,
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(raw[:32]),
+			Y:   base64.RawURLEncoding.EncodeToString(raw[32:]),
+		}, nil
+	}
+	return JWK{}, xerrors.NewInternalServerError(fmt.Sprintf("unsupported public key %T", publicKey))
+}
+
+// PublicKey decodes the key, an *rsa.PublicKey or an *ecdsa.PublicKey
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			break
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if k.Crv != "P-256" || errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			break
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := key.ECDH(); err != nil {
+			// The point is not on the curve
+			break
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid or unsupported JWK %q", k.Kid)
+}
+
+// JWKSHandler serves keys as a JWKS, eg. on /.well-known/jwks.json
+func JWKSHandler(keys ...JWK) http.Handler {
+	body, _ := json.Marshal(JWKSet{Keys: keys})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_, _ = w.Write(body)
+	})
+}
+
+// JWKSOptions configures a JWKS
+type JWKSOptions struct {
+	// Client defaults to an http.Client with a 10s timeout
+	Client *http.Client
+	// RefreshInterval is how long the keys are used before being fetched
+	// again, 1h by default
+	RefreshInterval time.Duration
+	// MinRefreshInterval throttles the fetches triggered by unknown key ids,
+	// 1m by default
+	MinRefreshInterval time.Duration
+}
+
+// JWKS is a KeySource fetching the keys of a JWKS URL. Keys are cached and
+// fetched again after RefreshInterval, or at once when a token names an
+// unknown key, so rotated keys are picked up. When a fetch fails the cached
+// keys keep being used.
+type JWKS struct {
+	url  string
+	opts JWKSOptions
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	fetched time.Time
+
+	refreshMu sync.Mutex
+	attempted time.Time
+	err       error // of the last fetch
+}
+
+// NewJWKS creates a key source of the JWKS served at url
+func NewJWKS(url string, opts JWKSOptions) *JWKS {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = time.Hour
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = time.Minute
+	}
+	return &JWKS{url: url, opts: opts}
+}
+
+// Key returns the key kid, or the single key of the set when kid is empty
+func (j *JWKS) Key(ctx context.Context, kid string) (interface{}, error) {
+	key, found, fresh := j.lookup(kid)
+	if found && fresh {
+		return key, nil
+	}
+	err := j.refresh(ctx, !found)
+	if key, found, _ = j.lookup(kid); found {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Refresh fetches the keys
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	return j.fetch(ctx)
+}
+
+func (j *JWKS) lookup(kid string) (key interface{}, found bool, fresh bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	fresh = time.Since(j.fetched) < j.opts.RefreshInterval
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true, fresh
+		}
+	}
+	key, found = j.keys[kid]
+	return key, found, fresh
+}
+
+// refresh fetches the keys unless another caller just did, or unless a fetch
+// was attempted less than MinRefreshInterval ago when throttled
+func (j *JWKS) refresh(ctx context.Context, throttled bool) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	j.mu.RLock()
+	fetched := j.fetched
+	j.mu.RUnlock()
+	if time.Since(fetched) < j.opts.MinRefreshInterval {
+		return nil
+	}
+	if throttled && time.Since(j.attempted) < j.opts.MinRefreshInterval {
+		return j.err
+	}
+	return j.fetch(ctx)
+}
+
+// fetch fetches the keys, refreshMu being held
+func (j *JWKS) fetch(ctx context.Context) error {
+	j.attempted = time.Now()
+	j.err = j.download(ctx)
+	return j.err
+}
+
+func (j *JWKS) download(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "invalid JWKS URL")
+	}
+	response, err := j.opts.Client.Do(request)
+	if err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot fetch the JWKS")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return xerrors.WrapServiceUnavailable(fmt.Errorf("GET %s: %s", j.url, response.Status), "cannot fetch the JWKS")
+	}
+	var set JWKSet
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "invalid JWKS")
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped, the others are still usable
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.fetched = time.Now()
+	j.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package xauth authenticates requests and callers: JWTs, API keys, OIDC,
+// passwords, permissions and webhook signatures
+package xauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Signing methods
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+const (
+	// CodeTokenMissing is the error code of requests without a token
+	CodeTokenMissing = "token_missing"
+	// CodeTokenExpired is the error code of expired tokens
+	CodeTokenExpired = "token_expired"
+	// CodeTokenInvalid is the error code of tokens failing verification
+	CodeTokenInvalid = "token_invalid"
+)
+
+// Claims are the registered claims of a token. Custom claim types embed it:
+//
+//	type UserClaims struct {
+//		xauth.Claims
+//		TenantID string   `json:"tid"`
+//		Roles    []string `json:"roles"`
+//	}
+type Claims struct {
+	jwt.RegisteredClaims
+}
+
+// registered gives the Signer access to the registered claims of custom
+// claim types
+type registered interface {
+	registeredClaims() *jwt.RegisteredClaims
+}
+
+func (c *Claims) registeredClaims() *jwt.RegisteredClaims {
+	return &c.RegisteredClaims
+}
+
+// SignerOptions configures a Signer
+type SignerOptions struct {
+	// Method is HS256, RS256 or ES256
+	Method string
+	// Key is a []byte secret for HS256, an *rsa.PrivateKey for RS256 and an
+	// *ecdsa.PrivateKey on the P-256 curve for ES256
+	Key interface{}
+	// KeyID is sent in the kid header so verifiers pick the key among their JWKS
+	KeyID string
+	// Issuer is the iss claim of tokens without one
+	Issuer string
+	// Audience is the aud claim of tokens without one
+	Audience []string
+	// TTL sets the exp claim of tokens without one, 15m by default
+	TTL time.Duration
+}
+
+// Signer issues tokens
+type Signer struct {
+	opts   SignerOptions
+	method jwt.SigningMethod
+}
+
+// NewSigner creates a signer, failing when the key does not suit the method
+func NewSigner(opts SignerOptions) (*Signer, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Minute
+	}
+	var ok bool
+	switch opts.Method {
+	case HS256:
+		var key []byte
+		key, ok = opts.Key.([]byte)
+		ok = ok && len(key) > 0
+	case RS256:
+		_, ok = opts.Key.(*rsa.PrivateKey)
+	case ES256:
+		var key *ecdsa.PrivateKey
+		key, ok = opts.Key.(*ecdsa.PrivateKey)
+		ok = ok && key.Curve == elliptic.P256()
+	default:
+		return nil, xerrors.NewInternalServerError(fmt.Sprintf("unsupported signing method %q", opts.Method))
+	}
+	if !ok {
+		return nil, xerrors.NewInternalServerError(fmt.Sprintf("invalid %s signing key %T", opts.Method, opts.Key))
+	}
+	return &Signer{opts: opts, method: jwt.GetSigningMethod(opts.Method)}, nil
+}
+
+// Sign issues a token of claims, a *Claims or a pointer to a custom claim
+// type embedding Claims. The empty iss, aud, exp, iat and jti claims are set.
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	if r, ok := claims.(registered); ok {
+		rc := r.registeredClaims()
+		now := time.Now()
+		if rc.Issuer == "" {
+			rc.Issuer = s.opts.Issuer
+		}
+		if len(rc.Audience) == 0 {
+			rc.Audience = s.opts.Audience
+		}
+		if rc.ExpiresAt == nil {
+			rc.ExpiresAt = jwt.NewNumericDate(now.Add(s.opts.TTL))
+		}
+		if rc.IssuedAt == nil {
+			rc.IssuedAt = jwt.NewNumericDate(now)
+		}
+		if rc.ID == "" {
+			id := make([]byte, 16)
+			if _, err := rand.Read(id); err != nil {
+				return "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot generate the token id")
+			}
+			rc.ID = hex.EncodeToString(id)
+		}
+	}
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.opts.KeyID != "" {
+		token.Header["kid"] = s.opts.KeyID
+	}
+	signed, err := token.SignedString(s.opts.Key)
+	if err != nil {
+		return "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot sign the token")
+	}
+	return signed, nil
+}
+
+// PublicKey returns the JWK of the public key verifying the tokens, to be
+// served in a JWKS. HS256 signers have none.
+func (s *Signer) PublicKey() (JWK, error) {
+	switch key := s.opts.Key.(type) {
+	case *rsa.PrivateKey:
+		return NewJWK(s.opts.KeyID, &key.PublicKey)
+	case *ecdsa.PrivateKey:
+		return NewJWK(s.opts.KeyID, &key.PublicKey)
+	}
+	return JWK{}, xerrors.NewInternalServerError("symmetric keys cannot be published")
+}
+
+// KeySource returns the key verifying a token signed with the key kid, which
+// is empty when the token has no kid header. See StaticKey and JWKS.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (interface{}, error)
+}
+
+// KeySourceFunc adapts a function to KeySource
+type KeySourceFunc func(ctx context.Context, kid string) (interface{}, error)
+
+// Key calls f
+func (f KeySourceFunc) Key(ctx context.Context, kid string) (interface{}, error) {
+	return f(ctx, kid)
+}
+
+// StaticKey verifies every token with key: a []byte secret, an
+// *rsa.PublicKey or an *ecdsa.PublicKey
+func StaticKey(key interface{}) KeySource {
+	return KeySourceFunc(func(context.Context, string) (interface{}, error) {
+		return key, nil
+	})
+}
+
+// VerifierOptions configures a Verifier
+type VerifierOptions struct {
+	// Keys returns the verification keys
+	Keys KeySource
+	// Methods are the accepted signing methods, HS256, RS256 and ES256 by
+	// default. A key only verifies the methods of its type, so an RSA public
+	// key is never used as an HS256 secret.
+	Methods []string
+	// Issuer is the required iss claim, unchecked when empty
+	Issuer string
+	// Audience is the required aud claim, unchecked when empty
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Verifier verifies tokens and their standard claims. Tokens must have an
+// exp claim.
+type Verifier struct {
+	opts   VerifierOptions
+	parser *jwt.Parser
+}
+
+// NewVerifier creates a verifier
+func NewVerifier(opts VerifierOptions) *Verifier {
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{HS256, RS256, ES256}
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(opts.Methods),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if opts.Issuer != "" {
+		options = append(options, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		options = append(options, jwt.WithAudience(opts.Audience))
+	}
+	return &Verifier{opts: opts, parser: jwt.NewParser(options...)}
+}
+
+// Verify verifies token and decodes its claims into claims, a pointer. It
+// fails with a 401 coded CodeTokenExpired or CodeTokenInvalid, or with the
+// 5xx error of the key source when the keys are unavailable.
+func (v *Verifier) Verify(ctx context.Context, token string, claims jwt.Claims) error {
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.opts.Keys.Key(ctx, kid)
+	})
+	if err == nil {
+		return nil
+	}
+	var keyErr xerrors.RestErr
+	if errors.As(err, &keyErr) && keyErr.StatusCode() >= http.StatusInternalServerError {
+		return keyErr
+	}
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return unauthorized(CodeTokenExpired, "token is expired", err)
+	}
+	return unauthorized(CodeTokenInvalid, "invalid token", err)
+}
+
+// Verify verifies token with v and returns its claims of type C
+func Verify[C any, PC interface {
+	*C
+	jwt.Claims
+}](ctx context.Context, v *Verifier, token string) (*C, error) {
+	claims := PC(new(C))
+	if err := v.Verify(ctx, token, claims); err != nil {
+		return nil, err
+	}
+	return (*C)(claims), nil
+}
+
+func unauthorized(code string, message string, cause error) xerrors.RestErr {
+	return xerrors.New().
+		Status(http.StatusUnauthorized).
+		Code(code).
+		Message(message).
+		Cause(cause).
+		Build()
+}
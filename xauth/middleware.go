@@ -0,0 +1,72 @@
+package xauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+type claimsKey struct{}
+
+// BearerToken returns the token of the Authorization header of r, or an
+// empty string
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Middleware verifies the bearer token of the requests with v and stores
+// its claims, of type C, in the request context. Requests without a valid
+// token are rejected with a 401 xerrors JSON body.
+//
+//	handler = xauth.Middleware[UserClaims](verifier)(handler)
+//	claims, _ := xauth.ClaimsFrom[UserClaims](r.Context())
+func Middleware[C any, PC interface {
+	*C
+	jwt.Claims
+}](v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := BearerToken(r)
+			if token == "" {
+				reject(w, unauthorized(CodeTokenMissing, "missing bearer token", nil))
+				return
+			}
+			claims, err := Verify[C, PC](r.Context(), v, token)
+			if err != nil {
+				reject(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// WithClaims returns a copy of ctx carrying claims, a pointer
+func WithClaims(ctx context.Context, claims interface{}) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFrom returns the claims of type C stored by Middleware or WithClaims
+func ClaimsFrom[C any](ctx context.Context) (*C, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*C)
+	return claims, ok
+}
+
+// reject writes err, asking 401 clients for a bearer token
+func reject(w http.ResponseWriter, err error) {
+	switch {
+	case xerrors.CodeOf(err) == CodeTokenMissing:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case xerrors.StatusOf(err) == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	xerrors.WriteJSON(w, err)
+}
@@ -0,0 +1,322 @@
+package xauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Discovery is the OpenID Provider metadata served on
+// /.well-known/openid-configuration
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	EndSessionEndpoint               string   `json:"end_session_endpoint,omitempty"`
+	ScopesSupported                  []string `json:"scopes_supported,omitempty"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Discover fetches the metadata of the provider issuer, checking it is the
+// one it claims to be
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Discovery, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var discovery Discovery
+	endpoint := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, endpoint, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != issuer {
+		return nil, xerrors.NewBadGatewayError(fmt.Sprintf("the provider claims to be %q instead of %q", discovery.Issuer, issuer))
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, xerrors.NewBadGatewayError("incomplete provider metadata")
+	}
+	return &discovery, nil
+}
+
+// OIDCOptions configures a RelyingParty
+type OIDCOptions struct {
+	// Issuer is the URL of the provider, eg. https://login.example.com
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider
+	RedirectURL string
+	// Scopes are requested besides openid, eg. profile and email
+	Scopes []string
+	// Client defaults to an http.Client with a 10s timeout
+	Client *http.Client
+	// JWKS configures the fetching of the keys of the provider
+	JWKS JWKSOptions
+	// Leeway tolerates clock skew when checking the ID tokens
+	Leeway time.Duration
+}
+
+// RelyingParty logs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE:
+//
+//	request, _ := rp.AuthCodeURL()
+//	// keep request in a short lived encrypted cookie, redirect to request.URL
+//	...
+//	// in the handler of RedirectURL
+//	tokens, claims, err := rp.Callback(ctx, r, request)
+type RelyingParty struct {
+	opts      OIDCOptions
+	discovery *Discovery
+	verifier  *Verifier
+}
+
+// NewRelyingParty discovers the provider and creates a relying party
+func NewRelyingParty(ctx context.Context, opts OIDCOptions) (*RelyingParty, error) {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.JWKS.Client == nil {
+		opts.JWKS.Client = opts.Client
+	}
+	discovery, err := Discover(ctx, opts.Client, opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	var methods []string
+	for _, alg := range discovery.IDTokenSigningAlgValuesSupported {
+		if alg == RS256 || alg == ES256 {
+			methods = append(methods, alg)
+		}
+	}
+	if len(methods) == 0 {
+		methods = []string{RS256}
+	}
+	verifier := NewVerifier(VerifierOptions{
+		Keys:     NewJWKS(discovery.JWKSURI, opts.JWKS),
+		Methods:  methods,
+		Issuer:   discovery.Issuer,
+		Audience: opts.ClientID,
+		Leeway:   opts.Leeway,
+	})
+	return &RelyingParty{opts: opts, discovery: discovery, verifier: verifier}, nil
+}
+
+// Discovery returns the metadata of the provider
+func (rp *RelyingParty) Discovery() *Discovery {
+	return rp.discovery
+}
+
+// AuthRequest is an authorization request. Its values must be kept until
+// the callback, eg. in an encrypted cookie, as they prove it answers this
+// request.
+type AuthRequest struct {
+	URL          string `json:"url"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// AuthCodeURL creates an authorization request, params being added to its
+// URL, eg. prompt or login_hint
+func (rp *RelyingParty) AuthCodeURL(params ...url.Values) (AuthRequest, error) {
+	var request AuthRequest
+	for _, value := range []*string{&request.State, &request.Nonce, &request.CodeVerifier} {
+		random, err := randomString()
+		if err != nil {
+			return AuthRequest{}, err
+		}
+		*value = random
+	}
+	challenge := sha256.Sum256([]byte(request.CodeVerifier))
+
+	query := url.Values{}
+	for _, p := range params {
+		for key, values := range p {
+			query[key] = values
+		}
+	}
+	query.Set("response_type", "code")
+	query.Set("client_id", rp.opts.ClientID)
+	query.Set("redirect_uri", rp.opts.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, rp.opts.Scopes...), " "))
+	query.Set("state", request.State)
+	query.Set("nonce", request.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(rp.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	request.URL = rp.discovery.AuthorizationEndpoint + separator + query.Encode()
+	return request, nil
+}
+
+// IDClaims are the claims of an ID token
+type IDClaims struct {
+	Claims
+	Nonce             string `json:"nonce,omitempty"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Tokens are the tokens returned by the provider
+type Tokens struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	ExpiresIn    int       `json:"expires_in,omitempty"`
+	Expiry       time.Time `json:"-"`
+}
+
+// Callback handles the redirection of the provider to RedirectURL: it
+// checks the state of r is the one of request, then exchanges the code.
+// Denied or forged callbacks fail with a 401 coded CodeTokenInvalid, the
+// error of the provider being in the provider_error detail.
+func (rp *RelyingParty) Callback(ctx context.Context, r *http.Request, request AuthRequest) (*Tokens, *IDClaims, error) {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		err := unauthorized(CodeTokenInvalid, fmt.Sprintf("authorization denied: %s", strings.TrimSpace(reason+" "+query.Get("error_description"))), nil)
+		return nil, nil, xerrors.WithDetail(err, "provider_error", reason)
+	}
+	if request.State == "" || query.Get("state") != request.State {
+		return nil, nil, unauthorized(CodeTokenInvalid, "invalid authorization state", nil)
+	}
+	return rp.Exchange(ctx, query.Get("code"), request)
+}
+
+// Exchange exchanges an authorization code for tokens and verifies the ID
+// token, its nonce being the one of request
+func (rp *RelyingParty) Exchange(ctx context.Context, code string, request AuthRequest) (*Tokens, *IDClaims, error) {
+	tokens, err := rp.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.opts.RedirectURL},
+		"code_verifier": {request.CodeVerifier},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, nil, xerrors.NewBadGatewayError("the provider returned no ID token")
+	}
+	claims, err := rp.VerifyIDToken(ctx, tokens.IDToken, request.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, claims, nil
+}
+
+// Refresh gets new tokens with a refresh token. The ID token, when returned,
+// is not verified.
+func (rp *RelyingParty) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	return rp.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// VerifyIDToken verifies an ID token and its nonce, unchecked when empty
+func (rp *RelyingParty) VerifyIDToken(ctx context.Context, idToken string, nonce string) (*IDClaims, error) {
+	claims, err := Verify[IDClaims](ctx, rp.verifier, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, unauthorized(CodeTokenInvalid, "invalid ID token nonce", nil)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != rp.opts.ClientID {
+		return nil, unauthorized(CodeTokenInvalid, "invalid ID token authorized party", nil)
+	}
+	return claims, nil
+}
+
+// token calls the token endpoint. OAuth errors, eg. an expired code, fail
+// with a 401, the others with a 502.
+func (rp *RelyingParty) token(ctx context.Context, form url.Values) (*Tokens, error) {
+	form.Set("client_id", rp.opts.ClientID)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "invalid token endpoint")
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if rp.opts.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(rp.opts.ClientID), url.QueryEscape(rp.opts.ClientSecret))
+	}
+	response, err := rp.opts.Client.Do(request)
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusBadGateway, "cannot reach the token endpoint")
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusBadGateway, "cannot read the token response")
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+			return nil, unauthorized(oauthErr.Error, strings.TrimSpace("token request failed: "+oauthErr.Error+" "+oauthErr.Description), nil)
+		}
+		return nil, xerrors.NewBadGatewayError(fmt.Sprintf("token request failed: %s", response.Status))
+	}
+	var tokens Tokens
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.AccessToken == "" {
+		return nil, xerrors.NewBadGatewayError("invalid token response")
+	}
+	if tokens.ExpiresIn > 0 {
+		tokens.Expiry = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	}
+	return &tokens, nil
+}
+
+// getJSON decodes the JSON document at endpoint into v, failing with a 502
+func getJSON(ctx context.Context, client *http.Client, endpoint string, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, fmt.Sprintf("invalid URL %s", endpoint))
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusBadGateway, fmt.Sprintf("cannot fetch %s", endpoint))
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return xerrors.NewBadGatewayError(fmt.Sprintf("GET %s: %s", endpoint, response.Status))
+	}
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return xerrors.Wrap(err, http.StatusBadGateway, fmt.Sprintf("invalid JSON at %s", endpoint))
+	}
+	return nil
+}
+
+// randomString returns 32 random bytes encoded in base64url, fit for a PKCE
+// code verifier
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot generate random values")
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,298 @@
+package xauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Password hashing algorithms
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// CodeInvalidCredentials is the error code of wrong passwords
+const CodeInvalidCredentials = "invalid_credentials"
+
+// Argon2Params are the parameters of argon2id hashes
+type Argon2Params struct {
+	// Memory is in KiB, 64 MiB by default
+	Memory uint32
+	// Iterations defaults to 3
+	Iterations uint32
+	// Parallelism defaults to 2
+	Parallelism uint8
+	// SaltLength defaults to 16 bytes
+	SaltLength uint32
+	// KeyLength defaults to 32 bytes
+	KeyLength uint32
+}
+
+// HasherOptions configures a Hasher
+type HasherOptions struct {
+	// Algorithm of the new hashes, Argon2id by default. Hashes of both
+	// algorithms are verified whatever the algorithm.
+	Algorithm string
+	Argon2    Argon2Params
+	// BcryptCost defaults to 12
+	BcryptCost int
+}
+
+// Hasher hashes passwords in the PHC string format for argon2id, eg.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, and the modular crypt format
+// for bcrypt
+type Hasher struct {
+	opts HasherOptions
+}
+
+// DefaultHasher is the hasher of HashPassword and CheckPassword
+var DefaultHasher = NewHasher(HasherOptions{})
+
+// NewHasher creates a password hasher
+func NewHasher(opts HasherOptions) *Hasher {
+	if opts.Algorithm == "" {
+		opts.Algorithm = Argon2id
+	}
+	if opts.Argon2.Memory == 0 {
+		opts.Argon2.Memory = 64 * 1024
+	}
+	if opts.Argon2.Iterations == 0 {
+		opts.Argon2.Iterations = 3
+	}
+	if opts.Argon2.Parallelism == 0 {
+		opts.Argon2.Parallelism = 2
+	}
+	if opts.Argon2.SaltLength == 0 {
+		opts.Argon2.SaltLength = 16
+	}
+	if opts.Argon2.KeyLength == 0 {
+		opts.Argon2.KeyLength = 32
+	}
+	if opts.BcryptCost == 0 {
+		opts.BcryptCost = 12
+	}
+	return &Hasher{opts: opts}
+}
+
+// Hash hashes password with a random salt
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.opts.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.opts.BcryptCost)
+		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+			return "", xerrors.WrapBadRequest(err, "password is too long")
+		}
+		if err != nil {
+			return "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot hash the password")
+		}
+		return string(hash), nil
+	}
+
+	p := h.opts.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", xerrors.Wrap(err, http.StatusInternalServerError, "cannot hash the password")
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports in constant time whether password matches encoded. It
+// fails with a 500 when encoded is not a hash it knows.
+func (h *Hasher) Verify(password string, encoded string) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		}
+		return false, xerrors.Wrap(err, http.StatusInternalServerError, "invalid password hash")
+	}
+
+	p, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+// NeedsRehash reports whether encoded was hashed with another algorithm or
+// other parameters than the ones of h
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return h.opts.Algorithm != Bcrypt || err != nil || cost != h.opts.BcryptCost
+	}
+	p, salt, key, err := decodeArgon2(encoded)
+	if err != nil || h.opts.Algorithm != Argon2id {
+		return true
+	}
+	want := h.opts.Argon2
+	return p.Memory != want.Memory || p.Iterations != want.Iterations || p.Parallelism != want.Parallelism ||
+		uint32(len(salt)) != want.SaltLength || uint32(len(key)) != want.KeyLength
+}
+
+// Check verifies password on login, failing with a 401 coded
+// CodeInvalidCredentials when it does not match. When the hash needs a
+// rehash, it returns the new hash to store, else an empty string.
+func (h *Hasher) Check(password string, encoded string) (string, error) {
+	ok, err := h.Verify(password, encoded)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", xerrors.New().
+			Status(http.StatusUnauthorized).
+			Code(CodeInvalidCredentials).
+			Message("invalid credentials").
+			Build()
+	}
+	if !h.NeedsRehash(encoded) {
+		return "", nil
+	}
+	return h.Hash(password)
+}
+
+// HashPassword hashes password with the DefaultHasher
+func HashPassword(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+// CheckPassword checks password with the DefaultHasher, see Hasher.Check
+func CheckPassword(password string, encoded string) (string, error) {
+	return DefaultHasher.Check(password, encoded)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2(encoded string) (p Argon2Params, salt []byte, key []byte, err error) {
+	invalid := xerrors.NewInternalServerError("invalid password hash")
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, invalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, invalid
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, invalid
+	}
+	salt, errSalt := base64.RawStdEncoding.DecodeString(parts[4])
+	key, errKey := base64.RawStdEncoding.DecodeString(parts[5])
+	if errSalt != nil || errKey != nil || len(key) == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, invalid
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
+
+// PasswordPolicy are the strength rules of new passwords. Length matters
+// more than composition, so the composition rules are off by default.
+type PasswordPolicy struct {
+	// MinLength is in characters, 12 by default
+	MinLength int
+	// MaxLength is in characters, 128 by default, bounding the hashing cost
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Forbidden are rejected passwords, eg. the most common ones, compared
+	// case insensitively
+	Forbidden []string
+}
+
+// DefaultPasswordPolicy is the policy of ValidatePassword
+var DefaultPasswordPolicy = PasswordPolicy{
+	Forbidden: []string{"password", "123456789012", "qwertyuiopas", "passwordpassword", "letmeinletmein"},
+}
+
+// Validate checks password against the policy, returning a 422
+// ValidationError on the password field or nil. userInputs, eg. the email
+// or name of the user, must not be contained in the password.
+func (p PasswordPolicy) Validate(password string, userInputs ...string) error {
+	minLength, maxLength := p.MinLength, p.MaxLength
+	if minLength <= 0 {
+		minLength = 12
+	}
+	if maxLength <= 0 {
+		maxLength = 128
+	}
+
+	var violations xerrors.Violations
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		violations.Addf("password", "min", nil, "password must have at least %d characters", minLength)
+	}
+	if length > maxLength {
+		violations.Addf("password", "max", nil, "password must have at most %d characters", maxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	for _, rule := range []struct {
+		required, present bool
+		name, kind        string
+	}{
+		{p.RequireUpper, upper, "upper", "an uppercase letter"},
+		{p.RequireLower, lower, "lower", "a lowercase letter"},
+		{p.RequireDigit, digit, "digit", "a digit"},
+		{p.RequireSymbol, symbol, "symbol", "a symbol"},
+	} {
+		if rule.required && !rule.present {
+			violations.Add("password", rule.name, "password must contain "+rule.kind, nil)
+		}
+	}
+
+	lowered := strings.ToLower(password)
+	for _, forbidden := range p.Forbidden {
+		if lowered == strings.ToLower(forbidden) {
+			violations.Add("password", "common", "password is too common", nil)
+			break
+		}
+	}
+	for _, input := range userInputs {
+		if len(input) >= 4 && strings.Contains(lowered, strings.ToLower(input)) {
+			violations.Add("password", "personal", "password must not contain personal information", nil)
+			break
+		}
+	}
+	if err := violations.Err("weak password"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidatePassword checks password against the DefaultPasswordPolicy
+func ValidatePassword(password string, userInputs ...string) error {
+	return DefaultPasswordPolicy.Validate(password, userInputs...)
+}
@@ -0,0 +1,193 @@
+package xauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// CodePermissionDenied is the error code of principals lacking a permission
+const CodePermissionDenied = "permission_denied"
+
+// Role grants permissions, eg. "orders:read". A permission ending with ":*"
+// grants every permission it prefixes and "*" grants them all.
+type Role struct {
+	Name        string
+	Permissions []string
+	// Inherits are the roles whose permissions the role also grants
+	Inherits []string
+}
+
+var (
+	rolesMu sync.RWMutex
+	roles   = make(map[string]Role)
+)
+
+// RegisterRole registers a role. It panics when the name is empty or already
+// registered, as roles are meant to be registered once at init time.
+func RegisterRole(role Role) {
+	if role.Name == "" {
+		panic("xauth: cannot register a role without a name")
+	}
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	if _, ok := roles[role.Name]; ok {
+		panic(fmt.Sprintf("xauth: role %s is already registered", role.Name))
+	}
+	roles[role.Name] = role
+}
+
+// Roles returns every registered role sorted by name
+func Roles() []Role {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	list := make([]Role, 0, len(roles))
+	for _, role := range roles {
+		list = append(list, role)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// FlushRoles removes every registered role
+func FlushRoles() {
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	roles = make(map[string]Role)
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	Subject string
+	Roles   []string
+	// Permissions are granted directly, eg. the scopes of an API key
+	Permissions []string
+}
+
+// Allowed reports whether the principal is granted permission, directly or
+// by one of its roles. Unknown roles grant nothing.
+func (p *Principal) Allowed(permission string) bool {
+	for _, granted := range p.Permissions {
+		if grants(granted, permission) {
+			return true
+		}
+	}
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	visited := make(map[string]bool)
+	for _, name := range p.Roles {
+		if roleAllows(name, permission, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllows reports whether the role name or the roles it inherits grant
+// permission, rolesMu being held
+func roleAllows(name string, permission string, visited map[string]bool) bool {
+	if visited[name] {
+		return false
+	}
+	visited[name] = true
+	role, ok := roles[name]
+	if !ok {
+		return false
+	}
+	for _, granted := range role.Permissions {
+		if grants(granted, permission) {
+			return true
+		}
+	}
+	for _, parent := range role.Inherits {
+		if roleAllows(parent, permission, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func grants(granted string, permission string) bool {
+	switch {
+	case granted == permission || granted == "*":
+		return true
+	case strings.HasSuffix(granted, ":*"):
+		return strings.HasPrefix(permission, granted[:len(granted)-1])
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of ctx, stored by WithPrincipal or
+// derived from the API key stored by APIKeyMiddleware, its scopes being its
+// permissions
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	if principal, ok := ctx.Value(principalKey{}).(*Principal); ok {
+		return principal, true
+	}
+	if apiKey, ok := APIKeyFrom(ctx); ok {
+		return &Principal{Subject: apiKey.ID, Permissions: apiKey.Scopes}, true
+	}
+	return nil, false
+}
+
+// ClaimsPrincipal stores the principal built from the claims of type C,
+// stored by Middleware which must run first
+//
+//	handler = xauth.ClaimsPrincipal(func(c *UserClaims) *xauth.Principal {
+//		return &xauth.Principal{Subject: c.Subject, Roles: c.Roles}
+//	})(handler)
+//	handler = xauth.Middleware[UserClaims](verifier)(handler)
+func ClaimsPrincipal[C any](principal func(claims *C) *Principal) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := ClaimsFrom[C](r.Context()); ok {
+				r = r.WithContext(WithPrincipal(r.Context(), principal(claims)))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Can checks the principal of ctx is granted permission, failing with a 401
+// without principal and a 403 coded CodePermissionDenied otherwise
+func Can(ctx context.Context, permission string) error {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		return xerrors.NewUnauthorizedError("authentication required")
+	}
+	if !principal.Allowed(permission) {
+		return xerrors.New().
+			Status(http.StatusForbidden).
+			Code(CodePermissionDenied).
+			Message(fmt.Sprintf("missing the %s permission", permission)).
+			Build()
+	}
+	return nil
+}
+
+// Require rejects the requests whose principal lacks one of permissions, see Can
+func Require(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, permission := range permissions {
+				if err := Can(r.Context(), permission); err != nil {
+					xerrors.WriteJSON(w, err)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
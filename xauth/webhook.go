@@ -0,0 +1,273 @@
+package xauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xcache"
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+const (
+	// CodeInvalidSignature is the error code of deliveries with a missing or
+	// wrong signature
+	CodeInvalidSignature = "invalid_signature"
+	// CodeSignatureExpired is the error code of deliveries signed too long
+	// ago or already received, likely replayed
+	CodeSignatureExpired = "signature_expired"
+)
+
+// WebhookVerifier checks the signature of a webhook delivery
+type WebhookVerifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// WebhookVerifierFunc adapts a function to WebhookVerifier
+type WebhookVerifierFunc func(r *http.Request, body []byte) error
+
+// Verify calls f
+func (f WebhookVerifierFunc) Verify(r *http.Request, body []byte) error {
+	return f(r, body)
+}
+
+// HMACOptions configures a generic HMAC verifier
+type HMACOptions struct {
+	// Secrets are tried in turn, so a secret can be rotated
+	Secrets []string
+	// Header holds the signature, X-Signature by default
+	Header string
+	// Prefix precedes the signature in the header, eg. "sha256="
+	Prefix string
+	// Hash defaults to sha256.New
+	Hash func() hash.Hash
+	// Base64 tells the signature is base64 encoded rather than hex
+	Base64 bool
+	// TimestampHeader holds the Unix time of the delivery, unchecked when
+	// empty. The signed payload is then the timestamp, a dot and the body.
+	TimestampHeader string
+	// Tolerance is the maximum age of a delivery, 5m by default
+	Tolerance time.Duration
+}
+
+// HMAC verifies HMAC signatures of the body, or of the timestamp and body
+func HMAC(opts HMACOptions) WebhookVerifier {
+	if opts.Header == "" {
+		opts.Header = "X-Signature"
+	}
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = 5 * time.Minute
+	}
+	return WebhookVerifierFunc(func(r *http.Request, body []byte) error {
+		encoded, ok := strings.CutPrefix(r.Header.Get(opts.Header), opts.Prefix)
+		if !ok || encoded == "" {
+			return invalidSignature("missing signature")
+		}
+		signature, err := decodeSignature(encoded, opts.Base64)
+		if err != nil {
+			return invalidSignature("malformed signature")
+		}
+		payload := body
+		if opts.TimestampHeader != "" {
+			timestamp := r.Header.Get(opts.TimestampHeader)
+			if err := checkTimestamp(timestamp, opts.Tolerance); err != nil {
+				return err
+			}
+			payload = signedPayload(timestamp, body)
+		}
+		if !validMAC(opts.Hash, opts.Secrets, payload, [][]byte{signature}) {
+			return invalidSignature("invalid signature")
+		}
+		return nil
+	})
+}
+
+// GitHub verifies the X-Hub-Signature-256 header of GitHub webhooks. GitHub
+// deliveries carry no timestamp, use WebhookOptions.DeliveryHeader to reject
+// replays.
+func GitHub(secrets ...string) WebhookVerifier {
+	return HMAC(HMACOptions{Secrets: secrets, Header: "X-Hub-Signature-256", Prefix: "sha256="})
+}
+
+// Stripe verifies the Stripe-Signature header of Stripe webhooks, eg.
+// "t=1492774577,v1=5257a869...", rejecting deliveries older than tolerance,
+// 5m by default. The same scheme is used by many providers.
+func Stripe(tolerance time.Duration, secrets ...string) WebhookVerifier {
+	return TimestampedHMAC("Stripe-Signature", "v1", tolerance, secrets...)
+}
+
+// TimestampedHMAC verifies Stripe style signature headers: a t= Unix time
+// and scheme= hex HMAC-SHA256 signatures of the timestamp, a dot and the
+// body, separated by commas
+func TimestampedHMAC(header string, scheme string, tolerance time.Duration, secrets ...string) WebhookVerifier {
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+	return WebhookVerifierFunc(func(r *http.Request, body []byte) error {
+		var timestamp string
+		var signatures [][]byte
+		for _, part := range strings.Split(r.Header.Get(header), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case scheme:
+				// Signatures of other schemes or malformed ones are ignored
+				if signature, err := hex.DecodeString(value); err == nil {
+					signatures = append(signatures, signature)
+				}
+			}
+		}
+		if timestamp == "" || len(signatures) == 0 {
+			return invalidSignature("missing signature")
+		}
+		if err := checkTimestamp(timestamp, tolerance); err != nil {
+			return err
+		}
+		if !validMAC(sha256.New, secrets, signedPayload(timestamp, body), signatures) {
+			return invalidSignature("invalid signature")
+		}
+		return nil
+	})
+}
+
+// WebhookOptions configures WebhookMiddleware
+type WebhookOptions struct {
+	Verifier WebhookVerifier
+	// MaxBodyBytes bounds the read body, 1 MiB by default
+	MaxBodyBytes int64
+	// DeliveryHeader holds the unique id of a delivery, eg.
+	// X-GitHub-Delivery, so deliveries already received are rejected.
+	// Unchecked when empty.
+	DeliveryHeader string
+	// Deliveries remembers the received delivery ids, required with a
+	// DeliveryHeader, eg. an xcache.Memory with a CleanupInterval closed
+	// with the server. Use a shared cache when several instances receive
+	// the deliveries.
+	Deliveries xcache.Adder[string, bool]
+	// ReplayWindow is how long delivery ids are remembered, 24h by default
+	ReplayWindow time.Duration
+}
+
+// WebhookMiddleware rejects with a 401 the deliveries whose signature is
+// missing or invalid, too old or already received. The body is read to be
+// verified and handed to the next handler intact. It panics when a
+// DeliveryHeader is set without Deliveries.
+func WebhookMiddleware(opts WebhookOptions) func(http.Handler) http.Handler {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 1 << 20
+	}
+	if opts.ReplayWindow <= 0 {
+		opts.ReplayWindow = 24 * time.Hour
+	}
+	if opts.DeliveryHeader != "" && opts.Deliveries == nil {
+		panic("xauth: webhook deliveries cannot be checked without a Deliveries cache")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, opts.MaxBodyBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					xerrors.WriteJSON(w, xerrors.NewRestError(http.StatusRequestEntityTooLarge, "webhook body is too large"))
+				} else {
+					xerrors.WriteJSON(w, xerrors.WrapBadRequest(err, "cannot read the webhook body"))
+				}
+				return
+			}
+			if err := opts.Verifier.Verify(r, body); err != nil {
+				xerrors.WriteJSON(w, err)
+				return
+			}
+			if opts.DeliveryHeader != "" {
+				if err := firstDelivery(r, opts); err != nil {
+					xerrors.WriteJSON(w, err)
+					return
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// firstDelivery records the delivery id of r, failing when it was received
+// already. Deliveries are recorded once verified so forged ones cannot block
+// genuine ones.
+func firstDelivery(r *http.Request, opts WebhookOptions) error {
+	id := r.Header.Get(opts.DeliveryHeader)
+	if id == "" {
+		return invalidSignature("missing delivery id")
+	}
+	added, err := opts.Deliveries.Add(r.Context(), id, true, opts.ReplayWindow)
+	if err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot record the webhook delivery")
+	}
+	if !added {
+		return signatureExpired("webhook delivery already received")
+	}
+	return nil
+}
+
+func checkTimestamp(timestamp string, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return invalidSignature("missing or malformed signature timestamp")
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return signatureExpired("signature timestamp is outside the tolerance")
+	}
+	return nil
+}
+
+func signedPayload(timestamp string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+1+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+// validMAC reports in constant time whether one of signatures is the MAC of
+// payload with one of secrets
+func validMAC(newHash func() hash.Hash, secrets []string, payload []byte, signatures [][]byte) bool {
+	valid := false
+	for _, secret := range secrets {
+		mac := hmac.New(newHash, []byte(secret))
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				valid = true
+			}
+		}
+	}
+	return valid
+}
+
+func decodeSignature(encoded string, base64Encoded bool) ([]byte, error) {
+	if base64Encoded {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return hex.DecodeString(encoded)
+}
+
+func invalidSignature(message string) xerrors.RestErr {
+	return unauthorized(CodeInvalidSignature, message, nil)
+}
+
+func signatureExpired(message string) xerrors.RestErr {
+	return unauthorized(CodeSignatureExpired, message, nil)
+}
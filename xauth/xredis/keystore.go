@@ -0,0 +1,74 @@
+// Package xredis implements the xauth stores on top of Redis
+package xredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/XandaLtd/xutils-go/xauth"
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// KeyStore stores API keys as JSON under their hash
+type KeyStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ xauth.KeyStore = (*KeyStore)(nil)
+
+// NewKeyStore creates a key store, prefix being prepended to the hashes,
+// "apikeys:" by default
+func NewKeyStore(client redis.UniversalClient, prefix string) *KeyStore {
+	if prefix == "" {
+		prefix = "apikeys:"
+	}
+	return &KeyStore{client: client, prefix: prefix}
+}
+
+// Lookup returns the key of hash, or nil when unknown
+func (s *KeyStore) Lookup(ctx context.Context, hash string) (*xauth.APIKey, error) {
+	data, err := s.client.Get(ctx, s.prefix+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot look up the API key")
+	}
+	var apiKey xauth.APIKey
+	if err := json.Unmarshal(data, &apiKey); err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "invalid stored API key")
+	}
+	return &apiKey, nil
+}
+
+// Put stores apiKey under hash, expiring it with the key
+func (s *KeyStore) Put(ctx context.Context, hash string, apiKey xauth.APIKey) error {
+	data, err := json.Marshal(apiKey)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot encode the API key")
+	}
+	var ttl time.Duration
+	if !apiKey.ExpiresAt.IsZero() {
+		if ttl = time.Until(apiKey.ExpiresAt); ttl <= 0 {
+			return s.Revoke(ctx, hash)
+		}
+	}
+	if err := s.client.Set(ctx, s.prefix+hash, data, ttl).Err(); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot store the API key")
+	}
+	return nil
+}
+
+// Revoke deletes the key of hash
+func (s *KeyStore) Revoke(ctx context.Context, hash string) error {
+	if err := s.client.Del(ctx, s.prefix+hash).Err(); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot revoke the API key")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package xcache provides type-safe caches sharing a single Cache interface
+package xcache
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultTTL makes Set use the default TTL of the cache
+	DefaultTTL time.Duration = 0
+	// NoExpiration makes Set keep the entry until it is deleted or evicted
+	NoExpiration time.Duration = -1
+)
+
+// Cache stores values of type V by keys of type K. Implementations are safe
+// for concurrent use.
+type Cache[K comparable, V any] interface {
+	// Get returns the value of key, reporting false when it is missing or expired
+	Get(ctx context.Context, key K) (V, bool, error)
+	// Set stores value for ttl, see DefaultTTL and NoExpiration
+	Set(ctx context.Context, key K, value V, ttl time.Duration) error
+	// Delete removes key, missing keys are ignored
+	Delete(ctx context.Context, key K) error
+	// GetOrLoad returns the value of key, calling loader and storing its
+	// result with the default TTL on a miss. Loader errors are returned as is
+	// and nothing is stored.
+	GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error)
+}
+
+// Adder is a Cache storing values only for the keys missing, atomically,
+// eg. to record ids once under concurrency
+type Adder[K comparable, V any] interface {
+	Cache[K, V]
+	// Add stores value for ttl unless key is present, reporting whether it
+	// was stored
+	Add(ctx context.Context, key K, value V, ttl time.Duration) (bool, error)
+}
+
+// Loader loads the value of a key missing from a cache
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// expiry returns the expiration time of an entry stored now, zero meaning never
+func expiry(now time.Time, ttl time.Duration, defaultTTL time.Duration) time.Time {
+	if ttl == DefaultTTL {
+		ttl = defaultTTL
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
@@ -0,0 +1,50 @@
+package xcache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoaderPanicked is returned to the callers waiting on a loader that panicked
+var ErrLoaderPanicked = errors.New("xcache: loader panicked")
+
+// Group coalesces concurrent calls for the same key, so a cache miss hit by
+// many requests runs the loader once. The zero value is ready to use.
+type Group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Do runs fn for key unless a call for key is in flight, in which case it
+// waits for that call and returns its result, shared reporting so. The
+// panics of fn are propagated to its caller, the waiting ones getting
+// ErrLoaderPanicked.
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (value V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.value, c.err, true
+	}
+	c := &call[V]{done: make(chan struct{}), err: ErrLoaderPanicked}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fn()
+	return c.value, c.err, false
+}
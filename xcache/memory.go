@@ -0,0 +1,232 @@
+package xcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryOptions configures a Memory cache
+type MemoryOptions struct {
+	// TTL is the default time to live of the entries, zero means no expiration
+	TTL time.Duration
+	// MaxEntries bounds the number of entries, an entry chosen by Policy being
+	// evicted to make room for a new one. Zero means unlimited.
+	MaxEntries int
+	// Policy is the eviction policy of a bounded cache, FIFO by default
+	Policy Policy
+	// CleanupInterval is how often the janitor removes expired entries. When
+	// zero expired entries are only removed as they are read or evicted.
+	CleanupInterval time.Duration
+}
+
+// Memory is an in-process Cache
+type Memory[K comparable, V any] struct {
+	opts    MemoryOptions
+	mu      sync.Mutex
+	entries map[K]*entry[V]
+	evictor evictor[K] // nil when unbounded
+	stop    chan struct{}
+	once    sync.Once
+	loads   Group[K, V]
+
+	hits, misses, evictions, expirations atomic.Uint64
+}
+
+// Stats are the counters of a cache since its creation
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Entries     int
+}
+
+type entry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+var _ Adder[string, interface{}] = (*Memory[string, interface{}])(nil)
+
+// NewMemory creates an in-process cache, starting its janitor when
+// opts.CleanupInterval is set. Call Close to stop the janitor.
+func NewMemory[K comparable, V any](opts MemoryOptions) *Memory[K, V] {
+	c := &Memory[K, V]{
+		opts:    opts,
+		entries: make(map[K]*entry[V]),
+		stop:    make(chan struct{}),
+	}
+	if opts.MaxEntries > 0 {
+		c.evictor = newEvictor[K](opts.Policy, opts.MaxEntries)
+	}
+	if opts.CleanupInterval > 0 {
+		go c.janitor(opts.CleanupInterval)
+	}
+	return c
+}
+
+// Get returns the value of key, reporting false when it is missing or expired
+func (c *Memory[K, V]) Get(_ context.Context, key K) (V, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		if !e.expired(time.Now()) {
+			if c.evictor != nil {
+				c.evictor.accessed(key)
+			}
+			c.hits.Add(1)
+			return e.value, true, nil
+		}
+		c.remove(key)
+		c.expirations.Add(1)
+	}
+	c.misses.Add(1)
+	var zero V
+	return zero, false, nil
+}
+
+// Set stores value for ttl, see DefaultTTL and NoExpiration
+func (c *Memory[K, V]) Set(_ context.Context, key K, value V, ttl time.Duration) error {
+	c.set(key, value, ttl)
+	return nil
+}
+
+// Add stores value for ttl unless key is present and not expired
+func (c *Memory[K, V]) Add(_ context.Context, key K, value V, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !e.expired(time.Now()) {
+		return false, nil
+	}
+	c.setLocked(key, value, ttl)
+	return true, nil
+}
+
+// Delete removes key
+func (c *Memory[K, V]) Delete(_ context.Context, key K) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		c.remove(key)
+	}
+	return nil
+}
+
+// GetOrLoad returns the value of key, calling loader and storing its result
+// with the default TTL on a miss. Concurrent misses of a key share a single
+// loader call, run with the context of the first caller.
+func (c *Memory[K, V]) GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error) {
+	if value, ok, _ := c.Get(ctx, key); ok {
+		return value, nil
+	}
+	value, err, _ := c.loads.Do(key, func() (V, error) {
+		value, err := loader(ctx, key)
+		if err == nil {
+			c.set(key, value, DefaultTTL)
+		}
+		return value, err
+	})
+	return value, err
+}
+
+// Len returns the number of entries, including the expired ones not removed yet
+func (c *Memory[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Clear removes every entry
+func (c *Memory[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[K]*entry[V])
+	if c.evictor != nil {
+		c.evictor = newEvictor[K](c.opts.Policy, c.opts.MaxEntries)
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *Memory[K, V]) Stats() Stats {
+	return Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+		Entries:     c.Len(),
+	}
+}
+
+// Close stops the janitor
+func (c *Memory[K, V]) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *Memory[K, V]) set(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(key, value, ttl)
+}
+
+func (c *Memory[K, V]) setLocked(key K, value V, ttl time.Duration) {
+	e := &entry[V]{value: value, expiresAt: expiry(time.Now(), ttl, c.opts.TTL)}
+	if _, ok := c.entries[key]; ok {
+		c.entries[key] = e
+		if c.evictor != nil {
+			c.evictor.accessed(key)
+		}
+		return
+	}
+	if c.evictor != nil {
+		for len(c.entries) >= c.opts.MaxEntries {
+			victim, ok := c.evictor.victim(key)
+			if !ok {
+				break
+			}
+			c.remove(victim)
+			c.evictions.Add(1)
+		}
+	}
+	c.entries[key] = e
+	if c.evictor != nil {
+		c.evictor.added(key)
+	}
+}
+
+func (c *Memory[K, V]) remove(key K) {
+	delete(c.entries, key)
+	if c.evictor != nil {
+		c.evictor.removed(key)
+	}
+}
+
+func (c *Memory[K, V]) removeExpired(now time.Time) {
+	for key, e := range c.entries {
+		if e.expired(now) {
+			c.remove(key)
+			c.expirations.Add(1)
+		}
+	}
+}
+
+func (c *Memory[K, V]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.removeExpired(time.Now())
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (e *entry[V]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
@@ -0,0 +1,271 @@
+package xcache
+
+import "container/list"
+
+// Policy selects the entry a bounded Memory cache evicts to make room
+type Policy int
+
+const (
+	// FIFO evicts the entry inserted first
+	FIFO Policy = iota
+	// LRU evicts the least recently used entry
+	LRU
+	// LFU evicts the least frequently used entry, the least recently used
+	// one among equally used entries
+	LFU
+	// ARC balances recency and frequency, adapting to the workload with the
+	// history of the recently evicted keys
+	ARC
+)
+
+// String returns the name of the policy
+func (p Policy) String() string {
+	switch p {
+	case FIFO:
+		return "fifo"
+	case LRU:
+		return "lru"
+	case LFU:
+		return "lfu"
+	case ARC:
+		return "arc"
+	}
+	return "unknown"
+}
+
+// evictor tracks the keys of a cache for its eviction policy
+type evictor[K comparable] interface {
+	// added records a new key
+	added(key K)
+	// accessed records a read or an update of a key
+	accessed(key K)
+	// removed forgets a deleted or expired key
+	removed(key K)
+	// victim returns the key to evict to make room for incoming
+	victim(incoming K) (K, bool)
+}
+
+func newEvictor[K comparable](policy Policy, capacity int) evictor[K] {
+	switch policy {
+	case LRU:
+		return &recencyEvictor[K]{order: list.New(), elements: make(map[K]*list.Element), touch: true}
+	case LFU:
+		return &lfuEvictor[K]{items: make(map[K]*lfuItem[K]), buckets: make(map[int]*list.List)}
+	case ARC:
+		return newARCEvictor[K](capacity)
+	}
+	return &recencyEvictor[K]{order: list.New(), elements: make(map[K]*list.Element)}
+}
+
+// recencyEvictor evicts the back of a list, accesses moving keys to the front when touch is set
+type recencyEvictor[K comparable] struct {
+	order    *list.List
+	elements map[K]*list.Element
+	touch    bool
+}
+
+func (e *recencyEvictor[K]) added(key K) {
+	e.elements[key] = e.order.PushFront(key)
+}
+
+func (e *recencyEvictor[K]) accessed(key K) {
+	if element, ok := e.elements[key]; ok && e.touch {
+		e.order.MoveToFront(element)
+	}
+}
+
+func (e *recencyEvictor[K]) removed(key K) {
+	if element, ok := e.elements[key]; ok {
+		e.order.Remove(element)
+		delete(e.elements, key)
+	}
+}
+
+func (e *recencyEvictor[K]) victim(K) (K, bool) {
+	if back := e.order.Back(); back != nil {
+		return back.Value.(K), true
+	}
+	var zero K
+	return zero, false
+}
+
+type lfuItem[K comparable] struct {
+	freq    int
+	element *list.Element
+}
+
+// lfuEvictor keeps a list of keys per use count, most recent first
+type lfuEvictor[K comparable] struct {
+	items   map[K]*lfuItem[K]
+	buckets map[int]*list.List
+	minFreq int
+}
+
+func (e *lfuEvictor[K]) push(key K, item *lfuItem[K]) {
+	bucket, ok := e.buckets[item.freq]
+	if !ok {
+		bucket = list.New()
+		e.buckets[item.freq] = bucket
+	}
+	item.element = bucket.PushFront(key)
+}
+
+func (e *lfuEvictor[K]) pop(item *lfuItem[K]) {
+	bucket := e.buckets[item.freq]
+	bucket.Remove(item.element)
+	if bucket.Len() == 0 {
+		delete(e.buckets, item.freq)
+	}
+}
+
+func (e *lfuEvictor[K]) added(key K) {
+	item := &lfuItem[K]{freq: 1}
+	e.items[key] = item
+	e.push(key, item)
+	e.minFreq = 1
+}
+
+func (e *lfuEvictor[K]) accessed(key K) {
+	item, ok := e.items[key]
+	if !ok {
+		return
+	}
+	e.pop(item)
+	if _, ok := e.buckets[item.freq]; !ok && e.minFreq == item.freq {
+		e.minFreq++
+	}
+	item.freq++
+	e.push(key, item)
+}
+
+func (e *lfuEvictor[K]) removed(key K) {
+	if item, ok := e.items[key]; ok {
+		e.pop(item)
+		delete(e.items, key)
+	}
+}
+
+func (e *lfuEvictor[K]) victim(K) (K, bool) {
+	bucket, ok := e.buckets[e.minFreq]
+	if !ok {
+		// Removals may leave minFreq stale
+		e.minFreq = 0
+		for freq := range e.buckets {
+			if e.minFreq == 0 || freq < e.minFreq {
+				e.minFreq = freq
+			}
+		}
+		if bucket, ok = e.buckets[e.minFreq]; !ok {
+			var zero K
+			return zero, false
+		}
+	}
+	return bucket.Back().Value.(K), true
+}
+
+// arcEvictor implements the Adaptive Replacement Cache: t1 holds the keys
+// seen once and t2 the keys seen more, b1 and b2 remember the keys evicted
+// from them. Hits in b1 grow the target size p of t1, hits in b2 shrink it.
+type arcEvictor[K comparable] struct {
+	capacity       int
+	p              int
+	t1, t2, b1, b2 *arcList[K]
+}
+
+type arcList[K comparable] struct {
+	order    *list.List
+	elements map[K]*list.Element
+}
+
+func newARCList[K comparable]() *arcList[K] {
+	return &arcList[K]{order: list.New(), elements: make(map[K]*list.Element)}
+}
+
+func (l *arcList[K]) has(key K) bool {
+	_, ok := l.elements[key]
+	return ok
+}
+
+func (l *arcList[K]) pushFront(key K) {
+	l.elements[key] = l.order.PushFront(key)
+}
+
+func (l *arcList[K]) remove(key K) bool {
+	element, ok := l.elements[key]
+	if ok {
+		l.order.Remove(element)
+		delete(l.elements, key)
+	}
+	return ok
+}
+
+func (l *arcList[K]) removeBack() (K, bool) {
+	back := l.order.Back()
+	if back == nil {
+		var zero K
+		return zero, false
+	}
+	key := back.Value.(K)
+	l.remove(key)
+	return key, true
+}
+
+func (l *arcList[K]) len() int {
+	return l.order.Len()
+}
+
+func newARCEvictor[K comparable](capacity int) *arcEvictor[K] {
+	return &arcEvictor[K]{
+		capacity: capacity,
+		t1:       newARCList[K](),
+		t2:       newARCList[K](),
+		b1:       newARCList[K](),
+		b2:       newARCList[K](),
+	}
+}
+
+func (e *arcEvictor[K]) added(key K) {
+	switch {
+	case e.b1.remove(key):
+		e.p = min(e.capacity, e.p+max(e.b2.len()/max(e.b1.len(), 1), 1))
+		e.t2.pushFront(key)
+	case e.b2.remove(key):
+		e.p = max(0, e.p-max(e.b1.len()/max(e.b2.len(), 1), 1))
+		e.t2.pushFront(key)
+	default:
+		e.t1.pushFront(key)
+	}
+	// Bound the history to the capacity of the cache
+	for e.t1.len()+e.b1.len() > e.capacity && e.b1.len() > 0 {
+		e.b1.removeBack()
+	}
+	for e.t1.len()+e.t2.len()+e.b1.len()+e.b2.len() > 2*e.capacity && e.b2.len() > 0 {
+		e.b2.removeBack()
+	}
+}
+
+func (e *arcEvictor[K]) accessed(key K) {
+	if e.t1.remove(key) || e.t2.remove(key) {
+		e.t2.pushFront(key)
+	}
+}
+
+func (e *arcEvictor[K]) removed(key K) {
+	if !e.t1.remove(key) {
+		e.t2.remove(key)
+	}
+}
+
+// victim moves the evicted key to its history list, as it is evicted next
+func (e *arcEvictor[K]) victim(incoming K) (K, bool) {
+	if e.t1.len() > 0 && (e.t1.len() > e.p || (e.b2.has(incoming) && e.t1.len() == e.p) || e.t2.len() == 0) {
+		key, _ := e.t1.removeBack()
+		e.b1.pushFront(key)
+		return key, true
+	}
+	key, ok := e.t2.removeBack()
+	if ok {
+		e.b2.pushFront(key)
+	}
+	return key, ok
+}
@@ -0,0 +1,81 @@
+// Package xprom exports the statistics of xcache caches to Prometheus,
+// labeled by cache name
+package xprom
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/XandaLtd/xutils-go/xcache"
+)
+
+// Source is a cache reporting statistics, eg. *xcache.Memory
+type Source interface {
+	Stats() xcache.Stats
+}
+
+// Collector reads the statistics of the added caches when scraped
+type Collector struct {
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+	entries     *prometheus.Desc
+
+	mu      sync.Mutex
+	sources map[string]Source
+}
+
+// NewCollector creates the metrics <namespace>_cache_hits_total,
+// <namespace>_cache_misses_total, <namespace>_cache_evictions_total,
+// <namespace>_cache_expirations_total and <namespace>_cache_entries, labeled by cache
+func NewCollector(namespace string) *Collector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, []string{"cache"}, nil)
+	}
+	return &Collector{
+		hits:        desc("hits_total", "Cache reads finding a value."),
+		misses:      desc("misses_total", "Cache reads finding no value."),
+		evictions:   desc("evictions_total", "Entries evicted to make room for new ones."),
+		expirations: desc("expirations_total", "Expired entries removed."),
+		entries:     desc("entries", "Entries currently held."),
+		sources:     make(map[string]Source),
+	}
+}
+
+// Add exports the statistics of source under name, replacing the cache
+// previously added with that name
+func (c *Collector) Add(name string, source Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources[name] = source
+}
+
+// Remove stops exporting the statistics of the cache added under name
+func (c *Collector) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sources, name)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.entries
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, source := range c.sources {
+		stats := source.Stats()
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits), name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses), name)
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations), name)
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Entries), name)
+	}
+}
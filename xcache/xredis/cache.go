@@ -0,0 +1,170 @@
+// Package xredis implements xcache.Cache on top of Redis
+package xredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/XandaLtd/xutils-go/xcache"
+)
+
+// Codec serializes the cached values
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+var (
+	// JSON serializes values with encoding/json
+	JSON Codec = jsonCodec{}
+	// MsgPack serializes values with MessagePack, more compact than JSON
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Options configures a Redis cache
+type Options struct {
+	// Prefix is prepended to every key, eg. "users:"
+	Prefix string
+	// TTL is the default time to live of the entries, zero means no expiration
+	TTL time.Duration
+	// Codec defaults to JSON
+	Codec Codec
+}
+
+// Cache stores values in Redis, keys being formatted with fmt.Sprint
+type Cache[K comparable, V any] struct {
+	client redis.UniversalClient
+	opts   Options
+	loads  xcache.Group[string, V]
+}
+
+var _ xcache.Adder[string, interface{}] = (*Cache[string, interface{}])(nil)
+
+// New creates a cache storing its entries with client
+func New[K comparable, V any](client redis.UniversalClient, opts Options) *Cache[K, V] {
+	if opts.Codec == nil {
+		opts.Codec = JSON
+	}
+	return &Cache[K, V]{client: client, opts: opts}
+}
+
+// Key returns the Redis key of key
+func (c *Cache[K, V]) Key(key K) string {
+	return c.opts.Prefix + fmt.Sprint(key)
+}
+
+// Get returns the value of key, reporting false when it is missing
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (V, bool, error) {
+	var value V
+	data, err := c.client.Get(ctx, c.Key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+	if err := c.opts.Codec.Unmarshal(data, &value); err != nil {
+		return value, false, fmt.Errorf("cannot decode cached value of %s: %w", c.Key(key), err)
+	}
+	return value, true, nil
+}
+
+// getTTL is Get also returning the remaining time to live of key, zero when
+// it does not expire
+func (c *Cache[K, V]) getTTL(ctx context.Context, key K) (V, time.Duration, bool, error) {
+	var value V
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	// The error of the pipeline is the one of its first failed command
+	_, _ = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, c.Key(key))
+		pttl = pipe.PTTL(ctx, c.Key(key))
+		return nil
+	})
+	data, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return value, 0, false, nil
+	}
+	if err != nil {
+		return value, 0, false, err
+	}
+	if err := c.opts.Codec.Unmarshal(data, &value); err != nil {
+		return value, 0, false, fmt.Errorf("cannot decode cached value of %s: %w", c.Key(key), err)
+	}
+	// PTTL fails when the key expired in between and is negative without expiration
+	ttl, err := pttl.Result()
+	if err != nil || ttl < 0 {
+		ttl = 0
+	}
+	return value, ttl, true, nil
+}
+
+// Set stores value for ttl, see xcache.DefaultTTL and xcache.NoExpiration
+func (c *Cache[K, V]) Set(ctx context.Context, key K, value V, ttl time.Duration) error {
+	data, err := c.opts.Codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode value of %s: %w", c.Key(key), err)
+	}
+	return c.client.Set(ctx, c.Key(key), data, c.expiration(ttl)).Err()
+}
+
+// Add stores value for ttl unless key is present, with SET NX
+func (c *Cache[K, V]) Add(ctx context.Context, key K, value V, ttl time.Duration) (bool, error) {
+	data, err := c.opts.Codec.Marshal(value)
+	if err != nil {
+		return false, fmt.Errorf("cannot encode value of %s: %w", c.Key(key), err)
+	}
+	return c.client.SetNX(ctx, c.Key(key), data, c.expiration(ttl)).Result()
+}
+
+// Delete removes key
+func (c *Cache[K, V]) Delete(ctx context.Context, key K) error {
+	return c.client.Del(ctx, c.Key(key)).Err()
+}
+
+// GetOrLoad returns the value of key, calling loader and storing its result
+// with the default TTL on a miss. Redis failures are not errors, the value
+// is loaded instead so an unavailable cache does not fail the reads.
+// Concurrent misses of a key within the process share a single loader call,
+// run with the context of the first caller.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, loader xcache.Loader[K, V]) (V, error) {
+	value, ok, err := c.Get(ctx, key)
+	if err == nil && ok {
+		return value, nil
+	}
+	value, err, _ = c.loads.Do(c.Key(key), func() (V, error) {
+		value, err := loader(ctx, key)
+		if err == nil {
+			_ = c.Set(ctx, key, value, xcache.DefaultTTL)
+		}
+		return value, err
+	})
+	return value, err
+}
+
+// expiration returns the Redis expiration of ttl, zero meaning none
+func (c *Cache[K, V]) expiration(ttl time.Duration) time.Duration {
+	if ttl == xcache.DefaultTTL {
+		ttl = c.opts.TTL
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
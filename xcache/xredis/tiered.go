@@ -0,0 +1,191 @@
+package xredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/XandaLtd/xutils-go/xcache"
+)
+
+// TieredOptions configures a Tiered cache
+type TieredOptions struct {
+	// Local configures the in-process tier. Its TTL bounds how long an
+	// instance may serve a value written by another one when an
+	// invalidation is lost, eg. while reconnecting.
+	Local xcache.MemoryOptions
+	// Remote configures the Redis tier
+	Remote Options
+	// Channel is the pub/sub channel of the invalidations, defaults to
+	// "xcache:invalidate:" + Remote.Prefix
+	Channel string
+}
+
+// Tiered is a cache keeping values in process in front of Redis. Writes and
+// deletes are published so every instance drops its local copy of the key.
+type Tiered[K comparable, V any] struct {
+	local    *xcache.Memory[string, V]
+	localTTL time.Duration
+	remote   *Cache[K, V]
+	client   redis.UniversalClient
+	channel  string
+	instance string
+	loads    xcache.Group[string, V]
+	pubsub   *redis.PubSub
+	cancel   context.CancelFunc
+	done     chan struct{}
+	once     sync.Once
+}
+
+var _ xcache.Cache[string, interface{}] = (*Tiered[string, interface{}])(nil)
+
+// NewTiered creates a two-tier cache and subscribes to its invalidations.
+// Call Close to unsubscribe.
+func NewTiered[K comparable, V any](client redis.UniversalClient, opts TieredOptions) *Tiered[K, V] {
+	if opts.Channel == "" {
+		opts.Channel = "xcache:invalidate:" + opts.Remote.Prefix
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Tiered[K, V]{
+		local:    xcache.NewMemory[string, V](opts.Local),
+		localTTL: opts.Local.TTL,
+		remote:   New[K, V](client, opts.Remote),
+		client:   client,
+		channel:  opts.Channel,
+		instance: hex.EncodeToString(id),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	c.pubsub = client.Subscribe(ctx, c.channel)
+	go c.listen(ctx)
+	return c
+}
+
+// Get returns the local value of key, else its Redis value which is then
+// kept locally until it expires in Redis, within the TTL of the local tier
+func (c *Tiered[K, V]) Get(ctx context.Context, key K) (V, bool, error) {
+	redisKey := c.remote.Key(key)
+	if value, ok, _ := c.local.Get(ctx, redisKey); ok {
+		return value, true, nil
+	}
+	value, remaining, ok, err := c.remote.getTTL(ctx, key)
+	if err == nil && ok {
+		_ = c.local.Set(ctx, redisKey, value, c.localExpiration(remaining))
+	}
+	return value, ok, err
+}
+
+// Set stores value in both tiers and invalidates the other instances. The
+// local entry expires with the Redis one, within the TTL of the local tier.
+func (c *Tiered[K, V]) Set(ctx context.Context, key K, value V, ttl time.Duration) error {
+	if err := c.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	redisKey := c.remote.Key(key)
+	_ = c.local.Set(ctx, redisKey, value, c.localExpiration(c.remote.expiration(ttl)))
+	return c.publish(ctx, redisKey)
+}
+
+// Delete removes key from both tiers and invalidates the other instances
+func (c *Tiered[K, V]) Delete(ctx context.Context, key K) error {
+	redisKey := c.remote.Key(key)
+	_ = c.local.Delete(ctx, redisKey)
+	if err := c.remote.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.publish(ctx, redisKey)
+}
+
+// GetOrLoad returns the value of key from the local tier, else from Redis,
+// else from loader, storing it in both tiers with their default TTL. Redis
+// failures are not errors, as for Cache.GetOrLoad. Concurrent misses of a
+// key within the process share a single call.
+func (c *Tiered[K, V]) GetOrLoad(ctx context.Context, key K, loader xcache.Loader[K, V]) (V, error) {
+	redisKey := c.remote.Key(key)
+	if value, ok, _ := c.local.Get(ctx, redisKey); ok {
+		return value, nil
+	}
+	value, err, _ := c.loads.Do(redisKey, func() (V, error) {
+		value, remaining, ok, err := c.remote.getTTL(ctx, key)
+		if err == nil && ok {
+			_ = c.local.Set(ctx, redisKey, value, c.localExpiration(remaining))
+			return value, nil
+		}
+		value, err = loader(ctx, key)
+		if err != nil {
+			return value, err
+		}
+		_ = c.remote.Set(ctx, key, value, xcache.DefaultTTL)
+		_ = c.local.Set(ctx, redisKey, value, c.localExpiration(c.remote.expiration(xcache.DefaultTTL)))
+		return value, nil
+	})
+	return value, err
+}
+
+// localExpiration returns the local TTL of an entry expiring in Redis after
+// remaining, zero meaning never: the default TTL of the local tier unless
+// remaining is shorter
+func (c *Tiered[K, V]) localExpiration(remaining time.Duration) time.Duration {
+	if remaining > 0 && (c.localTTL <= 0 || remaining < c.localTTL) {
+		return remaining
+	}
+	return xcache.DefaultTTL
+}
+
+// Local returns the in-process tier, eg. to read its statistics
+func (c *Tiered[K, V]) Local() *xcache.Memory[string, V] {
+	return c.local
+}
+
+// Close unsubscribes from the invalidations and stops the local janitor
+func (c *Tiered[K, V]) Close() error {
+	c.once.Do(func() {
+		c.cancel()
+		// Unblocks the pending Receive
+		_ = c.pubsub.Close()
+		<-c.done
+		_ = c.local.Close()
+	})
+	return nil
+}
+
+// publish sends "<instance> <key>" so the publisher ignores its own message
+func (c *Tiered[K, V]) publish(ctx context.Context, redisKey string) error {
+	return c.client.Publish(ctx, c.channel, c.instance+" "+redisKey).Err()
+}
+
+func (c *Tiered[K, V]) listen(ctx context.Context) {
+	defer close(c.done)
+	for {
+		msg, err := c.pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// pubsub reconnects on the next Receive
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// Invalidations may have been missed while disconnected
+			c.local.Clear()
+		case *redis.Message:
+			instance, redisKey, ok := strings.Cut(msg.Payload, " ")
+			if ok && instance != c.instance {
+				_ = c.local.Delete(ctx, redisKey)
+			}
+		}
+	}
+}
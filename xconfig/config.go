@@ -0,0 +1,433 @@
+// Package xconfig populates configuration structs from defaults, files,
+// environment variables and flags
+package xconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Options tells Load where to read the configuration from. Sources override
+// each other in this order: default tags, Files, environment variables, Args.
+type Options struct {
+	// Files are YAML (.yaml, .yml) or JSON (.json) files, later files override earlier ones
+	Files []string
+	// EnvPrefix is prepended to the derived environment variable names, eg. "APP_"
+	EnvPrefix string
+	// Args are the command line flags, eg. os.Args[1:]. No flags are parsed when nil.
+	Args []string
+	// Context is passed to the secret providers, defaults to context.Background()
+	Context context.Context
+	// Checks validate the loaded configuration, after the validate tags and Validator hooks
+	Checks []Check
+}
+
+// field is a leaf of the configuration struct
+type field struct {
+	path     []string
+	value    reflect.Value
+	env      string
+	flag     string
+	usage    string
+	def      string
+	hasDef   bool
+	required bool
+	rules    string
+	set      bool
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Load populates cfg, a pointer to a struct, driven by its field tags:
+//
+//	config:"name"    the key in files, defaults to the yaml or json tag, else the snake cased field name
+//	env:"NAME"       the environment variable, defaults to EnvPrefix + the upper cased key path joined by "_"
+//	flag:"name"      the flag, defaults to the key path joined by "." with "-" instead of "_"
+//	default:"value"  the value used when no source sets the field
+//	required:"true"  fails when no source sets the field
+//	usage:"text"     the flag usage
+//	validate:"rules" the rules checked once loaded, see xerrors.Validator
+//
+// Nested structs are walked, their key path being prefixed by the field key.
+// String values referencing a secret, eg. vault://secret/db#password, are
+// resolved once every source is applied. See RegisterSecretProvider.
+//
+// The loaded configuration then goes through the Defaulter hooks, the
+// required and validate tags, the Validator hooks and opts.Checks. Every
+// invalid value, missing field and failed rule is reported in a single
+// validation error addressed by key path, eg. db.port; unreadable files and
+// unknown flags give a 500.
+func Load(cfg interface{}, opts Options) error {
+	root := reflect.ValueOf(cfg)
+	if root.Kind() != reflect.Ptr || root.Elem().Kind() != reflect.Struct {
+		return xerrors.NewInternalServerError("xconfig: cfg must be a pointer to a struct")
+	}
+	fields := collectFields(root.Elem(), nil, opts.EnvPrefix)
+
+	var violations xerrors.Violations
+	for _, f := range fields {
+		if !f.value.IsZero() {
+			f.set = true
+		}
+		if f.hasDef && !f.set {
+			if err := setString(f.value, f.def); err != nil {
+				violations.Add(f.key(), "default", err.Error(), f.def)
+				continue
+			}
+			f.set = true
+		}
+	}
+
+	for _, path := range opts.Files {
+		values, err := readFile(path)
+		if err != nil {
+			return xerrors.Wrap(err, http.StatusInternalServerError, fmt.Sprintf("cannot load configuration file %s", path))
+		}
+		for _, f := range fields {
+			raw, ok := lookup(values, f.path)
+			if !ok {
+				continue
+			}
+			if err := setValue(f.value, raw); err != nil {
+				violations.Add(f.key(), "type", fmt.Sprintf("%s in %s", err.Error(), path), raw)
+				continue
+			}
+			f.set = true
+		}
+	}
+
+	for _, f := range fields {
+		raw, ok := os.LookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := setString(f.value, raw); err != nil {
+			violations.Add(f.key(), "type", fmt.Sprintf("%s in %s", err.Error(), f.env), nil)
+			continue
+		}
+		f.set = true
+	}
+
+	if opts.Args != nil {
+		if err := parseFlags(fields, opts.Args, &violations); err != nil {
+			return xerrors.Wrap(err, http.StatusInternalServerError, "invalid command line flags")
+		}
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	resolveSecrets(ctx, fields, &violations)
+
+	nodes := collectStructs(root.Elem(), nil)
+	applyDefaults(nodes)
+	for _, f := range fields {
+		if !f.set && !f.value.IsZero() {
+			// Set by a Defaulter
+			f.set = true
+		}
+		if f.required && !f.set {
+			violations.Addf(f.key(), "required", nil, "%s is required (env %s, flag -%s)", f.key(), f.env, f.flag)
+		}
+	}
+	validateFields(fields, &violations)
+	runValidators(cfg, nodes, opts.Checks, &violations)
+	if err := violations.Err("invalid configuration"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *field) key() string {
+	return strings.Join(f.path, ".")
+}
+
+func collectFields(v reflect.Value, path []string, envPrefix string) []*field {
+	var fields []*field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := keyName(sf)
+		if name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct && !fv.Addr().Type().Implements(textUnmarshalerType) {
+			fields = append(fields, collectFields(fv, fieldPath, envPrefix)...)
+			continue
+		}
+
+		f := &field{path: fieldPath, value: fv, usage: sf.Tag.Get("usage")}
+		f.env = sf.Tag.Get("env")
+		if f.env == "" {
+			f.env = envPrefix + strings.ToUpper(strings.Join(fieldPath, "_"))
+		}
+		f.flag = sf.Tag.Get("flag")
+		if f.flag == "" {
+			f.flag = strings.ReplaceAll(strings.Join(fieldPath, "."), "_", "-")
+		}
+		f.def, f.hasDef = sf.Tag.Lookup("default")
+		f.required, _ = strconv.ParseBool(sf.Tag.Get("required"))
+		f.rules = sf.Tag.Get("validate")
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func keyName(sf reflect.StructField) string {
+	for _, tag := range []string{"config", "yaml", "json"} {
+		if name := strings.SplitN(sf.Tag.Get(tag), ",", 2)[0]; name != "" {
+			return name
+		}
+	}
+	return snakeCase(sf.Name)
+}
+
+// snakeCase converts a Go name, eg. MaxIdleConns or HTTPPort, to max_idle_conns or http_port
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// Numbers are kept as written, eg. 1048576 rather than 1.048576e+06
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		err = fmt.Errorf("unsupported configuration file type %q", filepath.Ext(path))
+	}
+	return values, err
+}
+
+func lookup(values map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = values
+	for _, key := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func parseFlags(fields []*field, args []string, violations *xerrors.Violations) error {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	byFlag := make(map[string]*field, len(fields))
+	for _, f := range fields {
+		byFlag[f.flag] = f
+		fs.Var(&flagValue{text: f.def, isBool: f.value.Kind() == reflect.Bool}, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		f := byFlag[fl.Name]
+		if err := setString(f.value, fl.Value.String()); err != nil {
+			violations.Add(f.key(), "type", fmt.Sprintf("%s in flag -%s", err.Error(), fl.Name), nil)
+			return
+		}
+		f.set = true
+	})
+	return nil
+}
+
+// setValue sets v from a value decoded from a file
+func setValue(v reflect.Value, raw interface{}) error {
+	switch raw := raw.(type) {
+	case nil:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("cannot use a list for a %s", v.Type())
+		}
+		slice := reflect.MakeSlice(v.Type(), len(raw), len(raw))
+		for i, item := range raw {
+			if err := setValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case map[string]interface{}:
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot use an object for a %s", v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(raw))
+		for key, item := range raw {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(elem, item); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+		return nil
+	case string:
+		return setString(v, raw)
+	case json.Number:
+		return setString(v, raw.String())
+	case bool:
+		return setString(v, strconv.FormatBool(raw))
+	case float64:
+		return setString(v, strconv.FormatFloat(raw, 'f', -1, 64))
+	default:
+		return setString(v, fmt.Sprint(raw))
+	}
+}
+
+// flagValue is the text of a flag, those of bool fields taking no argument
+// as -verbose
+type flagValue struct {
+	text   string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.text
+}
+
+func (v *flagValue) Set(s string) error {
+	v.text = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// setString sets v from its textual representation. Lists are comma
+// separated and maps are written k1=v1,k2=v2.
+func setString(v reflect.Value, s string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(s, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid map entry %q, expected key=value", pair)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setString(elem, strings.TrimSpace(kv[1])); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := setString(elem.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(elem)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
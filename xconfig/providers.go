@@ -0,0 +1,105 @@
+package xconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// VaultProvider reads secrets from the KV version 2 engine of Vault, eg.
+// vault://secret/db#password reads the password key of the db secret of the
+// secret mount
+type VaultProvider struct {
+	// Address of Vault, defaults to VAULT_ADDR
+	Address string
+	// Token used to authenticate, defaults to VAULT_TOKEN
+	Token string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Resolve reads the secret at ref.Path, the first path segment being the
+// mount. The key is required when the secret has several keys.
+func (p VaultProvider) Resolve(ctx context.Context, ref SecretRef) (string, error) {
+	address, token := p.Address, p.Token
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	parts := strings.SplitN(strings.Trim(ref.Path, "/"), "/", 2)
+	if address == "" || len(parts) != 2 {
+		return "", fmt.Errorf("vault address and a mount/path reference are required")
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(address, "/"), parts[0], parts[1])
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", xerrors.NewRestErrorFromResponse(resp)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	values := body.Data.Data
+	key := ref.Key
+	if key == "" && len(values) == 1 {
+		for only := range values {
+			key = only
+		}
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// AWSSecretsManager returns a provider for aws-sm://name#key references,
+// getSecret returning the SecretString of a secret. Keeping the SDK call on
+// the caller side avoids depending on the AWS SDK, eg.
+//
+//	xconfig.RegisterSecretProvider("aws-sm", xconfig.AWSSecretsManager(
+//		func(ctx context.Context, name string) (string, error) {
+//			out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &name})
+//			if err != nil {
+//				return "", err
+//			}
+//			return aws.ToString(out.SecretString), nil
+//		}))
+func AWSSecretsManager(getSecret func(ctx context.Context, name string) (string, error)) SecretProvider {
+	return SecretProviderFunc(func(ctx context.Context, ref SecretRef) (string, error) {
+		secret, err := getSecret(ctx, ref.Path)
+		if err != nil {
+			return "", err
+		}
+		return SecretKey(secret, ref.Key)
+	})
+}
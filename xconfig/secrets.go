@@ -0,0 +1,146 @@
+package xconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// SecretRef is a reference to a secret, eg. vault://secret/db#password is
+// {Scheme: "vault", Path: "secret/db", Key: "password"}
+type SecretRef struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+// SecretProvider resolves the secret references of a scheme
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref SecretRef) (string, error)
+}
+
+// SecretProviderFunc adapts a function to a SecretProvider
+type SecretProviderFunc func(ctx context.Context, ref SecretRef) (string, error)
+
+// Resolve calls f
+func (f SecretProviderFunc) Resolve(ctx context.Context, ref SecretRef) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = make(map[string]SecretProvider)
+)
+
+// RegisterSecretProvider makes Load resolve the string values starting with
+// scheme:// with provider. Values of unregistered schemes, eg. https://, are
+// kept as they are.
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[scheme] = provider
+}
+
+// FlushSecretProviders removes every registered secret provider
+func FlushSecretProviders() {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders = make(map[string]SecretProvider)
+}
+
+// ParseSecretRef parses scheme://path#key, the key being optional
+func ParseSecretRef(value string) (SecretRef, bool) {
+	sep := strings.Index(value, "://")
+	if sep <= 0 {
+		return SecretRef{}, false
+	}
+	ref := SecretRef{Scheme: value[:sep], Path: value[sep+3:]}
+	if hash := strings.LastIndexByte(ref.Path, '#'); hash >= 0 {
+		ref.Path, ref.Key = ref.Path[:hash], ref.Path[hash+1:]
+	}
+	return ref, ref.Path != ""
+}
+
+// String returns the reference as scheme://path#key
+func (r SecretRef) String() string {
+	if r.Key == "" {
+		return r.Scheme + "://" + r.Path
+	}
+	return r.Scheme + "://" + r.Path + "#" + r.Key
+}
+
+// SecretKey extracts key from a secret stored as a JSON object, returning
+// the secret itself when key is empty
+func SecretKey(secret string, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot read key %q", key)
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func secretProvider(scheme string) SecretProvider {
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	return secretProviders[scheme]
+}
+
+// resolveSecrets replaces the secret references held by string fields, and
+// by the elements of string slices and maps. Resolved values are never
+// reported in violations.
+func resolveSecrets(ctx context.Context, fields []*field, violations *xerrors.Violations) {
+	for _, f := range fields {
+		resolve := func(value string) (string, bool) {
+			ref, ok := ParseSecretRef(value)
+			if !ok {
+				return value, true
+			}
+			provider := secretProvider(ref.Scheme)
+			if provider == nil {
+				return value, true
+			}
+			secret, err := provider.Resolve(ctx, ref)
+			if err != nil {
+				violations.Add(f.key(), "secret", fmt.Sprintf("cannot resolve %s: %s", ref, err.Error()), nil)
+				return value, false
+			}
+			return secret, true
+		}
+
+		v := f.value
+		switch {
+		case v.Kind() == reflect.String:
+			if secret, ok := resolve(v.String()); ok {
+				v.SetString(secret)
+			}
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+			for i := 0; i < v.Len(); i++ {
+				if secret, ok := resolve(v.Index(i).String()); ok {
+					v.Index(i).SetString(secret)
+				}
+			}
+		case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.String:
+			iter := v.MapRange()
+			for iter.Next() {
+				if secret, ok := resolve(iter.Value().String()); ok {
+					v.SetMapIndex(iter.Key(), reflect.ValueOf(secret).Convert(v.Type().Elem()))
+				}
+			}
+		}
+	}
+}
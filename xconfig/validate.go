@@ -0,0 +1,110 @@
+package xconfig
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Defaulter is implemented by configuration structs, or their nested
+// structs, computing defaults from other loaded values, eg. a read timeout
+// defaulting to the overall timeout
+type Defaulter interface {
+	SetDefaults()
+}
+
+// Validator is implemented by configuration structs, or their nested
+// structs, checking rules across fields. Field names are relative to the
+// struct, Load prefixes them with its key path.
+type Validator interface {
+	Validate(violations *xerrors.Violations)
+}
+
+// Check is a validation run on the whole configuration once loaded
+type Check func(cfg interface{}, violations *xerrors.Violations)
+
+// node is a struct of the configuration, the root having an empty path
+type node struct {
+	path  []string
+	value reflect.Value
+}
+
+// collectStructs lists the structs of v, nested ones first
+func collectStructs(v reflect.Value, path []string) []node {
+	var nodes []node
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if sf.PkgPath != "" || fv.Kind() != reflect.Struct || fv.Addr().Type().Implements(textUnmarshalerType) {
+			continue
+		}
+		name := keyName(sf)
+		if name == "-" {
+			continue
+		}
+		nodes = append(nodes, collectStructs(fv, append(append([]string(nil), path...), name))...)
+	}
+	return append(nodes, node{path: path, value: v})
+}
+
+// applyDefaults calls the Defaulter hooks, nested structs first
+func applyDefaults(nodes []node) {
+	for _, n := range nodes {
+		if defaulter, ok := n.value.Addr().Interface().(Defaulter); ok {
+			defaulter.SetDefaults()
+		}
+	}
+}
+
+// validateFields checks the validate tags of the fields, eg. validate:"min=1,max=65535",
+// with the rules of xerrors.Validator
+func validateFields(fields []*field, violations *xerrors.Violations) {
+	for _, f := range fields {
+		if f.rules == "" {
+			continue
+		}
+		err := xerrors.Validator().Var(f.value.Interface(), f.rules)
+		if err == nil {
+			continue
+		}
+		var fieldErrors validator.ValidationErrors
+		if !errors.As(err, &fieldErrors) {
+			violations.Add(f.key(), "validate", err.Error(), nil)
+			continue
+		}
+		for _, fieldError := range fieldErrors {
+			violations.Add(f.key(), fieldError.Tag(), f.key()+" "+xerrors.ValidationMessage(fieldError.Tag(), fieldError.Param()), nil)
+		}
+	}
+}
+
+// runValidators calls the Validator hooks, nested structs first, then checks
+func runValidators(cfg interface{}, nodes []node, checks []Check, violations *xerrors.Violations) {
+	for _, n := range nodes {
+		validator, ok := n.value.Addr().Interface().(Validator)
+		if !ok {
+			continue
+		}
+		from := len(*violations)
+		validator.Validate(violations)
+		if len(n.path) == 0 {
+			continue
+		}
+		prefix := strings.Join(n.path, ".")
+		for i := from; i < len(*violations); i++ {
+			if field := (*violations)[i].Field; field != "" {
+				(*violations)[i].Field = prefix + "." + field
+			} else {
+				(*violations)[i].Field = prefix
+			}
+		}
+	}
+	for _, check := range checks {
+		check(cfg, violations)
+	}
+}
@@ -0,0 +1,303 @@
+package xdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// MigrateOptions configures a Migrator
+type MigrateOptions struct {
+	// Dir is the directory of the migration files in the file system, "."
+	// by default
+	Dir string
+	// Table records the applied versions, schema_migrations by default
+	Table string
+}
+
+// Migration is a versioned schema change, read from the files
+// <version>_<name>.up.sql and, optionally, <version>_<name>.down.sql, eg.
+// 0001_create_users.up.sql
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migrator applies and reverts the migrations of a file system, each in a
+// transaction with the record of its version. Instances migrating at once
+// are serialized with an advisory lock on Postgres and MySQL. MySQL commits
+// the DDL statements implicitly, so a failed migration may be half applied.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	table      string
+	migrations []Migration
+}
+
+// NewMigrator reads the migrations of fsys
+func NewMigrator(db *sql.DB, fsys fs.FS, opts MigrateOptions) (*Migrator, error) {
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	if opts.Table == "" {
+		opts.Table = "schema_migrations"
+	}
+	entries, err := fs.ReadDir(fsys, opts.Dir)
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot read the migrations")
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, xerrors.Wrap(err, http.StatusInternalServerError, "invalid migration version "+entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(opts.Dir, entry.Name()))
+		if err != nil {
+			return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot read the migration "+entry.Name())
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, xerrors.NewInternalServerError(fmt.Sprintf("duplicate migration version %d: %s and %s", version, migration.Name, match[2]))
+		}
+		if match[3] == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	m := &Migrator{db: db, dialect: dialectOf(db), table: opts.Table}
+	for _, migration := range byVersion {
+		if migration.up == "" {
+			return nil, xerrors.NewInternalServerError(fmt.Sprintf("migration %d_%s has no up file", migration.Version, migration.Name))
+		}
+		m.migrations = append(m.migrations, *migration)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version })
+	return m, nil
+}
+
+// Migrate applies the pending migrations of fsys, eg. at startup
+func Migrate(ctx context.Context, db *sql.DB, fsys fs.FS, opts MigrateOptions) error {
+	m, err := NewMigrator(db, fsys, opts)
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx)
+}
+
+// dialectOf tells the placeholders and locks to use from the driver of db
+func dialectOf(db *sql.DB) string {
+	d := db.Driver()
+	if logged, ok := d.(*loggedDriver); ok {
+		d = logged.parent
+	}
+	driver := fmt.Sprintf("%T", d)
+	switch {
+	case strings.Contains(driver, "mysql"):
+		return "mysql"
+	case strings.Contains(driver, "stdlib"), strings.Contains(driver, "pq."), strings.Contains(driver, "pgx"):
+		return "postgres"
+	}
+	return ""
+}
+
+// Migrations returns the migrations read, by version
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Version returns the last version applied, 0 when none
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, xerrors.FromDBError(err)
+	}
+	defer conn.Close()
+	applied, err := m.applied(ctx, conn)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1], nil
+}
+
+// Up applies the migrations not applied yet, by version
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		done := make(map[int64]bool, len(applied))
+		for _, version := range applied {
+			done[version] = true
+		}
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the last steps migrations applied
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		byVersion := make(map[int64]Migration, len(m.migrations))
+		for _, migration := range m.migrations {
+			byVersion[migration.Version] = migration
+		}
+		for i := len(applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			migration, ok := byVersion[applied[i]]
+			if !ok {
+				return xerrors.NewInternalServerError(fmt.Sprintf("migration %d is applied but unknown", applied[i]))
+			}
+			if migration.down == "" {
+				return xerrors.NewInternalServerError(fmt.Sprintf("migration %d_%s has no down file", migration.Version, migration.Name))
+			}
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// locked runs f on a connection holding the migration lock
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return xerrors.FromDBError(err)
+	}
+	defer conn.Close()
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(m.table))
+	key := int64(hash.Sum64())
+	switch m.dialect {
+	case "postgres":
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot lock the migrations")
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+	case "mysql":
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", m.table).Scan(&locked); err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot lock the migrations")
+		}
+		if locked.Int64 != 1 {
+			return xerrors.NewServiceUnavailableError("cannot lock the migrations")
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", m.table)
+	}
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table+
+		" (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot create the table "+m.table)
+	}
+	return f(conn)
+}
+
+// applied returns the versions applied, in order
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) ([]int64, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM "+m.table+" ORDER BY version")
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot read the applied migrations")
+	}
+	defer rows.Close()
+	var versions []int64
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, xerrors.FromDBError(err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.FromDBError(err)
+	}
+	return versions, nil
+}
+
+// run applies or reverts migration in a transaction with its record
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	direction, script := "up", migration.up
+	record := "INSERT INTO " + m.table + " (version, name) VALUES (" + placeholder(m.dialect, 1) + ", " + placeholder(m.dialect, 2) + ")"
+	args := []interface{}{migration.Version, migration.Name}
+	if !up {
+		direction, script = "down", migration.down
+		record = "DELETE FROM " + m.table + " WHERE version = " + placeholder(m.dialect, 1)
+		args = args[:1]
+	}
+
+	started := time.Now()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.FromDBError(err)
+	}
+	if strings.TrimSpace(script) != "" {
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			_ = tx.Rollback()
+			return xerrors.Wrap(err, http.StatusInternalServerError,
+				fmt.Sprintf("migration %d_%s %s failed", migration.Version, migration.Name, direction))
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return xerrors.FromDBError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.FromDBError(err)
+	}
+	xlogger.Info("xdb: migration "+direction,
+		zap.Int64("version", migration.Version),
+		zap.String("name", migration.Name),
+		zap.String("duration", time.Since(started).String()),
+	)
+	return nil
+}
+
+// placeholder returns the n-th query parameter of dialect
+func placeholder(dialect string, n int) string {
+	if dialect == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
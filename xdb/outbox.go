@@ -0,0 +1,321 @@
+package xdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// OutboxEvent is an event written to the outbox with the business data and
+// published later by a Relay
+type OutboxEvent struct {
+	// ID is set by the database
+	ID      int64
+	Topic   string
+	Key     string
+	Payload []byte
+	Headers map[string]string
+	// CreatedAt and Attempts are set when read by a Relay
+	CreatedAt time.Time
+	Attempts  int
+}
+
+// OutboxPublisher publishes the events of the outbox to a message bus
+type OutboxPublisher interface {
+	Publish(ctx context.Context, event OutboxEvent) error
+}
+
+// OutboxPublisherFunc is a function publishing the events of the outbox
+type OutboxPublisherFunc func(ctx context.Context, event OutboxEvent) error
+
+// Publish calls f
+func (f OutboxPublisherFunc) Publish(ctx context.Context, event OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// OutboxOptions configures an Outbox
+type OutboxOptions struct {
+	// Table defaults to outbox
+	Table string
+}
+
+// Outbox writes events in the transactions of the business data, so they
+// are published if and only if the data is committed, on Postgres or MySQL 8
+// with parseTime=true
+type Outbox struct {
+	db      *sql.DB
+	dialect string
+	table   string
+}
+
+// NewOutbox creates the outbox of db
+func NewOutbox(db *sql.DB, opts OutboxOptions) *Outbox {
+	if opts.Table == "" {
+		opts.Table = "outbox"
+	}
+	return &Outbox{db: db, dialect: dialectOf(db), table: opts.Table}
+}
+
+// Schema returns the statements creating the outbox table, eg. to copy in a
+// migration file
+func (o *Outbox) Schema() string {
+	if o.dialect == "mysql" {
+		return "CREATE TABLE IF NOT EXISTS " + o.table + ` (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	msg_key VARCHAR(255) NOT NULL,
+	payload LONGBLOB NOT NULL,
+	headers TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NULL,
+	INDEX ` + o.table + `_pending (published_at, id)
+);
+`
+	}
+	return "CREATE TABLE IF NOT EXISTS " + o.table + ` (
+	id BIGSERIAL PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	msg_key VARCHAR(255) NOT NULL,
+	payload BYTEA NOT NULL,
+	headers TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS ` + o.table + `_pending ON ` + o.table + ` (id) WHERE published_at IS NULL;
+`
+}
+
+// CreateTable creates the outbox table when it does not exist. MySQL needs
+// multiStatements=true in the DSN.
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	if _, err := o.db.ExecContext(ctx, o.Schema()); err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot create the table "+o.table)
+	}
+	return nil
+}
+
+// EnqueueInTx writes events in tx, eg. within WithTx:
+//
+//	err := xdb.WithTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+//		if _, err := tx.ExecContext(ctx, "INSERT INTO orders (id, total) VALUES ($1, $2)", order.ID, order.Total); err != nil {
+//			return err
+//		}
+//		return outbox.EnqueueInTx(ctx, tx, xdb.OutboxEvent{Topic: "orders.created", Key: order.ID, Payload: payload})
+//	})
+func (o *Outbox) EnqueueInTx(ctx context.Context, tx *sql.Tx, events ...OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(events))
+	args := make([]interface{}, 0, 4*len(events))
+	for _, event := range events {
+		if event.Topic == "" {
+			return xerrors.NewBadRequestError("an outbox event needs a topic")
+		}
+		headers, err := json.Marshal(event.Headers)
+		if err != nil {
+			return xerrors.Wrap(err, http.StatusBadRequest, "invalid outbox event headers")
+		}
+		if event.Payload == nil {
+			event.Payload = []byte{}
+		}
+		n := len(args)
+		placeholders = append(placeholders, "("+placeholder(o.dialect, n+1)+", "+placeholder(o.dialect, n+2)+", "+
+			placeholder(o.dialect, n+3)+", "+placeholder(o.dialect, n+4)+")")
+		args = append(args, event.Topic, event.Key, event.Payload, string(headers))
+	}
+	query := "INSERT INTO " + o.table + " (topic, msg_key, payload, headers) VALUES " + strings.Join(placeholders, ", ")
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return xerrors.FromDBError(err)
+	}
+	return nil
+}
+
+// RelayOptions configures a Relay
+type RelayOptions struct {
+	// Interval between the polls of the outbox, 1s by default. A full batch
+	// is followed by the next one at once.
+	Interval time.Duration
+	// BatchSize is the number of events published per transaction, 100 by
+	// default
+	BatchSize int
+	// Retention is how long the published events are kept, 24h by default
+	Retention time.Duration
+	// CleanupInterval between the deletions of the events older than the
+	// Retention, 1h by default
+	CleanupInterval time.Duration
+}
+
+// Relay publishes the pending events of an outbox, in order, at least once:
+// an event published but not yet marked so when the relay stops is published
+// again. A failed event stops its batch and is retried at the next poll.
+// Several relays can run at once, the batches being locked with SKIP LOCKED.
+type Relay struct {
+	outbox    *Outbox
+	publisher OutboxPublisher
+	opts      RelayOptions
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// Relay creates a relay publishing the events with publisher
+func (o *Outbox) Relay(publisher OutboxPublisher, opts RelayOptions) *Relay {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Hour
+	}
+	return &Relay{outbox: o, publisher: publisher, opts: opts}
+}
+
+// Start relays the events until ctx is done or Stop is called
+func (r *Relay) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.stopped = make(chan struct{})
+	go r.loop(ctx, r.stopped)
+}
+
+// Stop stops relaying, after the batch being published
+func (r *Relay) Stop() {
+	r.mu.Lock()
+	cancel, stopped := r.cancel, r.stopped
+	r.cancel = nil
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-stopped
+	}
+}
+
+func (r *Relay) loop(ctx context.Context, stopped chan struct{}) {
+	defer close(stopped)
+	var cleaned time.Time
+	for {
+		published, err := r.Publish(ctx)
+		if err != nil && ctx.Err() == nil {
+			xlogger.Warning("xdb: outbox relay failed", zap.String("table", r.outbox.table), zap.String("error", err.Error()))
+		}
+		if time.Since(cleaned) >= r.opts.CleanupInterval {
+			cleaned = time.Now()
+			if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				xlogger.Warning("xdb: outbox cleanup failed", zap.String("table", r.outbox.table), zap.String("error", err.Error()))
+			}
+		}
+
+		wait := r.opts.Interval
+		if err == nil && published == r.opts.BatchSize {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Publish publishes a batch of pending events and returns the number of
+// events published
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	o := r.outbox
+	published := 0
+	err := WithTx(ctx, o.db, func(ctx context.Context, tx *sql.Tx) error {
+		published = 0
+		events, err := r.pending(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := r.publisher.Publish(ctx, event); err != nil {
+				xlogger.Warning("xdb: outbox event not published",
+					zap.String("table", o.table),
+					zap.Int64("id", event.ID),
+					zap.String("topic", event.Topic),
+					zap.Int("attempts", event.Attempts+1),
+					zap.String("error", err.Error()),
+				)
+				_, err = tx.ExecContext(ctx, "UPDATE "+o.table+" SET attempts = attempts + 1, last_error = "+
+					placeholder(o.dialect, 1)+" WHERE id = "+placeholder(o.dialect, 2), err.Error(), event.ID)
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "UPDATE "+o.table+" SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1 WHERE id = "+
+				placeholder(o.dialect, 1), event.ID)
+			if err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	return published, err
+}
+
+// pending locks and reads a batch of pending events
+func (r *Relay) pending(ctx context.Context, tx *sql.Tx) ([]OutboxEvent, error) {
+	o := r.outbox
+	rows, err := tx.QueryContext(ctx, "SELECT id, topic, msg_key, payload, headers, created_at, attempts FROM "+o.table+
+		" WHERE published_at IS NULL ORDER BY id LIMIT "+strconv.Itoa(r.opts.BatchSize)+" FOR UPDATE SKIP LOCKED")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []OutboxEvent
+	for rows.Next() {
+		var event OutboxEvent
+		var headers string
+		if err := rows.Scan(&event.ID, &event.Topic, &event.Key, &event.Payload, &headers, &event.CreatedAt, &event.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(headers), &event.Headers); err != nil {
+			return nil, xerrors.Wrap(err, http.StatusInternalServerError, "invalid headers of the outbox event "+strconv.FormatInt(event.ID, 10))
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Cleanup deletes the events published before the Retention and returns
+// their number
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	o := r.outbox
+	cutoff := "CURRENT_TIMESTAMP - " + placeholder(o.dialect, 1) + " * INTERVAL '1 second'"
+	if o.dialect == "mysql" {
+		cutoff = "CURRENT_TIMESTAMP - INTERVAL ? SECOND"
+	}
+	result, err := o.db.ExecContext(ctx, "DELETE FROM "+o.table+" WHERE published_at < "+cutoff, int64(r.opts.Retention.Seconds()))
+	if err != nil {
+		return 0, xerrors.FromDBError(err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,447 @@
+package xdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmetrics"
+	"github.com/XandaLtd/xutils-go/xrest"
+)
+
+// QueryLogOptions configures WrapConnector
+type QueryLogOptions struct {
+	// SlowThreshold is the duration above which the queries are logged as
+	// warnings with slow=true, 200ms by default. The other queries are
+	// logged at the debug level.
+	SlowThreshold time.Duration
+	// Redact returns the logged form of an argument, RedactArg by default
+	Redact func(arg interface{}) string
+	// Metrics records db_queries_total by query and status and
+	// db_query_duration_seconds by query, xmetrics.Default by default
+	Metrics *xmetrics.Registry
+}
+
+// RedactArg logs the numbers, booleans, times and NULLs as is, and only
+// the length of the strings and bytes, which may hold personal data
+func RedactArg(arg interface{}) string {
+	switch arg := arg.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("<string len=%d>", len(arg))
+	case []byte:
+		return fmt.Sprintf("<bytes len=%d>", len(arg))
+	case time.Time:
+		return arg.Format(time.RFC3339Nano)
+	case int64, float64, bool:
+		return fmt.Sprint(arg)
+	}
+	return fmt.Sprintf("<%T>", arg)
+}
+
+// WrapConnector logs the queries run through connector with their
+// normalized statement, redacted arguments, rows and duration, and records
+// their metrics. Open a database with sql.OpenDB(xdb.WrapConnector(...)),
+// or set Options.QueryLog.
+func WrapConnector(connector driver.Connector, opts QueryLogOptions) driver.Connector {
+	if opts.SlowThreshold <= 0 {
+		opts.SlowThreshold = 200 * time.Millisecond
+	}
+	if opts.Redact == nil {
+		opts.Redact = RedactArg
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = xmetrics.Default
+	}
+	l := &queryLogger{
+		opts:      opts,
+		queries:   opts.Metrics.Counter("db_queries_total", "Database queries, by normalized query and status.", "query", "status"),
+		durations: opts.Metrics.Histogram("db_query_duration_seconds", "Durations of the database queries, by normalized query.", nil, "query"),
+	}
+	return &loggedConnector{parent: connector, logger: l}
+}
+
+type queryLogger struct {
+	opts      QueryLogOptions
+	queries   *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+}
+
+var (
+	whitespace       = regexp.MustCompile(`\s+`)
+	quotedLiteral    = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteral   = regexp.MustCompile(`\$\d+|\b\d+(?:\.\d+)?\b`)
+	repeatedLiterals = regexp.MustCompile(`\?(?:\s*,\s*\?)+`)
+)
+
+// Normalize replaces the literals and placeholders of a statement with ?,
+// collapsing the lists, and its whitespace with single spaces, so the
+// executions of a query share its normalized statement
+func Normalize(statement string) string {
+	statement = quotedLiteral.ReplaceAllString(statement, "?")
+	statement = numericLiteral.ReplaceAllString(statement, "?")
+	statement = repeatedLiterals.ReplaceAllString(statement, "?, ...")
+	return strings.TrimSpace(whitespace.ReplaceAllString(statement, " "))
+}
+
+// log logs and measures a query that started at started
+func (l *queryLogger) log(ctx context.Context, query string, args []driver.NamedValue, rows int64, started time.Time, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	elapsed := time.Since(started)
+	statement := Normalize(query)
+	label := statement
+	if len(label) > 200 {
+		label = label[:200]
+	}
+	status := "ok"
+	if err != nil && !errors.Is(err, io.EOF) {
+		status = "error"
+	}
+	l.queries.WithLabelValues(label, status).Inc()
+	l.durations.WithLabelValues(label).Observe(elapsed.Seconds())
+
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = l.opts.Redact(arg.Value)
+	}
+	tags := []zap.Field{
+		zap.String("statement", statement),
+		zap.Strings("args", redacted),
+		zap.Int64("rows", rows),
+		zap.String("duration", elapsed.String()),
+	}
+	if requestID, ok := ctx.Value(xrest.RequestIDKey).(string); ok && requestID != "" {
+		tags = append(tags, zap.String("request_id", requestID))
+	}
+	switch {
+	case status == "error":
+		xlogger.Warning("xdb: query failed", append(tags, zap.String("error", err.Error()))...)
+	case elapsed > l.opts.SlowThreshold:
+		xlogger.Warning("xdb: slow query", append(tags, zap.Bool("slow", true))...)
+	default:
+		xlogger.Debug("xdb: query", tags...)
+	}
+}
+
+type loggedConnector struct {
+	parent driver.Connector
+	logger *queryLogger
+}
+
+func (c *loggedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.parent.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &loggedConn{parent: conn, logger: c.logger}, nil
+}
+
+func (c *loggedConnector) Driver() driver.Driver {
+	return &loggedDriver{parent: c.parent.Driver(), logger: c.logger}
+}
+
+type loggedDriver struct {
+	parent driver.Driver
+	logger *queryLogger
+}
+
+func (d *loggedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &loggedConn{parent: conn, logger: d.logger}, nil
+}
+
+// loggedConn forwards the optional interfaces of the driver connection,
+// falling back to what database/sql does without them
+type loggedConn struct {
+	parent driver.Conn
+	logger *queryLogger
+}
+
+func (c *loggedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *loggedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.parent.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.parent.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logged := &loggedStmt{parent: stmt, conn: c, query: query, logger: c.logger}
+	if _, ok := stmt.(driver.ColumnConverter); ok {
+		return &loggedConverterStmt{logged}, nil
+	}
+	return logged, nil
+}
+
+func (c *loggedConn) Close() error {
+	return c.parent.Close()
+}
+
+func (c *loggedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *loggedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.parent.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("xdb: the driver does not support transaction options")
+	}
+	return c.parent.Begin()
+}
+
+func (c *loggedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.parent.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	started := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	c.logger.log(ctx, query, args, rowsAffected(result, err), started, err)
+	return result, err
+}
+
+func (c *loggedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.parent.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	started := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		c.logger.log(ctx, query, args, 0, started, err)
+		return nil, err
+	}
+	return &loggedRows{parent: rows, ctx: ctx, query: query, args: args, started: started, logger: c.logger}, nil
+}
+
+func (c *loggedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.parent.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *loggedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.parent.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *loggedConn) IsValid() bool {
+	if validator, ok := c.parent.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *loggedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.parent.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type loggedStmt struct {
+	parent driver.Stmt
+	// conn checks the arguments when parent does not
+	conn   *loggedConn
+	query  string
+	logger *queryLogger
+}
+
+func (s *loggedStmt) Close() error {
+	return s.parent.Close()
+}
+
+func (s *loggedStmt) NumInput() int {
+	return s.parent.NumInput()
+}
+
+func (s *loggedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *loggedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	started := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.parent.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.parent.Exec(values(args))
+	}
+	s.logger.log(ctx, s.query, args, rowsAffected(result, err), started, err)
+	return result, err
+}
+
+func (s *loggedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *loggedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	started := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.parent.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.parent.Query(values(args))
+	}
+	if err != nil {
+		s.logger.log(ctx, s.query, args, 0, started, err)
+		return nil, err
+	}
+	return &loggedRows{parent: rows, ctx: ctx, query: s.query, args: args, started: started, logger: s.logger}, nil
+}
+
+// CheckNamedValue asks the connection when the statement has no checker, as
+// database/sql does not once a statement has one
+func (s *loggedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.parent.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return s.conn.CheckNamedValue(value)
+}
+
+// loggedConverterStmt is a loggedStmt whose parent converts its arguments
+type loggedConverterStmt struct {
+	*loggedStmt
+}
+
+func (s *loggedConverterStmt) ColumnConverter(index int) driver.ValueConverter {
+	return s.parent.(driver.ColumnConverter).ColumnConverter(index)
+}
+
+// loggedRows counts the rows read and logs the query when closed
+type loggedRows struct {
+	parent  driver.Rows
+	ctx     context.Context
+	query   string
+	args    []driver.NamedValue
+	started time.Time
+	logger  *queryLogger
+	rows    int64
+	err     error
+}
+
+func (r *loggedRows) Columns() []string {
+	return r.parent.Columns()
+}
+
+func (r *loggedRows) Next(dest []driver.Value) error {
+	err := r.parent.Next(dest)
+	switch {
+	case err == nil:
+		r.rows++
+	case !errors.Is(err, io.EOF):
+		r.err = err
+	}
+	return err
+}
+
+func (r *loggedRows) Close() error {
+	err := r.parent.Close()
+	r.logger.log(r.ctx, r.query, r.args, r.rows, r.started, r.err)
+	return err
+}
+
+func (r *loggedRows) HasNextResultSet() bool {
+	if sets, ok := r.parent.(driver.RowsNextResultSet); ok {
+		return sets.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *loggedRows) NextResultSet() error {
+	if sets, ok := r.parent.(driver.RowsNextResultSet); ok {
+		return sets.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *loggedRows) ColumnTypeScanType(index int) reflect.Type {
+	if types, ok := r.parent.(driver.RowsColumnTypeScanType); ok {
+		return types.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *loggedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if types, ok := r.parent.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return types.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *loggedRows) ColumnTypeLength(index int) (int64, bool) {
+	if types, ok := r.parent.(driver.RowsColumnTypeLength); ok {
+		return types.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *loggedRows) ColumnTypeNullable(index int) (bool, bool) {
+	if types, ok := r.parent.(driver.RowsColumnTypeNullable); ok {
+		return types.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *loggedRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if types, ok := r.parent.(driver.RowsColumnTypePrecisionScale); ok {
+		return types.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func rowsAffected(result driver.Result, err error) int64 {
+	if err != nil || result == nil {
+		return 0
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return rows
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	plain := make([]driver.Value, len(args))
+	for i, arg := range args {
+		plain[i] = arg.Value
+	}
+	return plain
+}
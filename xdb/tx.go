@@ -0,0 +1,111 @@
+package xdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// TxOptions configures WithTxOptions
+type TxOptions struct {
+	// Isolation defaults to the one of the database
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts is the maximum number of attempts, including the first
+	// one, 3 by default
+	MaxAttempts int
+	// Backoff is the wait before the first retry, 50ms by default. It
+	// doubles after every attempt, with jitter, up to MaxBackoff, 1s by
+	// default.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// WithTx runs fn in a transaction of db, committed when fn returns nil and
+// rolled back when it returns an error or panics, the panic becoming a 500.
+// Serialization failures and deadlocks, of fn or of the commit, retry the
+// whole transaction, so fn must not have other side effects. Errors are
+// translated with xerrors.FromDBError:
+//
+//	err := xdb.WithTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+//		if _, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - $1 WHERE id = $2", amount, from); err != nil {
+//			return err
+//		}
+//		_, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + $1 WHERE id = $2", amount, to)
+//		return err
+//	})
+func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return WithTxOptions(ctx, db, TxOptions{}, fn)
+}
+
+// WithTxOptions is WithTx with options
+func WithTxOptions(ctx context.Context, db *sql.DB, opts TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 50 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Second
+	}
+
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}, fn)
+		if err == nil || attempt >= opts.MaxAttempts || !IsSerializationFailure(err) {
+			return xerrors.FromDBError(err)
+		}
+		xlogger.Warning("xdb: transaction conflict, retrying", zap.Int("attempt", attempt), zap.String("error", err.Error()))
+
+		// Jitter between half and the full backoff to spread the retries of
+		// the conflicting transactions
+		half := backoff / 2
+		timer := time.NewTimer(half + time.Duration(rand.Int63n(int64(half)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return xerrors.FromDBError(err)
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, opts.MaxBackoff)
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			_ = tx.Rollback()
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	if err := fn(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsSerializationFailure reports whether err is a serialization failure or
+// a deadlock, after which the transaction can be retried
+func IsSerializationFailure(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		state := pgErr.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1213
+}
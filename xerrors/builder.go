@@ -0,0 +1,84 @@
+package xerrors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Builder constructs errors step by step, eg.
+//
+//	xerrors.New().Status(409).Code("ORDER_ALREADY_PAID").Message("order already paid").Detail("order_id", id).Build()
+type Builder struct {
+	status    int
+	code      string
+	message   string
+	details   map[string]interface{}
+	cause     error
+	severity  Severity
+	retryable *bool
+}
+
+// New starts building an error. The status defaults to 500 and the message to
+// the default message of the status.
+func New() *Builder {
+	return &Builder{status: http.StatusInternalServerError}
+}
+
+func (b *Builder) Status(status int) *Builder {
+	b.status = status
+	return b
+}
+
+func (b *Builder) Code(code string) *Builder {
+	b.code = code
+	return b
+}
+
+func (b *Builder) Message(message string) *Builder {
+	b.message = message
+	return b
+}
+
+func (b *Builder) Messagef(format string, args ...interface{}) *Builder {
+	b.message = fmt.Sprintf(format, args...)
+	return b
+}
+
+func (b *Builder) Detail(key string, value interface{}) *Builder {
+	if b.details == nil {
+		b.details = make(map[string]interface{})
+	}
+	b.details[key] = value
+	return b
+}
+
+func (b *Builder) Cause(err error) *Builder {
+	b.cause = err
+	return b
+}
+
+func (b *Builder) Severity(severity Severity) *Builder {
+	b.severity = severity
+	return b
+}
+
+func (b *Builder) Retryable(retryable bool) *Builder {
+	b.retryable = &retryable
+	return b
+}
+
+// Build returns the error. The builder can be reused to build other errors.
+func (b *Builder) Build() RestErr {
+	message := b.message
+	if message == "" {
+		message = defaultMessage(b.status)
+	}
+	e := newRestErr(b.status, b.code, message, b.cause)
+	e.ErrDetails = copyDetails(b.details)
+	e.severity = b.severity
+	if b.retryable != nil {
+		retryable := *b.retryable
+		e.ErrRetryable = &retryable
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package xerrors
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Template defines an error once so every team instantiates it with the same
+// code and status
+type Template struct {
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	// Message may reference params with {name} placeholders, eg. "order {order_id} is already paid"
+	Message string `json:"message"`
+	// Description documents when the error happens, for the generated error reference
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = make(map[string]Template)
+)
+
+// Define registers a template in the catalog and returns it. It panics when
+// the code is empty or already defined, as templates are meant to be defined
+// once at init time.
+func Define(t Template) Template {
+	if t.Code == "" {
+		panic("xerrors: cannot define a template without a code")
+	}
+	if t.Status == 0 {
+		t.Status = http.StatusInternalServerError
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if _, ok := catalog[t.Code]; ok {
+		panic(fmt.Sprintf("xerrors: template %s is already defined", t.Code))
+	}
+	catalog[t.Code] = t
+	return t
+}
+
+// Lookup returns the template defined for code
+func Lookup(code string) (Template, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	t, ok := catalog[code]
+	return t, ok
+}
+
+// Catalog returns every defined template sorted by code, eg. to generate an error reference
+func Catalog() []Template {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	templates := make([]Template, 0, len(catalog))
+	for _, t := range catalog {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Code < templates[j].Code })
+	return templates
+}
+
+// FlushCatalog removes every defined template
+func FlushCatalog() {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog = make(map[string]Template)
+}
+
+// New instantiates the template. params fill the placeholders of the message
+// and are attached as details.
+func (t Template) New(params map[string]interface{}) RestErr {
+	e := newRestErr(t.Status, t.Code, t.message(params), nil)
+	e.ErrDetails = copyDetails(params)
+	return e
+}
+
+// FromCode instantiates the template defined for code. An undefined code
+// gives a 500 carrying that code, so the mistake shows up in logs.
+func FromCode(code string, params map[string]interface{}) RestErr {
+	t, ok := Lookup(code)
+	if !ok {
+		return newRestErr(http.StatusInternalServerError, code, defaultMessage(http.StatusInternalServerError), fmt.Errorf("xerrors: undefined error code %s", code))
+	}
+	e := newRestErr(t.Status, t.Code, t.message(params), nil)
+	e.ErrDetails = copyDetails(params)
+	return e
+}
+
+func (t Template) message(params map[string]interface{}) string {
+	if t.Message == "" {
+		return defaultMessage(t.Status)
+	}
+	return expandPlaceholders(t.Message, params)
+}
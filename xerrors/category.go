@@ -0,0 +1,66 @@
+package xerrors
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Category tells who is at fault for an error, to split fault attribution in
+// logs and dashboards
+type Category string
+
+const (
+	// CategoryClient is for requests the client got wrong
+	CategoryClient Category = "client"
+	// CategoryServer is for bugs and failures of the service itself
+	CategoryServer Category = "server"
+	// CategoryNetwork is for failures to reach another host
+	CategoryNetwork Category = "network"
+	// CategoryDependency is for failures of an upstream service or database
+	CategoryDependency Category = "dependency"
+	// CategoryValidation is for invalid input
+	CategoryValidation Category = "validation"
+	// CategoryAuth is for missing or insufficient credentials
+	CategoryAuth Category = "auth"
+)
+
+// WithCategory returns a copy of err with the given category
+func WithCategory(err RestErr, category Category) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.category = category
+	return e
+}
+
+// CategoryOf returns the category of err, as set by WithCategory or else
+// derived from its cause and status: network errors in the chain give
+// network, 401 and 403 auth, 400 and 422 validation, 502, 503 and 504
+// dependency, other 4xx client and anything else server.
+func CategoryOf(err error) Category {
+	var found RestErr
+	if errors.As(err, &found) {
+		if e, ok := found.(*restErr); ok && e.category != "" {
+			return e.category
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return CategoryNetwork
+	}
+
+	switch status := StatusOf(err); {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return CategoryAuth
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return CategoryValidation
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return CategoryDependency
+	case status >= 400 && status < 500:
+		return CategoryClient
+	default:
+		return CategoryServer
+	}
+}
@@ -0,0 +1,76 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+const envDebug = "ERRORS_DEBUG"
+
+var debugMode = getDebugMode()
+
+func getDebugMode() int32 {
+	if enabled, _ := strconv.ParseBool(os.Getenv(envDebug)); enabled {
+		return 1
+	}
+	return 0
+}
+
+// SetDebugMode enables the "causes" member of the JSON body, listing the
+// messages of the wrapped errors innermost first. Only enable it for internal
+// services: causes may expose implementation details. The initial mode is
+// read from ERRORS_DEBUG and defaults to disabled.
+func SetDebugMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&debugMode, v)
+}
+
+func debugEnabled() bool {
+	return atomic.LoadInt32(&debugMode) == 1
+}
+
+// MarshalJSON adds the causes of the error in debug mode. Otherwise the
+// message of 5xx errors is made safe when SetSafeServerMessages is enabled.
+// Extensions of parsed bodies are written back as is.
+func (e *restErr) MarshalJSON() ([]byte, error) {
+	type plain restErr
+	var body []byte
+	var err error
+	switch {
+	case !debugEnabled():
+		body, err = json.Marshal((*plain)(e.public()))
+	case e.cause == nil:
+		body, err = json.Marshal((*plain)(e))
+	default:
+		body, err = json.Marshal(struct {
+			*plain
+			Causes []string `json:"causes,omitempty"`
+		}{(*plain)(e), causesOf(e.cause)})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return appendMembers(body, e.extensions)
+}
+
+// causesOf returns the messages of err and the errors it wraps, innermost first
+func causesOf(err error) []string {
+	var causes []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		if r, ok := err.(RestErr); ok {
+			causes = append(causes, r.Message())
+		} else {
+			causes = append(causes, err.Error())
+		}
+	}
+	for i, j := 0, len(causes)-1; i < j; i, j = i+1, j-1 {
+		causes[i], causes[j] = causes[j], causes[i]
+	}
+	return causes
+}
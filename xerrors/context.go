@@ -0,0 +1,31 @@
+package xerrors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the non standard status, introduced by nginx,
+// of requests the client gave up on before a response was written
+const StatusClientClosedRequest = 499
+
+// FromContextErr maps context.DeadlineExceeded to a retryable 504 and
+// context.Canceled to a non retryable 499, keeping err as the cause. It
+// returns nil when err is not a context error.
+func FromContextErr(err error) RestErr {
+	var e *restErr
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		e = newRestErr(http.StatusGatewayTimeout, "", defaultMessage(http.StatusGatewayTimeout), err)
+		retryable := true
+		e.ErrRetryable = &retryable
+	case errors.Is(err, context.Canceled):
+		e = newRestErr(StatusClientClosedRequest, "", defaultMessage(StatusClientClosedRequest), err)
+		retryable := false
+		e.ErrRetryable = &retryable
+	default:
+		return nil
+	}
+	return e
+}
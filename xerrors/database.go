@@ -0,0 +1,111 @@
+package xerrors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// CodeDuplicate is set on errors caused by a unique constraint violation
+	CodeDuplicate = "DUPLICATE"
+	// CodeInvalidReference is set on errors caused by a foreign key violation
+	CodeInvalidReference = "INVALID_REFERENCE"
+	// CodeConstraintViolation is set on errors caused by a not null or check constraint violation
+	CodeConstraintViolation = "CONSTRAINT_VIOLATION"
+	// CodeTransientDBFailure is set on errors caused by a deadlock, a lock or a statement timeout
+	CodeTransientDBFailure = "TRANSIENT_DB_FAILURE"
+)
+
+type dbErrorKind int
+
+const (
+	dbUnknown dbErrorKind = iota
+	dbDuplicate
+	dbInvalidReference
+	dbConstraint
+	dbTransient
+)
+
+// Postgres SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+var postgresStates = map[string]dbErrorKind{
+	"23505": dbDuplicate,
+	"23503": dbInvalidReference,
+	"23502": dbConstraint,
+	"23514": dbConstraint,
+	"40001": dbTransient, // serialization_failure
+	"40P01": dbTransient, // deadlock_detected
+	"55P03": dbTransient, // lock_not_available
+	"57014": dbTransient, // query_canceled, raised by statement_timeout
+}
+
+// MySQL server error numbers
+var mysqlNumbers = map[int]dbErrorKind{
+	1062: dbDuplicate,
+	1451: dbInvalidReference,
+	1452: dbInvalidReference,
+	1048: dbConstraint,
+	3819: dbConstraint,
+	1205: dbTransient, // lock wait timeout
+	1213: dbTransient, // deadlock
+	3024: dbTransient, // max_execution_time exceeded
+}
+
+var mysqlErrorPattern = regexp.MustCompile(`^Error (\d{4})\b`)
+
+// FromDBError translates a database error into a RestErr keeping it as its
+// cause: sql.ErrNoRows becomes a 404, unique violations a 409, foreign key and
+// other constraint violations a 400, and deadlocks and timeouts a 503 the
+// caller can retry. Postgres errors are recognized through their SQLState
+// method (pgx, lib/pq) and MySQL errors through their "Error NNNN" message, so
+// no driver is imported. Errors already carrying a RestErr are returned as is
+// and anything else becomes a 500. It returns nil when err is nil.
+func FromDBError(err error) RestErr {
+	if err == nil {
+		return nil
+	}
+	var existing RestErr
+	if errors.As(err, &existing) {
+		return existing
+	}
+
+	var e *restErr
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		e = newRestErr(http.StatusNotFound, "", defaultMessage(http.StatusNotFound), err)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		e = newRestErr(http.StatusServiceUnavailable, CodeTransientDBFailure, defaultMessage(http.StatusServiceUnavailable), err)
+	default:
+		switch dbErrorKindOf(err) {
+		case dbDuplicate:
+			e = newRestErr(http.StatusConflict, CodeDuplicate, "resource already exists", err)
+		case dbInvalidReference:
+			e = newRestErr(http.StatusBadRequest, CodeInvalidReference, "invalid reference to a related resource", err)
+		case dbConstraint:
+			e = newRestErr(http.StatusBadRequest, CodeConstraintViolation, "invalid or missing value", err)
+		case dbTransient:
+			e = newRestErr(http.StatusServiceUnavailable, CodeTransientDBFailure, defaultMessage(http.StatusServiceUnavailable), err)
+		default:
+			e = newRestErr(http.StatusInternalServerError, "", defaultMessage(http.StatusInternalServerError), err)
+		}
+	}
+	return e
+}
+
+func dbErrorKindOf(err error) dbErrorKind {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return postgresStates[pgErr.SQLState()]
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if m := mysqlErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+			number, _ := strconv.Atoi(m[1])
+			return mysqlNumbers[number]
+		}
+	}
+	return dbUnknown
+}
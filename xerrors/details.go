@@ -0,0 +1,58 @@
+package xerrors
+
+import "errors"
+
+// WithDetail returns a copy of err carrying an additional detail, eg. the id
+// of the resource involved or a retry hint. Details are serialized in the
+// "details" member of the JSON body.
+func WithDetail(err RestErr, key string, value interface{}) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	if e.ErrDetails == nil {
+		e.ErrDetails = make(map[string]interface{})
+	}
+	e.ErrDetails[key] = value
+	return e
+}
+
+// WithDetails returns a copy of err carrying all the given details
+func WithDetails(err RestErr, details map[string]interface{}) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	if e.ErrDetails == nil {
+		e.ErrDetails = make(map[string]interface{}, len(details))
+	}
+	for k, v := range details {
+		e.ErrDetails[k] = v
+	}
+	return e
+}
+
+// Details returns the details attached to the error
+func (e *restErr) Details() map[string]interface{} {
+	return e.ErrDetails
+}
+
+// DetailsOf returns the details of the first RestErr in the chain of err
+func DetailsOf(err error) map[string]interface{} {
+	var e *restErr
+	if errors.As(err, &e) {
+		return e.ErrDetails
+	}
+	return nil
+}
+
+func copyDetails(details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(details))
+	for k, v := range details {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,245 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MediaTypeXML is the media type written by WriteXML
+	MediaTypeXML = "application/xml"
+	// MediaTypeYAML is the media type written by WriteYAML
+	MediaTypeYAML = "application/yaml"
+)
+
+// errorDocument is the XML and YAML representation of an error
+type errorDocument struct {
+	XMLName xml.Name `xml:"error" yaml:"-"`
+	// Index is the position of a joined error in its batch
+	Index       *int          `xml:"index,attr,omitempty" yaml:"index,omitempty"`
+	Error       bool          `xml:"-" yaml:"error"`
+	StatusCode  int           `xml:"status_code" yaml:"status_code"`
+	Message     string        `xml:"message" yaml:"message"`
+	Code        string        `xml:"code,omitempty" yaml:"code,omitempty"`
+	FieldErrors []FieldError  `xml:"field_errors>field_error,omitempty" yaml:"field_errors,omitempty"`
+	Details     detailsMap    `xml:"details,omitempty" yaml:"details,omitempty"`
+	Retryable   *bool         `xml:"retryable,omitempty" yaml:"retryable,omitempty"`
+	RequestID   string        `xml:"request_id,omitempty" yaml:"request_id,omitempty"`
+	Errors      errorList     `xml:"errors,omitempty" yaml:"errors,omitempty"`
+	Extensions  extensionsMap `xml:"extensions,omitempty" yaml:",inline"`
+}
+
+// detailsMap is written in XML as <detail name="key">value</detail> elements
+type detailsMap map[string]interface{}
+
+// errorList holds the joined errors, written in XML as <error> elements
+type errorList []errorDocument
+
+// extensionsMap holds the decoded extension members, written in XML as
+// <extension name="member">value</extension> elements with the JSON of the
+// values other than strings
+type extensionsMap map[string]interface{}
+
+func (e *restErr) document() errorDocument {
+	doc := errorDocument{
+		Error:       true,
+		StatusCode:  e.ErrStatusCode,
+		Message:     publicMessage(e),
+		Code:        e.ErrCode,
+		FieldErrors: e.ErrFieldErrors,
+		Details:     detailsMap(e.ErrDetails),
+		Retryable:   e.ErrRetryable,
+		RequestID:   e.ErrRequestID,
+	}
+	for _, joined := range e.ErrErrors {
+		if joined.Err == nil {
+			continue
+		}
+		inner, ok := joined.Err.(*restErr)
+		if !ok {
+			inner = clone(joined.Err)
+		}
+		index := joined.Index
+		innerDoc := inner.document()
+		innerDoc.Index = &index
+		doc.Errors = append(doc.Errors, innerDoc)
+	}
+	if len(e.extensions) > 0 {
+		doc.Extensions = make(extensionsMap, len(e.extensions))
+		for name, raw := range e.extensions {
+			var value interface{}
+			if json.Unmarshal(raw, &value) == nil {
+				doc.Extensions[name] = value
+			}
+		}
+	}
+	return doc
+}
+
+// MarshalXML writes the error as an <error> element
+func (e *restErr) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return enc.Encode(e.document())
+}
+
+// MarshalYAML writes the error with the member names of the JSON body
+func (e *restErr) MarshalYAML() (interface{}, error) {
+	return e.document(), nil
+}
+
+func (d detailsMap) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if len(d) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		detail := xml.StartElement{Name: xml.Name{Local: "detail"}, Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: k}}}
+		if err := enc.EncodeElement(fmt.Sprint(d[k]), detail); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (l errorList) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if len(l) == 0 {
+		return nil
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, doc := range l {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (m extensionsMap) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if len(m) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range names {
+		text, ok := m[name].(string)
+		if !ok {
+			data, err := json.Marshal(m[name])
+			if err != nil {
+				return err
+			}
+			text = string(data)
+		}
+		extension := xml.StartElement{Name: xml.Name{Local: "extension"}, Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}}}
+		if err := enc.EncodeElement(text, extension); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// WriteXML writes err as an XML response with its status code. See WriteJSON.
+func WriteXML(w http.ResponseWriter, err error) {
+	writeEncoded(w, err, MediaTypeXML, func(v interface{}) ([]byte, error) {
+		body, marshalErr := xml.Marshal(v)
+		return append([]byte(xml.Header), body...), marshalErr
+	})
+}
+
+// WriteYAML writes err as a YAML response with its status code. See WriteJSON.
+func WriteYAML(w http.ResponseWriter, err error) {
+	writeEncoded(w, err, MediaTypeYAML, yaml.Marshal)
+}
+
+// Write writes err in the format preferred by the Accept header of r among
+// JSON, problem JSON, XML and YAML, defaulting to JSON
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	switch negotiate(r.Header.Get("Accept")) {
+	case MediaTypeProblem:
+		WriteProblem(w, err)
+	case MediaTypeXML:
+		WriteXML(w, err)
+	case MediaTypeYAML:
+		WriteYAML(w, err)
+	default:
+		WriteJSON(w, err)
+	}
+}
+
+func writeEncoded(w http.ResponseWriter, err error, contentType string, marshal func(interface{}) ([]byte, error)) {
+	if err == nil {
+		return
+	}
+	found := ToRestErr(err)
+	e, ok := found.(*restErr)
+	if !ok {
+		e = clone(found)
+	}
+	body, marshalErr := marshal(e.document())
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setResponseHeaders(w, found)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(found.StatusCode())
+	_, _ = w.Write(body)
+	ObserveWritten(found)
+}
+
+var acceptedMediaTypes = map[string]string{
+	"application/json":   "application/json",
+	MediaTypeProblem:     MediaTypeProblem,
+	"application/xml":    MediaTypeXML,
+	"text/xml":           MediaTypeXML,
+	"application/yaml":   MediaTypeYAML,
+	"application/x-yaml": MediaTypeYAML,
+	"text/yaml":          MediaTypeYAML,
+}
+
+// negotiate returns the supported media type with the highest quality in an Accept header
+func negotiate(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		supported, ok := acceptedMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+		if q > bestQ {
+			best, bestQ = supported, q
+		}
+	}
+	return best
+}
@@ -4,37 +4,260 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// RestErr is an error carrying the HTTP status and the message returned to API clients
 type RestErr interface {
+	error
+	StatusCode() int
+	Code() string
+	Message() string
+}
+
+// LegacyRestErr is the previous shape of RestErr, whose Error method reported
+// whether the value is an error instead of implementing the error interface.
+//
+// Deprecated: Use RestErr. ToLegacy adapts a RestErr for code that still
+// expects this interface.
+type LegacyRestErr interface {
 	Error() bool
 	StatusCode() int
 	Message() string
 }
 
 type restErr struct {
-	ErrError      bool   `json:"error"`
-	ErrStatusCode int    `json:"status_code"`
-	ErrMessage    string `json:"message"`
+	ErrError       bool                   `json:"error"`
+	ErrStatusCode  int                    `json:"status_code"`
+	ErrMessage     string                 `json:"message"`
+	ErrCode        string                 `json:"code,omitempty"`
+	ErrFieldErrors []FieldError           `json:"field_errors,omitempty"`
+	ErrDetails     map[string]interface{} `json:"details,omitempty"`
+	ErrRetryable   *bool                  `json:"retryable,omitempty"`
+	ErrErrors      []JoinedError          `json:"errors,omitempty"`
+	ErrRequestID   string                 `json:"request_id,omitempty"`
+
+	cause    error
+	stack    stack
+	severity Severity
+	category Category
+	internal map[string]interface{}
+	// extensions holds the unknown members of a parsed body
+	extensions map[string]json.RawMessage
+	logged     int32
+	rateLimit  *RateLimit
+}
+
+type legacyRestErr struct {
+	err RestErr
 }
 
-func (e restErr) Error() bool {
-	return e.ErrError
+// Error returns the message followed by the message of the cause, if any
+func (e *restErr) Error() string {
+	if e.cause != nil {
+		return e.ErrMessage + ": " + e.cause.Error()
+	}
+	return e.ErrMessage
 }
 
-func (e restErr) StatusCode() int {
+func (e *restErr) StatusCode() int {
 	return e.ErrStatusCode
 }
 
-func (e restErr) Message() string {
+// Code returns the machine-readable error code, eg. USER_NOT_FOUND
+func (e *restErr) Code() string {
+	return e.ErrCode
+}
+
+func (e *restErr) Message() string {
 	return e.ErrMessage
 }
 
+// Unwrap returns the cause so errors.Is and errors.As can inspect it
+func (e *restErr) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is a RestErr with the same status and code, or
+// the same status and message when target has no code, which allows RestErr
+// values to be used as sentinels
+func (e *restErr) Is(target error) bool {
+	t, ok := target.(RestErr)
+	if !ok || t.StatusCode() != e.ErrStatusCode {
+		return false
+	}
+	if t.Code() != "" {
+		return t.Code() == e.ErrCode
+	}
+	return t.Message() == e.ErrMessage
+}
+
+// ToLegacy adapts a RestErr to the deprecated LegacyRestErr interface
+//
+// Deprecated: Use RestErr directly.
+func ToLegacy(err RestErr) LegacyRestErr {
+	return legacyRestErr{err: err}
+}
+
+func (e legacyRestErr) Error() bool {
+	return true
+}
+
+func (e legacyRestErr) StatusCode() int {
+	return e.err.StatusCode()
+}
+
+func (e legacyRestErr) Message() string {
+	return e.err.Message()
+}
+
 func NewRestError(status int, message string) RestErr {
-	return restErr{
+	return newRestErr(status, "", message, nil)
+}
+
+// NewRestErrorWithCode creates an error with a machine-readable code clients can branch on
+func NewRestErrorWithCode(status int, code string, message string) RestErr {
+	return newRestErr(status, code, message, nil)
+}
+
+// WithCode returns a copy of err with the given code
+func WithCode(err RestErr, code string) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.ErrCode = code
+	return e
+}
+
+// CodeOf returns the code of the first RestErr in the chain of err, or an empty string
+func CodeOf(err error) string {
+	var restErr RestErr
+	if errors.As(err, &restErr) {
+		return restErr.Code()
+	}
+	return ""
+}
+
+// Wrap creates a RestErr with the given status and message that keeps err as
+// its cause. It returns nil when err is nil.
+func Wrap(err error, status int, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(status, "", message, err)
+}
+
+// WrapWithStatus creates a RestErr with the given status and its default
+// message that keeps err as its cause. It returns nil when err is nil.
+func WrapWithStatus(err error, status int) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(status, "", defaultMessage(status), err)
+}
+
+func WrapBadRequest(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusBadRequest, "", message, err)
+}
+
+func WrapNotFound(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusNotFound, "", message, err)
+}
+
+func WrapUnauthorized(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusUnauthorized, "", message, err)
+}
+
+func WrapForbidden(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusForbidden, "", message, err)
+}
+
+func WrapConflict(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusConflict, "", message, err)
+}
+
+func WrapUnprocessableEntity(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusUnprocessableEntity, "", message, err)
+}
+
+func WrapInternalServerError(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusInternalServerError, "", message, err)
+}
+
+func WrapBadGateway(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusBadGateway, "", message, err)
+}
+
+func WrapServiceUnavailable(err error, message string) RestErr {
+	if err == nil {
+		return nil
+	}
+	return newRestErr(http.StatusServiceUnavailable, "", message, err)
+}
+
+// newRestErr must be called directly by the exported constructors so the
+// captured stack starts at their caller
+func newRestErr(status int, code string, message string, cause error) *restErr {
+	e := &restErr{
 		ErrError:      true,
 		ErrStatusCode: status,
+		ErrCode:       code,
 		ErrMessage:    message,
+		cause:         cause,
+	}
+	if captureStack(status) {
+		e.stack = callers(2)
+	}
+	return e
+}
+
+// clone returns a copy of err that can be modified without affecting the original
+func clone(err RestErr) *restErr {
+	if e, ok := err.(*restErr); ok {
+		c := *e
+		c.ErrFieldErrors = append([]FieldError(nil), e.ErrFieldErrors...)
+		c.ErrDetails = copyDetails(e.ErrDetails)
+		c.ErrErrors = append([]JoinedError(nil), e.ErrErrors...)
+		c.internal = copyDetails(e.internal)
+		c.extensions = copyExtensions(e.extensions)
+		if e.ErrRetryable != nil {
+			retryable := *e.ErrRetryable
+			c.ErrRetryable = &retryable
+		}
+		return &c
+	}
+	return &restErr{
+		ErrError:      true,
+		ErrStatusCode: err.StatusCode(),
+		ErrMessage:    err.Message(),
+		ErrCode:       err.Code(),
+		cause:         errors.Unwrap(err),
 	}
 }
 
@@ -43,37 +266,98 @@ func NewRestErrorFromBytes(bytes []byte) (RestErr, error) {
 	if err := json.Unmarshal(bytes, &apiErr); err != nil {
 		return nil, errors.New("invalid error json response")
 	}
-	return apiErr, nil
+	return &apiErr, nil
 }
 
 func NewBadRequestError(message string) RestErr {
-	return restErr{
-		ErrError:      true,
-		ErrStatusCode: http.StatusBadRequest,
-		ErrMessage:    message,
-	}
+	return newRestErr(http.StatusBadRequest, "", message, nil)
 }
 
 func NewNotFoundError(message string) RestErr {
-	return restErr{
-		ErrError:      true,
-		ErrStatusCode: http.StatusNotFound,
-		ErrMessage:    message,
-	}
+	return newRestErr(http.StatusNotFound, "", message, nil)
 }
 
 func NewUnauthorizedError(message string) RestErr {
-	return restErr{
-		ErrError:      true,
-		ErrStatusCode: http.StatusUnauthorized,
-		ErrMessage:    message,
-	}
+	return newRestErr(http.StatusUnauthorized, "", message, nil)
 }
 
 func NewInternalServerError(message string) RestErr {
-	return restErr{
-		ErrError:      true,
-		ErrStatusCode: http.StatusInternalServerError,
-		ErrMessage:    message,
+	return newRestErr(http.StatusInternalServerError, "", message, nil)
+}
+
+func NewForbiddenError(message string) RestErr {
+	return newRestErr(http.StatusForbidden, "", message, nil)
+}
+
+func NewConflictError(message string) RestErr {
+	return newRestErr(http.StatusConflict, "", message, nil)
+}
+
+func NewGoneError(message string) RestErr {
+	return newRestErr(http.StatusGone, "", message, nil)
+}
+
+func NewUnprocessableEntityError(message string) RestErr {
+	return newRestErr(http.StatusUnprocessableEntity, "", message, nil)
+}
+
+// NewTooManyRequestsError creates a 429 carrying the rate limit state. The
+// writers of this package send it in the Retry-After and X-RateLimit-*
+// headers. A zero retryAfter or negative limit omits the matching headers.
+func NewTooManyRequestsError(message string, retryAfter time.Duration, limit int, remaining int) RestErr {
+	e := newRestErr(http.StatusTooManyRequests, "", message, nil)
+	e.rateLimit = &RateLimit{RetryAfter: retryAfter, Limit: limit, Remaining: remaining}
+	return e
+}
+
+func NewNotImplementedError(message string) RestErr {
+	return newRestErr(http.StatusNotImplemented, "", message, nil)
+}
+
+func NewBadGatewayError(message string) RestErr {
+	return newRestErr(http.StatusBadGateway, "", message, nil)
+}
+
+func NewServiceUnavailableError(message string) RestErr {
+	return newRestErr(http.StatusServiceUnavailable, "", message, nil)
+}
+
+func NewGatewayTimeoutError(message string) RestErr {
+	return newRestErr(http.StatusGatewayTimeout, "", message, nil)
+}
+
+// FromStatus creates an error for the status with a default message
+func FromStatus(status int) RestErr {
+	return newRestErr(status, "", defaultMessage(status), nil)
+}
+
+var defaultMessages = map[int]string{
+	http.StatusBadRequest:            "bad request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "resource not found",
+	http.StatusMethodNotAllowed:      "method not allowed",
+	http.StatusConflict:              "resource conflict",
+	http.StatusGone:                  "resource no longer available",
+	http.StatusPreconditionFailed:    "precondition failed",
+	http.StatusRequestEntityTooLarge: "request body too large",
+	http.StatusUnsupportedMediaType:  "unsupported media type",
+	http.StatusUnprocessableEntity:   "unprocessable entity",
+	http.StatusTooManyRequests:       "too many requests",
+	StatusClientClosedRequest:        "client closed request",
+	http.StatusInternalServerError:   "internal server error",
+	http.StatusNotImplemented:        "not implemented",
+	http.StatusBadGateway:            "bad gateway",
+	http.StatusServiceUnavailable:    "service unavailable",
+	http.StatusGatewayTimeout:        "gateway timeout",
+}
+
+func defaultMessage(status int) string {
+	if message, ok := defaultMessages[status]; ok {
+		return message
+	}
+	if text := http.StatusText(status); text != "" {
+		return strings.ToLower(text)
 	}
+	return "unknown error"
 }
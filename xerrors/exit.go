@@ -0,0 +1,45 @@
+package xerrors
+
+// Exit codes from sysexits.h, understood by most orchestrators and shells
+const (
+	ExitOK          = 0
+	ExitUsage       = 64
+	ExitDataErr     = 65
+	ExitUnavailable = 69
+	ExitSoftware    = 70
+	ExitTempFail    = 75
+	ExitNoPerm      = 77
+	// ExitInterrupted is the code of processes stopped by SIGINT
+	ExitInterrupted = 130
+)
+
+// ExitCode maps err to a process exit code, eg. for os.Exit(xerrors.ExitCode(err)):
+// 0 without error, 130 when canceled, 75 when retryable, 64 for validation
+// errors, 77 for auth errors, 69 for network and dependency errors, 65 for
+// other client errors and 70 for anything else.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if contextErr := FromContextErr(err); contextErr != nil && StatusOf(err) == 0 {
+		err = contextErr
+	}
+	if IsStatus(err, StatusClientClosedRequest) {
+		return ExitInterrupted
+	}
+	if IsRetryable(err) {
+		return ExitTempFail
+	}
+	switch CategoryOf(err) {
+	case CategoryValidation:
+		return ExitUsage
+	case CategoryAuth:
+		return ExitNoPerm
+	case CategoryNetwork, CategoryDependency:
+		return ExitUnavailable
+	case CategoryClient:
+		return ExitDataErr
+	default:
+		return ExitSoftware
+	}
+}
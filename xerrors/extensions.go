@@ -0,0 +1,93 @@
+package xerrors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// restErrMembers are the members decoded into the fields of restErr
+var restErrMembers = map[string]bool{
+	"error": true, "status_code": true, "message": true, "code": true, "field_errors": true,
+	"details": true, "retryable": true, "errors": true, "request_id": true,
+}
+
+// UnmarshalJSON keeps the unknown members of the body as extensions, so
+// proxied upstream errors are written back without losing context
+func (e *restErr) UnmarshalJSON(data []byte) error {
+	type plain restErr
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for name := range restErrMembers {
+		delete(members, name)
+	}
+	if len(members) > 0 {
+		e.extensions = members
+	} else {
+		e.extensions = nil
+	}
+	return nil
+}
+
+// ExtensionsOf returns the unknown members of the parsed body of the first
+// RestErr in the chain of err
+func ExtensionsOf(err error) map[string]json.RawMessage {
+	var e *restErr
+	if errors.As(err, &e) {
+		return e.extensions
+	}
+	return nil
+}
+
+// appendMembers adds to a serialized object the members it does not have yet,
+// sorted by name
+func appendMembers(body []byte, members map[string]json.RawMessage) ([]byte, error) {
+	if len(members) == 0 {
+		return body, nil
+	}
+	var existing map[string]json.RawMessage
+	if err := json.Unmarshal(body, &existing); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		if _, ok := existing[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return body, nil
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	out.Write(body[:len(body)-1])
+	for _, name := range names {
+		if len(existing) > 0 || out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(members[name])
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+func copyExtensions(extensions map[string]json.RawMessage) map[string]json.RawMessage {
+	if extensions == nil {
+		return nil
+	}
+	c := make(map[string]json.RawMessage, len(extensions))
+	for k, v := range extensions {
+		c[k] = v
+	}
+	return c
+}
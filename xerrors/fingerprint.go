@@ -0,0 +1,49 @@
+package xerrors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	uuidPattern   = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	hexPattern    = regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]*[0-9][0-9a-f]*\b`)
+	quotedPattern = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+)
+
+// Fingerprint returns a stable hash of the code, status, normalized message
+// and top stack frame of err, so occurrences of the same failure can be
+// grouped and deduplicated. Ids, numbers and quoted values are removed from
+// the message first. Log emits it as the "fingerprint" field.
+func Fingerprint(err error) string {
+	if err == nil {
+		return ""
+	}
+	found := ToRestErr(err)
+
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(found.StatusCode())))
+	h.Write([]byte{0})
+	h.Write([]byte(found.Code()))
+	h.Write([]byte{0})
+	h.Write([]byte(normalizeMessage(found.Message())))
+	h.Write([]byte{0})
+
+	if e, ok := found.(*restErr); ok {
+		if trace := e.StackTrace(); len(trace) > 0 {
+			// The function and not the line, which moves with unrelated changes
+			h.Write([]byte(trace[0].Function))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func normalizeMessage(message string) string {
+	message = uuidPattern.ReplaceAllString(message, "<uuid>")
+	message = quotedPattern.ReplaceAllString(message, "<value>")
+	message = hexPattern.ReplaceAllString(message, "<n>")
+	return strings.ToLower(strings.Join(strings.Fields(message), " "))
+}
@@ -0,0 +1,131 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// StatusPolicy derives the status of joined errors from the status of each of them
+type StatusPolicy func(statuses []int) int
+
+// DefaultStatusPolicy is the policy used by Join
+var DefaultStatusPolicy StatusPolicy = WorstStatus
+
+// WorstStatus returns the highest status, so a single 5xx makes the whole batch a 5xx
+func WorstStatus(statuses []int) int {
+	worst := 0
+	for _, status := range statuses {
+		if status > worst {
+			worst = status
+		}
+	}
+	return worst
+}
+
+// FirstStatus returns the status of the first error
+func FirstStatus(statuses []int) int {
+	return statuses[0]
+}
+
+// MultiStatus always returns 207, leaving clients to inspect each error
+func MultiStatus(statuses []int) int {
+	return http.StatusMultiStatus
+}
+
+// JoinedError is an error of a batch along with its position in the batch
+type JoinedError struct {
+	Index int
+	Err   RestErr
+}
+
+// MultiError is an error aggregating the errors of a batch operation
+type MultiError interface {
+	RestErr
+	Errors() []JoinedError
+}
+
+// Join aggregates errs with the DefaultStatusPolicy. See Combine.
+func Join(errs ...RestErr) MultiError {
+	return combine(DefaultStatusPolicy, errs)
+}
+
+// Combine aggregates errs into a single error whose status is derived by
+// policy. Nil errors are skipped but the index of the others is their
+// position in errs, so callers can pass one entry per item of a batch. It
+// returns nil when every error is nil.
+func Combine(policy StatusPolicy, errs ...RestErr) MultiError {
+	return combine(policy, errs)
+}
+
+func combine(policy StatusPolicy, errs []RestErr) MultiError {
+	var joined []JoinedError
+	var statuses []int
+	var causes []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		joined = append(joined, JoinedError{Index: i, Err: err})
+		statuses = append(statuses, err.StatusCode())
+		causes = append(causes, err)
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+
+	message := joined[0].Err.Message()
+	if len(joined) > 1 {
+		message = fmt.Sprintf("%d errors occurred", len(joined))
+	}
+	e := newRestErr(policy(statuses), "", message, errors.Join(causes...))
+	if e.stack != nil {
+		// Start the stack at the caller of Join or Combine
+		e.stack = callers(2)
+	}
+	e.ErrErrors = joined
+	return e
+}
+
+// Errors returns the errors aggregated by Join or Combine
+func (e *restErr) Errors() []JoinedError {
+	return e.ErrErrors
+}
+
+// MarshalJSON serializes the error with its index as an additional member
+func (j JoinedError) MarshalJSON() ([]byte, error) {
+	body, err := json.Marshal(j.Err)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 2 || body[0] != '{' {
+		return nil, fmt.Errorf("xerrors: cannot add the index to %s", body)
+	}
+	prefix := fmt.Sprintf(`{"index":%d`, j.Index)
+	if string(body) == "{}" {
+		return []byte(prefix + "}"), nil
+	}
+	return append([]byte(prefix+","), body[1:]...), nil
+}
+
+// UnmarshalJSON reads an error serialized by MarshalJSON
+func (j *JoinedError) UnmarshalJSON(data []byte) error {
+	var index struct {
+		Index int `json:"index"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	var e restErr
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	delete(e.extensions, "index")
+	if len(e.extensions) == 0 {
+		e.extensions = nil
+	}
+	j.Index = index.Index
+	j.Err = &e
+	return nil
+}
@@ -0,0 +1,123 @@
+package xerrors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	translationsMu sync.RWMutex
+	translations   = make(map[string]map[string]string)
+)
+
+// RegisterTranslations adds the messages of a language, keyed by error code.
+// Messages may reference details of the error with {name} placeholders, eg.
+// "la commande {order_id} est déjà payée".
+func RegisterTranslations(lang string, messages map[string]string) {
+	lang = normalizeLanguage(lang)
+	translationsMu.Lock()
+	defer translationsMu.Unlock()
+
+	if translations[lang] == nil {
+		translations[lang] = make(map[string]string, len(messages))
+	}
+	for key, message := range messages {
+		translations[lang][key] = message
+	}
+}
+
+// FlushTranslations removes every registered translation
+func FlushTranslations() {
+	translationsMu.Lock()
+	defer translationsMu.Unlock()
+	translations = make(map[string]map[string]string)
+}
+
+// Localize returns a copy of err with its message translated to the preferred
+// language. lang is a language tag or an Accept-Language header value; regional
+// variants fall back to their base language. err is returned unchanged when
+// it has no code or no translation is registered for its code.
+func Localize(err RestErr, lang string) RestErr {
+	if err == nil || err.Code() == "" {
+		return err
+	}
+
+	translationsMu.RLock()
+	message, ok := lookupTranslation(err.Code(), parseAcceptLanguage(lang))
+	translationsMu.RUnlock()
+	if !ok {
+		return err
+	}
+
+	e := clone(err)
+	e.ErrMessage = expandPlaceholders(message, e.ErrDetails)
+	return e
+}
+
+func lookupTranslation(key string, langs []string) (string, bool) {
+	for _, lang := range langs {
+		if message, ok := translations[lang][key]; ok {
+			return message, true
+		}
+		if i := strings.IndexByte(lang, '-'); i > 0 {
+			if message, ok := translations[lang[:i]][key]; ok {
+				return message, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseAcceptLanguage returns the languages of an Accept-Language value by
+// decreasing preference, ignoring the ones with a zero quality
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := normalizeLanguage(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{lang: lang, q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	result := make([]string, len(langs))
+	for i, l := range langs {
+		result[i] = l.lang
+	}
+	return result
+}
+
+func normalizeLanguage(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+func expandPlaceholders(message string, details map[string]interface{}) string {
+	if len(details) == 0 || !strings.Contains(message, "{") {
+		return message
+	}
+	pairs := make([]string, 0, len(details)*2)
+	for k, v := range details {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
@@ -0,0 +1,36 @@
+package xerrors
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// LogAndReturn logs err with Log unless it was already logged by a lower
+// layer, then returns it as a RestErr. Returning the result lets every layer
+// call it without logging the same failure twice. It returns nil when err is nil.
+func LogAndReturn(err error, tags ...zap.Field) RestErr {
+	if err == nil {
+		return nil
+	}
+	result := ToRestErr(err)
+	if wasLogged(err) {
+		return result
+	}
+	Log(err, tags...)
+	if e, ok := result.(*restErr); ok {
+		atomic.StoreInt32(&e.logged, 1)
+	}
+	return result
+}
+
+// wasLogged reports whether an error in the chain of err went through LogAndReturn
+func wasLogged(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*restErr); ok && atomic.LoadInt32(&e.logged) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package xerrors
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// FieldNames are the names of the members of the JSON body. Empty names keep
+// the default name and "-" omits the member.
+type FieldNames struct {
+	Error       string
+	StatusCode  string
+	Message     string
+	Code        string
+	FieldErrors string
+	Details     string
+	Retryable   string
+	Errors      string
+	RequestID   string
+}
+
+// Marshaller serializes errors with custom member names, eg. to keep the
+// contract of an API that used "status" and "detail":
+//
+//	m := xerrors.Marshaller{Names: xerrors.FieldNames{StatusCode: "status", Message: "detail", Error: "-"}}
+type Marshaller struct {
+	Names FieldNames
+	View  View
+}
+
+// memberOrder lists the default member names in the order they are serialized
+var memberOrder = []string{
+	"error", "status_code", "message", "code", "field_errors", "details",
+	"retryable", "errors", "request_id", "causes", "stack", "internal_details",
+}
+
+var knownMember = func() map[string]bool {
+	known := make(map[string]bool, len(memberOrder))
+	for _, member := range memberOrder {
+		known[member] = true
+	}
+	return known
+}()
+
+func (n FieldNames) renames() map[string]string {
+	return map[string]string{
+		"error":        n.Error,
+		"status_code":  n.StatusCode,
+		"message":      n.Message,
+		"code":         n.Code,
+		"field_errors": n.FieldErrors,
+		"details":      n.Details,
+		"retryable":    n.Retryable,
+		"errors":       n.Errors,
+		"request_id":   n.RequestID,
+	}
+}
+
+// Marshal serializes err with the configured names and view. Errors that are
+// not a RestErr are serialized as a 500.
+func (m Marshaller) Marshal(err error) ([]byte, error) {
+	body, marshalErr := MarshalView(err, m.View)
+	if marshalErr != nil || err == nil {
+		return body, marshalErr
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+
+	renames := m.Names.renames()
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for _, member := range memberOrder {
+		value, ok := members[member]
+		if !ok {
+			continue
+		}
+		name := member
+		if renamed := renames[member]; renamed == "-" {
+			continue
+		} else if renamed != "" {
+			name = renamed
+		}
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	out.WriteByte('}')
+
+	// Extensions of parsed bodies keep their name
+	extensions := make(map[string]json.RawMessage)
+	for member, value := range members {
+		if !knownMember[member] {
+			extensions[member] = value
+		}
+	}
+	return appendMembers(out.Bytes(), extensions)
+}
+
+// Unmarshal parses an error serialized with the configured names
+func (m Marshaller) Unmarshal(data []byte) (RestErr, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for member, renamed := range m.Names.renames() {
+		if renamed == "" || renamed == "-" || renamed == member {
+			continue
+		}
+		if value, ok := members[renamed]; ok {
+			delete(members, renamed)
+			members[member] = value
+		}
+	}
+	body, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	return NewRestErrorFromBytes(body)
+}
+
+// WriteJSON writes err as a JSON response with the configured names. See xerrors.WriteJSON.
+func (m Marshaller) WriteJSON(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+	body, marshalErr := m.Marshal(err)
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	restErr := ToRestErr(err)
+	setResponseHeaders(w, restErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(restErr.StatusCode())
+	_, _ = w.Write(body)
+	ObserveWritten(restErr)
+}
@@ -0,0 +1,45 @@
+package xerrors
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Observer is notified of the errors written as responses, eg. to count
+// them by status and code. Errors are observed once complete rather than
+// when created, since their code, details and field errors are often set
+// afterwards.
+type Observer interface {
+	Written(err RestErr)
+}
+
+var (
+	observersMu sync.Mutex
+	observers   atomic.Value // []Observer
+)
+
+// AddObserver registers an observer. Observers are called synchronously and
+// must be cheap and safe for concurrent use.
+func AddObserver(observer Observer) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	current, _ := observers.Load().([]Observer)
+	observers.Store(append(append([]Observer(nil), current...), observer))
+}
+
+// FlushObservers removes every registered observer
+func FlushObservers() {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	observers.Store([]Observer(nil))
+}
+
+// ObserveWritten notifies the observers that err was written as a response.
+// The writers of this package call it already, adapters writing errors
+// themselves must call it.
+func ObserveWritten(err RestErr) {
+	current, _ := observers.Load().([]Observer)
+	for _, observer := range current {
+		observer.Written(err)
+	}
+}
@@ -0,0 +1,26 @@
+package xerrors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// FromPanic converts a value returned by recover into a 500 whose cause holds
+// the panic message. The stack trace is captured whatever the current
+// StackMode, so calling it from the deferred function records where the panic
+// happened. It returns nil when recovered is nil.
+func FromPanic(recovered interface{}) RestErr {
+	if recovered == nil {
+		return nil
+	}
+	var cause error
+	if err, ok := recovered.(error); ok {
+		cause = fmt.Errorf("panic: %w", err)
+	} else {
+		cause = fmt.Errorf("panic: %v", recovered)
+	}
+
+	e := newRestErr(http.StatusInternalServerError, "", defaultMessage(http.StatusInternalServerError), cause)
+	e.stack = callers(1)
+	return e
+}
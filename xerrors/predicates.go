@@ -0,0 +1,84 @@
+package xerrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// StatusOf returns the status of the first RestErr in the chain of err, or 0
+func StatusOf(err error) int {
+	var restErr RestErr
+	if errors.As(err, &restErr) {
+		return restErr.StatusCode()
+	}
+	return 0
+}
+
+// IsStatus reports whether the first RestErr in the chain of err has the given status
+func IsStatus(err error, status int) bool {
+	return err != nil && StatusOf(err) == status
+}
+
+// IsClientError reports whether the first RestErr in the chain of err is a 4xx
+func IsClientError(err error) bool {
+	status := StatusOf(err)
+	return status >= 400 && status < 500
+}
+
+// IsServerError reports whether the first RestErr in the chain of err is a 5xx
+func IsServerError(err error) bool {
+	status := StatusOf(err)
+	return status >= 500 && status < 600
+}
+
+func IsBadRequest(err error) bool {
+	return IsStatus(err, http.StatusBadRequest)
+}
+
+func IsUnauthorized(err error) bool {
+	return IsStatus(err, http.StatusUnauthorized)
+}
+
+func IsForbidden(err error) bool {
+	return IsStatus(err, http.StatusForbidden)
+}
+
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+func IsConflict(err error) bool {
+	return IsStatus(err, http.StatusConflict)
+}
+
+func IsGone(err error) bool {
+	return IsStatus(err, http.StatusGone)
+}
+
+func IsUnprocessableEntity(err error) bool {
+	return IsStatus(err, http.StatusUnprocessableEntity)
+}
+
+func IsTooManyRequests(err error) bool {
+	return IsStatus(err, http.StatusTooManyRequests)
+}
+
+func IsInternalServerError(err error) bool {
+	return IsStatus(err, http.StatusInternalServerError)
+}
+
+func IsNotImplemented(err error) bool {
+	return IsStatus(err, http.StatusNotImplemented)
+}
+
+func IsBadGateway(err error) bool {
+	return IsStatus(err, http.StatusBadGateway)
+}
+
+func IsServiceUnavailable(err error) bool {
+	return IsStatus(err, http.StatusServiceUnavailable)
+}
+
+func IsGatewayTimeout(err error) bool {
+	return IsStatus(err, http.StatusGatewayTimeout)
+}
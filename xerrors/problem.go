@@ -0,0 +1,156 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// MediaTypeProblem is the media type of RFC 9457 problem documents
+const MediaTypeProblem = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 (formerly RFC 7807) problem document
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Extensions holds every additional member of the document
+	Extensions map[string]interface{} `json:"-"`
+}
+
+var problemMembers = map[string]bool{"type": true, "title": true, "status": true, "detail": true, "instance": true}
+
+// MarshalJSON serializes the extensions as top level members
+func (p ProblemDetails) MarshalJSON() ([]byte, error) {
+	type problem ProblemDetails
+	base, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return base, err
+	}
+
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		if !problemMembers[k] {
+			members[k] = v
+		}
+	}
+	var standard map[string]json.RawMessage
+	if err := json.Unmarshal(base, &standard); err != nil {
+		return nil, err
+	}
+	for k, v := range standard {
+		members[k] = v
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON collects every non standard member into Extensions
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	type problem ProblemDetails
+	if err := json.Unmarshal(data, (*problem)(p)); err != nil {
+		return err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for k := range problemMembers {
+		delete(members, k)
+	}
+	if len(members) > 0 {
+		p.Extensions = members
+	} else {
+		p.Extensions = nil
+	}
+	return nil
+}
+
+// ToProblem converts err into a problem document. The title is the status
+// text, the detail is the message and the code becomes the "code" extension.
+// Errors whose code has a registered problem type get its URI and title.
+func ToProblem(err RestErr) ProblemDetails {
+	p := ProblemDetails{
+		Title:  http.StatusText(err.StatusCode()),
+		Status: err.StatusCode(),
+		Detail: publicMessage(err),
+	}
+	if code := err.Code(); code != "" {
+		p.setExtension("code", code)
+		if t, ok := problemTypeForCode(code); ok {
+			p.Type = t.URI
+			p.Title = t.Title
+		}
+	}
+	if v, ok := err.(ValidationError); ok && len(v.FieldErrors()) > 0 {
+		p.setExtension("errors", v.FieldErrors())
+	}
+	if details := DetailsOf(err); len(details) > 0 {
+		p.setExtension("details", details)
+	}
+	if requestID := RequestIDOf(err); requestID != "" {
+		p.setExtension("request_id", requestID)
+	}
+	if cause := errors.Unwrap(err); cause != nil && debugEnabled() {
+		p.setExtension("causes", causesOf(cause))
+	}
+	return p
+}
+
+func (p *ProblemDetails) setExtension(name string, value interface{}) {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[name] = value
+}
+
+// FromProblem converts a problem document into a RestErr, using the title
+// when the document has no detail
+func FromProblem(p ProblemDetails) RestErr {
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	code, _ := p.Extensions["code"].(string)
+	if t, ok := LookupProblemType(p.Type); ok && code == "" {
+		code = t.Code
+	}
+	e := newRestErr(status, code, message, nil)
+	if requestID, ok := p.Extensions["request_id"].(string); ok {
+		e.ErrRequestID = requestID
+	}
+	return e
+}
+
+// WriteProblem writes err as an application/problem+json response. Errors
+// that are not a RestErr are written as a 500 without exposing their message.
+// Nothing is written when err is nil.
+func WriteProblem(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+	restErr := ToRestErr(err)
+	body, marshalErr := json.Marshal(ToProblem(restErr))
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setResponseHeaders(w, restErr)
+	w.Header().Set("Content-Type", MediaTypeProblem)
+	w.WriteHeader(restErr.StatusCode())
+	_, _ = w.Write(body)
+	ObserveWritten(restErr)
+}
+
+// ToRestErr converts err with Translate, which the writers of this package use
+// to find the error to render
+func ToRestErr(err error) RestErr {
+	return Translate(err)
+}
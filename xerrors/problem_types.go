@@ -0,0 +1,163 @@
+package xerrors
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProblemType documents a problem "type" URI
+type ProblemType struct {
+	URI    string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Code is the error code rendered with this type by ToProblem
+	Code string `json:"code,omitempty"`
+	// Extensions lists the extension members problems of this type may
+	// carry, any member is allowed when it is empty
+	Extensions []string `json:"extensions,omitempty"`
+	// Required lists the extension members problems of this type must carry
+	Required []string `json:"required,omitempty"`
+}
+
+// aboutBlank is the default problem type, meaning the problem has no additional semantics
+const aboutBlank = "about:blank"
+
+var (
+	problemTypesMu  sync.RWMutex
+	problemTypes    = make(map[string]ProblemType)
+	problemTypeCode = make(map[string]string)
+)
+
+// RegisterProblemType registers a problem type and returns it. It panics when
+// the URI is empty or already registered, as types are meant to be
+// registered once at init time.
+func RegisterProblemType(t ProblemType) ProblemType {
+	if t.URI == "" || t.URI == aboutBlank {
+		panic("xerrors: cannot register a problem type without a URI")
+	}
+	if t.Status == 0 {
+		t.Status = http.StatusInternalServerError
+	}
+	if t.Title == "" {
+		t.Title = http.StatusText(t.Status)
+	}
+
+	problemTypesMu.Lock()
+	defer problemTypesMu.Unlock()
+	if _, ok := problemTypes[t.URI]; ok {
+		panic(fmt.Sprintf("xerrors: problem type %s is already registered", t.URI))
+	}
+	problemTypes[t.URI] = t
+	if t.Code != "" {
+		problemTypeCode[t.Code] = t.URI
+	}
+	return t
+}
+
+// LookupProblemType returns the problem type registered for uri
+func LookupProblemType(uri string) (ProblemType, bool) {
+	problemTypesMu.RLock()
+	defer problemTypesMu.RUnlock()
+	t, ok := problemTypes[uri]
+	return t, ok
+}
+
+// ProblemTypes returns every registered problem type sorted by URI, eg. to
+// generate the error documentation
+func ProblemTypes() []ProblemType {
+	problemTypesMu.RLock()
+	defer problemTypesMu.RUnlock()
+
+	types := make([]ProblemType, 0, len(problemTypes))
+	for _, t := range problemTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].URI < types[j].URI })
+	return types
+}
+
+// FlushProblemTypes removes every registered problem type
+func FlushProblemTypes() {
+	problemTypesMu.Lock()
+	defer problemTypesMu.Unlock()
+	problemTypes = make(map[string]ProblemType)
+	problemTypeCode = make(map[string]string)
+}
+
+// problemTypeForCode returns the problem type registered for an error code
+func problemTypeForCode(code string) (ProblemType, bool) {
+	problemTypesMu.RLock()
+	defer problemTypesMu.RUnlock()
+	t, ok := problemTypes[problemTypeCode[code]]
+	return t, ok
+}
+
+// NewProblem creates a problem of a registered type, with its default title
+// and status. An unregistered type gives a 500 about:blank problem.
+func NewProblem(typeURI string, detail string, extensions map[string]interface{}) ProblemDetails {
+	t, ok := LookupProblemType(typeURI)
+	if !ok {
+		return ProblemDetails{
+			Type:       aboutBlank,
+			Title:      http.StatusText(http.StatusInternalServerError),
+			Status:     http.StatusInternalServerError,
+			Detail:     detail,
+			Extensions: extensions,
+		}
+	}
+	p := ProblemDetails{Type: t.URI, Title: t.Title, Status: t.Status, Detail: detail, Extensions: extensions}
+	if t.Code != "" {
+		p.setExtension("code", t.Code)
+	}
+	return p
+}
+
+// Validate checks that the problem conforms to its registered type: same
+// status and title, every required extension member present and no
+// undeclared one. Problems without a type or of type about:blank are valid.
+func (p ProblemDetails) Validate() error {
+	if p.Type == "" || p.Type == aboutBlank {
+		return nil
+	}
+	t, ok := LookupProblemType(p.Type)
+	if !ok {
+		return fmt.Errorf("problem type %s is not registered", p.Type)
+	}
+
+	var violations []string
+	if p.Status != t.Status {
+		violations = append(violations, fmt.Sprintf("status is %d instead of %d", p.Status, t.Status))
+	}
+	if p.Title != t.Title {
+		violations = append(violations, fmt.Sprintf("title is %q instead of %q", p.Title, t.Title))
+	}
+	for _, name := range t.Required {
+		if _, ok := p.Extensions[name]; !ok {
+			violations = append(violations, fmt.Sprintf("extension %s is missing", name))
+		}
+	}
+	if len(t.Extensions) > 0 {
+		allowed := map[string]bool{"code": true}
+		for _, name := range append(t.Extensions, t.Required...) {
+			allowed[name] = true
+		}
+		var undeclared []string
+		for name := range p.Extensions {
+			if !allowed[name] {
+				undeclared = append(undeclared, name)
+			}
+		}
+		sort.Strings(undeclared)
+		for _, name := range undeclared {
+			violations = append(violations, fmt.Sprintf("extension %s is not declared", name))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("problem does not conform to %s: %s", p.Type, strings.Join(violations, "; "))
+	}
+	return nil
+}
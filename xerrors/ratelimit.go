@@ -0,0 +1,56 @@
+package xerrors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the rate limit state of a client that was throttled
+type RateLimit struct {
+	// RetryAfter is how long the client must wait before its next request
+	RetryAfter time.Duration
+	// Limit is the number of requests allowed per window, negative if unknown
+	Limit int
+	// Remaining is the number of requests left in the window
+	Remaining int
+}
+
+// RateLimitOf returns the rate limit state of the first RestErr in the chain of err carrying one
+func RateLimitOf(err error) (RateLimit, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*restErr); ok && e.rateLimit != nil {
+			return *e.rateLimit, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+// ResponseHeaders returns the headers to send along with err, eg. Retry-After
+// and X-RateLimit-* for rate limited requests. The writers of this package set
+// them already, adapters writing errors themselves must set them.
+func ResponseHeaders(err error) http.Header {
+	header := make(http.Header)
+	rateLimit, ok := RateLimitOf(err)
+	if !ok {
+		return header
+	}
+	if rateLimit.RetryAfter > 0 {
+		// Retry-After is in whole seconds, round up so clients never retry too early
+		seconds := strconv.FormatInt(int64((rateLimit.RetryAfter+time.Second-1)/time.Second), 10)
+		header.Set("Retry-After", seconds)
+		header.Set("X-RateLimit-Reset", seconds)
+	}
+	if rateLimit.Limit >= 0 {
+		header.Set("X-RateLimit-Limit", strconv.Itoa(rateLimit.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(rateLimit.Remaining))
+	}
+	return header
+}
+
+func setResponseHeaders(w http.ResponseWriter, err error) {
+	for name, values := range ResponseHeaders(err) {
+		w.Header()[name] = values
+	}
+}
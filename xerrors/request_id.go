@@ -0,0 +1,26 @@
+package xerrors
+
+import "errors"
+
+// WithRequestID returns a copy of err carrying the id of the request that
+// failed. It is serialized as "request_id" and logged by Log, so users can
+// quote it in support tickets.
+func WithRequestID(err RestErr, requestID string) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.ErrRequestID = requestID
+	return e
+}
+
+// RequestIDOf returns the request id of the first RestErr in the chain of err
+// that carries one
+func RequestIDOf(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*restErr); ok && e.ErrRequestID != "" {
+			return e.ErrRequestID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxResponseErrorBytes is the most that is read from an error response body
+	maxResponseErrorBytes = 64 << 10
+	// maxResponseErrorExcerpt is the length of the body excerpt kept in fallback messages
+	maxResponseErrorExcerpt = 256
+)
+
+// NewRestErrorFromResponse reads the error returned by an upstream service.
+// Bodies holding an xerrors JSON error or a problem document are decoded,
+// anything else gives an error with the response status and an excerpt of
+// the body. At most 64KB of the body are read and the body is always closed.
+// It returns nil when resp is nil.
+func NewRestErrorFromResponse(resp *http.Response) RestErr {
+	if resp == nil {
+		return nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseErrorBytes))
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == MediaTypeProblem {
+		var problem ProblemDetails
+		if err := json.Unmarshal(body, &problem); err == nil {
+			if problem.Status == 0 {
+				problem.Status = resp.StatusCode
+			}
+			return FromProblem(problem)
+		}
+	}
+
+	var parsed restErr
+	if err := json.Unmarshal(body, &parsed); err == nil && (parsed.ErrStatusCode != 0 || parsed.ErrMessage != "") {
+		if parsed.ErrStatusCode == 0 {
+			parsed.ErrStatusCode = resp.StatusCode
+		}
+		parsed.ErrError = true
+		return &parsed
+	}
+
+	message := fmt.Sprintf("upstream responded with status %d", resp.StatusCode)
+	if excerpt := bodyExcerpt(body); excerpt != "" {
+		message += ": " + excerpt
+	}
+	return newRestErr(resp.StatusCode, "", message, readErr)
+}
+
+// bodyExcerpt returns the start of body as a single line of valid text
+func bodyExcerpt(body []byte) string {
+	excerpt := strings.Join(strings.Fields(string(body)), " ")
+	if len(excerpt) <= maxResponseErrorExcerpt {
+		return excerpt
+	}
+	cut := maxResponseErrorExcerpt
+	for cut > 0 && !utf8.RuneStart(excerpt[cut]) {
+		cut--
+	}
+	return excerpt[:cut] + "..."
+}
@@ -0,0 +1,105 @@
+// Package resttest asserts on the semantics of xerrors errors in tests, eg.
+//
+//	resttest.AssertStatus(t, err, http.StatusNotFound)
+//	resttest.AssertCode(t, err, "USER_NOT_FOUND")
+//
+// Errors written by handlers can be checked the same way after parsing the
+// recorded response with xerrors.NewRestErrorFromResponse.
+package resttest
+
+import (
+	"errors"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// TestingT is the subset of testing.TB the assertions use
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertStatus checks that err holds a RestErr with the given status
+func AssertStatus(t TestingT, err error, status int) bool {
+	t.Helper()
+	restErr, ok := restErrOf(t, err)
+	if !ok {
+		return false
+	}
+	if restErr.StatusCode() != status {
+		t.Errorf("expected status %d, got %d (%s)", status, restErr.StatusCode(), restErr.Error())
+		return false
+	}
+	return true
+}
+
+// AssertCode checks that err holds a RestErr with the given code
+func AssertCode(t TestingT, err error, code string) bool {
+	t.Helper()
+	restErr, ok := restErrOf(t, err)
+	if !ok {
+		return false
+	}
+	if restErr.Code() != code {
+		t.Errorf("expected code %q, got %q (%s)", code, restErr.Code(), restErr.Error())
+		return false
+	}
+	return true
+}
+
+// AssertMessage checks that err holds a RestErr with the given message
+func AssertMessage(t TestingT, err error, message string) bool {
+	t.Helper()
+	restErr, ok := restErrOf(t, err)
+	if !ok {
+		return false
+	}
+	if restErr.Message() != message {
+		t.Errorf("expected message %q, got %q", message, restErr.Message())
+		return false
+	}
+	return true
+}
+
+// AssertFieldError checks that err holds a ValidationError with a violation
+// on field, and of one of the given rules when any is given
+func AssertFieldError(t TestingT, err error, field string, rules ...string) bool {
+	t.Helper()
+	var validationErr xerrors.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("expected a validation error, got %v", err)
+		return false
+	}
+
+	var fieldRules []string
+	for _, fieldErr := range validationErr.FieldErrors() {
+		if fieldErr.Field != field {
+			continue
+		}
+		if len(rules) == 0 {
+			return true
+		}
+		for _, rule := range rules {
+			if fieldErr.Rule == rule {
+				return true
+			}
+		}
+		fieldRules = append(fieldRules, fieldErr.Rule)
+	}
+	if len(fieldRules) == 0 {
+		t.Errorf("expected a violation on field %q, got %v", field, validationErr.FieldErrors())
+	} else {
+		t.Errorf("expected a violation of %v on field %q, got %v", rules, field, fieldRules)
+	}
+	return false
+}
+
+func restErrOf(t TestingT, err error) (xerrors.RestErr, bool) {
+	t.Helper()
+	var restErr xerrors.RestErr
+	if !errors.As(err, &restErr) {
+		t.Errorf("expected a RestErr, got %v", err)
+		return nil, false
+	}
+	return restErr, true
+}
@@ -0,0 +1,41 @@
+package xerrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// IsRetryableStatus reports whether a request failing with status may succeed
+// when retried: 429, 502, 503 and 504 are retryable
+func IsRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// WithRetryable returns a copy of err explicitly marked as retryable or not,
+// overriding the classification by status
+func WithRetryable(err RestErr, retryable bool) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.ErrRetryable = &retryable
+	return e
+}
+
+// IsRetryable reports whether the first RestErr in the chain of err may
+// succeed when retried, as set by WithRetryable or else derived from its
+// status. Errors without a RestErr are not retryable.
+func IsRetryable(err error) bool {
+	var found RestErr
+	if !errors.As(err, &found) {
+		return false
+	}
+	if e, ok := found.(*restErr); ok && e.ErrRetryable != nil {
+		return *e.ErrRetryable
+	}
+	return IsRetryableStatus(found.StatusCode())
+}
@@ -0,0 +1,51 @@
+package xerrors
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+const envSafeMessages = "ERRORS_SAFE_MESSAGES"
+
+var safeMessages = getSafeMessages()
+
+func getSafeMessages() int32 {
+	if enabled, _ := strconv.ParseBool(os.Getenv(envSafeMessages)); enabled {
+		return 1
+	}
+	return 0
+}
+
+// SetSafeServerMessages replaces the message of 5xx errors by the default
+// message of their status whenever they are serialized for clients, so SQL
+// errors or internal names never leak into responses. Logs, the InternalView
+// and the debug mode keep the real message. The initial mode is read from
+// ERRORS_SAFE_MESSAGES and defaults to disabled.
+func SetSafeServerMessages(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&safeMessages, v)
+}
+
+// publicMessage returns the message of err clients may see
+func publicMessage(err RestErr) string {
+	if atomic.LoadInt32(&safeMessages) == 1 && err.StatusCode() >= http.StatusInternalServerError {
+		return defaultMessage(err.StatusCode())
+	}
+	return err.Message()
+}
+
+// public returns e, or a copy of e with its public message when it differs
+func (e *restErr) public() *restErr {
+	message := publicMessage(e)
+	if message == e.ErrMessage {
+		return e
+	}
+	c := *e
+	c.ErrMessage = message
+	return &c
+}
@@ -0,0 +1,85 @@
+package xerrors
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Severity tells how urgently an error needs attention
+type Severity string
+
+const (
+	// SeverityInfo is for expected failures, eg. most client errors
+	SeverityInfo Severity = "info"
+	// SeverityWarn is for failures worth watching, eg. rate limiting
+	SeverityWarn Severity = "warn"
+	// SeverityError is for failures that need to be investigated
+	SeverityError Severity = "error"
+	// SeverityCritical is for failures that should page someone
+	SeverityCritical Severity = "critical"
+)
+
+// WithSeverity returns a copy of err with the given severity
+func WithSeverity(err RestErr, severity Severity) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.severity = severity
+	return e
+}
+
+// SeverityOf returns the severity of the first RestErr in the chain of err.
+// Errors without an explicit severity are info below 500 and error from 500
+// on; errors without a RestErr are errors.
+func SeverityOf(err error) Severity {
+	var found RestErr
+	if !errors.As(err, &found) {
+		return SeverityError
+	}
+	if e, ok := found.(*restErr); ok && e.severity != "" {
+		return e.severity
+	}
+	if found.StatusCode() >= http.StatusInternalServerError {
+		return SeverityError
+	}
+	return SeverityInfo
+}
+
+// Log writes err with xlogger at the level matching its severity. Critical
+// errors are logged at error level with a "severity" field alerts can match.
+func Log(err error, tags ...zap.Field) {
+	if err == nil {
+		return
+	}
+	restErr := ToRestErr(err)
+	severity := SeverityOf(err)
+	tags = append(tags,
+		zap.Int("status_code", restErr.StatusCode()),
+		zap.String("severity", string(severity)),
+		zap.String("category", string(CategoryOf(err))),
+		zap.String("fingerprint", Fingerprint(err)),
+	)
+	if code := restErr.Code(); code != "" {
+		tags = append(tags, zap.String("code", code))
+	}
+	if requestID := RequestIDOf(err); requestID != "" {
+		tags = append(tags, zap.String("request_id", requestID))
+	}
+	if details := DetailsOf(err); len(details) > 0 {
+		tags = append(tags, zap.Any("details", details))
+	}
+
+	switch severity {
+	case SeverityInfo:
+		xlogger.Info(err.Error(), tags...)
+	case SeverityWarn:
+		xlogger.Warning(err.Error(), tags...)
+	default:
+		xlogger.Error(restErr.Message(), err, tags...)
+	}
+}
@@ -0,0 +1,113 @@
+package xerrors
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+const envStackMode = "ERRORS_STACK_MODE"
+
+// StackMode controls which errors capture a stack trace when they are created
+type StackMode int32
+
+const (
+	// StackNone never captures stack traces, except through WithStack
+	StackNone StackMode = iota
+	// StackServerErrors captures stack traces for 5xx errors only
+	StackServerErrors
+	// StackAll captures stack traces for every error
+	StackAll
+)
+
+const maxStackDepth = 32
+
+var stackMode = int32(getStackMode())
+
+type stack []uintptr
+
+func getStackMode() StackMode {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(envStackMode))) {
+	case "5xx", "server":
+		return StackServerErrors
+	case "all":
+		return StackAll
+	default:
+		return StackNone
+	}
+}
+
+// SetStackMode changes which errors capture a stack trace. The initial mode is
+// read from ERRORS_STACK_MODE ("none", "5xx" or "all") and defaults to none.
+func SetStackMode(mode StackMode) {
+	atomic.StoreInt32(&stackMode, int32(mode))
+}
+
+func captureStack(status int) bool {
+	switch StackMode(atomic.LoadInt32(&stackMode)) {
+	case StackAll:
+		return true
+	case StackServerErrors:
+		return status >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// callers records the stack of the caller skip frames above its own caller
+func callers(skip int) stack {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+// WithStack returns a copy of err carrying a stack trace captured at the call
+// site, whatever the current StackMode
+func WithStack(err RestErr) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.stack = callers(1)
+	return e
+}
+
+// StackTrace returns the frames recorded when the error was created, if any
+func (e *restErr) StackTrace() []runtime.Frame {
+	if len(e.stack) == 0 {
+		return nil
+	}
+	var trace []runtime.Frame
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		trace = append(trace, frame)
+		if !more {
+			break
+		}
+	}
+	return trace
+}
+
+// Format prints the stack trace after the message with %+v
+func (e *restErr) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			_, _ = io.WriteString(s, e.Error())
+			for _, frame := range e.StackTrace() {
+				_, _ = fmt.Fprintf(s, "\n%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+			}
+			return
+		}
+		_, _ = io.WriteString(s, e.Error())
+	case 's':
+		_, _ = io.WriteString(s, e.Error())
+	case 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	}
+}
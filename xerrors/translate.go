@@ -0,0 +1,60 @@
+package xerrors
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Translator maps errors of a third party library to a RestErr, reporting
+// false for the errors it does not know
+type Translator func(err error) (RestErr, bool)
+
+var (
+	translatorsMu sync.Mutex
+	translators   atomic.Value // []Translator
+)
+
+// RegisterTranslator adds a translator run by Translate, after the ones
+// already registered, eg.
+//
+//	xerrors.RegisterTranslator(func(err error) (xerrors.RestErr, bool) {
+//		if errors.Is(err, redis.Nil) {
+//			return xerrors.WrapWithStatus(err, http.StatusNotFound), true
+//		}
+//		return nil, false
+//	})
+func RegisterTranslator(translator Translator) {
+	translatorsMu.Lock()
+	defer translatorsMu.Unlock()
+	current, _ := translators.Load().([]Translator)
+	translators.Store(append(append([]Translator(nil), current...), translator))
+}
+
+// FlushTranslators removes every registered translator
+func FlushTranslators() {
+	translatorsMu.Lock()
+	defer translatorsMu.Unlock()
+	translators.Store([]Translator(nil))
+}
+
+// Translate returns the first RestErr in the chain of err, else the result
+// of the first registered translator that knows err, else a generic 500
+// keeping err as its cause. It returns nil when err is nil.
+func Translate(err error) RestErr {
+	if err == nil {
+		return nil
+	}
+	var restErr RestErr
+	if errors.As(err, &restErr) {
+		return restErr
+	}
+	current, _ := translators.Load().([]Translator)
+	for _, translator := range current {
+		if translated, ok := translator(err); ok && translated != nil {
+			return translated
+		}
+	}
+	return newRestErr(http.StatusInternalServerError, "", defaultMessage(http.StatusInternalServerError), err)
+}
@@ -0,0 +1,109 @@
+package xerrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	validatorOnce     sync.Once
+	structValidator   *validator.Validate
+	validationMu      sync.RWMutex
+	validationFormats = map[string]string{
+		"required": "is required",
+		"email":    "must be a valid email address",
+		"url":      "must be a valid URL",
+		"uuid":     "must be a valid UUID",
+		"min":      "must be at least %s",
+		"max":      "must be at most %s",
+		"len":      "must have a length of %s",
+		"gt":       "must be greater than %s",
+		"gte":      "must be greater than or equal to %s",
+		"lt":       "must be less than %s",
+		"lte":      "must be less than or equal to %s",
+		"oneof":    "must be one of [%s]",
+	}
+)
+
+// Validator returns the validator used by Validate, eg. to register custom
+// rules. Field names are taken from the json tags.
+func Validator() *validator.Validate {
+	validatorOnce.Do(func() {
+		structValidator = validator.New()
+		structValidator.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			switch name {
+			case "-":
+				return ""
+			case "":
+				return field.Name
+			}
+			return name
+		})
+	})
+	return structValidator
+}
+
+// SetValidationMessage sets the message of the violations of a rule. The
+// format may contain a %s verb, replaced by the rule parameter.
+func SetValidationMessage(rule string, format string) {
+	validationMu.Lock()
+	defer validationMu.Unlock()
+	validationFormats[rule] = format
+}
+
+// Validate checks v against its validate struct tags and returns a
+// ValidationError listing every violation, or nil when v is valid. Values are
+// not echoed back, as they may be secrets.
+func Validate(v interface{}) RestErr {
+	err := Validator().Struct(v)
+	if err == nil {
+		return nil
+	}
+	var violations validator.ValidationErrors
+	if !errors.As(err, &violations) {
+		return newRestErr(http.StatusInternalServerError, "", defaultMessage(http.StatusInternalServerError), err)
+	}
+
+	fieldErrors := make([]FieldError, len(violations))
+	for i, violation := range violations {
+		field := violation.Namespace()
+		if dot := strings.IndexByte(field, '.'); dot >= 0 {
+			// Drop the name of the validated struct
+			field = field[dot+1:]
+		}
+		fieldErrors[i] = FieldError{
+			Field:   field,
+			Rule:    violation.Tag(),
+			Message: field + " " + validationMessage(violation),
+		}
+	}
+	e := newRestErr(http.StatusUnprocessableEntity, "", "validation failed", err)
+	e.ErrFieldErrors = fieldErrors
+	return e
+}
+
+func validationMessage(violation validator.FieldError) string {
+	return ValidationMessage(violation.Tag(), violation.Param())
+}
+
+// ValidationMessage returns the message of a violation of rule, without the
+// field name, eg. "must be at least 3" for min=3. See SetValidationMessage.
+func ValidationMessage(rule string, param string) string {
+	validationMu.RLock()
+	format, ok := validationFormats[rule]
+	validationMu.RUnlock()
+	if !ok {
+		return fmt.Sprintf("failed the %s rule", rule)
+	}
+	if strings.Contains(format, "%s") {
+		return fmt.Sprintf(format, param)
+	}
+	return format
+}
@@ -0,0 +1,66 @@
+package xerrors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// FieldError is a single violation found while validating a field
+type FieldError struct {
+	Field   string      `json:"field" xml:"field" yaml:"field"`
+	Rule    string      `json:"rule" xml:"rule" yaml:"rule"`
+	Message string      `json:"message" xml:"message" yaml:"message"`
+	Value   interface{} `json:"value,omitempty" xml:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// ValidationError is a 422 error carrying every field violation found
+type ValidationError interface {
+	RestErr
+	FieldErrors() []FieldError
+}
+
+// Violations collects field errors while validating input
+type Violations []FieldError
+
+// NewValidationError creates a 422 error with the given field violations
+func NewValidationError(message string, fieldErrors ...FieldError) ValidationError {
+	e := newRestErr(http.StatusUnprocessableEntity, "", message, nil)
+	e.ErrFieldErrors = append([]FieldError(nil), fieldErrors...)
+	return e
+}
+
+// WithFieldErrors returns a copy of err carrying additional field
+// violations, keeping its status
+func WithFieldErrors(err RestErr, fieldErrors ...FieldError) ValidationError {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	e.ErrFieldErrors = append(e.ErrFieldErrors, fieldErrors...)
+	return e
+}
+
+// FieldErrors returns the field violations of a validation error
+func (e *restErr) FieldErrors() []FieldError {
+	return e.ErrFieldErrors
+}
+
+// Add records a violation
+func (v *Violations) Add(field string, rule string, message string, value interface{}) {
+	*v = append(*v, FieldError{Field: field, Rule: rule, Message: message, Value: value})
+}
+
+// Addf records a violation with a formatted message
+func (v *Violations) Addf(field string, rule string, value interface{}, format string, args ...interface{}) {
+	v.Add(field, rule, fmt.Sprintf(format, args...), value)
+}
+
+// Err returns a ValidationError holding every violation, or nil when there are none
+func (v Violations) Err(message string) ValidationError {
+	if len(v) == 0 {
+		return nil
+	}
+	e := newRestErr(http.StatusUnprocessableEntity, "", message, nil)
+	e.ErrFieldErrors = append([]FieldError(nil), v...)
+	return e
+}
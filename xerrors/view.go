@@ -0,0 +1,90 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// View selects what a serialized error exposes
+type View int
+
+const (
+	// PublicView only exposes what API clients may see: no causes, stack or
+	// internal details, and safe 5xx messages when SetSafeServerMessages is enabled
+	PublicView View = iota
+	// InternalView also exposes the causes, the stack trace and the internal
+	// details, for logs and admin APIs
+	InternalView
+)
+
+// WithInternalDetail returns a copy of err carrying a detail that is only
+// serialized in the InternalView, eg. the query that failed
+func WithInternalDetail(err RestErr, key string, value interface{}) RestErr {
+	if err == nil {
+		return nil
+	}
+	e := clone(err)
+	if e.internal == nil {
+		e.internal = make(map[string]interface{})
+	}
+	e.internal[key] = value
+	return e
+}
+
+// MarshalView serializes err as JSON with the given view, whatever the debug
+// mode. Errors that are not a RestErr are serialized as a 500.
+func MarshalView(err error, view View) ([]byte, error) {
+	found := ToRestErr(err)
+	if found == nil {
+		return []byte("null"), nil
+	}
+	e, ok := found.(*restErr)
+	if !ok {
+		e = clone(found)
+	}
+	if view == PublicView {
+		e = e.public()
+	}
+
+	type plain restErr
+	out := struct {
+		*plain
+		Causes          []string               `json:"causes,omitempty"`
+		Stack           []string               `json:"stack,omitempty"`
+		InternalDetails map[string]interface{} `json:"internal_details,omitempty"`
+	}{plain: (*plain)(e)}
+	if view == InternalView {
+		if e.cause != nil {
+			out.Causes = causesOf(e.cause)
+		}
+		for _, frame := range e.StackTrace() {
+			out.Stack = append(out.Stack, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		}
+		out.InternalDetails = e.internal
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return appendMembers(body, e.extensions)
+}
+
+// WriteJSONView writes err as a JSON response with the given view. See WriteJSON.
+func WriteJSONView(w http.ResponseWriter, err error, view View) {
+	if err == nil {
+		return
+	}
+	body, marshalErr := MarshalView(err, view)
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	restErr := ToRestErr(err)
+	setResponseHeaders(w, restErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(restErr.StatusCode())
+	_, _ = w.Write(body)
+	ObserveWritten(restErr)
+}
@@ -0,0 +1,27 @@
+package xerrors
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteJSON writes err as a JSON response with its status code. Errors that
+// are not a RestErr are written as a 500 without exposing their message.
+// Nothing is written when err is nil.
+func WriteJSON(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+	restErr := ToRestErr(err)
+	body, marshalErr := json.Marshal(restErr)
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setResponseHeaders(w, restErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(restErr.StatusCode())
+	_, _ = w.Write(body)
+	ObserveWritten(restErr)
+}
@@ -0,0 +1,65 @@
+// Package xecho renders the errors of Echo handlers as xerrors JSON bodies
+package xecho
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Middleware renders the errors returned by handlers as xerrors JSON bodies,
+// unless a response was already committed. Echo's own HTTP errors keep their
+// status and panics are recovered and rendered as a 500.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					if recovered == http.ErrAbortHandler {
+						panic(recovered)
+					}
+					err = render(c, xerrors.FromPanic(recovered))
+				}
+			}()
+
+			if err := next(c); err != nil {
+				return render(c, err)
+			}
+			return nil
+		}
+	}
+}
+
+// HTTPErrorHandler renders errors as xerrors JSON bodies. Set it as the
+// Echo.HTTPErrorHandler to also cover the errors raised outside of handlers,
+// eg. when no route matches.
+func HTTPErrorHandler(err error, c echo.Context) {
+	_ = render(c, err)
+}
+
+func render(c echo.Context, err error) error {
+	if c.Response().Committed {
+		return nil
+	}
+	xerrors.WriteJSON(c.Response(), convert(err))
+	return nil
+}
+
+// convert maps Echo's HTTP errors to a RestErr with the same status
+func convert(err error) error {
+	var restErr xerrors.RestErr
+	if errors.As(err, &restErr) {
+		return restErr
+	}
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	if message, ok := httpErr.Message.(string); ok && message != http.StatusText(httpErr.Code) {
+		return xerrors.Wrap(err, httpErr.Code, message)
+	}
+	return xerrors.Wrap(err, httpErr.Code, xerrors.FromStatus(httpErr.Code).Message())
+}
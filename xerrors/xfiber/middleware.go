@@ -0,0 +1,65 @@
+// Package xfiber renders the errors of Fiber handlers as xerrors JSON bodies
+package xfiber
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Middleware renders the errors returned by handlers as xerrors JSON bodies.
+// Fiber's own errors keep their status and panics are recovered and rendered
+// as a 500.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = ErrorHandler(c, xerrors.FromPanic(recovered))
+			}
+		}()
+
+		if err := c.Next(); err != nil {
+			return ErrorHandler(c, err)
+		}
+		return nil
+	}
+}
+
+// ErrorHandler renders err as an xerrors JSON body. Set it as the
+// fiber.Config ErrorHandler to also cover the errors raised outside of
+// handlers, eg. when no route matches.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	restErr := xerrors.ToRestErr(convert(err))
+	body, marshalErr := json.Marshal(restErr)
+	if marshalErr != nil {
+		return c.Status(http.StatusInternalServerError).SendString(http.StatusText(http.StatusInternalServerError))
+	}
+	for name, values := range xerrors.ResponseHeaders(restErr) {
+		for _, value := range values {
+			c.Set(name, value)
+		}
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	xerrors.ObserveWritten(restErr)
+	return c.Status(restErr.StatusCode()).Send(body)
+}
+
+// convert maps Fiber's errors to a RestErr with the same status
+func convert(err error) error {
+	var restErr xerrors.RestErr
+	if errors.As(err, &restErr) {
+		return restErr
+	}
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) {
+		return err
+	}
+	if fiberErr.Message != "" && fiberErr.Message != http.StatusText(fiberErr.Code) {
+		return xerrors.Wrap(err, fiberErr.Code, fiberErr.Message)
+	}
+	return xerrors.Wrap(err, fiberErr.Code, xerrors.FromStatus(fiberErr.Code).Message())
+}
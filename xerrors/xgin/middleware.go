@@ -0,0 +1,34 @@
+// Package xgin renders the errors of Gin handlers as xerrors JSON bodies
+package xgin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Middleware renders the last error added to the context with c.Error as an
+// xerrors JSON body, unless the handler already wrote a response. Panics are
+// recovered and rendered as a 500.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				c.Abort()
+				xerrors.WriteJSON(c.Writer, xerrors.FromPanic(recovered))
+			}
+		}()
+
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		xerrors.WriteJSON(c.Writer, c.Errors.Last().Err)
+	}
+}
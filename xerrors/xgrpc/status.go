@@ -0,0 +1,178 @@
+// Package xgrpc maps xerrors values to gRPC statuses and back, so services
+// exposing both REST and gRPC endpoints share a single error vocabulary.
+package xgrpc
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Domain is the domain set on the ErrorInfo details of converted errors
+var Domain = "xutils-go"
+
+var httpToCode = map[int]codes.Code{
+	http.StatusBadRequest:             codes.InvalidArgument,
+	http.StatusUnauthorized:           codes.Unauthenticated,
+	http.StatusForbidden:              codes.PermissionDenied,
+	http.StatusNotFound:               codes.NotFound,
+	http.StatusConflict:               codes.AlreadyExists,
+	http.StatusGone:                   codes.NotFound,
+	http.StatusPreconditionFailed:     codes.FailedPrecondition,
+	http.StatusUnprocessableEntity:    codes.InvalidArgument,
+	http.StatusTooManyRequests:        codes.ResourceExhausted,
+	xerrors.StatusClientClosedRequest: codes.Canceled,
+	http.StatusInternalServerError:    codes.Internal,
+	http.StatusNotImplemented:         codes.Unimplemented,
+	http.StatusBadGateway:             codes.Unavailable,
+	http.StatusServiceUnavailable:     codes.Unavailable,
+	http.StatusGatewayTimeout:         codes.DeadlineExceeded,
+}
+
+var codeToHTTP = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           xerrors.StatusClientClosedRequest,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// CodeFromHTTP returns the gRPC code matching an HTTP status
+func CodeFromHTTP(httpStatus int) codes.Code {
+	if code, ok := httpToCode[httpStatus]; ok {
+		return code
+	}
+	switch {
+	case httpStatus >= 200 && httpStatus < 300:
+		return codes.OK
+	case httpStatus >= 400 && httpStatus < 500:
+		return codes.FailedPrecondition
+	default:
+		return codes.Unknown
+	}
+}
+
+// HTTPFromCode returns the HTTP status matching a gRPC code
+func HTTPFromCode(code codes.Code) int {
+	if httpStatus, ok := codeToHTTP[code]; ok {
+		return httpStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// ToGRPCStatus converts err into a gRPC status. The error code and details
+// travel as an ErrorInfo and field violations as a BadRequest detail.
+func ToGRPCStatus(err xerrors.RestErr) *status.Status {
+	st := status.New(CodeFromHTTP(err.StatusCode()), err.Message())
+
+	var details []protoadapt.MessageV1
+	restDetails := xerrors.DetailsOf(err)
+	if err.Code() != "" || len(restDetails) > 0 {
+		info := &errdetails.ErrorInfo{Reason: err.Code(), Domain: Domain}
+		if len(restDetails) > 0 {
+			info.Metadata = make(map[string]string, len(restDetails))
+			for k, v := range restDetails {
+				info.Metadata[k] = fmt.Sprint(v)
+			}
+		}
+		details = append(details, info)
+	}
+	if rateLimit, ok := xerrors.RateLimitOf(err); ok && rateLimit.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(rateLimit.RetryAfter)})
+	}
+	if v, ok := err.(xerrors.ValidationError); ok && len(v.FieldErrors()) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, fe := range v.FieldErrors() {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+				Reason:      fe.Rule,
+			})
+		}
+		details = append(details, badRequest)
+	}
+
+	if len(details) == 0 {
+		return st
+	}
+	withDetails, detailsErr := st.WithDetails(details...)
+	if detailsErr != nil {
+		return st
+	}
+	return withDetails
+}
+
+// FromGRPCError converts an error returned by a gRPC call into a RestErr that
+// keeps it as its cause. It returns nil for a nil error.
+func FromGRPCError(err error) xerrors.RestErr {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "internal server error")
+	}
+
+	var violations xerrors.Violations
+	var metadata map[string]string
+	var reason string
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+			metadata = d.Metadata
+		case *errdetails.BadRequest:
+			for _, fv := range d.FieldViolations {
+				violations.Add(fv.Field, fv.Reason, fv.Description, nil)
+			}
+		}
+	}
+
+	restErr := xerrors.Wrap(err, HTTPFromCode(st.Code()), st.Message())
+	if len(violations) > 0 {
+		restErr = xerrors.WithFieldErrors(restErr, violations...)
+	}
+	if reason != "" {
+		restErr = xerrors.WithCode(restErr, reason)
+	}
+	if len(metadata) > 0 {
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			restErr = xerrors.WithDetail(restErr, k, metadata[k])
+		}
+	}
+	return restErr
+}
+
+// Translator converts gRPC status errors with FromGRPCError. Register it with
+// xerrors.RegisterTranslator so gRPC errors are rendered with their status.
+func Translator(err error) (xerrors.RestErr, bool) {
+	if _, ok := status.FromError(err); !ok {
+		return nil, false
+	}
+	return FromGRPCError(err), true
+}
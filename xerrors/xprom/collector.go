@@ -0,0 +1,57 @@
+// Package xprom counts xerrors errors with Prometheus, labeled by status code,
+// error code and category
+package xprom
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Collector counts the errors written. It is both an xerrors.Observer and a
+// prometheus.Collector.
+type Collector struct {
+	written *prometheus.CounterVec
+}
+
+// NewCollector creates the counter <namespace>_errors_written_total, labeled
+// by status, code and category
+func NewCollector(namespace string) *Collector {
+	labels := []string{"status", "code", "category"}
+	return &Collector{
+		written: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_written_total",
+			Help:      "Errors written as responses, by status code, error code and category.",
+		}, labels),
+	}
+}
+
+// Register creates a collector, registers it with registerer and starts
+// observing errors. Use prometheus.DefaultRegisterer for the default registry.
+func Register(registerer prometheus.Registerer, namespace string) (*Collector, error) {
+	c := NewCollector(namespace)
+	if err := registerer.Register(c); err != nil {
+		return nil, err
+	}
+	xerrors.AddObserver(c)
+	return c, nil
+}
+
+func (c *Collector) Written(err xerrors.RestErr) {
+	c.written.WithLabelValues(labels(err)...).Inc()
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.written.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.written.Collect(ch)
+}
+
+func labels(err xerrors.RestErr) []string {
+	return []string{strconv.Itoa(err.StatusCode()), err.Code(), string(xerrors.CategoryOf(err))}
+}
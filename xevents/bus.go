@@ -0,0 +1,283 @@
+// Package xevents is an in-process publish/subscribe bus of typed domain
+// events, for the events of a service before it needs a broker
+package xevents
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Topic is a topic of events of type T
+//
+//	var OrderCreated = xevents.NewTopic[OrderCreated]("orders.created")
+type Topic[T any] struct {
+	name string
+}
+
+// NewTopic creates a topic of events of type T
+func NewTopic[T any](name string) Topic[T] {
+	return Topic[T]{name: name}
+}
+
+// Name returns the name of the topic
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Options configures a Bus
+type Options struct {
+	// Buffer is the number of events queued for an asynchronous subscriber,
+	// 1024 by default. Publish blocks when a queue is full.
+	Buffer int
+}
+
+// Bus dispatches the events published on a topic to its subscribers.
+// Synchronous subscribers are called by Publish, in the order of
+// subscription; asynchronous subscribers each have a queue handled in the
+// background, receiving the events in the order they were queued for it,
+// which is the order of publication for a single publisher. A subscriber
+// failing or panicking does not affect the others.
+type Bus struct {
+	opts Options
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type topic struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	handle func(ctx context.Context, event interface{}) error
+	// queue is nil for synchronous subscribers
+	queue chan delivery
+	// stop is closed on unsubscribe, releasing the publishers waiting for
+	// room in queue
+	stop chan struct{}
+	once sync.Once
+	// mu orders the sends to queue and guards closed
+	mu     sync.Mutex
+	closed bool
+}
+
+type delivery struct {
+	ctx   context.Context
+	event interface{}
+}
+
+// Default is the bus of the package functions called with a nil bus
+var Default = New(Options{})
+
+// New creates a bus
+func New(opts Options) *Bus {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1024
+	}
+	return &Bus{opts: opts, topics: make(map[string]*topic)}
+}
+
+// Subscribe calls fn with the events of t, synchronously in Publish, whose
+// error it makes fail. It returns the function unsubscribing fn.
+func Subscribe[T any](bus *Bus, t Topic[T], fn func(ctx context.Context, event T) error) (unsubscribe func()) {
+	return orDefault(bus).subscribe(t.name, wrap(fn), false)
+}
+
+// SubscribeAsync calls fn with the events of t in the background, in the
+// order they were published. The errors of fn are logged. It returns the
+// function unsubscribing fn, which handles the events queued already.
+func SubscribeAsync[T any](bus *Bus, t Topic[T], fn func(ctx context.Context, event T) error) (unsubscribe func()) {
+	return orDefault(bus).subscribe(t.name, wrap(fn), true)
+}
+
+// Publish publishes event on t. It queues the event for the asynchronous
+// subscribers, waiting for room until ctx is done, then calls the
+// synchronous subscribers and returns their errors joined.
+func Publish[T any](ctx context.Context, bus *Bus, t Topic[T], event T) error {
+	return orDefault(bus).publish(ctx, t.name, event)
+}
+
+// Close stops accepting events and waits until ctx is done for the
+// asynchronous subscribers to handle their queued events
+func (b *Bus) Close(ctx context.Context) error {
+	var subscribers []*subscriber
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, t := range b.topics {
+			t.mu.Lock()
+			subscribers = append(subscribers, t.subscribers...)
+			t.subscribers = nil
+			t.mu.Unlock()
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range subscribers {
+		s.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return xerrors.WrapServiceUnavailable(ctx.Err(), "the event bus did not drain its queues")
+	}
+}
+
+func (b *Bus) subscribe(name string, handle func(ctx context.Context, event interface{}) error, async bool) func() {
+	s := &subscriber{handle: handle}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return func() {}
+	}
+	t := b.topics[name]
+	if t == nil {
+		t = &topic{}
+		b.topics[name] = t
+	}
+	if async {
+		s.queue = make(chan delivery, b.opts.Buffer)
+		s.stop = make(chan struct{})
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for d := range s.queue {
+				if err := call(d.ctx, s, d.event); err != nil {
+					xlogger.Error("xevents: asynchronous subscriber failed", err, zap.String("topic", name))
+				}
+			}
+		}()
+	}
+
+	t.mu.Lock()
+	// Copied on write, so publish can iterate without the lock
+	t.subscribers = append(t.subscribers[:len(t.subscribers):len(t.subscribers)], s)
+	t.mu.Unlock()
+
+	return func() {
+		if t.remove(s) {
+			s.close()
+		}
+	}
+}
+
+// remove removes s from the subscribers, reporting whether it was one
+func (t *topic) remove(s *subscriber) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, other := range t.subscribers {
+		if other == s {
+			t.subscribers = append(t.subscribers[:i:i], t.subscribers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// send queues d for an asynchronous subscriber, waiting for room until ctx
+// is done. The events sent once it is closed are dropped.
+func (s *subscriber) send(ctx context.Context, d delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.queue <- d:
+		return nil
+	case <-s.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close closes the queue of an asynchronous subscriber once the publishers
+// waiting for room gave up
+func (s *subscriber) close() {
+	if s.queue == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+		s.mu.Lock()
+		s.closed = true
+		close(s.queue)
+		s.mu.Unlock()
+	})
+}
+
+func (b *Bus) publish(ctx context.Context, name string, event interface{}) error {
+	b.mu.Lock()
+	t, closed := b.topics[name], b.closed
+	b.mu.Unlock()
+	if closed {
+		return xerrors.NewServiceUnavailableError("the event bus is closed")
+	}
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	subscribers := t.subscribers
+	t.mu.Unlock()
+	for _, s := range subscribers {
+		if s.queue == nil {
+			continue
+		}
+		if err := s.send(ctx, delivery{ctx: context.WithoutCancel(ctx), event: event}); err != nil {
+			return xerrors.WrapServiceUnavailable(err, "the queue of a subscriber of "+name+" is full")
+		}
+	}
+
+	var errs []xerrors.RestErr
+	for _, s := range subscribers {
+		if s.queue != nil {
+			continue
+		}
+		if err := call(ctx, s, event); err != nil {
+			errs = append(errs, xerrors.Translate(err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return xerrors.Join(errs...)
+}
+
+// call runs the subscriber, turning its panics into errors
+func call(ctx context.Context, s *subscriber, event interface{}) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	return s.handle(ctx, event)
+}
+
+func wrap[T any](fn func(ctx context.Context, event T) error) func(ctx context.Context, event interface{}) error {
+	return func(ctx context.Context, event interface{}) error {
+		return fn(ctx, event.(T))
+	}
+}
+
+func orDefault(bus *Bus) *Bus {
+	if bus == nil {
+		return Default
+	}
+	return bus
+}
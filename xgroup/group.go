@@ -0,0 +1,131 @@
+// Package xgroup runs goroutines as a group: bounded concurrency, panics
+// turned into errors and the errors of every task collected
+package xgroup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Options configures a Group
+type Options struct {
+	// Limit is the number of tasks running at once, Go blocking past it.
+	// Zero means unlimited.
+	Limit int
+	// CollectAll keeps running the tasks after an error and makes Wait return
+	// every error. By default the first error cancels the group context.
+	CollectAll bool
+	// TaskTimeout cancels the context of a task lasting longer, zero meaning no timeout
+	TaskTimeout time.Duration
+}
+
+// Group runs tasks in goroutines and waits for them
+type Group struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	tasks int
+	first error
+	errs  []xerrors.RestErr // by task index
+}
+
+// New creates a group whose tasks run with a context derived from ctx,
+// returned as well. It is canceled on the first error unless
+// opts.CollectAll is set, and once Wait returns.
+func New(ctx context.Context, opts Options) (*Group, context.Context) {
+	g := &Group{opts: opts}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	if opts.Limit > 0 {
+		g.sem = make(chan struct{}, opts.Limit)
+	}
+	return g, g.ctx
+}
+
+// Go runs task in a goroutine, blocking while the limit of running tasks is
+// reached. Each task gets its own context, canceled once it returns.
+func (g *Group) Go(task func(ctx context.Context) error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(task)
+}
+
+// TryGo runs task when the limit of running tasks is not reached, reporting
+// whether it did
+func (g *Group) TryGo(task func(ctx context.Context) error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(task)
+	return true
+}
+
+// Wait waits for every task and returns the first error, or with CollectAll
+// an xerrors.MultiError of every error, indexed by the order of the tasks,
+// those that are not a RestErr being translated with xerrors.Translate
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.opts.CollectAll || g.first == nil {
+		return g.first
+	}
+	return xerrors.Join(g.errs...)
+}
+
+func (g *Group) start(task func(ctx context.Context) error) {
+	g.mu.Lock()
+	index := g.tasks
+	g.tasks++
+	g.errs = append(g.errs, nil)
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		if err := g.run(task); err != nil {
+			g.mu.Lock()
+			if g.first == nil {
+				g.first = err
+			}
+			g.errs[index] = xerrors.Translate(err)
+			g.mu.Unlock()
+			if !g.opts.CollectAll {
+				g.cancel()
+			}
+		}
+	}()
+}
+
+// run calls task with its own context, turning its panics into errors
+func (g *Group) run(task func(ctx context.Context) error) (err error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if g.opts.TaskTimeout > 0 {
+		ctx, cancel = context.WithTimeout(g.ctx, g.opts.TaskTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(g.ctx)
+	}
+	defer cancel()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	return task(ctx)
+}
@@ -0,0 +1,54 @@
+package xhealth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DB checks a database answers pings
+func DB(db *sql.DB) CheckFunc {
+	return db.PingContext
+}
+
+// HTTP checks a GET of url answers with a 2xx status, eg. the health
+// endpoint of an upstream API. client defaults to http.DefaultClient, the
+// check timeout bounding the request.
+func HTTP(client *http.Client, url string) CheckFunc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return fmt.Errorf("GET %s: %s", url, response.Status)
+		}
+		return nil
+	}
+}
+
+// DiskSpace checks the file system of path has at least minFree bytes
+// available
+func DiskSpace(path string, minFree uint64) CheckFunc {
+	return func(context.Context) error {
+		free, err := freeSpace(path)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d bytes available on %s, %d required", free, path, minFree)
+		}
+		return nil
+	}
+}
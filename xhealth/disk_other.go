@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package xhealth
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func freeSpace(string) (uint64, error) {
+	return 0, fmt.Errorf("disk space checks are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,320 @@
+// Package xhealth runs named health checks and serves them on liveness and
+// readiness endpoints
+package xhealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xcache"
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Status is the status of a check or of a report
+type Status string
+
+const (
+	// Up means the check passed
+	Up Status = "up"
+	// Degraded means a non critical check failed
+	Degraded Status = "degraded"
+	// Down means a critical check failed
+	Down Status = "down"
+	// Starting means a check failed within its StartupGrace, before it ever
+	// passed
+	Starting Status = "starting"
+	// NotReady means the checker was set not ready, eg. during a graceful
+	// shutdown
+	NotReady Status = "not_ready"
+)
+
+// CheckFunc checks a dependency, returning an error when it is unhealthy
+type CheckFunc func(ctx context.Context) error
+
+// Check is a named health check
+type Check struct {
+	Name  string
+	Check CheckFunc
+	// Timeout fails the check when it lasts longer, 5s by default
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused, so probes do not hammer the
+	// dependencies. Zero means 5s, negative means no cache.
+	CacheTTL time.Duration
+	// Critical checks make the report Down when they fail, the others only
+	// Degraded
+	Critical bool
+	// Liveness checks are run by the liveness endpoint too. Only checks of
+	// the process itself belong there, eg. a deadlock detector: a failing
+	// liveness probe restarts the process.
+	Liveness bool
+	// StartupGrace is how long after the registration failures are reported
+	// as Starting rather than Down, until the check passes once, eg. while a
+	// cache warms up. Starting critical checks fail the readiness, not the
+	// liveness.
+	StartupGrace time.Duration
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Status    Status    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of the checks, grouped into the critical ones and
+// the informational ones
+type Report struct {
+	Status        Status            `json:"status"`
+	Critical      map[string]Result `json:"critical,omitempty"`
+	Informational map[string]Result `json:"informational,omitempty"`
+}
+
+// Checker runs registered checks
+type Checker struct {
+	mu       sync.RWMutex
+	checks   map[string]*entry
+	results  map[string]Result
+	runs     xcache.Group[string, Result]
+	notReady atomic.Bool
+}
+
+// entry is a registered check
+type entry struct {
+	Check
+	registeredAt time.Time
+	passed       atomic.Bool
+}
+
+// NewChecker creates a ready checker without checks
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]*entry), results: make(map[string]Result)}
+}
+
+// Default is the checker of the package level functions
+var Default = NewChecker()
+
+// Register registers a check. It panics when the name is empty or already
+// registered, as checks are meant to be registered once at startup.
+func (c *Checker) Register(check Check) {
+	if check.Name == "" || check.Check == nil {
+		panic("xhealth: cannot register a check without a name or a function")
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = 5 * time.Second
+	}
+	if check.CacheTTL == 0 {
+		check.CacheTTL = 5 * time.Second
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.checks[check.Name]; ok {
+		panic(fmt.Sprintf("xhealth: check %s is already registered", check.Name))
+	}
+	c.checks[check.Name] = &entry{Check: check, registeredAt: time.Now()}
+}
+
+// Flush removes every check
+func (c *Checker) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = make(map[string]*entry)
+	c.results = make(map[string]Result)
+}
+
+// SetReady flips the readiness of the checker. While not ready, the
+// readiness endpoint answers NotReady without running the checks. An
+// xhttpserver.Server sets it not ready when shutting down, before the
+// connections drain.
+func (c *Checker) SetReady(ready bool) {
+	c.notReady.Store(!ready)
+}
+
+// Ready reports whether the checker is ready, see SetReady
+func (c *Checker) Ready() bool {
+	return !c.notReady.Load()
+}
+
+// Run runs the checks, all of them or the liveness ones only, in parallel
+func (c *Checker) Run(ctx context.Context, livenessOnly bool) Report {
+	c.mu.RLock()
+	var checks []*entry
+	for _, check := range c.checks {
+		if check.Liveness || !livenessOnly {
+			checks = append(checks, check)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.result(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: Up}
+	for i, check := range checks {
+		status := results[i].Status
+		if status == Starting && livenessOnly {
+			// A process still starting must not be restarted
+			status = Up
+		}
+		if check.Critical {
+			report.Critical = add(report.Critical, check.Name, results[i])
+			report.Status = worst(report.Status, status)
+		} else {
+			report.Informational = add(report.Informational, check.Name, results[i])
+			if status == Down {
+				report.Status = worst(report.Status, Degraded)
+			}
+		}
+	}
+	return report
+}
+
+func add(results map[string]Result, name string, result Result) map[string]Result {
+	if results == nil {
+		results = make(map[string]Result)
+	}
+	results[name] = result
+	return results
+}
+
+// severity orders the statuses of a report, the worst one winning
+var severity = map[Status]int{Up: 0, Degraded: 1, Starting: 2, NotReady: 3, Down: 4}
+
+func worst(status Status, other Status) Status {
+	if severity[other] > severity[status] {
+		return other
+	}
+	return status
+}
+
+// result returns the cached result of check, or runs it once for the
+// concurrent callers
+func (c *Checker) result(ctx context.Context, check *entry) Result {
+	c.mu.RLock()
+	cached, ok := c.results[check.Name]
+	c.mu.RUnlock()
+	if ok && check.CacheTTL > 0 && time.Since(cached.CheckedAt) < check.CacheTTL {
+		return cached
+	}
+	result, _, _ := c.runs.Do(check.Name, func() (Result, error) {
+		// The result is shared, so it must not depend on the probe giving up
+		result := run(context.WithoutCancel(ctx), &check.Check)
+		if result.Status == Up {
+			check.passed.Store(true)
+		} else if !check.passed.Load() && time.Since(check.registeredAt) < check.StartupGrace {
+			result.Status = Starting
+		}
+		c.mu.Lock()
+		c.results[check.Name] = result
+		c.mu.Unlock()
+		return result, nil
+	})
+	return result
+}
+
+// run runs check within its timeout, even when it ignores its context
+func run(ctx context.Context, check *Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- xerrors.FromPanic(recovered)
+			}
+		}()
+		done <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", check.Timeout)
+	}
+	result := Result{Status: Up, Duration: time.Since(started).String(), CheckedAt: time.Now()}
+	if err != nil {
+		result.Status = Down
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler serves the liveness checks, eg. on /healthz, with a 503
+// when the report is Down
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(true)
+}
+
+// ReadinessHandler serves every check, eg. on /readyz, with a 503 when the
+// report is Down or Starting, or when the checker is not ready
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(false)
+}
+
+func (c *Checker) handler(livenessOnly bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !livenessOnly && !c.Ready() {
+			writeReport(w, Report{Status: NotReady})
+			return
+		}
+		writeReport(w, c.Run(r.Context(), livenessOnly))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if severity[report.Status] > severity[Degraded] {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// Register registers a check on the Default checker
+func Register(check Check) {
+	Default.Register(check)
+}
+
+// FlushChecks removes every check of the Default checker
+func FlushChecks() {
+	Default.Flush()
+}
+
+// SetReady flips the readiness of the Default checker
+func SetReady(ready bool) {
+	Default.SetReady(ready)
+}
+
+// Ready reports whether the Default checker is ready
+func Ready() bool {
+	return Default.Ready()
+}
+
+// LivenessHandler serves the liveness checks of the Default checker
+func LivenessHandler() http.Handler {
+	return Default.LivenessHandler()
+}
+
+// ReadinessHandler serves the checks of the Default checker
+func ReadinessHandler() http.Handler {
+	return Default.ReadinessHandler()
+}
@@ -0,0 +1,17 @@
+// Package xredis implements xhealth checks of Redis
+package xredis
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/XandaLtd/xutils-go/xhealth"
+)
+
+// Check checks Redis answers pings
+func Check(client redis.UniversalClient) xhealth.CheckFunc {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
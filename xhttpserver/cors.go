@@ -0,0 +1,110 @@
+package xhttpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORS
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to call, eg.
+	// "https://app.example.com". "*" allows every origin and a leading
+	// "*." every subdomain, eg. "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE
+	AllowedMethods []string
+	// AllowedHeaders defaults to the headers requested by the preflight
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers readable by the caller
+	ExposedHeaders []string
+	// AllowCredentials allows cookies. The allowed origin is then echoed
+	// rather than "*", which it cannot be combined with.
+	AllowCredentials bool
+	// MaxAge is how long browsers cache the preflight, 10m by default
+	MaxAge time.Duration
+}
+
+// CORS answers the preflight requests and sets the CORS headers of the
+// requests from allowed origins. It panics when AllowCredentials is set with
+// the "*" origin, which would let every website make credentialed requests.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	if opts.AllowCredentials && contains(opts.AllowedOrigins, "*") {
+		panic("xhttpserver: CORS cannot allow credentials from every origin, list the allowed origins")
+	}
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = 10 * time.Minute
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || !allowedOrigin(opts.AllowedOrigins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if contains(opts.AllowedOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Preflight
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func allowedOrigin(allowed []string, origin string) bool {
+	for _, pattern := range allowed {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(pattern, "*."); ok {
+			rest, hasScheme := strings.CutPrefix(origin, scheme)
+			if hasScheme && strings.HasSuffix(rest, "."+domain) && !strings.Contains(strings.TrimSuffix(rest, "."+domain), "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package xhttpserver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Gzip compresses the responses of clients accepting gzip, at level, eg.
+// gzip.DefaultCompression. Responses already encoded, without body or of
+// compressed types such as images are sent as is.
+func Gzip(level int) func(http.Handler) http.Handler {
+	pool := sync.Pool{New: func() interface{} {
+		writer, err := gzip.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			writer = gzip.NewWriter(io.Discard)
+		}
+		return writer
+	}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, pool: &pool}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accepts coding
+func acceptsEncoding(header string, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(name), coding) {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipWriter decides to compress when the header is written
+type gzipWriter struct {
+	http.ResponseWriter
+	pool        *sync.Pool
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	// Informational responses, eg. 103 Early Hints, precede the final one
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	g.wroteHeader = true
+	header := g.Header()
+	if compressible(status, header) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.gz = g.pool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush supports streaming responses
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSockets, whose upgrade responses are not compressed
+func (g *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", g.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipWriter) close() {
+	if g.gz == nil {
+		return
+	}
+	_ = g.gz.Close()
+	g.gz.Reset(io.Discard)
+	g.pool.Put(g.gz)
+	g.gz = nil
+}
+
+func compressible(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "image/svg") {
+		return true
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/x-gzip", "application/octet-stream", "text/event-stream"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package xhttpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xrest"
+)
+
+// Chain wraps handler with middlewares, the first one being the outermost
+func Chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Defaults are the middlewares of most services: request id, access log,
+// panic recovery and security headers
+func Defaults() []func(http.Handler) http.Handler {
+	return []func(http.Handler) http.Handler{
+		RequestID(""),
+		AccessLog(),
+		Recover(),
+		SecurityHeaders(SecurityOptions{}),
+	}
+}
+
+// RequestID reads the request id of the header, X-Request-Id by default, or
+// generates one. It is sent back in the same header and stored in the
+// request context under xrest.RequestIDKey, so xrest clients propagate it.
+func RequestID(header string) func(http.Handler) http.Handler {
+	if header == "" {
+		header = string(xrest.RequestIDKey)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if id == "" || len(id) > 128 {
+				id = newRequestID()
+			}
+			w.Header().Set(header, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), xrest.RequestIDKey, id)))
+		})
+	}
+}
+
+// RequestIDFrom returns the request id stored by RequestID
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(xrest.RequestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
+
+// Recover turns the panics of the handlers into a logged 500 xerrors JSON
+// body carrying the request id
+func Recover() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				err := xerrors.WithRequestID(xerrors.FromPanic(recovered), RequestIDFrom(r.Context()))
+				xerrors.WriteJSON(w, xerrors.LogAndReturn(err, zap.String("method", r.Method), zap.String("path", r.URL.Path)))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AccessLog logs every request with xlogger: 5xx as errors, 4xx as warnings
+// and the others as info
+func AccessLog() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.Status()
+			tags := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Int64("bytes", recorder.bytes),
+				zap.String("duration", time.Since(started).String()),
+				zap.String("remote_addr", r.RemoteAddr),
+			}
+			if id := RequestIDFrom(r.Context()); id != "" {
+				tags = append(tags, zap.String("request_id", id))
+			}
+			message := fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, status)
+			switch {
+			case status >= http.StatusInternalServerError:
+				xlogger.Error(message, nil, tags...)
+			case status >= http.StatusBadRequest:
+				xlogger.Warning(message, tags...)
+			default:
+				xlogger.Info(message, tags...)
+			}
+		})
+	}
+}
+
+// MaxBodySize rejects with a 413 the request bodies larger than limit bytes.
+// Bodies without Content-Length fail when read past the limit.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				xerrors.WriteJSON(w, xerrors.NewRestError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", limit)))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SecurityOptions configures SecurityHeaders. Empty values take the
+// default, "-" omits the header.
+type SecurityOptions struct {
+	// ContentSecurityPolicy defaults to "default-src 'none'; frame-ancestors 'none'",
+	// fit for APIs
+	ContentSecurityPolicy string
+	// FrameOptions defaults to DENY
+	FrameOptions string
+	// ReferrerPolicy defaults to no-referrer
+	ReferrerPolicy string
+	// HSTSMaxAge sets Strict-Transport-Security on HTTPS requests, 1 year by
+	// default, negative to omit it
+	HSTSMaxAge time.Duration
+}
+
+// SecurityHeaders sets the usual security headers on every response
+func SecurityHeaders(opts SecurityOptions) func(http.Handler) http.Handler {
+	headers := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": orDefault(opts.ContentSecurityPolicy, "default-src 'none'; frame-ancestors 'none'"),
+		"X-Frame-Options":         orDefault(opts.FrameOptions, "DENY"),
+		"Referrer-Policy":         orDefault(opts.ReferrerPolicy, "no-referrer"),
+	}
+	if opts.HSTSMaxAge == 0 {
+		opts.HSTSMaxAge = 365 * 24 * time.Hour
+	}
+	hsts := ""
+	if opts.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d; includeSubDomains", int64(opts.HSTSMaxAge.Seconds()))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range headers {
+				if value != "-" {
+					w.Header().Set(name, value)
+				}
+			}
+			if hsts != "" && r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func orDefault(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// statusRecorder records the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status written, 200 when none was
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// Flush supports streaming responses
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSockets
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", s.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,291 @@
+package xhttpserver
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// CodeInvalidQuery is the code of the errors of BindList and BindQuery
+const CodeInvalidQuery = "invalid_query"
+
+// SortField is a field of a sort parameter, eg. "-created_at"
+type SortField struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// String formats the field as in the sort parameter
+func (s SortField) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// ListParams are the parameters of a list endpoint:
+//
+//	GET /orders?page=2&per_page=50&sort=-created_at,id&status=paid
+//
+// Cursor pagination replaces page with cursor, an opaque token of the
+// previous response.
+type ListParams struct {
+	Page    int
+	PerPage int
+	Cursor  string
+	Sort    []SortField
+	// Filters holds the values of the ListOptions.Filters parameters present
+	Filters url.Values
+}
+
+// Offset is the number of items before the page
+func (p ListParams) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// ListOptions configures BindList
+type ListOptions struct {
+	// PerPage defaults to 20
+	PerPage int
+	// MaxPerPage defaults to 100
+	MaxPerPage int
+	// Sortable are the fields allowed in sort, none by default
+	Sortable []string
+	// Sort is the sort when the parameter is absent, eg. "-created_at"
+	Sort string
+	// Filters are the parameters copied into ListParams.Filters, the others
+	// are ignored
+	Filters []string
+}
+
+// BindList parses the page, per_page, cursor and sort parameters of r, and
+// the filter parameters into filters when not nil, see BindQuery. Invalid
+// values are reported together in a 400.
+func BindList(r *http.Request, opts ListOptions, filters interface{}) (ListParams, error) {
+	if opts.PerPage <= 0 {
+		opts.PerPage = 20
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = 100
+	}
+	query := r.URL.Query()
+	params := ListParams{Page: 1, PerPage: opts.PerPage, Cursor: query.Get("cursor")}
+	var violations xerrors.Violations
+
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		switch {
+		case err != nil || page < 1:
+			violations.Add("page", "min", "page must be a number greater than 0", value)
+		case params.Cursor != "":
+			violations.Add("page", "excluded_with", "page cannot be used with cursor", value)
+		default:
+			params.Page = page
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > opts.MaxPerPage {
+			violations.Addf("per_page", "max", value, "per_page must be a number between 1 and %d", opts.MaxPerPage)
+		} else {
+			params.PerPage = perPage
+		}
+	}
+	// Bounds Offset
+	if params.Page > math.MaxInt/params.PerPage {
+		violations.Add("page", "max", "page is too large", query.Get("page"))
+		params.Page = 1
+	}
+
+	sort := opts.Sort
+	if query.Has("sort") {
+		sort = query.Get("sort")
+	}
+	params.Sort = parseSort(sort, opts.Sortable, &violations)
+
+	for _, name := range opts.Filters {
+		if values, ok := query[name]; ok {
+			if params.Filters == nil {
+				params.Filters = make(url.Values)
+			}
+			params.Filters[name] = values
+		}
+	}
+	if filters != nil {
+		bindQuery(query, filters, &violations)
+	}
+	return params, invalidQuery(violations)
+}
+
+func parseSort(sort string, sortable []string, violations *xerrors.Violations) []SortField {
+	var fields []SortField
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field := SortField{Field: strings.TrimPrefix(part, "+")}
+		if name, desc := strings.CutPrefix(part, "-"); desc {
+			field = SortField{Field: name, Desc: true}
+		}
+		switch {
+		case !contains(sortable, field.Field):
+			violations.Addf("sort", "oneof", part, "cannot sort by %s, sortable fields are [%s]", field.Field, strings.Join(sortable, " "))
+		case seen[field.Field]:
+			violations.Addf("sort", "unique", part, "cannot sort twice by %s", field.Field)
+		default:
+			seen[field.Field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// BindQuery parses the query parameters of r into the fields of dst, a
+// pointer to a struct, named by their query tag:
+//
+//	type OrderFilters struct {
+//		Status []string   `query:"status"`
+//		Since  time.Time  `query:"since"`
+//		Paid   *bool      `query:"paid"`
+//	}
+//
+// Strings, booleans, numbers, durations, RFC 3339 times, encoding.TextUnmarshaler
+// and pointers to them are supported, and slices of them from repeated or
+// comma separated values. Invalid values are reported together in a 400.
+func BindQuery(r *http.Request, dst interface{}) error {
+	var violations xerrors.Violations
+	bindQuery(r.URL.Query(), dst, &violations)
+	return invalidQuery(violations)
+}
+
+func bindQuery(query url.Values, dst interface{}, violations *xerrors.Violations) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("xhttpserver: cannot bind the query into %T, a pointer to a struct is required", dst))
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := strings.Split(field.Tag.Get("query"), ",")[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		values, ok := query[name]
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), values); err != nil {
+			violations.Addf(name, "type", strings.Join(values, ","), "%s %s", name, err.Error())
+		}
+	}
+}
+
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && !field.Addr().Type().Implements(textUnmarshaler) {
+		var items []string
+		for _, value := range values {
+			items = append(items, strings.Split(value, ",")...)
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[len(values)-1])
+}
+
+var (
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType    = reflect.TypeOf(time.Duration(0))
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+func setValue(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	switch {
+	case field.Type() == timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("must be an RFC 3339 time")
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration")
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Addr().Type().Implements(textUnmarshaler):
+		if err := field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("is invalid")
+		}
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a positive integer")
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetFloat(f)
+	default:
+		panic(fmt.Sprintf("xhttpserver: cannot bind a query parameter into a %s", field.Type()))
+	}
+	return nil
+}
+
+func invalidQuery(violations xerrors.Violations) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return xerrors.New().
+		Status(http.StatusBadRequest).
+		Code(CodeInvalidQuery).
+		Message("invalid query parameters").
+		Detail("violations", []xerrors.FieldError(violations)).
+		Build()
+}
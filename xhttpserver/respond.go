@@ -0,0 +1,100 @@
+package xhttpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Envelope is the body of the responses when SetEnvelope is enabled:
+//
+//	{"data": {...}, "meta": {...}}
+type Envelope[T any] struct {
+	Data T           `json:"data"`
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+func (Envelope[T]) envelope() {}
+
+// enveloped is implemented by every Envelope, so RespondJSON does not wrap
+// them twice
+type enveloped interface {
+	envelope()
+}
+
+// PageMeta is the meta of a list response, see ListParams.Meta
+type PageMeta struct {
+	Page       int    `json:"page,omitempty"`
+	PerPage    int    `json:"per_page"`
+	Total      *int   `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Meta returns the meta of the page of p. total is omitted when negative,
+// eg. when counting is too expensive, and the page when paginating with a
+// cursor.
+func (p ListParams) Meta(total int, nextCursor string) PageMeta {
+	meta := PageMeta{PerPage: p.PerPage, NextCursor: nextCursor}
+	if p.Cursor == "" {
+		meta.Page = p.Page
+	}
+	if total >= 0 {
+		meta.Total = &total
+	}
+	return meta
+}
+
+var envelopeEnabled atomic.Bool
+
+// SetEnvelope makes RespondJSON wrap the bodies in an Envelope, so every
+// endpoint of a service answers with the same shape. Errors are not
+// wrapped.
+func SetEnvelope(enabled bool) {
+	envelopeEnabled.Store(enabled)
+}
+
+// RespondJSON writes v as a JSON response with status. v is encoded before
+// anything is written, so an encoding failure is answered with a 500 rather
+// than a truncated body.
+func RespondJSON(w http.ResponseWriter, status int, v interface{}) {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	if _, ok := v.(enveloped); !ok && envelopeEnabled.Load() {
+		v = Envelope[interface{}]{Data: v}
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		RespondError(w, xerrors.WrapInternalServerError(err, "cannot encode the response"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// RespondPage writes a 200 with items and the meta of their page, in an
+// Envelope whether SetEnvelope is enabled or not
+func RespondPage[T any](w http.ResponseWriter, items []T, meta PageMeta) {
+	if items == nil {
+		items = []T{}
+	}
+	RespondJSON(w, http.StatusOK, Envelope[[]T]{Data: items, Meta: meta})
+}
+
+// RespondError writes err as an xerrors JSON body, see xerrors.WriteJSON. A
+// nil err is written as a 500, as it is a bug of the handler.
+func RespondError(w http.ResponseWriter, err error) {
+	if err == nil {
+		err = xerrors.FromStatus(http.StatusInternalServerError)
+	}
+	xerrors.WriteJSON(w, err)
+}
+
+// NoContent writes a 204
+func NoContent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
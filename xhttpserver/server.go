@@ -0,0 +1,226 @@
+// Package xhttpserver runs HTTP servers with sane timeouts, TLS and graceful
+// shutdown, logging with xlogger
+package xhttpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Options configures a Server. Zero durations take their default, negative
+// ones disable the timeout.
+type Options struct {
+	// Addr defaults to ":8080"
+	Addr string
+	// ReadHeaderTimeout defaults to 10s
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout defaults to 30s
+	ReadTimeout time.Duration
+	// WriteTimeout defaults to 30s, disable it for streaming responses
+	WriteTimeout time.Duration
+	// IdleTimeout defaults to 120s
+	IdleTimeout time.Duration
+	// MaxHeaderBytes defaults to 1 MiB
+	MaxHeaderBytes int
+	// TLS serves HTTPS, see DefaultTLSConfig. The certificates are either
+	// in the config or in CertFile and KeyFile.
+	TLS      *tls.Config
+	CertFile string
+	KeyFile  string
+	// DrainDelay is waited for before closing the listener on shutdown, while
+	// ShuttingDown reports true, so load balancers stop routing new requests
+	DrainDelay time.Duration
+	// Readiness is set not ready as soon as the shutdown starts, before the
+	// DrainDelay, eg. an xhealth.Checker
+	Readiness Readiness
+	// ShutdownTimeout bounds the wait for the requests in flight, 30s by default
+	ShutdownTimeout time.Duration
+	// Signals trigger the shutdown, SIGINT and SIGTERM by default
+	Signals []os.Signal
+}
+
+// Readiness is the readiness of a service, as reported to its probes
+type Readiness interface {
+	SetReady(ready bool)
+}
+
+// Server is an http.Server shutting down gracefully
+type Server struct {
+	opts         Options
+	server       *http.Server
+	shuttingDown atomic.Bool
+}
+
+// New creates a server of handler
+func New(handler http.Handler, opts Options) *Server {
+	if opts.Addr == "" {
+		opts.Addr = ":8080"
+	}
+	opts.ReadHeaderTimeout = timeout(opts.ReadHeaderTimeout, 10*time.Second)
+	opts.ReadTimeout = timeout(opts.ReadTimeout, 30*time.Second)
+	opts.WriteTimeout = timeout(opts.WriteTimeout, 30*time.Second)
+	opts.IdleTimeout = timeout(opts.IdleTimeout, 120*time.Second)
+	if opts.MaxHeaderBytes <= 0 {
+		opts.MaxHeaderBytes = 1 << 20
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = 30 * time.Second
+	}
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return &Server{
+		opts: opts,
+		server: &http.Server{
+			Addr:              opts.Addr,
+			Handler:           handler,
+			TLSConfig:         opts.TLS,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			ReadTimeout:       opts.ReadTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+			MaxHeaderBytes:    opts.MaxHeaderBytes,
+			ErrorLog:          log.New(errorLog{}, "", 0),
+		},
+	}
+}
+
+// timeout returns the default of zero durations, and 0 for negative ones,
+// which means no timeout to http.Server
+func timeout(d time.Duration, def time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return def
+	case d < 0:
+		return 0
+	}
+	return d
+}
+
+// DefaultTLSConfig requires TLS 1.2 and its modern cipher suites
+func DefaultTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// HTTP returns the underlying http.Server, eg. to register shutdown hooks
+func (s *Server) HTTP() *http.Server {
+	return s.server
+}
+
+// ShuttingDown reports whether the server is shutting down, for readiness
+// probes to fail during the DrainDelay
+func (s *Server) ShuttingDown() bool {
+	return s.shuttingDown.Load()
+}
+
+// Run listens on Addr and serves until ctx is done or one of the Signals is
+// received, then shuts down gracefully. It returns nil once every request
+// in flight completed.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot listen on "+s.opts.Addr)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener, see Run
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, stop := signal.NotifyContext(ctx, s.opts.Signals...)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		if s.opts.TLS != nil || s.opts.CertFile != "" {
+			served <- s.server.ServeTLS(listener, s.opts.CertFile, s.opts.KeyFile)
+		} else {
+			served <- s.server.Serve(listener)
+		}
+	}()
+	xlogger.Info("xhttpserver: listening", zap.String("addr", listener.Addr().String()), zap.Bool("tls", s.opts.TLS != nil || s.opts.CertFile != ""))
+
+	select {
+	case err := <-served:
+		return xerrors.Wrap(err, http.StatusInternalServerError, "server stopped")
+	case <-ctx.Done():
+	}
+	stop()
+	xlogger.Info("xhttpserver: shutting down", zap.String("drain_delay", s.opts.DrainDelay.String()))
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.opts.DrainDelay+s.opts.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "server stopped")
+	}
+	xlogger.Info("xhttpserver: stopped")
+	return nil
+}
+
+// Shutdown waits for the DrainDelay, then stops accepting connections and
+// waits for the requests in flight. When ctx is done first, the remaining
+// connections are closed and a 503 is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	if s.opts.Readiness != nil {
+		s.opts.Readiness.SetReady(false)
+	}
+	if s.opts.DrainDelay > 0 {
+		select {
+		case <-time.After(s.opts.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		_ = s.server.Close()
+		return xerrors.WrapServiceUnavailable(err, "requests were still in flight at shutdown")
+	}
+	return nil
+}
+
+// Run serves handler until SIGINT or SIGTERM, the usual main of a service:
+//
+//	func main() {
+//		if err := xhttpserver.Run(router, xhttpserver.Options{Addr: ":8080"}); err != nil {
+//			xlogger.Fatal("server failed", err)
+//		}
+//	}
+func Run(handler http.Handler, opts Options) error {
+	return New(handler, opts).Run(context.Background())
+}
+
+// errorLog writes the errors of http.Server, such as TLS handshake failures,
+// with xlogger
+type errorLog struct{}
+
+func (errorLog) Write(p []byte) (int, error) {
+	xlogger.Warning("xhttpserver: " + strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,278 @@
+package xhttpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Event is a Server-Sent Event
+type Event struct {
+	// ID is set by SSEHub.Publish, from a sequence of the hub
+	ID string
+	// Event is the event type, "message" when empty
+	Event string
+	// Data is written as is when a string or []byte, as JSON otherwise
+	Data interface{}
+}
+
+// SSEOptions configures an SSEHub
+type SSEOptions struct {
+	// QueueSize is the number of events queued per client, 64 by default.
+	// Clients falling further behind are disconnected, to reconnect with
+	// their Last-Event-ID.
+	QueueSize int
+	// Heartbeat is the interval of the comments keeping the idle connections
+	// open through proxies, 15s by default, negative to disable it
+	Heartbeat time.Duration
+	// History is the number of events kept for the Last-Event-ID replay, 256
+	// by default
+	History int
+	// Retry is the reconnection delay advised to the clients, the browser
+	// default when zero
+	Retry time.Duration
+	// Topics returns the topics a request subscribes to, by default its topic
+	// query parameters. No topic subscribes to every topic.
+	Topics func(r *http.Request) []string
+}
+
+// SSEHub broadcasts events to the Server-Sent Events clients subscribed to
+// their topic:
+//
+//	hub := xhttpserver.NewSSEHub(xhttpserver.SSEOptions{})
+//	mux.Handle("/events", hub)
+//	hub.Publish("orders", xhttpserver.Event{Event: "created", Data: order})
+//
+// The server WriteTimeout is lifted for the event streams.
+type SSEHub struct {
+	opts    SSEOptions
+	mu      sync.Mutex
+	clients map[*sseClient]struct{}
+	history []sseFrame
+	next    int
+	seq     uint64
+	closed  bool
+}
+
+// sseFrame is an encoded event
+type sseFrame struct {
+	seq   uint64
+	topic string
+	data  []byte
+}
+
+type sseClient struct {
+	topics map[string]bool
+	frames chan []byte
+	// kicked is closed when the client falls behind or the hub closes
+	kicked chan struct{}
+	once   sync.Once
+}
+
+func (c *sseClient) subscribed(topic string) bool {
+	return len(c.topics) == 0 || c.topics[topic]
+}
+
+func (c *sseClient) kick() {
+	c.once.Do(func() { close(c.kicked) })
+}
+
+// NewSSEHub creates a hub
+func NewSSEHub(opts SSEOptions) *SSEHub {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = 15 * time.Second
+	}
+	if opts.History <= 0 {
+		opts.History = 256
+	}
+	if opts.Topics == nil {
+		opts.Topics = func(r *http.Request) []string {
+			return r.URL.Query()["topic"]
+		}
+	}
+	return &SSEHub{opts: opts, clients: make(map[*sseClient]struct{})}
+}
+
+// Publish sends event to the clients subscribed to topic and returns it
+// with its ID. Clients whose queue is full are disconnected.
+func (h *SSEHub) Publish(topic string, event Event) (Event, error) {
+	data, err := eventData(event.Data)
+	if err != nil {
+		return event, xerrors.WrapInternalServerError(err, "cannot encode the event")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return event, xerrors.NewServiceUnavailableError("the event hub is closed")
+	}
+	h.seq++
+	event.ID = strconv.FormatUint(h.seq, 10)
+	frame := sseFrame{seq: h.seq, topic: topic, data: encodeEvent(event.ID, event.Event, data)}
+	if len(h.history) < h.opts.History {
+		h.history = append(h.history, frame)
+	} else {
+		h.history[h.next] = frame
+		h.next = (h.next + 1) % h.opts.History
+	}
+
+	for client := range h.clients {
+		if !client.subscribed(topic) {
+			continue
+		}
+		select {
+		case client.frames <- frame.data:
+		default:
+			client.kick()
+			delete(h.clients, client)
+		}
+	}
+	return event, nil
+}
+
+func eventData(data interface{}) ([]byte, error) {
+	switch data := data.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(data), nil
+	case []byte:
+		return data, nil
+	}
+	return json.Marshal(data)
+}
+
+func encodeEvent(id string, event string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("id: " + id + "\n")
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// Clients returns the number of clients connected
+func (h *SSEHub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Close disconnects every client and rejects the new ones, eg. before the
+// server shuts down, as event streams never complete on their own
+func (h *SSEHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for client := range h.clients {
+		client.kick()
+		delete(h.clients, client)
+	}
+}
+
+// ServeHTTP streams the events to a client, starting with the ones
+// published after its Last-Event-ID still in the history
+func (h *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	client := &sseClient{
+		topics: make(map[string]bool),
+		frames: make(chan []byte, h.opts.QueueSize),
+		kicked: make(chan struct{}),
+	}
+	for _, topic := range h.opts.Topics(r) {
+		client.topics[topic] = true
+	}
+	replay, err := h.subscribe(client, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		RespondError(w, err)
+		return
+	}
+	defer h.unsubscribe(client)
+
+	_ = controller.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if h.opts.Retry > 0 {
+		_, _ = w.Write([]byte("retry: " + strconv.FormatInt(h.opts.Retry.Milliseconds(), 10) + "\n\n"))
+	}
+	for _, frame := range replay {
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	var heartbeat <-chan time.Time
+	if h.opts.Heartbeat > 0 {
+		ticker := time.NewTicker(h.opts.Heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		var frame []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.kicked:
+			return
+		case <-heartbeat:
+			frame = []byte(": ping\n\n")
+		case frame = <-client.frames:
+		}
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// subscribe registers client and returns the events to replay, atomically
+// so none is missed or sent twice
+func (h *SSEHub) subscribe(client *sseClient, lastEventID string) ([][]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, xerrors.NewServiceUnavailableError("the event hub is closed")
+	}
+	h.clients[client] = struct{}{}
+	if lastEventID == "" {
+		return nil, nil
+	}
+	last, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	var replay [][]byte
+	for i := range h.history {
+		frame := h.history[(h.next+i)%len(h.history)]
+		if frame.seq > last && client.subscribed(frame.topic) {
+			replay = append(replay, frame.data)
+		}
+	}
+	return replay, nil
+}
+
+func (h *SSEHub) unsubscribe(client *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
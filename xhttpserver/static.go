@@ -0,0 +1,167 @@
+package xhttpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// StaticOptions configures Static
+type StaticOptions struct {
+	// Index is served for the directories, index.html by default
+	Index string
+	// SPA serves the root Index for the paths matching no file and having no
+	// extension, so the client side router of a single-page app handles them
+	SPA bool
+	// MaxAge is the Cache-Control max-age of the assets, 1h by default. Index
+	// files are always revalidated.
+	MaxAge time.Duration
+	// Immutable reports whether an asset never changes, eg. when its name
+	// holds a content hash, to cache it for a year. By default the files
+	// under assets/ and static/ are.
+	Immutable func(name string) bool
+}
+
+// Static serves the files of fsys, an embed.FS or os.DirFS, with an ETag
+// and Cache-Control. The .br and .gz variants of a file are served instead
+// of it to the clients accepting them.
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	assets, _ := fs.Sub(dist, "dist")
+//	mux.Handle("/", xhttpserver.Static(assets, xhttpserver.StaticOptions{SPA: true}))
+func Static(fsys fs.FS, opts StaticOptions) http.Handler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = time.Hour
+	}
+	if opts.Immutable == nil {
+		opts.Immutable = func(name string) bool {
+			return strings.HasPrefix(name, "assets/") || strings.HasPrefix(name, "static/")
+		}
+	}
+	s := &static{fsys: fsys, opts: opts}
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+type static struct {
+	fsys  fs.FS
+	opts  StaticOptions
+	etags sync.Map
+}
+
+// etag is the cached ETag of a file, valid as long as its size and
+// modification time do not change
+type etag struct {
+	size    int64
+	modTime time.Time
+	value   string
+}
+
+func (s *static) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		RespondError(w, xerrors.NewRestError(http.StatusMethodNotAllowed, r.Method+" is not allowed"))
+		return
+	}
+	requested := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	name := requested
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, s.opts.Index)
+		info, err = fs.Stat(s.fsys, name)
+	}
+	if err != nil && s.opts.SPA && path.Ext(requested) == "" {
+		name = s.opts.Index
+		info, err = fs.Stat(s.fsys, name)
+	}
+	if err != nil || info.IsDir() {
+		RespondError(w, xerrors.NewNotFoundError(r.URL.Path+" not found"))
+		return
+	}
+
+	switch {
+	case path.Base(name) == s.opts.Index:
+		w.Header().Set("Cache-Control", "no-cache")
+	case s.opts.Immutable(name):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.opts.MaxAge.Seconds())))
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	accepted := r.Header.Get("Accept-Encoding")
+	for _, variant := range []struct{ coding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if !acceptsEncoding(accepted, variant.coding) {
+			continue
+		}
+		if variantInfo, err := fs.Stat(s.fsys, name+variant.ext); err == nil && !variantInfo.IsDir() {
+			w.Header().Set("Content-Encoding", variant.coding)
+			s.serveFile(w, r, name+variant.ext, variantInfo)
+			return
+		}
+	}
+	s.serveFile(w, r, name, info)
+}
+
+func (s *static) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		RespondError(w, xerrors.WrapInternalServerError(err, "cannot open "+name))
+		return
+	}
+	defer file.Close()
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		RespondError(w, xerrors.NewInternalServerError(fmt.Sprintf("cannot seek %s of %T", name, s.fsys)))
+		return
+	}
+	tag, err := s.etag(name, info, content)
+	if err != nil {
+		RespondError(w, xerrors.WrapInternalServerError(err, "cannot read "+name))
+		return
+	}
+	w.Header().Set("ETag", tag)
+	// ServeContent answers the conditional and range requests
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag returns the ETag of a file, a hash of its content computed once
+func (s *static) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if cached, ok := s.etags.Load(name); ok {
+		cached := cached.(etag)
+		if cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+			return cached.value, nil
+		}
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	value := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag{size: info.Size(), modTime: info.ModTime(), value: value})
+	return value, nil
+}
@@ -0,0 +1,474 @@
+package xhttpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xauth"
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// WSOptions configures a WSHub
+type WSOptions struct {
+	// AllowedOrigins are the origins of the browsers allowed to connect, see
+	// CORSOptions. By default only the host of the request is.
+	AllowedOrigins []string
+	// Permissions are required to connect, see xauth.Can. The xauth
+	// middlewares must run before the hub.
+	Permissions []string
+	// ReadLimit is the maximum size of a received message, 64 KiB by default
+	ReadLimit int64
+	// SendQueue is the number of messages queued per connection, 64 by
+	// default. Connections falling further behind are closed, so a slow
+	// client cannot hold the memory of the server.
+	SendQueue int
+	// WriteTimeout bounds the write of a message, 10s by default
+	WriteTimeout time.Duration
+	// IdleTimeout closes the connections receiving neither a message nor a
+	// pong for that long, 60s by default. Pings are sent at 9/10 of it.
+	IdleTimeout time.Duration
+	// OnConnect is called once a connection is upgraded, eg. to join rooms
+	// from its claims. An error closes the connection.
+	OnConnect func(conn *WSConn) error
+	// OnMessage is called with every message received, from the read loop
+	// of the connection
+	OnMessage func(conn *WSConn, data []byte)
+	// OnClose is called once a connection is closed and has left its rooms
+	OnClose func(conn *WSConn)
+}
+
+// WSHub manages WebSocket connections and their rooms:
+//
+//	hub := xhttpserver.NewWSHub(xhttpserver.WSOptions{
+//		OnConnect: func(conn *xhttpserver.WSConn) error {
+//			claims, _ := xhttpserver.WSClaims[MyClaims](conn)
+//			conn.Join("user:" + claims.Subject)
+//			return nil
+//		},
+//	})
+//	mux.Handle("/ws", xauth.Middleware[MyClaims](verifier)(hub))
+//	hub.BroadcastJSON("user:42", notification)
+type WSHub struct {
+	opts     WSOptions
+	upgrader websocket.Upgrader
+	mu       sync.RWMutex
+	conns    map[*WSConn]struct{}
+	rooms    map[string]map[*WSConn]struct{}
+	closed   bool
+}
+
+// WSConn is a WebSocket connection of a WSHub
+type WSConn struct {
+	hub    *WSHub
+	conn   *websocket.Conn
+	ctx    context.Context
+	id     string
+	send   chan wsMessage
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	rooms  map[string]struct{}
+	reason string
+	// closing stops the read loop from extending its deadline, guarded by mu
+	closing bool
+}
+
+type wsMessage struct {
+	kind int
+	data []byte
+}
+
+// NewWSHub creates a hub
+func NewWSHub(opts WSOptions) *WSHub {
+	if opts.ReadLimit <= 0 {
+		opts.ReadLimit = 64 << 10
+	}
+	if opts.SendQueue <= 0 {
+		opts.SendQueue = 64
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 60 * time.Second
+	}
+	h := &WSHub{
+		opts:  opts,
+		conns: make(map[*WSConn]struct{}),
+		rooms: make(map[string]map[*WSConn]struct{}),
+	}
+	if len(opts.AllowedOrigins) > 0 {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || allowedOrigin(opts.AllowedOrigins, origin)
+		}
+	}
+	return h
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection, served until
+// the client or the hub closes it
+func (h *WSHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, permission := range h.opts.Permissions {
+		if err := xauth.Can(r.Context(), permission); err != nil {
+			RespondError(w, err)
+			return
+		}
+	}
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		RespondError(w, xerrors.NewServiceUnavailableError("the WebSocket hub is closed"))
+		return
+	}
+
+	// The upgrader writes its own error responses
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &WSConn{
+		hub:   h,
+		conn:  conn,
+		ctx:   context.WithoutCancel(r.Context()),
+		id:    RequestIDFrom(r.Context()),
+		send:  make(chan wsMessage, h.opts.SendQueue),
+		done:  make(chan struct{}),
+		rooms: make(map[string]struct{}),
+	}
+	if c.id == "" {
+		c.id = newRequestID()
+	}
+	if !h.add(c) {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+		_ = conn.Close()
+		return
+	}
+	xlogger.Debug("xhttpserver: websocket connected", zap.String("conn_id", c.id), zap.String("remote_addr", r.RemoteAddr))
+
+	go c.writeLoop()
+	if h.opts.OnConnect != nil {
+		if err := h.opts.OnConnect(c); err != nil {
+			xlogger.Warning("xhttpserver: websocket rejected", zap.String("conn_id", c.id), zap.String("error", err.Error()))
+			c.close(websocket.ClosePolicyViolation, xerrors.Translate(err).Message())
+		}
+	}
+	c.readLoop()
+	h.remove(c)
+	if h.opts.OnClose != nil {
+		h.opts.OnClose(c)
+	}
+	xlogger.Debug("xhttpserver: websocket closed", zap.String("conn_id", c.id), zap.String("reason", c.closeReason()))
+}
+
+func (h *WSHub) add(c *WSConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.conns[c] = struct{}{}
+	return true
+}
+
+func (h *WSHub) remove(c *WSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+	c.rooms = nil
+}
+
+// leave removes c from room, h.mu being held
+func (h *WSHub) leave(c *WSConn, room string) {
+	delete(h.rooms[room], c)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Broadcast sends a text message to the connections of room, every
+// connection when room is empty, and returns the number of connections it
+// was queued for
+func (h *WSHub) Broadcast(room string, data []byte) int {
+	h.mu.RLock()
+	conns := h.conns
+	if room != "" {
+		conns = h.rooms[room]
+	}
+	targets := make([]*WSConn, 0, len(conns))
+	for c := range conns {
+		targets = append(targets, c)
+	}
+	h.mu.RUnlock()
+
+	sent := 0
+	for _, c := range targets {
+		if c.queue(wsMessage{kind: websocket.TextMessage, data: data}) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// BroadcastJSON sends v as JSON to the connections of room, see Broadcast
+func (h *WSHub) BroadcastJSON(room string, v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, xerrors.WrapInternalServerError(err, "cannot encode the message")
+	}
+	return h.Broadcast(room, data), nil
+}
+
+// Conns returns the number of connections
+func (h *WSHub) Conns() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// Rooms returns the rooms having connections
+func (h *WSHub) Rooms() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Close closes every connection and rejects the new ones, eg. before the
+// server shuts down, as hijacked connections are not waited for
+func (h *WSHub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	conns := make([]*WSConn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+	for _, c := range conns {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// ID returns the id of the connection, the request id of the upgrade
+func (c *WSConn) ID() string {
+	return c.id
+}
+
+// Context returns the context of the upgrade request, holding its claims
+// and principal, without its cancellation
+func (c *WSConn) Context() context.Context {
+	return c.ctx
+}
+
+// Principal returns the xauth principal of the connection
+func (c *WSConn) Principal() (*xauth.Principal, bool) {
+	return xauth.PrincipalFrom(c.ctx)
+}
+
+// WSClaims returns the claims of type C of the connection, stored by
+// xauth.Middleware on the upgrade request
+func WSClaims[C any](c *WSConn) (*C, bool) {
+	return xauth.ClaimsFrom[C](c.ctx)
+}
+
+// Join adds the connection to room
+func (c *WSConn) Join(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms == nil {
+		// Closed
+		return
+	}
+	c.rooms[room] = struct{}{}
+	if c.hub.rooms[room] == nil {
+		c.hub.rooms[room] = make(map[*WSConn]struct{})
+	}
+	c.hub.rooms[room][c] = struct{}{}
+}
+
+// Leave removes the connection from room
+func (c *WSConn) Leave(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rooms[room]; ok {
+		delete(c.rooms, room)
+		c.hub.leave(c, room)
+	}
+}
+
+// Rooms returns the rooms of the connection
+func (c *WSConn) Rooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Send queues a text message. When the queue is full the connection is
+// closed and a 503 returned.
+func (c *WSConn) Send(data []byte) error {
+	return c.queue(wsMessage{kind: websocket.TextMessage, data: data})
+}
+
+// SendBinary queues a binary message, see Send
+func (c *WSConn) SendBinary(data []byte) error {
+	return c.queue(wsMessage{kind: websocket.BinaryMessage, data: data})
+}
+
+// SendJSON queues v as a JSON text message, see Send
+func (c *WSConn) SendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return xerrors.WrapInternalServerError(err, "cannot encode the message")
+	}
+	return c.Send(data)
+}
+
+// Close closes the connection normally
+func (c *WSConn) Close() {
+	c.close(websocket.CloseNormalClosure, "")
+}
+
+func (c *WSConn) queue(message wsMessage) error {
+	select {
+	case <-c.done:
+		return xerrors.NewServiceUnavailableError("the WebSocket connection is closed")
+	default:
+	}
+	select {
+	case c.send <- message:
+		return nil
+	default:
+		xlogger.Warning("xhttpserver: websocket too slow, closing", zap.String("conn_id", c.id), zap.Int("queued", len(c.send)))
+		c.close(websocket.CloseTryAgainLater, "too slow")
+		return xerrors.NewServiceUnavailableError("the WebSocket connection is too slow")
+	}
+}
+
+// close stops the write loop, which sends a close message with code and
+// closes the connection, ending the read loop
+func (c *WSConn) close(code int, reason string) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.reason = reason
+		c.closing = true
+		c.mu.Unlock()
+		_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, truncateReason(reason)), time.Now().Add(c.hub.opts.WriteTimeout))
+		close(c.done)
+		// Unblocks the read loop, the net.Conn being safe for concurrent use
+		_ = c.conn.NetConn().SetReadDeadline(time.Now())
+	})
+}
+
+// maxCloseReason is the size of the longest reason of a close frame, whose
+// payload is 125 bytes at most including the code
+const maxCloseReason = 123
+
+// truncateReason shortens reason to fit a close frame, on a rune boundary
+func truncateReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	n := maxCloseReason
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// extendDeadline pushes back the read deadline unless the connection is
+// closing, the deadline set by close unblocking the read loop
+func (c *WSConn) extendDeadline(idle time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return nil
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(idle))
+}
+
+func (c *WSConn) closeReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
+
+func (c *WSConn) readLoop() {
+	defer c.conn.Close()
+	idle := c.hub.opts.IdleTimeout
+	c.conn.SetReadLimit(c.hub.opts.ReadLimit)
+	_ = c.extendDeadline(idle)
+	c.conn.SetPongHandler(func(string) error {
+		return c.extendDeadline(idle)
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.close(websocket.CloseNormalClosure, readError(err))
+			return
+		}
+		_ = c.extendDeadline(idle)
+		if c.hub.opts.OnMessage != nil {
+			c.hub.opts.OnMessage(c, data)
+		}
+	}
+}
+
+func readError(err error) string {
+	if closeErr, ok := err.(*websocket.CloseError); ok {
+		return closeErr.Error()
+	}
+	if err == websocket.ErrReadLimit {
+		return "message too large"
+	}
+	return err.Error()
+}
+
+func (c *WSConn) writeLoop() {
+	ping := time.NewTicker(c.hub.opts.IdleTimeout * 9 / 10)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteTimeout))
+			if err := c.conn.WriteMessage(message.kind, message.data); err != nil {
+				c.close(websocket.CloseInternalServerErr, err.Error())
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.hub.opts.WriteTimeout)); err != nil {
+				c.close(websocket.CloseInternalServerErr, err.Error())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package xmetrics
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterRuntime registers the go_* runtime metrics and the process_*
+// metrics, eg. CPU, memory and open file descriptors. The default
+// Prometheus registry already has them.
+func (r *Registry) RegisterRuntime() error {
+	if err := r.Register(collectors.NewGoCollector()); err != nil {
+		return err
+	}
+	return r.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// BuildInfo sets the build_info gauge to 1, labeled by version, revision and
+// go_version, so dashboards can show which build runs. version defaults to
+// the module version and the revision is read from the VCS information
+// embedded by go build.
+func (r *Registry) BuildInfo(version string) {
+	revision := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	if version == "" {
+		version = "unknown"
+	}
+	r.Gauge("build_info", "Build of the running service, always 1.", "version", "revision", "go_version").
+		WithLabelValues(version, revision, runtime.Version()).
+		Set(1)
+}
+
+// RegisterRuntime registers the runtime metrics with the Default registry
+func RegisterRuntime() error {
+	return Default.RegisterRuntime()
+}
+
+// BuildInfo sets the build_info gauge of the Default registry
+func BuildInfo(version string) {
+	Default.BuildInfo(version)
+}
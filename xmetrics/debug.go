@@ -0,0 +1,237 @@
+package xmetrics
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xhttpserver"
+)
+
+// DebugOptions configures DebugHandler
+type DebugOptions struct {
+	// Auth guards the endpoints, eg. an xauth middleware followed by
+	// xauth.Require("debug"). It is required unless LoopbackOnly is set.
+	Auth func(http.Handler) http.Handler
+	// LoopbackOnly serves only the loopback clients, without Auth when it
+	// is nil. Behind a reverse proxy or sidecar on the same host every
+	// request comes from loopback, so only set it when the port is not
+	// proxied.
+	LoopbackOnly bool
+	// MaxDuration bounds the seconds of the CPU profiles and execution
+	// traces, 60s by default
+	MaxDuration time.Duration
+}
+
+// started is the start of the process, as reported by the snapshot
+var started = time.Now()
+
+// DebugHandler serves the debugging endpoints under /debug, to mount at the
+// root of a mux, ideally on a separate port, see ServeDebug:
+//
+//   - /debug/pprof/ lists the profiles, served as /debug/pprof/<name> for
+//     go tool pprof, the CPU profile being /debug/pprof/profile?seconds=30
+//     and the execution trace /debug/pprof/trace?seconds=5
+//   - /debug/vars serves expvar
+//   - /debug/snapshot serves the goroutine and heap statistics as JSON, with
+//     the goroutine stacks when ?stacks=1
+//
+// Unlike net/http/pprof, nothing is registered on http.DefaultServeMux.
+// It panics when neither Auth nor LoopbackOnly is set.
+func DebugHandler(opts DebugOptions) http.Handler {
+	if opts.Auth == nil && !opts.LoopbackOnly {
+		panic("xmetrics: DebugHandler requires Auth, or LoopbackOnly when the port is not proxied")
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = 60 * time.Second
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/{$}", profileIndex)
+	mux.HandleFunc("GET /debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
+		cpuProfile(w, r, opts.MaxDuration)
+	})
+	mux.HandleFunc("GET /debug/pprof/trace", func(w http.ResponseWriter, r *http.Request) {
+		executionTrace(w, r, opts.MaxDuration)
+	})
+	mux.HandleFunc("GET /debug/pprof/{name}", profile)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/snapshot", snapshot)
+
+	var handler http.Handler = mux
+	if opts.LoopbackOnly {
+		handler = loopbackOnly(handler)
+	}
+	if opts.Auth != nil {
+		handler = opts.Auth(handler)
+	}
+	return handler
+}
+
+// ServeDebug serves DebugHandler on addr, eg. "localhost:6060", until ctx
+// is done. The write timeout is lifted for the long profiles.
+func ServeDebug(ctx context.Context, addr string, opts DebugOptions) error {
+	return xhttpserver.New(DebugHandler(opts), xhttpserver.Options{Addr: addr, WriteTimeout: -1}).Run(ctx)
+}
+
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			xerrors.WriteJSON(w, xerrors.NewForbiddenError("the debug endpoints are only served to loopback clients"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func profileIndex(w http.ResponseWriter, _ *http.Request) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, p := range profiles {
+		fmt.Fprintf(w, "%-14s %6d  /debug/pprof/%s?debug=1\n", p.Name(), p.Count(), p.Name())
+	}
+	fmt.Fprintf(w, "%-14s %6s  /debug/pprof/profile?seconds=30\n", "cpu", "")
+	fmt.Fprintf(w, "%-14s %6s  /debug/pprof/trace?seconds=5\n", "trace", "")
+}
+
+func profile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	p := pprof.Lookup(name)
+	if p == nil {
+		xerrors.WriteJSON(w, xerrors.NewNotFoundError("unknown profile "+name))
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	_ = p.WriteTo(w, debug)
+}
+
+// seconds returns the seconds parameter of r, within max
+func seconds(r *http.Request, def time.Duration, max time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get("seconds")
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	d := time.Duration(n * float64(time.Second))
+	if err != nil || d <= 0 || d > max {
+		return 0, xerrors.NewBadRequestError(fmt.Sprintf("seconds must be a number between 0 and %d", int(max.Seconds())))
+	}
+	return d, nil
+}
+
+func cpuProfile(w http.ResponseWriter, r *http.Request, max time.Duration) {
+	d, err := seconds(r, 30*time.Second, max)
+	if err != nil {
+		xerrors.WriteJSON(w, err)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + 10*time.Second))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		xerrors.WriteJSON(w, xerrors.WrapConflict(err, "a CPU profile is already running"))
+		return
+	}
+	wait(r.Context(), d)
+	pprof.StopCPUProfile()
+}
+
+func executionTrace(w http.ResponseWriter, r *http.Request, max time.Duration) {
+	d, err := seconds(r, time.Second, max)
+	if err != nil {
+		xerrors.WriteJSON(w, err)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + 10*time.Second))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		xerrors.WriteJSON(w, xerrors.WrapConflict(err, "an execution trace is already running"))
+		return
+	}
+	wait(r.Context(), d)
+	trace.Stop()
+}
+
+func wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Snapshot is the body of /debug/snapshot
+type Snapshot struct {
+	Time          time.Time `json:"time"`
+	Uptime        string    `json:"uptime"`
+	GoVersion     string    `json:"go_version"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	Goroutines    int       `json:"goroutines"`
+	HeapAlloc     uint64    `json:"heap_alloc_bytes"`
+	HeapInuse     uint64    `json:"heap_inuse_bytes"`
+	HeapObjects   uint64    `json:"heap_objects"`
+	Sys           uint64    `json:"sys_bytes"`
+	NumGC         uint32    `json:"num_gc"`
+	LastGC        time.Time `json:"last_gc"`
+	PauseTotal    string    `json:"gc_pause_total"`
+	NextGC        uint64    `json:"next_gc_bytes"`
+	GoroutineDump string    `json:"goroutine_dump,omitempty"`
+}
+
+func snapshot(w http.ResponseWriter, r *http.Request) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	s := Snapshot{
+		Time:        time.Now(),
+		Uptime:      time.Since(started).Round(time.Second).String(),
+		GoVersion:   runtime.Version(),
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   stats.HeapAlloc,
+		HeapInuse:   stats.HeapInuse,
+		HeapObjects: stats.HeapObjects,
+		Sys:         stats.Sys,
+		NumGC:       stats.NumGC,
+		LastGC:      time.Unix(0, int64(stats.LastGC)),
+		PauseTotal:  time.Duration(stats.PauseTotalNs).String(),
+		NextGC:      stats.NextGC,
+	}
+	if r.URL.Query().Get("stacks") == "1" {
+		buf := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) || len(buf) >= 64<<20 {
+				s.GoroutineDump = string(buf[:n])
+				break
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(s)
+}
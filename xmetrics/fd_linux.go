@@ -0,0 +1,23 @@
+//go:build linux
+
+package xmetrics
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// fileDescriptors returns the number of open file descriptors and their
+// limit, -1 when unknown
+func fileDescriptors() (int, int) {
+	open, limit := -1, -1
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		open = len(entries)
+	}
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err == nil && rlimit.Cur < math.MaxInt32 {
+		limit = int(rlimit.Cur)
+	}
+	return open, limit
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package xmetrics
+
+// fileDescriptors returns -1, as the file descriptors are only counted on
+// Linux
+func fileDescriptors() (int, int) {
+	return -1, -1
+}
@@ -0,0 +1,96 @@
+package xmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPOptions configures HTTPMiddleware
+type HTTPOptions struct {
+	// Route returns the route label of a served request. It defaults to the
+	// pattern matched by http.ServeMux, so the middleware must wrap the mux
+	// directly, and to "unmatched" without pattern. Never use the raw path:
+	// every id would create a time series.
+	Route func(r *http.Request) string
+	// Buckets of the request durations in seconds default to
+	// prometheus.DefBuckets
+	Buckets []float64
+}
+
+// HTTPMiddleware records the RED metrics of the requests:
+// http_requests_total by method, route and status,
+// http_request_duration_seconds by method and route, and
+// http_requests_in_flight
+func (r *Registry) HTTPMiddleware(opts HTTPOptions) func(http.Handler) http.Handler {
+	if opts.Route == nil {
+		opts.Route = func(r *http.Request) string {
+			if r.Pattern == "" {
+				return "unmatched"
+			}
+			return r.Pattern
+		}
+	}
+	requests := r.Counter("http_requests_total", "HTTP requests served, by method, route and status.", "method", "route", "status")
+	durations := r.Histogram("http_request_duration_seconds", "Durations of the HTTP requests, by method and route.", opts.Buckets, "method", "route")
+	inFlight := r.Gauge("http_requests_in_flight", "HTTP requests being served.").WithLabelValues()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started := time.Now()
+			inFlight.Inc()
+			defer inFlight.Dec()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, req)
+
+			route := opts.Route(req)
+			requests.WithLabelValues(req.Method, route, strconv.Itoa(recorder.status)).Inc()
+			durations.WithLabelValues(req.Method, route).Observe(time.Since(started).Seconds())
+		})
+	}
+}
+
+// HTTPMiddleware records the RED metrics of the requests in the Default
+// registry
+func HTTPMiddleware(opts HTTPOptions) func(http.Handler) http.Handler {
+	return Default.HTTPMiddleware(opts)
+}
+
+// statusRecorder records the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush supports streaming responses
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSockets
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", s.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
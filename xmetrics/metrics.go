@@ -0,0 +1,210 @@
+// Package xmetrics exposes Prometheus metrics with consistent names and
+// labels: HTTP RED metrics, runtime and build information, and a small
+// facade over counters, gauges and histograms
+package xmetrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Options configures a Registry
+type Options struct {
+	// Namespace prefixes the metric names, eg. the service name
+	Namespace string
+	// Registerer and Gatherer default to a new prometheus.Registry
+	Registerer prometheus.Registerer
+	Gatherer   prometheus.Gatherer
+	// ConstLabels are added to every metric created by the Registry
+	ConstLabels prometheus.Labels
+}
+
+// Registry creates metrics once and returns them on the next calls, so
+// they can be declared where they are used
+type Registry struct {
+	opts    Options
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// metric is a metric created by a Registry
+type metric struct {
+	kind      string
+	labels    []string
+	collector prometheus.Collector
+}
+
+// New creates a registry
+func New(opts Options) *Registry {
+	if opts.Registerer == nil && opts.Gatherer == nil {
+		registry := prometheus.NewRegistry()
+		opts.Registerer, opts.Gatherer = registry, registry
+	}
+	return &Registry{opts: opts, metrics: make(map[string]metric)}
+}
+
+// Default is the registry of the package level functions, registering with
+// the default Prometheus registry
+var Default = New(Options{Registerer: prometheus.DefaultRegisterer, Gatherer: prometheus.DefaultGatherer})
+
+// Register registers a collector. A collector already registered is not an
+// error.
+func (r *Registry) Register(collector prometheus.Collector) error {
+	err := r.opts.Registerer.Register(collector)
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		return nil
+	}
+	return err
+}
+
+// Handler serves the metrics, eg. on /metrics
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.opts.Gatherer, promhttp.HandlerOpts{
+		Registry:          r.opts.Registerer,
+		EnableOpenMetrics: true,
+	})
+}
+
+// Counter returns the counter vector name, created with help and labels
+// on the first call. It panics when name was created with another type or
+// other labels, as metrics are declared by the code.
+func (r *Registry) Counter(name string, help string, labels ...string) *prometheus.CounterVec {
+	return r.metric("counter", name, labels, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   r.opts.Namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: r.opts.ConstLabels,
+		}, labels)
+	}).(*prometheus.CounterVec)
+}
+
+// Gauge returns the gauge vector name, see Counter
+func (r *Registry) Gauge(name string, help string, labels ...string) *prometheus.GaugeVec {
+	return r.metric("gauge", name, labels, func() prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   r.opts.Namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: r.opts.ConstLabels,
+		}, labels)
+	}).(*prometheus.GaugeVec)
+}
+
+// Histogram returns the histogram vector name, see Counter. buckets
+// default to prometheus.DefBuckets, fit for durations in seconds.
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	return r.metric("histogram", name, labels, func() prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   r.opts.Namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: r.opts.ConstLabels,
+			Buckets:     buckets,
+		}, labels)
+	}).(*prometheus.HistogramVec)
+}
+
+func (r *Registry) metric(kind string, name string, labels []string, create func() prometheus.Collector) prometheus.Collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		if existing.kind != kind || !slices.Equal(existing.labels, labels) {
+			panic(fmt.Sprintf("xmetrics: %s is a %s labeled by %v, not a %s labeled by %v", name, existing.kind, existing.labels, kind, labels))
+		}
+		return existing.collector
+	}
+	collector := create()
+	if err := r.opts.Registerer.Register(collector); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if !errors.As(err, &already) {
+			panic(fmt.Sprintf("xmetrics: cannot register %s: %s", name, err))
+		}
+		// Created by another Registry of the same Prometheus registry
+		collector = already.ExistingCollector
+	}
+	r.metrics[name] = metric{kind: kind, labels: slices.Clone(labels), collector: collector}
+	return collector
+}
+
+// Inc increments the counter name, labeled by the pairs of names and values
+// of labels, eg. Inc("orders_total", "channel", "web")
+func (r *Registry) Inc(name string, labels ...string) {
+	names, values := pairs(labels)
+	r.Counter(name, help(name), names...).WithLabelValues(values...).Inc()
+}
+
+// Set sets the gauge name, see Inc
+func (r *Registry) Set(name string, value float64, labels ...string) {
+	names, values := pairs(labels)
+	r.Gauge(name, help(name), names...).WithLabelValues(values...).Set(value)
+}
+
+// Observe observes value in the histogram name with the default buckets,
+// see Inc
+func (r *Registry) Observe(name string, value float64, labels ...string) {
+	names, values := pairs(labels)
+	r.Histogram(name, help(name), nil, names...).WithLabelValues(values...).Observe(value)
+}
+
+func pairs(labels []string) ([]string, []string) {
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("xmetrics: labels %v are not pairs of names and values", labels))
+	}
+	names := make([]string, 0, len(labels)/2)
+	values := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+	return names, values
+}
+
+func help(name string) string {
+	return name + " recorded with xmetrics."
+}
+
+// Handler serves the metrics of the Default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Counter returns the counter vector name of the Default registry
+func Counter(name string, help string, labels ...string) *prometheus.CounterVec {
+	return Default.Counter(name, help, labels...)
+}
+
+// Gauge returns the gauge vector name of the Default registry
+func Gauge(name string, help string, labels ...string) *prometheus.GaugeVec {
+	return Default.Gauge(name, help, labels...)
+}
+
+// Histogram returns the histogram vector name of the Default registry
+func Histogram(name string, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+// Inc increments the counter name of the Default registry
+func Inc(name string, labels ...string) {
+	Default.Inc(name, labels...)
+}
+
+// Set sets the gauge name of the Default registry
+func Set(name string, value float64, labels ...string) {
+	Default.Set(name, value, labels...)
+}
+
+// Observe observes value in the histogram name of the Default registry
+func Observe(name string, value float64, labels ...string) {
+	Default.Observe(name, value, labels...)
+}
@@ -0,0 +1,178 @@
+package xmetrics
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// RuntimeStats are the runtime statistics recorded by a Sampler
+type RuntimeStats struct {
+	Goroutines  int
+	HeapAlloc   uint64
+	HeapInuse   uint64
+	HeapObjects uint64
+	Sys         uint64
+	NumGC       uint32
+	// GCPauses are the pauses of the collections since the previous sample,
+	// the 256 last ones at most
+	GCPauses     []time.Duration
+	GCPauseTotal time.Duration
+	// OpenFDs and MaxFDs are -1 when unknown, on other systems than Linux
+	OpenFDs int
+	MaxFDs  int
+}
+
+// SamplerOptions configures a Sampler
+type SamplerOptions struct {
+	// Interval between samples, 15s by default
+	Interval time.Duration
+	// LogInterval logs the statistics with xlogger at most that often, for
+	// the environments without Prometheus. Zero never logs them.
+	LogInterval time.Duration
+}
+
+// Sampler records the runtime statistics in the background as the gauges
+// runtime_goroutines, runtime_heap_alloc_bytes, runtime_heap_inuse_bytes,
+// runtime_heap_objects, runtime_sys_bytes, runtime_gc_cycles,
+// runtime_open_fds and runtime_max_fds, and the histogram
+// runtime_gc_pause_seconds
+type Sampler struct {
+	registry *Registry
+	opts     SamplerOptions
+
+	mu      sync.Mutex
+	last    RuntimeStats
+	logged  time.Time
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewSampler creates a sampler recording in r
+func (r *Registry) NewSampler(opts SamplerOptions) *Sampler {
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	return &Sampler{registry: r, opts: opts}
+}
+
+// NewSampler creates a sampler recording in the Default registry
+func NewSampler(opts SamplerOptions) *Sampler {
+	return Default.NewSampler(opts)
+}
+
+// Start samples at once, then every Interval until ctx is done or Stop is
+// called
+func (s *Sampler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.stopped = make(chan struct{})
+	go s.loop(ctx, s.stopped)
+}
+
+// Stop stops sampling
+func (s *Sampler) Stop() {
+	s.mu.Lock()
+	cancel, stopped := s.cancel, s.stopped
+	s.cancel = nil
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-stopped
+	}
+}
+
+func (s *Sampler) loop(ctx context.Context, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		s.Sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample records the runtime statistics now and returns them
+func (s *Sampler) Sample() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: time.Duration(mem.PauseTotalNs),
+	}
+	stats.OpenFDs, stats.MaxFDs = fileDescriptors()
+
+	s.mu.Lock()
+	// PauseNs is a circular buffer of the 256 last pauses
+	for gc := max(s.last.NumGC, mem.NumGC-min(mem.NumGC, 256)) + 1; gc <= mem.NumGC; gc++ {
+		stats.GCPauses = append(stats.GCPauses, time.Duration(mem.PauseNs[(gc+255)%256]))
+	}
+	s.last = stats
+	log := s.opts.LogInterval > 0 && time.Since(s.logged) >= s.opts.LogInterval
+	if log {
+		s.logged = time.Now()
+	}
+	s.mu.Unlock()
+
+	s.record(stats)
+	if log {
+		xlogger.Info("xmetrics: runtime statistics",
+			zap.Int("goroutines", stats.Goroutines),
+			zap.Uint64("heap_alloc_bytes", stats.HeapAlloc),
+			zap.Uint64("heap_inuse_bytes", stats.HeapInuse),
+			zap.Uint64("heap_objects", stats.HeapObjects),
+			zap.Uint64("sys_bytes", stats.Sys),
+			zap.Uint32("gc_cycles", stats.NumGC),
+			zap.String("gc_pause_total", stats.GCPauseTotal.String()),
+			zap.Int("open_fds", stats.OpenFDs),
+			zap.Int("max_fds", stats.MaxFDs),
+		)
+	}
+	return stats
+}
+
+func (s *Sampler) record(stats RuntimeStats) {
+	r := s.registry
+	r.Gauge("runtime_goroutines", "Goroutines that currently exist.").WithLabelValues().Set(float64(stats.Goroutines))
+	r.Gauge("runtime_heap_alloc_bytes", "Bytes of allocated heap objects.").WithLabelValues().Set(float64(stats.HeapAlloc))
+	r.Gauge("runtime_heap_inuse_bytes", "Bytes in in-use heap spans.").WithLabelValues().Set(float64(stats.HeapInuse))
+	r.Gauge("runtime_heap_objects", "Allocated heap objects.").WithLabelValues().Set(float64(stats.HeapObjects))
+	r.Gauge("runtime_sys_bytes", "Bytes of memory obtained from the OS.").WithLabelValues().Set(float64(stats.Sys))
+	r.Gauge("runtime_gc_cycles", "Completed GC cycles.").WithLabelValues().Set(float64(stats.NumGC))
+	pauses := r.Histogram("runtime_gc_pause_seconds", "Stop-the-world pauses of the GC cycles.",
+		[]float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}).WithLabelValues()
+	for _, pause := range stats.GCPauses {
+		pauses.Observe(pause.Seconds())
+	}
+	if stats.OpenFDs >= 0 {
+		r.Gauge("runtime_open_fds", "Open file descriptors.").WithLabelValues().Set(float64(stats.OpenFDs))
+	}
+	if stats.MaxFDs >= 0 {
+		r.Gauge("runtime_max_fds", "Limit of open file descriptors.").WithLabelValues().Set(float64(stats.MaxFDs))
+	}
+}
+
+// Last returns the last statistics sampled
+func (s *Sampler) Last() RuntimeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
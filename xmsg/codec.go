@@ -0,0 +1,54 @@
+package xmsg
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Codec encodes the bodies of the messages
+type Codec interface {
+	// ContentType is the media type of the bodies
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSON encodes the bodies as JSON
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return "application/json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// Raw passes []byte and string bodies as is
+var Raw Codec = rawCodec{}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string { return "application/octet-stream" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errRawType
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return errRawType
+}
+
+var errRawType = errors.New("the raw codec only encodes []byte and string")
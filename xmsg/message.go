@@ -0,0 +1,137 @@
+// Package xmsg is the message model shared by the broker adapters: messages,
+// handler functions, typed encoding and trace propagation
+package xmsg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Headers set on the messages by the adapters
+const (
+	// HeaderContentType is the media type of the body, set by Encode
+	HeaderContentType = "content-type"
+	// HeaderError is the error of the last attempt of a retried or dead
+	// message
+	HeaderError = "x-error"
+	// HeaderOriginalTopic is the topic a retried or dead message was
+	// consumed from
+	HeaderOriginalTopic = "x-original-topic"
+	// HeaderAttempts is the number of attempts of a retried or dead message
+	HeaderAttempts = "x-attempts"
+)
+
+// Message is a message published to or consumed from a broker
+type Message struct {
+	// Topic is the topic, subject or queue of the message
+	Topic string
+	// Key orders the messages, eg. the Kafka partition key
+	Key     string
+	Body    []byte
+	Headers map[string]string
+	// ID is the broker id of a consumed message, if any
+	ID string
+	// Time is the publication time of a consumed message, when known
+	Time time.Time
+	// Attempt is the delivery attempt of a consumed message, 1 at first
+	Attempt int
+}
+
+// Header returns the header name of m
+func (m *Message) Header(name string) string {
+	return m.Headers[name]
+}
+
+// SetHeader sets the header name of m
+func (m *Message) SetHeader(name, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[name] = value
+}
+
+// Handler handles a consumed message. Returning an error retries the
+// message, unless it is permanent, see IsPermanent.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Call runs handler, turning its panics into errors
+func Call(ctx context.Context, handler Handler, msg *Message) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err so the message failing with it is not retried but
+// dead lettered at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether a message failing with err should not be
+// retried: errors marked with Permanent and client xerrors errors other than
+// 429 are, eg. a body that cannot be decoded
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	var restErr xerrors.RestErr
+	return errors.As(err, &restErr) && xerrors.IsClientError(restErr) && restErr.StatusCode() != http.StatusTooManyRequests
+}
+
+// Encode creates a message of topic with v encoded by codec as its body
+func Encode(codec Codec, topic, key string, v interface{}) (*Message, error) {
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, fmt.Sprintf("cannot encode a message of %s", topic))
+	}
+	return &Message{Topic: topic, Key: key, Body: body, Headers: map[string]string{HeaderContentType: codec.ContentType()}}, nil
+}
+
+// Forward returns a copy of msg to publish to topic, a retry or dead letter
+// topic, with the error, original topic and attempts headers
+func Forward(msg *Message, topic string, cause error) *Message {
+	forwarded := &Message{Topic: topic, Key: msg.Key, Body: msg.Body, Headers: make(map[string]string, len(msg.Headers)+3)}
+	for name, value := range msg.Headers {
+		forwarded.Headers[name] = value
+	}
+	forwarded.SetHeader(HeaderOriginalTopic, msg.Topic)
+	forwarded.SetHeader(HeaderAttempts, strconv.Itoa(msg.Attempt))
+	forwarded.SetHeader(HeaderError, cause.Error())
+	return forwarded
+}
+
+// Handle returns a handler decoding the body with codec before calling fn.
+// Bodies that cannot be decoded are permanent failures.
+//
+//	consumer.Handle("orders.created", xmsg.Handle(xmsg.JSON, func(ctx context.Context, msg *xmsg.Message, order OrderCreated) error {
+//		return service.Ship(ctx, order)
+//	}))
+func Handle[T any](codec Codec, fn func(ctx context.Context, msg *Message, v T) error) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		var v T
+		if err := codec.Unmarshal(msg.Body, &v); err != nil {
+			return Permanent(xerrors.WrapBadRequest(err, fmt.Sprintf("invalid message of %s", msg.Topic)))
+		}
+		return fn(ctx, msg, v)
+	}
+}
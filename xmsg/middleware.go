@@ -0,0 +1,135 @@
+package xmsg
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// Logging logs every message handled with xlogger: failures as warnings and
+// the others as debug
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			started := time.Now()
+			err := next(ctx, msg)
+			tags := []zap.Field{
+				zap.String("topic", msg.Topic),
+				zap.String("message_id", msg.ID),
+				zap.Int("attempt", msg.Attempt),
+				zap.String("duration", time.Since(started).String()),
+			}
+			if err != nil {
+				xlogger.Warning("xmsg: message failed", append(tags, zap.String("error", err.Error()), zap.Bool("permanent", IsPermanent(err)))...)
+				return err
+			}
+			xlogger.Debug("xmsg: message handled", tags...)
+			return nil
+		}
+	}
+}
+
+// Tracing handles the messages in the consumer span of system, child of the
+// trace context of their headers. The adapters trace their messages already;
+// it is for the other subscribers.
+func Tracing(system string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			ctx, span := StartConsume(ctx, system, msg)
+			defer xtrace.End(span, &err)
+			return next(ctx, msg)
+		}
+	}
+}
+
+// RetryOptions configures Retry
+type RetryOptions struct {
+	// Attempts is the number of calls of the handler, 3 by default
+	Attempts int
+	// Backoff is the wait before the second attempt, 100ms by default,
+	// doubling after every attempt with jitter
+	Backoff time.Duration
+	// MaxBackoff caps the wait, 5s by default
+	MaxBackoff time.Duration
+}
+
+// Retry calls the handler again when it fails, before the broker
+// redelivers the message. Permanent errors are not retried.
+func Retry(opts RetryOptions) Middleware {
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Second
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			backoff := opts.Backoff
+			for attempt := 1; ; attempt++ {
+				err := Call(ctx, next, msg)
+				if err == nil || IsPermanent(err) || attempt == opts.Attempts {
+					return err
+				}
+				wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+				select {
+				case <-ctx.Done():
+					return err
+				case <-time.After(wait):
+				}
+				backoff = min(2*backoff, opts.MaxBackoff)
+			}
+		}
+	}
+}
+
+// PoisonOptions configures Poison
+type PoisonOptions struct {
+	// MaxAttempts is the delivery attempt from which a failing message is
+	// poison, 5 by default
+	MaxAttempts int
+	// Publisher receives the poison messages, with the error, original topic
+	// and attempts headers. They are only logged without it.
+	Publisher Publisher
+	// Topic returns the dead letter topic of a topic, <topic>.dlq by default
+	Topic func(topic string) string
+}
+
+// Poison takes the poison messages, failing with a permanent error or
+// delivered MaxAttempts times, out of the broker: they are published to the
+// dead letter topic and succeed, so they are not redelivered.
+func Poison(opts PoisonOptions) Middleware {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Topic == nil {
+		opts.Topic = func(topic string) string { return topic + ".dlq" }
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			err := Call(ctx, next, msg)
+			if err == nil || !IsPermanent(err) && msg.Attempt < opts.MaxAttempts {
+				return err
+			}
+			tags := []zap.Field{zap.String("topic", msg.Topic), zap.String("message_id", msg.ID), zap.Int("attempt", msg.Attempt)}
+			if opts.Publisher == nil {
+				xlogger.Error("xmsg: poison message dropped", err, tags...)
+				return nil
+			}
+			dead := Forward(msg, opts.Topic(msg.Topic), err)
+			if publishErr := opts.Publisher.Publish(context.WithoutCancel(ctx), dead); publishErr != nil {
+				xlogger.Warning("xmsg: cannot publish the poison message to "+dead.Topic, append(tags, zap.String("error", publishErr.Error()))...)
+				return err
+			}
+			xlogger.Error("xmsg: poison message moved to "+dead.Topic, err, tags...)
+			return nil
+		}
+	}
+}
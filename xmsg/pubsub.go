@@ -0,0 +1,50 @@
+package xmsg
+
+import "context"
+
+// Publisher publishes messages to a broker. The publishers of the adapters
+// implement it, so application code does not depend on the broker.
+type Publisher interface {
+	// Publish publishes msgs to their topic
+	Publish(ctx context.Context, msgs ...*Message) error
+	// Send publishes v encoded with the codec of the publisher
+	Send(ctx context.Context, topic, key string, v interface{}) error
+}
+
+// Subscriber consumes the topics of its handlers. The consumers of the
+// adapters implement it.
+type Subscriber interface {
+	// Handle sets the handler of the messages of topic
+	Handle(topic string, handler Handler)
+	// Run consumes until ctx is done, then waits for the messages being
+	// handled
+	Run(ctx context.Context) error
+}
+
+// Middleware wraps a handler
+type Middleware func(next Handler) Handler
+
+// Chain wraps handler with middlewares, the first one being the outermost
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Use returns subscriber wrapping the handlers it is given with
+// middlewares, the first one being the outermost
+//
+//	subscriber := xmsg.Use(consumer, xmsg.Logging(), xmsg.Poison(xmsg.PoisonOptions{Publisher: publisher}), xmsg.Retry(xmsg.RetryOptions{}))
+func Use(subscriber Subscriber, middlewares ...Middleware) Subscriber {
+	return &middlewareSubscriber{Subscriber: subscriber, middlewares: middlewares}
+}
+
+type middlewareSubscriber struct {
+	Subscriber
+	middlewares []Middleware
+}
+
+func (s *middlewareSubscriber) Handle(topic string, handler Handler) {
+	s.Subscriber.Handle(topic, Chain(handler, s.middlewares...))
+}
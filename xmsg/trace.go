@@ -0,0 +1,63 @@
+package xmsg
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the tracer of the spans
+const instrumentation = "github.com/XandaLtd/xutils-go/xmsg"
+
+// Inject writes the trace context of ctx in the headers of msg, with the
+// global propagator set up by xtrace.Setup
+func Inject(ctx context.Context, msg *Message) {
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// Extract returns ctx with the trace context of the headers of msg
+func Extract(ctx context.Context, msg *Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// StartPublish starts the producer span of msg on system, eg. "kafka", and
+// injects it in the headers. End it with xtrace.End.
+func StartPublish(ctx context.Context, system string, msg *Message) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, "send "+msg.Topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes(system, "send", msg)...),
+	)
+	Inject(ctx, msg)
+	return ctx, span
+}
+
+// StartConsume starts the consumer span of msg on system, child of the
+// trace context of its headers. End it with xtrace.End.
+func StartConsume(ctx context.Context, system string, msg *Message) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(Extract(ctx, msg), "process "+msg.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes(system, "process", msg)...),
+	)
+}
+
+func attributes(system, operation string, msg *Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", system),
+		attribute.String("messaging.operation.type", operation),
+		attribute.String("messaging.destination.name", msg.Topic),
+		attribute.Int("messaging.message.body.size", len(msg.Body)),
+	}
+	if msg.ID != "" {
+		attrs = append(attrs, attribute.String("messaging.message.id", msg.ID))
+	}
+	if system == "kafka" && msg.Key != "" {
+		attrs = append(attrs, attribute.String("messaging.kafka.message.key", msg.Key))
+	}
+	return attrs
+}
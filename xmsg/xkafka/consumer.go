@@ -0,0 +1,282 @@
+package xkafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// ConsumerOptions configures a Consumer
+type ConsumerOptions struct {
+	Options
+	// GroupID is the consumer group, sharing the partitions between the
+	// instances of the service
+	GroupID string
+	// Attempts is the number of times a handler is called on a message
+	// before it moves to the next retry topic, 3 by default
+	Attempts int
+	// Backoff is the wait before the second attempt, 100ms by default,
+	// doubling after every attempt
+	Backoff time.Duration
+	// RetryDelays are the delays of the retry topics: a message still
+	// failing moves to <topic>.retry.1, consumed RetryDelays[0] after, then
+	// to <topic>.retry.2 and so on. After the last one, or at once for
+	// permanent errors, it moves to the dead letter topic <topic>.dlq.
+	RetryDelays []time.Duration
+	// Producer publishes the retried and dead messages, one of the Options
+	// with AutoCreateTopics by default
+	Producer *Producer
+}
+
+// Consumer consumes the topics of its handlers in a consumer group. The
+// offset of a message is committed once handled, retried or dead lettered,
+// so messages are handled at least once.
+type Consumer struct {
+	opts ConsumerOptions
+
+	mu       sync.RWMutex
+	handlers map[string]xmsg.Handler
+}
+
+var _ xmsg.Subscriber = (*Consumer)(nil)
+
+// NewConsumer creates a consumer
+func NewConsumer(opts ConsumerOptions) *Consumer {
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	return &Consumer{opts: opts, handlers: make(map[string]xmsg.Handler)}
+}
+
+// Handle sets the handler of the messages of topic
+func (c *Consumer) Handle(topic string, handler xmsg.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[topic] = handler
+}
+
+// Run creates the missing retry and dead letter topics, then consumes the
+// topics of the handlers and their retry topics until ctx is done. It then finishes the messages being handled, commits them and
+// leaves the group, so the partitions are rebalanced to the other
+// instances at once.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.mu.RLock()
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	c.mu.RUnlock()
+	if len(topics) == 0 {
+		return xerrors.NewInternalServerError("the Kafka consumer has no handler")
+	}
+	if c.opts.GroupID == "" {
+		return xerrors.NewInternalServerError("the Kafka consumer needs a GroupID")
+	}
+
+	producer := c.opts.Producer
+	if producer == nil {
+		producer = NewProducer(ProducerOptions{Options: c.opts.Options, AutoCreateTopics: true})
+		defer producer.Close()
+	}
+
+	c.createTopics(ctx, topics)
+
+	var wg sync.WaitGroup
+	for stage := 0; stage <= len(c.opts.RetryDelays); stage++ {
+		stageTopics := topics
+		groupID := c.opts.GroupID
+		if stage > 0 {
+			stageTopics = make([]string, len(topics))
+			for i, topic := range topics {
+				stageTopics[i] = retryTopic(topic, stage)
+			}
+			groupID += ".retry." + strconv.Itoa(stage)
+		}
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     c.opts.Brokers,
+			GroupID:     groupID,
+			GroupTopics: stageTopics,
+			Dialer:      &kafka.Dialer{ClientID: c.opts.ClientID, TLS: c.opts.TLS, SASLMechanism: c.opts.SASL, Timeout: 10 * time.Second, DualStack: true},
+			StartOffset: kafka.FirstOffset,
+			// Picks up the partitions of the topics created after joining
+			WatchPartitionChanges: true,
+			ErrorLogger:           errorLogger,
+		})
+		wg.Add(1)
+		go func(stage int) {
+			defer wg.Done()
+			c.consume(ctx, reader, producer, stage)
+		}(stage)
+	}
+	wg.Wait()
+	return nil
+}
+
+// createTopics creates the retry and dead letter topics of topics, with the
+// defaults of the cluster, so the retry stages are assigned partitions when
+// joining their group. Failures are only logged, the producer creating the
+// topics on their first message when the cluster allows it.
+func (c *Consumer) createTopics(ctx context.Context, topics []string) {
+	var configs []kafka.TopicConfig
+	for _, topic := range topics {
+		for stage := 1; stage <= len(c.opts.RetryDelays); stage++ {
+			configs = append(configs, kafka.TopicConfig{Topic: retryTopic(topic, stage), NumPartitions: -1, ReplicationFactor: -1})
+		}
+		configs = append(configs, kafka.TopicConfig{Topic: deadLetterTopic(topic), NumPartitions: -1, ReplicationFactor: -1})
+	}
+	transport := &kafka.Transport{ClientID: c.opts.ClientID, TLS: c.opts.TLS, SASL: c.opts.SASL}
+	defer transport.CloseIdleConnections()
+	client := &kafka.Client{Addr: kafka.TCP(c.opts.Brokers...), Timeout: 10 * time.Second, Transport: transport}
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: configs})
+	if err != nil {
+		xlogger.Warning("xkafka: cannot create the retry and dead letter topics", zap.String("error", err.Error()))
+		return
+	}
+	for topic, err := range resp.Errors {
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			xlogger.Warning("xkafka: cannot create topic "+topic, zap.String("error", err.Error()))
+		}
+	}
+}
+
+func retryTopic(topic string, stage int) string {
+	return topic + ".retry." + strconv.Itoa(stage)
+}
+
+func deadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// consume handles the messages of reader, of the retry stage, 0 being the
+// original topics
+func (c *Consumer) consume(ctx context.Context, reader *kafka.Reader, producer *Producer, stage int) {
+	defer func() {
+		if err := reader.Close(); err != nil {
+			xlogger.Warning("xkafka: cannot close the reader", zap.String("error", err.Error()))
+		}
+	}()
+	for {
+		record, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+			xlogger.Error("xkafka: cannot fetch", err, zap.String("group_id", reader.Config().GroupID))
+			sleep(ctx, time.Second)
+			continue
+		}
+		if !c.process(ctx, record, producer, stage) {
+			return
+		}
+		// A message processed is committed, even when stopping
+		if err := reader.CommitMessages(context.WithoutCancel(ctx), record); err != nil {
+			xlogger.Warning("xkafka: cannot commit, the message will be redelivered",
+				zap.String("topic", record.Topic), zap.Int("partition", record.Partition), zap.Int64("offset", record.Offset),
+				zap.String("error", err.Error()))
+		}
+	}
+}
+
+// process handles record, then retries or dead letters it when failing. It
+// reports false when ctx is done before, the record being redelivered.
+func (c *Consumer) process(ctx context.Context, record kafka.Message, producer *Producer, stage int) bool {
+	msg := fromRecord(record)
+	topic := msg.Topic
+	if stage > 0 {
+		topic = msg.Header(xmsg.HeaderOriginalTopic)
+		msg.Topic = topic
+		if !sleep(ctx, time.Until(record.Time.Add(c.opts.RetryDelays[stage-1]))) {
+			return false
+		}
+	}
+	previous, _ := strconv.Atoi(msg.Header(xmsg.HeaderAttempts))
+
+	c.mu.RLock()
+	handler, ok := c.handlers[topic]
+	c.mu.RUnlock()
+	var err error
+	if !ok {
+		err = xmsg.Permanent(xerrors.NewNotImplementedError("no handler for the topic " + topic))
+	}
+
+	backoff := c.opts.Backoff
+	for attempt := 1; ok && attempt <= c.opts.Attempts; attempt++ {
+		msg.Attempt = previous + attempt
+		if err = c.handle(ctx, handler, msg); err == nil {
+			return true
+		}
+		if xmsg.IsPermanent(err) || attempt == c.opts.Attempts {
+			break
+		}
+		xlogger.Warning("xkafka: message failed, retrying: "+err.Error(), logTags(msg)...)
+		if !sleep(ctx, backoff) {
+			return false
+		}
+		backoff *= 2
+	}
+
+	next := deadLetterTopic(topic)
+	if stage < len(c.opts.RetryDelays) && !xmsg.IsPermanent(err) {
+		next = retryTopic(topic, stage+1)
+		xlogger.Warning("xkafka: message failed, moving to "+next+": "+err.Error(), logTags(msg)...)
+	} else {
+		xlogger.Error("xkafka: message is dead, moving to "+next, err, logTags(msg)...)
+	}
+	return c.forward(ctx, producer, msg, next, err)
+}
+
+// handle calls handler in the consumer span of msg
+func (c *Consumer) handle(ctx context.Context, handler xmsg.Handler, msg *xmsg.Message) (err error) {
+	ctx, span := xmsg.StartConsume(ctx, system, msg)
+	defer xtrace.End(span, &err)
+	// A message taken is handled to completion, so its outcome is recorded
+	return xmsg.Call(context.WithoutCancel(ctx), handler, msg)
+}
+
+// forward publishes msg to the retry or dead letter topic next, retrying
+// until ctx is done
+func (c *Consumer) forward(ctx context.Context, producer *Producer, msg *xmsg.Message, next string, cause error) bool {
+	forwarded := xmsg.Forward(msg, next, cause)
+
+	backoff := c.opts.Backoff
+	for {
+		err := producer.Publish(context.WithoutCancel(ctx), forwarded)
+		if err == nil {
+			return true
+		}
+		xlogger.Error("xkafka: cannot move the message to "+next, err, logTags(msg)...)
+		if !sleep(ctx, backoff) {
+			return false
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// sleep waits d and reports whether ctx is still not done
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
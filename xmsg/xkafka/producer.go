@@ -0,0 +1,145 @@
+// Package xkafka produces and consumes Kafka messages with the xmsg model:
+// consumer groups, handler functions, retry and dead letter topics, logs and
+// traces
+package xkafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// system is the messaging.system of the spans
+const system = "kafka"
+
+// Options configures the connection to the cluster
+type Options struct {
+	// Brokers are the host:port of the bootstrap brokers
+	Brokers []string
+	// ClientID defaults to the one of kafka-go
+	ClientID string
+	TLS      *tls.Config
+	// SASL is eg. a plain.Mechanism or a scram mechanism
+	SASL sasl.Mechanism
+}
+
+// ProducerOptions configures a Producer
+type ProducerOptions struct {
+	Options
+	// Codec encodes the values of Send, xmsg.JSON by default
+	Codec xmsg.Codec
+	// BatchTimeout is how long messages wait for a batch, 10ms by default
+	BatchTimeout time.Duration
+	// AutoCreateTopics creates the missing topics, when the cluster allows
+	// it, eg. the retry and dead letter topics
+	AutoCreateTopics bool
+}
+
+// Producer publishes messages, acknowledged by all the in-sync replicas,
+// to the partition of their key
+type Producer struct {
+	writer *kafka.Writer
+	codec  xmsg.Codec
+}
+
+var _ xmsg.Publisher = (*Producer)(nil)
+
+// NewProducer creates a producer
+func NewProducer(opts ProducerOptions) *Producer {
+	if opts.Codec == nil {
+		opts.Codec = xmsg.JSON
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = 10 * time.Millisecond
+	}
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(opts.Brokers...),
+		Balancer:               &kafka.Hash{},
+		BatchTimeout:           opts.BatchTimeout,
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: opts.AutoCreateTopics,
+		Transport:              &kafka.Transport{ClientID: opts.ClientID, TLS: opts.TLS, SASL: opts.SASL},
+		ErrorLogger:            errorLogger,
+	}
+	return &Producer{writer: writer, codec: opts.Codec}
+}
+
+// errorLogger logs the errors of kafka-go as warnings
+var errorLogger = kafka.LoggerFunc(func(format string, args ...interface{}) {
+	xlogger.Warning("xkafka: " + fmt.Sprintf(format, args...))
+})
+
+// Publish publishes msgs, each with its producer span, and waits for their
+// acknowledgement
+func (p *Producer) Publish(ctx context.Context, msgs ...*xmsg.Message) (err error) {
+	records := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		_, span := xmsg.StartPublish(ctx, system, msg)
+		defer xtrace.End(span, &err)
+		records[i] = toRecord(msg)
+	}
+	if err := p.writer.WriteMessages(ctx, records...); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot publish to Kafka")
+	}
+	return nil
+}
+
+// Send publishes v encoded with the Codec of the producer
+func (p *Producer) Send(ctx context.Context, topic, key string, v interface{}) error {
+	msg, err := xmsg.Encode(p.codec, topic, key, v)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, msg)
+}
+
+// Close flushes the pending messages and closes the producer
+func (p *Producer) Close() error {
+	if err := p.writer.Close(); err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot close the Kafka producer")
+	}
+	return nil
+}
+
+func toRecord(msg *xmsg.Message) kafka.Message {
+	record := kafka.Message{Topic: msg.Topic, Value: msg.Body}
+	if msg.Key != "" {
+		record.Key = []byte(msg.Key)
+	}
+	for name, value := range msg.Headers {
+		record.Headers = append(record.Headers, kafka.Header{Key: name, Value: []byte(value)})
+	}
+	return record
+}
+
+func fromRecord(record kafka.Message) *xmsg.Message {
+	msg := &xmsg.Message{
+		Topic:   record.Topic,
+		Key:     string(record.Key),
+		Body:    record.Value,
+		Headers: make(map[string]string, len(record.Headers)),
+		ID:      fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset),
+		Time:    record.Time,
+		Attempt: 1,
+	}
+	for _, header := range record.Headers {
+		msg.Headers[header.Key] = string(header.Value)
+	}
+	return msg
+}
+
+// logTags are the tags of the logs of msg
+func logTags(msg *xmsg.Message) []zap.Field {
+	return []zap.Field{zap.String("topic", msg.Topic), zap.String("message_id", msg.ID), zap.Int("attempt", msg.Attempt)}
+}
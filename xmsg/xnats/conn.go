@@ -0,0 +1,153 @@
+// Package xnats publishes and consumes NATS messages with the xmsg model,
+// with core NATS subscriptions or durable JetStream consumers
+package xnats
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+)
+
+// system is the messaging.system of the spans
+const system = "nats"
+
+// headerKey carries the key of the messages, which NATS lacks
+const headerKey = "X-Message-Key"
+
+// Options configures Connect
+type Options struct {
+	// URL is eg. "nats://localhost:4222", a comma separated list for a
+	// cluster, nats.DefaultURL by default
+	URL string
+	// Name identifies the connection in the monitoring of the server
+	Name string
+	// Credentials is the path of a .creds file
+	Credentials string
+	TLS         *tls.Config
+	// ReconnectWait between the reconnection attempts, 2s by default. The
+	// connection reconnects forever.
+	ReconnectWait time.Duration
+}
+
+// Conn is a NATS connection with its JetStream context
+type Conn struct {
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+// Connect connects to NATS
+func Connect(opts Options) (*Conn, error) {
+	if opts.URL == "" {
+		opts.URL = nats.DefaultURL
+	}
+	if opts.ReconnectWait <= 0 {
+		opts.ReconnectWait = 2 * time.Second
+	}
+	options := []nats.Option{
+		nats.Name(opts.Name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(opts.ReconnectWait),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				xlogger.Warning("xnats: disconnected, reconnecting", zap.String("error", err.Error()))
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			xlogger.Info("xnats: reconnected", zap.String("url", nc.ConnectedUrlRedacted()))
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			tags := []zap.Field{zap.String("error", err.Error())}
+			if sub != nil {
+				tags = append(tags, zap.String("subject", sub.Subject))
+			}
+			xlogger.Warning("xnats: asynchronous error", tags...)
+		}),
+	}
+	if opts.Credentials != "" {
+		options = append(options, nats.UserCredentials(opts.Credentials))
+	}
+	if opts.TLS != nil {
+		options = append(options, nats.Secure(opts.TLS))
+	}
+	nc, err := nats.Connect(opts.URL, options...)
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot connect to NATS")
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot create the JetStream context")
+	}
+	return &Conn{nc: nc, js: js}, nil
+}
+
+// NATS returns the underlying connection
+func (c *Conn) NATS() *nats.Conn {
+	return c.nc
+}
+
+// JetStream returns the JetStream context, eg. to create streams
+func (c *Conn) JetStream() jetstream.JetStream {
+	return c.js
+}
+
+// Close drains the subscriptions, flushes the messages being published and
+// closes the connection
+func (c *Conn) Close() error {
+	if err := c.nc.Drain(); err != nil {
+		c.nc.Close()
+		return xerrors.WrapServiceUnavailable(err, "cannot drain the NATS connection")
+	}
+	return nil
+}
+
+func toMsg(msg *xmsg.Message) *nats.Msg {
+	m := nats.NewMsg(msg.Topic)
+	m.Data = msg.Body
+	for name, value := range msg.Headers {
+		m.Header.Set(name, value)
+	}
+	if msg.Key != "" {
+		m.Header.Set(headerKey, msg.Key)
+	}
+	return m
+}
+
+func fromMsg(subject string, header nats.Header, data []byte) *xmsg.Message {
+	msg := &xmsg.Message{Topic: subject, Body: data, Headers: make(map[string]string, len(header)), Attempt: 1}
+	for name := range header {
+		msg.Headers[name] = header.Get(name)
+	}
+	msg.Key = msg.Headers[headerKey]
+	delete(msg.Headers, headerKey)
+	return msg
+}
+
+// fromJetStream converts a JetStream message, with its stream sequence as id
+func fromJetStream(m jetstream.Msg) *xmsg.Message {
+	msg := fromMsg(m.Subject(), m.Headers(), m.Data())
+	if metadata, err := m.Metadata(); err == nil {
+		msg.ID = metadata.Stream + "/" + strconv.FormatUint(metadata.Sequence.Stream, 10)
+		msg.Time = metadata.Timestamp
+		msg.Attempt = int(metadata.NumDelivered)
+	}
+	return msg
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return hex.EncodeToString(b)
+}
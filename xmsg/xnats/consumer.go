@@ -0,0 +1,255 @@
+package xnats
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// ConsumerOptions configures a Consumer
+type ConsumerOptions struct {
+	// Stream is the JetStream stream of the subjects. Without it the
+	// subjects are consumed with core NATS subscriptions: at most once,
+	// without retries.
+	Stream string
+	// Durable names the durable consumers, one per subject, or the queue
+	// group of the core NATS subscriptions, sharing the messages between
+	// the instances of the service
+	Durable string
+	// AckPolicy defaults to jetstream.AckExplicitPolicy
+	AckPolicy jetstream.AckPolicy
+	// AckWait is how long a message may be handled before it is
+	// redelivered, 30s by default
+	AckWait time.Duration
+	// MaxDeliver is the number of deliveries of a message before it is
+	// dead, 5 by default
+	MaxDeliver int
+	// Backoff are the delays of the redeliveries of the failed messages,
+	// the last one repeating, 1s by default
+	Backoff []time.Duration
+	// Concurrency is the number of messages of a subject handled at once, 1
+	// by default
+	Concurrency int
+	// DeadLetterPrefix republishes the dead messages to <prefix><subject>,
+	// with the x-error header, eg. "dlq."
+	DeadLetterPrefix string
+}
+
+// Consumer consumes the subjects of its handlers. JetStream messages are
+// acknowledged once handled; failed ones are redelivered after the Backoff
+// until MaxDeliver, and the ones failing with a permanent error are
+// terminated at once.
+type Consumer struct {
+	conn *Conn
+	opts ConsumerOptions
+
+	mu       sync.RWMutex
+	handlers map[string]xmsg.Handler
+}
+
+var _ xmsg.Subscriber = (*Consumer)(nil)
+
+// Consumer creates a consumer
+func (c *Conn) Consumer(opts ConsumerOptions) *Consumer {
+	if opts.AckWait <= 0 {
+		opts.AckWait = 30 * time.Second
+	}
+	if opts.MaxDeliver <= 0 {
+		opts.MaxDeliver = 5
+	}
+	if len(opts.Backoff) == 0 {
+		opts.Backoff = []time.Duration{time.Second}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &Consumer{conn: c, opts: opts, handlers: make(map[string]xmsg.Handler)}
+}
+
+// Handle sets the handler of the messages of subject, which may have
+// wildcards
+func (c *Consumer) Handle(subject string, handler xmsg.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[subject] = handler
+}
+
+// Run consumes the subjects of the handlers until ctx is done, then stops
+// fetching and waits for the messages being handled
+func (c *Consumer) Run(ctx context.Context) error {
+	c.mu.RLock()
+	handlers := make(map[string]xmsg.Handler, len(c.handlers))
+	for subject, handler := range c.handlers {
+		handlers[subject] = handler
+	}
+	c.mu.RUnlock()
+	if len(handlers) == 0 {
+		return xerrors.NewInternalServerError("the NATS consumer has no handler")
+	}
+
+	var wg sync.WaitGroup
+	// stops return once no callback can dispatch anymore, so that wg.Wait
+	// does not race with wg.Add
+	var stops []func()
+	defer func() {
+		for _, stop := range stops {
+			stop()
+		}
+		wg.Wait()
+	}()
+	for subject, handler := range handlers {
+		sem := make(chan struct{}, c.opts.Concurrency)
+		// dispatch handles the message in the background unless ctx is done
+		dispatch := func(process func()) bool {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			if ctx.Err() != nil {
+				<-sem
+				return false
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				process()
+			}()
+			return true
+		}
+
+		if c.opts.Stream == "" {
+			// The messages received once ctx is done are dropped, as core
+			// NATS delivers at most once anyway
+			sub, err := c.conn.nc.QueueSubscribe(subject, c.opts.Durable, func(m *nats.Msg) {
+				dispatch(func() { c.processCore(ctx, handler, m) })
+			})
+			if err != nil {
+				return xerrors.WrapServiceUnavailable(err, "cannot subscribe to "+subject)
+			}
+			closed := make(chan struct{})
+			// Called once the last callback returned
+			sub.SetClosedHandler(func(string) { close(closed) })
+			stops = append(stops, func() {
+				if err := sub.Drain(); err == nil {
+					<-closed
+				}
+			})
+			continue
+		}
+
+		consumer, err := c.conn.js.CreateOrUpdateConsumer(ctx, c.opts.Stream, c.config(subject))
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot create the JetStream consumer of "+subject)
+		}
+		consumeCtx, err := consumer.Consume(func(m jetstream.Msg) {
+			if !dispatch(func() { c.process(ctx, handler, m) }) {
+				// Redelivered at once rather than after AckWait
+				_ = m.Nak()
+			}
+		},
+			jetstream.PullMaxMessages(c.opts.Concurrency),
+			jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+				xlogger.Warning("xnats: consume error", zap.String("subject", subject), zap.String("error", err.Error()))
+			}),
+		)
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot consume "+subject)
+		}
+		stops = append(stops, func() {
+			consumeCtx.Stop()
+			<-consumeCtx.Closed()
+		})
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// config returns the configuration of the durable consumer of subject
+func (c *Consumer) config(subject string) jetstream.ConsumerConfig {
+	config := jetstream.ConsumerConfig{
+		FilterSubject: subject,
+		AckPolicy:     c.opts.AckPolicy,
+		AckWait:       c.opts.AckWait,
+		MaxDeliver:    c.opts.MaxDeliver,
+		MaxAckPending: c.opts.Concurrency,
+	}
+	if c.opts.Durable != "" {
+		// Durable names cannot have dots nor wildcards
+		config.Durable = c.opts.Durable + "_" + strings.NewReplacer(".", "_", "*", "any", ">", "all").Replace(subject)
+	}
+	return config
+}
+
+// process handles a JetStream message and acknowledges, redelivers or
+// terminates it
+func (c *Consumer) process(ctx context.Context, handler xmsg.Handler, m jetstream.Msg) {
+	msg := fromJetStream(m)
+	tags := []zap.Field{zap.String("subject", msg.Topic), zap.String("message_id", msg.ID), zap.Int("attempt", msg.Attempt)}
+	err := handle(ctx, handler, msg)
+	if c.opts.AckPolicy == jetstream.AckNonePolicy {
+		if err != nil {
+			xlogger.Error("xnats: message failed", err, tags...)
+		}
+		return
+	}
+
+	var ackErr error
+	switch {
+	case err == nil:
+		ackErr = m.Ack()
+	case xmsg.IsPermanent(err) || msg.Attempt >= c.opts.MaxDeliver:
+		xlogger.Error("xnats: message is dead", err, tags...)
+		c.deadLetter(ctx, msg, err)
+		ackErr = m.Term()
+	default:
+		delay := c.opts.Backoff[min(msg.Attempt, len(c.opts.Backoff))-1]
+		xlogger.Warning("xnats: message failed, redelivering in "+delay.String()+": "+err.Error(), tags...)
+		ackErr = m.NakWithDelay(delay)
+	}
+	if ackErr != nil {
+		xlogger.Warning("xnats: cannot acknowledge, the message will be redelivered", append(tags, zap.String("error", ackErr.Error()))...)
+	}
+}
+
+// processCore handles a core NATS message, whose failures are only logged
+func (c *Consumer) processCore(ctx context.Context, handler xmsg.Handler, m *nats.Msg) {
+	msg := fromMsg(m.Subject, m.Header, m.Data)
+	if err := handle(ctx, handler, msg); err != nil {
+		xlogger.Error("xnats: message failed", err, zap.String("subject", msg.Topic))
+		c.deadLetter(ctx, msg, err)
+	}
+}
+
+// deadLetter republishes msg to its dead letter subject, if any
+func (c *Consumer) deadLetter(ctx context.Context, msg *xmsg.Message, cause error) {
+	if c.opts.DeadLetterPrefix == "" {
+		return
+	}
+	dead := xmsg.Forward(msg, c.opts.DeadLetterPrefix+msg.Topic, cause)
+	publisher := c.conn.Publisher(PublisherOptions{JetStream: c.opts.Stream != ""})
+	if err := publisher.Publish(context.WithoutCancel(ctx), dead); err != nil {
+		xlogger.Error("xnats: cannot publish the dead message to "+dead.Topic, err, zap.String("subject", msg.Topic))
+	}
+}
+
+// handle calls handler in the consumer span of msg
+func handle(ctx context.Context, handler xmsg.Handler, msg *xmsg.Message) (err error) {
+	ctx, span := xmsg.StartConsume(ctx, system, msg)
+	defer xtrace.End(span, &err)
+	// A message taken is handled to completion, so its outcome is recorded
+	return xmsg.Call(context.WithoutCancel(ctx), handler, msg)
+}
@@ -0,0 +1,77 @@
+package xnats
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// PublisherOptions configures a Publisher
+type PublisherOptions struct {
+	// JetStream publishes to the streams, waiting for their acknowledgement
+	// and deduplicated by message id. Else the messages are published with
+	// core NATS, delivered at most once to the subscribers connected.
+	JetStream bool
+	// Codec encodes the values of Send, xmsg.JSON by default
+	Codec xmsg.Codec
+}
+
+// Publisher publishes messages to the subjects of their topic
+type Publisher struct {
+	conn *Conn
+	opts PublisherOptions
+}
+
+var _ xmsg.Publisher = (*Publisher)(nil)
+
+// Publisher creates a publisher
+func (c *Conn) Publisher(opts PublisherOptions) *Publisher {
+	if opts.Codec == nil {
+		opts.Codec = xmsg.JSON
+	}
+	return &Publisher{conn: c, opts: opts}
+}
+
+// Publish publishes msgs, each with its producer span. A message with an
+// ID keeps it as JetStream message id, deduplicating its republications.
+func (p *Publisher) Publish(ctx context.Context, msgs ...*xmsg.Message) error {
+	for _, msg := range msgs {
+		if err := p.publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) publish(ctx context.Context, msg *xmsg.Message) (err error) {
+	ctx, span := xmsg.StartPublish(ctx, system, msg)
+	defer xtrace.End(span, &err)
+	m := toMsg(msg)
+	if !p.opts.JetStream {
+		if err := p.conn.nc.PublishMsg(m); err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot publish to "+msg.Topic)
+		}
+		return nil
+	}
+	id := msg.ID
+	if id == "" {
+		id = newID()
+	}
+	if _, err := p.conn.js.PublishMsg(ctx, m, jetstream.WithMsgID(id)); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot publish to the stream of "+msg.Topic)
+	}
+	return nil
+}
+
+// Send publishes v encoded with the Codec of the publisher
+func (p *Publisher) Send(ctx context.Context, topic, key string, v interface{}) error {
+	msg, err := xmsg.Encode(p.opts.Codec, topic, key, v)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, msg)
+}
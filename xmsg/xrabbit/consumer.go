@@ -0,0 +1,195 @@
+package xrabbit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// ConsumerOptions configures a Consumer
+type ConsumerOptions struct {
+	// Prefetch is the number of unacknowledged messages of a queue, handled
+	// at once, 10 by default
+	Prefetch int
+	// Attempts is the number of times a handler is called on a message
+	// before it is rejected, 3 by default
+	Attempts int
+	// Backoff is the wait before the second attempt, 100ms by default,
+	// doubling after every attempt
+	Backoff time.Duration
+	// Declare declares the queues of the handlers with DeclareQueue
+	Declare bool
+}
+
+// Consumer consumes the queues of its handlers. A message is acknowledged
+// once handled and rejected without requeue when it keeps failing or fails
+// with a permanent error, the queue dead lettering it, see DeclareQueue.
+type Consumer struct {
+	conn *Conn
+	opts ConsumerOptions
+
+	mu       sync.RWMutex
+	handlers map[string]xmsg.Handler
+}
+
+var _ xmsg.Subscriber = (*Consumer)(nil)
+
+// Consumer creates a consumer
+func (c *Conn) Consumer(opts ConsumerOptions) *Consumer {
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = 10
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	return &Consumer{conn: c, opts: opts, handlers: make(map[string]xmsg.Handler)}
+}
+
+// Handle sets the handler of the messages of queue
+func (c *Consumer) Handle(queue string, handler xmsg.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[queue] = handler
+}
+
+// Run consumes the queues of the handlers until ctx is done, subscribing
+// again when the channel or the connection is lost. It then cancels the
+// subscriptions and waits for the messages being handled; the messages
+// prefetched but not handled are requeued.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.mu.RLock()
+	handlers := make(map[string]xmsg.Handler, len(c.handlers))
+	for queue, handler := range c.handlers {
+		handlers[queue] = handler
+	}
+	c.mu.RUnlock()
+	if len(handlers) == 0 {
+		return xerrors.NewInternalServerError("the RabbitMQ consumer has no handler")
+	}
+
+	var wg sync.WaitGroup
+	for queue, handler := range handlers {
+		wg.Add(1)
+		go func(queue string, handler xmsg.Handler) {
+			defer wg.Done()
+			c.consume(ctx, queue, handler)
+		}(queue, handler)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (c *Consumer) consume(ctx context.Context, queue string, handler xmsg.Handler) {
+	delay := c.conn.opts.ReconnectDelay
+	for ctx.Err() == nil {
+		err := c.subscribe(ctx, queue, handler)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			xlogger.Warning("xrabbit: subscription lost, subscribing again", zap.String("queue", queue), zap.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// subscribe handles the deliveries of queue until ctx is done or the
+// channel is closed
+func (c *Consumer) subscribe(ctx context.Context, queue string, handler xmsg.Handler) error {
+	ch, err := c.conn.channel(ctx)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if c.opts.Declare {
+		if err := declareQueue(ch, queue); err != nil {
+			return err
+		}
+	}
+	if err := ch.Qos(c.opts.Prefetch, 0, false); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot set the prefetch of "+queue)
+	}
+	tag := "xrabbit-" + newID()
+	deliveries, err := ch.Consume(queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot consume "+queue)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = ch.Cancel(tag, false)
+			return nil
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return xerrors.NewServiceUnavailableError("the channel of " + queue + " is closed")
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.process(ctx, queue, handler, delivery)
+			}()
+		}
+	}
+}
+
+// process handles delivery, retrying it Attempts times, then acknowledges
+// or rejects it
+func (c *Consumer) process(ctx context.Context, queue string, handler xmsg.Handler, delivery amqp.Delivery) {
+	msg := fromDelivery(queue, delivery)
+	tags := []zap.Field{zap.String("queue", queue), zap.String("message_id", msg.ID)}
+	first := msg.Attempt
+
+	var err error
+	backoff := c.opts.Backoff
+	for attempt := 1; attempt <= c.opts.Attempts; attempt++ {
+		msg.Attempt = first + attempt - 1
+		if err = handle(ctx, handler, msg); err == nil || xmsg.IsPermanent(err) || attempt == c.opts.Attempts {
+			break
+		}
+		xlogger.Warning("xrabbit: message failed, retrying: "+err.Error(), append(tags, zap.Int("attempt", msg.Attempt))...)
+		select {
+		case <-ctx.Done():
+			// Requeued for another consumer
+			_ = delivery.Nack(false, true)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if err == nil {
+		if ackErr := delivery.Ack(false); ackErr != nil {
+			xlogger.Warning("xrabbit: cannot acknowledge, the message will be redelivered", append(tags, zap.String("error", ackErr.Error()))...)
+		}
+		return
+	}
+	xlogger.Error("xrabbit: message is dead, rejecting it", err, append(tags, zap.Int("attempt", msg.Attempt))...)
+	if nackErr := delivery.Nack(false, false); nackErr != nil {
+		xlogger.Warning("xrabbit: cannot reject, the message will be redelivered", append(tags, zap.String("error", nackErr.Error()))...)
+	}
+}
+
+// handle calls handler in the consumer span of msg
+func handle(ctx context.Context, handler xmsg.Handler, msg *xmsg.Message) (err error) {
+	ctx, span := xmsg.StartConsume(ctx, system, msg)
+	defer xtrace.End(span, &err)
+	// A message taken is handled to completion, so its outcome is recorded
+	return xmsg.Call(context.WithoutCancel(ctx), handler, msg)
+}
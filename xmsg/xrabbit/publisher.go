@@ -0,0 +1,193 @@
+package xrabbit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// headerKey carries the key of the messages, which AMQP lacks
+const headerKey = "x-message-key"
+
+// PublisherOptions configures a Publisher
+type PublisherOptions struct {
+	// Exchange the messages are published to, with their topic as routing
+	// key. The default exchange routes them to the queue named by the topic.
+	Exchange string
+	// Codec encodes the values of Send, xmsg.JSON by default
+	Codec xmsg.Codec
+	// ConfirmTimeout bounds the wait for the confirmation of the broker,
+	// 10s by default
+	ConfirmTimeout time.Duration
+}
+
+// Publisher publishes persistent messages and waits for the publisher
+// confirms of the broker
+type Publisher struct {
+	conn *Conn
+	opts PublisherOptions
+
+	mu sync.Mutex
+	ch *amqp.Channel
+}
+
+var _ xmsg.Publisher = (*Publisher)(nil)
+
+// Publisher creates a publisher
+func (c *Conn) Publisher(opts PublisherOptions) *Publisher {
+	if opts.Codec == nil {
+		opts.Codec = xmsg.JSON
+	}
+	if opts.ConfirmTimeout <= 0 {
+		opts.ConfirmTimeout = 10 * time.Second
+	}
+	return &Publisher{conn: c, opts: opts}
+}
+
+// Publish publishes msgs, each with its producer span, and waits for their
+// confirmation. A channel lost while publishing is opened again once.
+func (p *Publisher) Publish(ctx context.Context, msgs ...*xmsg.Message) (err error) {
+	publishings := make([]amqp.Publishing, len(msgs))
+	for i, msg := range msgs {
+		_, span := xmsg.StartPublish(ctx, system, msg)
+		defer xtrace.End(span, &err)
+		publishings[i] = toPublishing(msg)
+	}
+
+	var confirmations []*amqp.DeferredConfirmation
+	for retry := 0; ; retry++ {
+		if confirmations, err = p.publish(ctx, msgs, publishings); err == nil || retry == 1 {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.opts.ConfirmTimeout)
+	defer cancel()
+	for i, confirmation := range confirmations {
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "no confirmation of RabbitMQ for "+msgs[i].Topic)
+		}
+		if !acked {
+			return xerrors.NewServiceUnavailableError("RabbitMQ rejected a message of " + msgs[i].Topic)
+		}
+	}
+	return nil
+}
+
+// publish publishes on the channel of p, forgetting it when it fails
+func (p *Publisher) publish(ctx context.Context, msgs []*xmsg.Message, publishings []amqp.Publishing) ([]*amqp.DeferredConfirmation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch == nil || p.ch.IsClosed() {
+		ch, err := p.conn.channel(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			return nil, xerrors.WrapServiceUnavailable(err, "cannot enable the RabbitMQ publisher confirms")
+		}
+		p.ch = ch
+	}
+
+	confirmations := make([]*amqp.DeferredConfirmation, len(msgs))
+	for i, msg := range msgs {
+		confirmation, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, p.opts.Exchange, msg.Topic, false, false, publishings[i])
+		if err != nil {
+			_ = p.ch.Close()
+			p.ch = nil
+			return nil, xerrors.WrapServiceUnavailable(err, "cannot publish to RabbitMQ")
+		}
+		confirmations[i] = confirmation
+	}
+	return confirmations, nil
+}
+
+// Send publishes v encoded with the Codec of the publisher
+func (p *Publisher) Send(ctx context.Context, topic, key string, v interface{}) error {
+	msg, err := xmsg.Encode(p.opts.Codec, topic, key, v)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, msg)
+}
+
+// Close closes the channel of the publisher
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch != nil {
+		_ = p.ch.Close()
+		p.ch = nil
+	}
+	return nil
+}
+
+func toPublishing(msg *xmsg.Message) amqp.Publishing {
+	publishing := amqp.Publishing{
+		Headers:      amqp.Table{},
+		ContentType:  msg.Header(xmsg.HeaderContentType),
+		DeliveryMode: amqp.Persistent,
+		MessageId:    newID(),
+		Timestamp:    time.Now(),
+		Body:         msg.Body,
+	}
+	for name, value := range msg.Headers {
+		if name != xmsg.HeaderContentType {
+			publishing.Headers[name] = value
+		}
+	}
+	if msg.Key != "" {
+		publishing.Headers[headerKey] = msg.Key
+	}
+	return publishing
+}
+
+func fromDelivery(queue string, delivery amqp.Delivery) *xmsg.Message {
+	msg := &xmsg.Message{
+		Topic:   queue,
+		Body:    delivery.Body,
+		Headers: make(map[string]string, len(delivery.Headers)+1),
+		ID:      delivery.MessageId,
+		Time:    delivery.Timestamp,
+		Attempt: 1,
+	}
+	for name, value := range delivery.Headers {
+		if s, ok := value.(string); ok {
+			msg.Headers[name] = s
+		}
+	}
+	if delivery.ContentType != "" {
+		msg.Headers[xmsg.HeaderContentType] = delivery.ContentType
+	}
+	msg.Key = msg.Headers[headerKey]
+	delete(msg.Headers, headerKey)
+	// Quorum queues count the deliveries, classic queues only flag them
+	if count, ok := delivery.Headers["x-delivery-count"].(int64); ok {
+		msg.Attempt = int(count) + 1
+	} else if delivery.Redelivered {
+		msg.Attempt = 2
+	}
+	return msg
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,203 @@
+// Package xsqs consumes AWS SQS queues and publishes to SQS queues and SNS
+// topics with the xmsg model
+package xsqs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xmsg"
+)
+
+// system is the messaging.system of the spans
+const system = "aws_sqs"
+
+// attributeKey carries the key of the messages, which SQS lacks
+const attributeKey = "x-message-key"
+
+// Options configures New
+type Options struct {
+	// Region defaults to the one of the environment, us-east-1 with an
+	// Endpoint
+	Region string
+	// Endpoint overrides the endpoint of SQS and SNS, eg.
+	// "http://localhost:4566" for LocalStack or ElasticMQ
+	Endpoint string
+	// AccessKeyID and SecretAccessKey are static credentials, eg. "test" for
+	// an emulator. The default credential chain is used without them.
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Client is a SQS and SNS client resolving the URLs of the queues by name
+type Client struct {
+	sqs *sqs.Client
+	sns *sns.Client
+
+	urls sync.Map
+}
+
+// New creates a client from the AWS configuration of the environment
+func New(ctx context.Context, opts Options) (*Client, error) {
+	var options []func(*config.LoadOptions) error
+	if opts.Region == "" && opts.Endpoint != "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Region != "" {
+		options = append(options, config.WithRegion(opts.Region))
+	}
+	if opts.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot load the AWS configuration")
+	}
+	return NewFromConfig(cfg, opts.Endpoint), nil
+}
+
+// NewFromConfig creates a client from cfg, with an optional endpoint
+func NewFromConfig(cfg aws.Config, endpoint string) *Client {
+	return &Client{
+		sqs: sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		sns: sns.NewFromConfig(cfg, func(o *sns.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+	}
+}
+
+// SQS returns the underlying SQS client
+func (c *Client) SQS() *sqs.Client {
+	return c.sqs
+}
+
+// SNS returns the underlying SNS client
+func (c *Client) SNS() *sns.Client {
+	return c.sns
+}
+
+// QueueURL returns the URL of queue, a name or already an URL
+func (c *Client) QueueURL(ctx context.Context, queue string) (string, error) {
+	if strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://") {
+		return queue, nil
+	}
+	if url, ok := c.urls.Load(queue); ok {
+		return url.(string), nil
+	}
+	out, err := c.sqs.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queue)})
+	if err != nil {
+		return "", xerrors.WrapServiceUnavailable(err, "cannot get the URL of the queue "+queue)
+	}
+	c.urls.Store(queue, aws.ToString(out.QueueUrl))
+	return aws.ToString(out.QueueUrl), nil
+}
+
+// CreateQueue creates queue if missing and returns its URL, eg. to set up
+// an emulator. Queues whose name ends with .fifo are FIFO queues.
+func (c *Client) CreateQueue(ctx context.Context, queue string) (string, error) {
+	input := &sqs.CreateQueueInput{QueueName: aws.String(queue)}
+	if isFIFO(queue) {
+		input.Attributes = map[string]string{
+			string(types.QueueAttributeNameFifoQueue):                 "true",
+			string(types.QueueAttributeNameContentBasedDeduplication): "true",
+		}
+	}
+	out, err := c.sqs.CreateQueue(ctx, input)
+	if err != nil {
+		return "", xerrors.WrapServiceUnavailable(err, "cannot create the queue "+queue)
+	}
+	c.urls.Store(queue, aws.ToString(out.QueueUrl))
+	return aws.ToString(out.QueueUrl), nil
+}
+
+// CreateTopic creates the SNS topic if missing and returns its ARN
+func (c *Client) CreateTopic(ctx context.Context, topic string) (string, error) {
+	out, err := c.sns.CreateTopic(ctx, &sns.CreateTopicInput{Name: aws.String(topic)})
+	if err != nil {
+		return "", xerrors.WrapServiceUnavailable(err, "cannot create the topic "+topic)
+	}
+	return aws.ToString(out.TopicArn), nil
+}
+
+// isTopic reports whether topic is a SNS topic ARN rather than a queue
+func isTopic(topic string) bool {
+	return strings.HasPrefix(topic, "arn:") && strings.Contains(topic, ":sns:")
+}
+
+func isFIFO(queue string) bool {
+	return strings.HasSuffix(queue, ".fifo")
+}
+
+func toAttributes(msg *xmsg.Message) map[string]types.MessageAttributeValue {
+	attributes := make(map[string]types.MessageAttributeValue, len(msg.Headers)+1)
+	for name, value := range msg.Headers {
+		attributes[name] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+	}
+	if msg.Key != "" {
+		attributes[attributeKey] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(msg.Key)}
+	}
+	return attributes
+}
+
+func toTopicAttributes(msg *xmsg.Message) map[string]snstypes.MessageAttributeValue {
+	attributes := make(map[string]snstypes.MessageAttributeValue, len(msg.Headers)+1)
+	for name, value := range toAttributes(msg) {
+		attributes[name] = snstypes.MessageAttributeValue{DataType: value.DataType, StringValue: value.StringValue}
+	}
+	return attributes
+}
+
+// fromMessage converts a message received from queue, unwrapping the
+// notifications of SNS topics delivered without raw message delivery
+func fromMessage(queue string, m types.Message) *xmsg.Message {
+	msg := &xmsg.Message{
+		Topic:   queue,
+		Body:    []byte(aws.ToString(m.Body)),
+		Headers: make(map[string]string, len(m.MessageAttributes)),
+		ID:      aws.ToString(m.MessageId),
+		Attempt: 1,
+	}
+	for name, value := range m.MessageAttributes {
+		if value.StringValue != nil {
+			msg.Headers[name] = *value.StringValue
+		}
+	}
+	if count, err := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+		msg.Attempt = count
+	}
+	if sent, err := strconv.ParseInt(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		msg.Time = time.UnixMilli(sent)
+	}
+	unwrapNotification(msg)
+	msg.Key = msg.Headers[attributeKey]
+	delete(msg.Headers, attributeKey)
+	return msg
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return hex.EncodeToString(b)
+}
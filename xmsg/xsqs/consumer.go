@@ -0,0 +1,359 @@
+package xsqs
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// ConsumerOptions configures a Consumer
+type ConsumerOptions struct {
+	// WaitTime is the long polling wait of the receives, 20s by default
+	WaitTime time.Duration
+	// MaxMessages is the number of messages received at once, 10 by default,
+	// fewer when less than MaxMessages handlers are idle
+	MaxMessages int
+	// Concurrency is the number of messages of a queue handled at once,
+	// MaxMessages by default
+	Concurrency int
+	// VisibilityTimeout is how long a received message is hidden from the
+	// other consumers, 30s by default. It is extended while the message is
+	// handled, so slow handlers keep their messages.
+	VisibilityTimeout time.Duration
+	// Backoff are the delays before a failed message is received again, the
+	// last one repeating, 1s by default. The redrive policy of the queue
+	// dead letters the messages received too many times.
+	Backoff []time.Duration
+	// DeadLetterQueue receives the messages failing with a permanent error,
+	// with the x-error header. They are else received again until the
+	// redrive policy of the queue dead letters them.
+	DeadLetterQueue string
+	// DeleteInterval bounds the wait of the handled messages, deleted by
+	// batches, 1s by default
+	DeleteInterval time.Duration
+}
+
+// Consumer consumes the queues of its handlers. Messages are deleted once
+// handled and made visible again after the Backoff when they fail.
+type Consumer struct {
+	client *Client
+	opts   ConsumerOptions
+
+	mu       sync.RWMutex
+	handlers map[string]xmsg.Handler
+}
+
+var _ xmsg.Subscriber = (*Consumer)(nil)
+
+// Consumer creates a consumer
+func (c *Client) Consumer(opts ConsumerOptions) *Consumer {
+	if opts.WaitTime <= 0 {
+		opts.WaitTime = 20 * time.Second
+	}
+	if opts.MaxMessages <= 0 || opts.MaxMessages > maxBatch {
+		opts.MaxMessages = maxBatch
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = opts.MaxMessages
+	}
+	if opts.VisibilityTimeout < 2*time.Second {
+		opts.VisibilityTimeout = 30 * time.Second
+	}
+	if len(opts.Backoff) == 0 {
+		opts.Backoff = []time.Duration{time.Second}
+	}
+	if opts.DeleteInterval <= 0 {
+		opts.DeleteInterval = time.Second
+	}
+	return &Consumer{client: c, opts: opts, handlers: make(map[string]xmsg.Handler)}
+}
+
+// Handle sets the handler of the messages of queue, a name or an URL
+func (c *Consumer) Handle(queue string, handler xmsg.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[queue] = handler
+}
+
+// Run consumes the queues of the handlers until ctx is done. It then stops
+// receiving, waits for the messages being handled and deletes them.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.mu.RLock()
+	handlers := make(map[string]xmsg.Handler, len(c.handlers))
+	for queue, handler := range c.handlers {
+		handlers[queue] = handler
+	}
+	c.mu.RUnlock()
+	if len(handlers) == 0 {
+		return xerrors.NewInternalServerError("the SQS consumer has no handler")
+	}
+
+	urls := make(map[string]string, len(handlers))
+	for queue := range handlers {
+		url, err := c.client.QueueURL(ctx, queue)
+		if err != nil {
+			return err
+		}
+		urls[queue] = url
+	}
+
+	var wg sync.WaitGroup
+	for queue, handler := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.consume(ctx, queue, urls[queue], handler)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// consume receives the messages of queue until ctx is done
+func (c *Consumer) consume(ctx context.Context, queue, url string, handler xmsg.Handler) {
+	deletes := make(chan string, c.opts.Concurrency)
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		c.delete(queue, url, deletes)
+	}()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.opts.Concurrency)
+	defer func() {
+		wg.Wait()
+		close(deletes)
+		<-deleted
+	}()
+	for ctx.Err() == nil {
+		// Slots are reserved before receiving, so every message received is
+		// processed and its visibility extended right away
+		slots := c.reserve(ctx, sem)
+		if slots == 0 {
+			return
+		}
+		out, err := c.client.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(url),
+			MaxNumberOfMessages:   int32(slots),
+			WaitTimeSeconds:       int32(c.opts.WaitTime / time.Second),
+			VisibilityTimeout:     int32(c.opts.VisibilityTimeout / time.Second),
+			MessageAttributeNames: []string{"All"},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameSentTimestamp,
+			},
+		})
+		var messages []types.Message
+		if err == nil {
+			messages = out.Messages
+		}
+		for range slots - len(messages) {
+			<-sem
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				xlogger.Warning("xsqs: cannot receive, retrying", zap.String("queue", queue), zap.String("error", err.Error()))
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+		for _, m := range messages {
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if c.process(ctx, queue, url, handler, m) {
+					deletes <- aws.ToString(m.ReceiptHandle)
+				}
+			}()
+		}
+	}
+}
+
+// reserve waits for a free slot of sem then takes the free ones, up to
+// MaxMessages, returning how many it took, none once ctx is done
+func (c *Consumer) reserve(ctx context.Context, sem chan struct{}) int {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return 0
+	}
+	slots := 1
+	for slots < c.opts.MaxMessages {
+		select {
+		case sem <- struct{}{}:
+			slots++
+		default:
+			return slots
+		}
+	}
+	return slots
+}
+
+// process handles m while extending its visibility and reports whether it
+// is to be deleted
+func (c *Consumer) process(ctx context.Context, queue, url string, handler xmsg.Handler, m types.Message) bool {
+	msg := fromMessage(queue, m)
+	tags := []zap.Field{zap.String("queue", queue), zap.String("message_id", msg.ID), zap.Int("attempt", msg.Attempt)}
+
+	done := make(chan struct{})
+	go c.extend(url, m.ReceiptHandle, done, tags)
+	err := handle(ctx, handler, msg)
+	close(done)
+
+	switch {
+	case err == nil:
+		return true
+	case xmsg.IsPermanent(err) && c.opts.DeadLetterQueue != "":
+		xlogger.Error("xsqs: message is dead, moving it to "+c.opts.DeadLetterQueue, err, tags...)
+		return c.deadLetter(ctx, msg, err)
+	default:
+		delay := c.opts.Backoff[min(msg.Attempt, len(c.opts.Backoff))-1]
+		xlogger.Warning("xsqs: message failed, receiving it again in "+delay.String()+": "+err.Error(), tags...)
+		c.setVisibility(url, m.ReceiptHandle, delay, tags)
+		return false
+	}
+}
+
+// extend extends the visibility of the message of receipt until done
+func (c *Consumer) extend(url string, receipt *string, done <-chan struct{}, tags []zap.Field) {
+	ticker := time.NewTicker(c.opts.VisibilityTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.setVisibility(url, receipt, c.opts.VisibilityTimeout, tags)
+		}
+	}
+}
+
+func (c *Consumer) setVisibility(url string, receipt *string, timeout time.Duration, tags []zap.Field) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := c.client.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(url),
+		ReceiptHandle:     receipt,
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	if err != nil {
+		xlogger.Warning("xsqs: cannot change the visibility of the message", append(tags, zap.String("error", err.Error()))...)
+	}
+}
+
+// deadLetter sends msg to the DeadLetterQueue and reports whether it is to
+// be deleted
+func (c *Consumer) deadLetter(ctx context.Context, msg *xmsg.Message, cause error) bool {
+	dead := xmsg.Forward(msg, c.opts.DeadLetterQueue, cause)
+	if err := c.client.Publisher(PublisherOptions{}).Publish(context.WithoutCancel(ctx), dead); err != nil {
+		xlogger.Error("xsqs: cannot move the dead message to "+dead.Topic, err, zap.String("queue", msg.Topic))
+		return false
+	}
+	return true
+}
+
+// delete deletes the messages of the receipts by batches, until receipts
+// is closed
+func (c *Consumer) delete(queue, url string, receipts <-chan string) {
+	ticker := time.NewTicker(c.opts.DeleteInterval)
+	defer ticker.Stop()
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		entries := make([]types.DeleteMessageBatchRequestEntry, len(batch))
+		for i, receipt := range batch {
+			entries[i] = types.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: aws.String(receipt)}
+		}
+		batch = batch[:0]
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		out, err := c.client.sqs.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(url), Entries: entries})
+		if err != nil {
+			xlogger.Warning("xsqs: cannot delete, the messages will be received again", zap.String("queue", queue), zap.Int("count", len(entries)), zap.String("error", err.Error()))
+			return
+		}
+		for _, failed := range out.Failed {
+			xlogger.Warning("xsqs: cannot delete, the message will be received again", zap.String("queue", queue), zap.String("error", aws.ToString(failed.Code)+" "+aws.ToString(failed.Message)))
+		}
+	}
+	for {
+		select {
+		case receipt, ok := <-receipts:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, receipt); len(batch) == maxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// notification is a SNS notification delivered to a queue without raw
+// message delivery
+type notification struct {
+	Type              string
+	MessageID         string `json:"MessageId"`
+	TopicArn          string
+	Message           string
+	Timestamp         time.Time
+	MessageAttributes map[string]struct {
+		Type  string
+		Value string
+	}
+}
+
+// unwrapNotification replaces the body of msg by the message of its SNS
+// notification, if it is one
+func unwrapNotification(msg *xmsg.Message) {
+	if len(msg.Body) == 0 || msg.Body[0] != '{' {
+		return
+	}
+	var n notification
+	if err := json.Unmarshal(msg.Body, &n); err != nil || n.Type != "Notification" || n.TopicArn == "" {
+		return
+	}
+	msg.Body = []byte(n.Message)
+	msg.ID = n.MessageID
+	msg.Time = n.Timestamp
+	for name, attribute := range n.MessageAttributes {
+		if attribute.Type == "String" {
+			msg.Headers[name] = attribute.Value
+		}
+	}
+}
+
+// handle calls handler in the consumer span of msg
+func handle(ctx context.Context, handler xmsg.Handler, msg *xmsg.Message) (err error) {
+	ctx, span := xmsg.StartConsume(ctx, system, msg)
+	defer xtrace.End(span, &err)
+	// A message taken is handled to completion, so its outcome is recorded
+	return xmsg.Call(context.WithoutCancel(ctx), handler, msg)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,154 @@
+package xsqs
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xmsg"
+	"github.com/XandaLtd/xutils-go/xtrace"
+)
+
+// maxBatch is the maximum number of entries of the SQS and SNS batches
+const maxBatch = 10
+
+// PublisherOptions configures a Publisher
+type PublisherOptions struct {
+	// Codec encodes the values of Send, xmsg.JSON by default
+	Codec xmsg.Codec
+}
+
+// Publisher publishes messages to the queue, a name or an URL, or to the
+// SNS topic, an ARN, of their topic. The topics fan the messages out to
+// their subscribed queues. Messages of FIFO queues and topics are grouped
+// by key and deduplicated by ID.
+type Publisher struct {
+	client *Client
+	opts   PublisherOptions
+}
+
+var _ xmsg.Publisher = (*Publisher)(nil)
+
+// Publisher creates a publisher
+func (c *Client) Publisher(opts PublisherOptions) *Publisher {
+	if opts.Codec == nil {
+		opts.Codec = xmsg.JSON
+	}
+	return &Publisher{client: c, opts: opts}
+}
+
+// Publish publishes msgs, each with its producer span, in batches of the
+// messages of a same topic
+func (p *Publisher) Publish(ctx context.Context, msgs ...*xmsg.Message) (err error) {
+	var topics []string
+	batches := make(map[string][]*xmsg.Message)
+	for _, msg := range msgs {
+		_, span := xmsg.StartPublish(ctx, system, msg)
+		defer xtrace.End(span, &err)
+		if _, ok := batches[msg.Topic]; !ok {
+			topics = append(topics, msg.Topic)
+		}
+		batches[msg.Topic] = append(batches[msg.Topic], msg)
+	}
+
+	for _, topic := range topics {
+		batch := batches[topic]
+		for start := 0; start < len(batch); start += maxBatch {
+			chunk := batch[start:min(start+maxBatch, len(batch))]
+			if isTopic(topic) {
+				err = p.publishTopic(ctx, topic, chunk)
+			} else {
+				err = p.publishQueue(ctx, topic, chunk)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) publishQueue(ctx context.Context, queue string, msgs []*xmsg.Message) error {
+	url, err := p.client.QueueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+	entries := make([]types.SendMessageBatchRequestEntry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = types.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			MessageBody:       aws.String(string(msg.Body)),
+			MessageAttributes: toAttributes(msg),
+		}
+		if isFIFO(queue) {
+			entries[i].MessageGroupId, entries[i].MessageDeduplicationId = fifo(msg)
+		}
+	}
+	out, err := p.client.sqs.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: aws.String(url), Entries: entries})
+	if err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot send to the queue "+queue)
+	}
+	if len(out.Failed) > 0 {
+		return batchError("cannot send to the queue "+queue, out.Failed[0].Code, out.Failed[0].Message, out.Failed[0].SenderFault)
+	}
+	return nil
+}
+
+func (p *Publisher) publishTopic(ctx context.Context, topic string, msgs []*xmsg.Message) error {
+	entries := make([]snstypes.PublishBatchRequestEntry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = snstypes.PublishBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			Message:           aws.String(string(msg.Body)),
+			MessageAttributes: toTopicAttributes(msg),
+		}
+		if isFIFO(topic) {
+			entries[i].MessageGroupId, entries[i].MessageDeduplicationId = fifo(msg)
+		}
+	}
+	out, err := p.client.sns.PublishBatch(ctx, &sns.PublishBatchInput{TopicArn: aws.String(topic), PublishBatchRequestEntries: entries})
+	if err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot publish to the topic "+topic)
+	}
+	if len(out.Failed) > 0 {
+		return batchError("cannot publish to the topic "+topic, out.Failed[0].Code, out.Failed[0].Message, out.Failed[0].SenderFault)
+	}
+	return nil
+}
+
+// Send publishes v encoded with the Codec of the publisher
+func (p *Publisher) Send(ctx context.Context, topic, key string, v interface{}) error {
+	msg, err := xmsg.Encode(p.opts.Codec, topic, key, v)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, msg)
+}
+
+// fifo returns the message group and deduplication ids of msg
+func fifo(msg *xmsg.Message) (*string, *string) {
+	group, id := msg.Key, msg.ID
+	if group == "" {
+		group = msg.Topic
+	}
+	if id == "" {
+		id = newID()
+	}
+	return aws.String(group), aws.String(id)
+}
+
+// batchError returns the error of a failed batch entry, a client error
+// when the sender is at fault
+func batchError(msg string, code, message *string, senderFault bool) error {
+	err := xerrors.NewServiceUnavailableError(msg + ": " + aws.ToString(code) + " " + aws.ToString(message))
+	if senderFault {
+		err = xerrors.NewBadRequestError(msg + ": " + aws.ToString(code) + " " + aws.ToString(message))
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package xratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Keyed keeps a limiter per key, eg. per client IP or API key. The least
+// recently used limiters are evicted past MaxKeys, and idle ones after
+// IdleTimeout.
+type Keyed struct {
+	newLimiter  func() Limiter
+	maxKeys     int
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	order    *list.List // of *keyedLimiter, most recently used first
+}
+
+type keyedLimiter struct {
+	key      string
+	limiter  Limiter
+	lastUsed time.Time
+}
+
+// NewKeyed creates per key limiters with newLimiter. A zero maxKeys or
+// idleTimeout disables the matching eviction. Pick an idle timeout long
+// enough for an evicted limiter to have fully recovered, as a new one
+// starts with a full allowance.
+func NewKeyed(newLimiter func() Limiter, maxKeys int, idleTimeout time.Duration) *Keyed {
+	return &Keyed{
+		newLimiter:  newLimiter,
+		maxKeys:     maxKeys,
+		idleTimeout: idleTimeout,
+		limiters:    make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Get returns the limiter of key, creating it when needed
+func (k *Keyed) Get(key string) Limiter {
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.idleTimeout > 0 {
+		for back := k.order.Back(); back != nil && now.Sub(back.Value.(*keyedLimiter).lastUsed) > k.idleTimeout; back = k.order.Back() {
+			k.remove(back)
+		}
+	}
+	if element, ok := k.limiters[key]; ok {
+		element.Value.(*keyedLimiter).lastUsed = now
+		k.order.MoveToFront(element)
+		return element.Value.(*keyedLimiter).limiter
+	}
+	if k.maxKeys > 0 && len(k.limiters) >= k.maxKeys {
+		k.remove(k.order.Back())
+	}
+	entry := &keyedLimiter{key: key, limiter: k.newLimiter(), lastUsed: now}
+	k.limiters[key] = k.order.PushFront(entry)
+	return entry.limiter
+}
+
+// Allow reports whether an event of key may happen now
+func (k *Keyed) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+
+// Reserve books the next permit of key
+func (k *Keyed) Reserve(key string) *Reservation {
+	return k.Get(key).Reserve()
+}
+
+// Wait blocks until an event of key may happen or ctx is done
+func (k *Keyed) Wait(ctx context.Context, key string) error {
+	return k.Get(key).Wait(ctx)
+}
+
+// Len returns the number of limiters kept
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
+
+func (k *Keyed) remove(element *list.Element) {
+	k.order.Remove(element)
+	delete(k.limiters, element.Value.(*keyedLimiter).key)
+}
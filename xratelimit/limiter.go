@@ -0,0 +1,88 @@
+// Package xratelimit provides in-process rate limiters and the middleware
+// applying them to HTTP servers and clients
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned by Wait when the request cannot be admitted
+// before the deadline of its context, or never when the limit is zero
+var ErrLimitExceeded = errors.New("rate limit exceeded")
+
+// Limiter admits events at a bounded rate. Implementations are safe for
+// concurrent use.
+type Limiter interface {
+	// Allow reports whether an event may happen now, consuming the permit when it does
+	Allow() bool
+	// Reserve books the next permit, telling how long to wait before using it
+	Reserve() *Reservation
+	// Wait blocks until an event may happen or ctx is done
+	Wait(ctx context.Context) error
+	// Limit is the number of events admitted at once, the burst of a token bucket
+	Limit() int
+	// Remaining is the number of events that may happen now
+	Remaining() int
+}
+
+// Reservation is a permit booked by Reserve
+type Reservation struct {
+	ok     bool
+	at     time.Time
+	once   sync.Once
+	cancel func()
+}
+
+// OK reports whether the permit was booked, Reserve failing when the limit
+// is zero
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long to wait before the event may happen
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns how long to wait from now before the event may happen
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok || !r.at.After(now) {
+		return 0
+	}
+	return r.at.Sub(now)
+}
+
+// Cancel gives the permit back when the event will not happen, eg. because
+// the delay is too long
+func (r *Reservation) Cancel() {
+	if r.ok && r.cancel != nil {
+		r.once.Do(r.cancel)
+	}
+}
+
+// wait waits for a reservation, giving it back when ctx is done first
+func wait(ctx context.Context, r *Reservation) error {
+	if !r.OK() {
+		return ErrLimitExceeded
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		r.Cancel()
+		return ErrLimitExceeded
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package xratelimit
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// KeyFunc returns the key a request is limited by
+type KeyFunc func(r *http.Request) string
+
+// ByIP limits requests by the IP address of the client connection. Behind a
+// proxy, use a KeyFunc reading the header set by the proxy instead.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware rejects the requests exceeding limiter with a 429 xerrors JSON
+// body and the Retry-After and X-RateLimit-* headers
+func Middleware(limiter Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if admit(w, limiter) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// KeyedMiddleware rejects the requests exceeding the limiter of their key,
+// see Middleware
+func KeyedMiddleware(limiters *Keyed, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if admit(w, limiters.Get(key(r))) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// admit writes the 429 of a rejected request, telling when to retry
+func admit(w http.ResponseWriter, limiter Limiter) bool {
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if reservation.OK() && delay == 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Limit()))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(limiter.Remaining()))
+		return true
+	}
+	reservation.Cancel()
+	xerrors.WriteJSON(w, xerrors.NewTooManyRequestsError("rate limit exceeded", delay, limiter.Limit(), 0))
+	return false
+}
+
+// Transport delays the requests of a client to respect limiter, failing with
+// ErrLimitExceeded when the request context expires first
+type Transport struct {
+	Limiter Limiter
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+// RoundTrip waits for the limiter then sends the request with Base
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(r.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,95 @@
+package xratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindow admits at most Limit events in any window of the given
+// duration. It keeps the time of the admitted events, so its memory grows
+// with the limit.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	events []time.Time // admitted or reserved, in order
+}
+
+var _ Limiter = (*SlidingWindow)(nil)
+
+// NewSlidingWindow creates a limiter admitting limit events per window
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{limit: limit, window: window}
+}
+
+// Allow reports whether an event may happen now, recording it when it does
+func (w *SlidingWindow) Allow() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	w.prune(now)
+	if w.limit <= 0 || len(w.events) >= w.limit {
+		return false
+	}
+	w.events = append(w.events, now)
+	return true
+}
+
+// Reserve books the earliest time an event may happen
+func (w *SlidingWindow) Reserve() *Reservation {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.limit <= 0 {
+		return &Reservation{}
+	}
+	now := time.Now()
+	w.prune(now)
+	at := now
+	if len(w.events) >= w.limit {
+		// The event leaving the window makes room
+		at = w.events[len(w.events)-w.limit].Add(w.window)
+	}
+	w.events = append(w.events, at)
+	return &Reservation{ok: true, at: at, cancel: func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i := len(w.events) - 1; i >= 0; i-- {
+			if w.events[i].Equal(at) {
+				w.events = append(w.events[:i], w.events[i+1:]...)
+				return
+			}
+		}
+	}}
+}
+
+// Wait blocks until an event may happen or ctx is done
+func (w *SlidingWindow) Wait(ctx context.Context) error {
+	return wait(ctx, w.Reserve())
+}
+
+// Limit returns the number of events admitted per window
+func (w *SlidingWindow) Limit() int {
+	return w.limit
+}
+
+// Remaining returns the number of events that may happen now
+func (w *SlidingWindow) Remaining() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(time.Now())
+	return max(w.limit-len(w.events), 0)
+}
+
+// prune forgets the events that left the window
+func (w *SlidingWindow) prune(now time.Time) {
+	start := now.Add(-w.window)
+	i := 0
+	for i < len(w.events) && !w.events[i].After(start) {
+		i++
+	}
+	if i > 0 {
+		w.events = append(w.events[:0], w.events[i:]...)
+	}
+}
@@ -0,0 +1,98 @@
+package xratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket admits bursts of up to Burst events, refilled at Rate events
+// per second
+type TokenBucket struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var _ Limiter = (*TokenBucket)(nil)
+
+// NewTokenBucket creates a full bucket of burst tokens refilled at rate per
+// second. A zero burst admits nothing.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// Every converts an interval between events to a rate, eg. Every(100*time.Millisecond) is 10
+func Every(interval time.Duration) float64 {
+	if interval <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(interval)
+}
+
+// Allow reports whether a token is available now, taking it when it is
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Reserve takes a token, possibly in advance of its refill
+func (b *TokenBucket) Reserve() *Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.refill(now)
+	if b.burst <= 0 || (b.tokens < 1 && b.rate <= 0) {
+		return &Reservation{}
+	}
+	b.tokens--
+	at := now
+	if b.tokens < 0 {
+		at = now.Add(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+	return &Reservation{ok: true, at: at, cancel: func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.refill(time.Now())
+		b.tokens = min(b.tokens+1, float64(b.burst))
+	}}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return wait(ctx, b.Reserve())
+}
+
+// Limit returns the burst
+func (b *TokenBucket) Limit() int {
+	return b.burst
+}
+
+// Remaining returns the number of whole tokens available
+func (b *TokenBucket) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 0 {
+		return 0
+	}
+	return int(b.tokens)
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, float64(b.burst))
+}
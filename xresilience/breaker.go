@@ -0,0 +1,368 @@
+// Package xresilience provides fault tolerance primitives usable around any
+// function call
+package xresilience
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets calls through, recording their outcome
+	StateClosed State = iota
+	// StateOpen rejects calls until the open duration elapses
+	StateOpen
+	// StateHalfOpen lets a few trial calls through to probe the recovery
+	StateHalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CodeCircuitOpen is the error code of the calls rejected by an open breaker
+const CodeCircuitOpen = "circuit_open"
+
+// ErrCircuitOpen is the cause of the errors returned for rejected calls
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerOptions configures a Breaker. Zero values use the defaults.
+type BreakerOptions struct {
+	// Name identifies the breaker in errors and callbacks
+	Name string
+	// WindowSize is the number of most recent calls the rates are computed on, 100 by default
+	WindowSize int
+	// MinimumCalls is the number of calls recorded before the breaker may open, 10 by default
+	MinimumCalls int
+	// FailureRateThreshold opens the breaker when reached, 0.5 by default
+	FailureRateThreshold float64
+	// SlowCallDuration makes calls lasting at least this long count as slow,
+	// zero disables slow call detection
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold opens the breaker when reached, 1 by default
+	SlowCallRateThreshold float64
+	// OpenDuration is how long the breaker stays open, 30s by default
+	OpenDuration time.Duration
+	// HalfOpenCalls is the number of trial calls that must succeed to close
+	// the breaker, 3 by default. A failed or slow trial call opens it again.
+	HalfOpenCalls int
+	// IsFailure reports whether the error of a call is a failure. By default
+	// every error but context cancellation and 4xx xerrors is.
+	IsFailure func(err error) bool
+	// OnStateChange is called after each transition, outside of the breaker lock
+	OnStateChange func(name string, from State, to State)
+}
+
+// BreakerMetrics is a snapshot of a breaker
+type BreakerMetrics struct {
+	State State
+	// Calls, Failures and SlowCalls are counted over the current window
+	Calls     int
+	Failures  int
+	SlowCalls int
+	// Rejected counts the calls rejected since the breaker was created
+	Rejected     uint64
+	FailureRate  float64
+	SlowCallRate float64
+}
+
+// Breaker is a circuit breaker: it opens when the failure rate or the slow
+// call rate over the recent calls reaches its threshold, rejects calls while
+// open, then lets trial calls through to decide whether to close again.
+type Breaker struct {
+	opts BreakerOptions
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	openedAt   time.Time
+	window     []outcome
+	next       int
+	recorded   int
+	failures   int
+	slowCalls  int
+	trials     int
+	successes  int
+	rejected   uint64
+}
+
+type outcome struct {
+	failure bool
+	slow    bool
+}
+
+// NewBreaker creates a closed breaker
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.WindowSize <= 0 {
+		opts.WindowSize = 100
+	}
+	if opts.MinimumCalls <= 0 {
+		opts.MinimumCalls = 10
+	}
+	if opts.MinimumCalls > opts.WindowSize {
+		opts.MinimumCalls = opts.WindowSize
+	}
+	if opts.FailureRateThreshold <= 0 {
+		opts.FailureRateThreshold = 0.5
+	}
+	if opts.SlowCallRateThreshold <= 0 {
+		opts.SlowCallRateThreshold = 1
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+	if opts.HalfOpenCalls <= 0 {
+		opts.HalfOpenCalls = 3
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = DefaultIsFailure
+	}
+	return &Breaker{opts: opts, window: make([]outcome, opts.WindowSize)}
+}
+
+// DefaultIsFailure counts every error as a failure but context cancellation
+// and client errors, which do not tell anything about the health of the
+// callee. Calls cancelled are not recorded at all, whatever IsFailure.
+func DefaultIsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var restErr xerrors.RestErr
+	if errors.As(err, &restErr) && xerrors.IsClientError(restErr) {
+		return false
+	}
+	return true
+}
+
+// Execute calls fn unless the breaker is open, in which case it returns a
+// 503 with the code CodeCircuitOpen and the cause ErrCircuitOpen. A panic of
+// fn is a failure.
+func (b *Breaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	generation, start, err := b.allow()
+	if err != nil {
+		return err
+	}
+	finished := false
+	defer func() {
+		if !finished {
+			b.record(generation, true, time.Since(start))
+		}
+	}()
+	err = fn(ctx)
+	finished = true
+	b.finish(generation, start, err)
+	return err
+}
+
+// Do calls fn through b, see Breaker.Execute
+func Do[T any](ctx context.Context, b *Breaker, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := b.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+// Allow reports whether a call may proceed, for calls that cannot be wrapped
+// in a function. done must be called with the outcome of the call.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	generation, start, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.finish(generation, start, err) })
+	}, nil
+}
+
+// allow takes a call, returning the generation and start time to record it
+// with
+func (b *Breaker) allow() (uint64, time.Time, error) {
+	b.mu.Lock()
+	now := time.Now()
+	transition := b.refresh(now)
+	switch {
+	case b.state == StateOpen, b.state == StateHalfOpen && b.trials >= b.opts.HalfOpenCalls:
+		b.rejected++
+		b.mu.Unlock()
+		b.notify(transition)
+		return 0, now, b.openErr()
+	case b.state == StateHalfOpen:
+		b.trials++
+	}
+	generation := b.generation
+	b.mu.Unlock()
+	b.notify(transition)
+	return generation, now, nil
+}
+
+// finish records the outcome of a call, cancelled calls only freeing their
+// half-open trial
+func (b *Breaker) finish(generation uint64, start time.Time, err error) {
+	if errors.Is(err, context.Canceled) {
+		b.mu.Lock()
+		if generation == b.generation && b.state == StateHalfOpen {
+			b.trials--
+		}
+		b.mu.Unlock()
+		return
+	}
+	b.record(generation, b.opts.IsFailure(err), time.Since(start))
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	transition := b.refresh(time.Now())
+	state := b.state
+	b.mu.Unlock()
+	b.notify(transition)
+	return state
+}
+
+// Metrics returns a snapshot of the breaker
+func (b *Breaker) Metrics() BreakerMetrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := BreakerMetrics{
+		State:     b.state,
+		Calls:     b.recorded,
+		Failures:  b.failures,
+		SlowCalls: b.slowCalls,
+		Rejected:  b.rejected,
+	}
+	if b.recorded > 0 {
+		m.FailureRate = float64(b.failures) / float64(b.recorded)
+		m.SlowCallRate = float64(b.slowCalls) / float64(b.recorded)
+	}
+	return m
+}
+
+// Reset closes the breaker and clears its window
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	transition := b.setState(StateClosed, time.Now())
+	b.mu.Unlock()
+	b.notify(transition)
+}
+
+type transition struct {
+	from, to State
+}
+
+func (b *Breaker) record(generation uint64, failure bool, elapsed time.Duration) {
+	slow := b.opts.SlowCallDuration > 0 && elapsed >= b.opts.SlowCallDuration
+	b.mu.Lock()
+	var changed *transition
+	if generation == b.generation {
+		switch b.state {
+		case StateClosed:
+			changed = b.recordClosed(outcome{failure: failure, slow: slow})
+		case StateHalfOpen:
+			if failure || slow {
+				changed = b.setState(StateOpen, time.Now())
+			} else if b.successes++; b.successes >= b.opts.HalfOpenCalls {
+				changed = b.setState(StateClosed, time.Now())
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.notify(changed)
+}
+
+func (b *Breaker) recordClosed(o outcome) *transition {
+	if b.recorded == len(b.window) {
+		evicted := b.window[b.next]
+		if evicted.failure {
+			b.failures--
+		}
+		if evicted.slow {
+			b.slowCalls--
+		}
+	} else {
+		b.recorded++
+	}
+	b.window[b.next] = o
+	b.next = (b.next + 1) % len(b.window)
+	if o.failure {
+		b.failures++
+	}
+	if o.slow {
+		b.slowCalls++
+	}
+
+	if b.recorded < b.opts.MinimumCalls {
+		return nil
+	}
+	calls := float64(b.recorded)
+	if float64(b.failures)/calls >= b.opts.FailureRateThreshold ||
+		(b.opts.SlowCallDuration > 0 && float64(b.slowCalls)/calls >= b.opts.SlowCallRateThreshold) {
+		return b.setState(StateOpen, time.Now())
+	}
+	return nil
+}
+
+// refresh moves an open breaker to half-open once the open duration elapsed
+func (b *Breaker) refresh(now time.Time) *transition {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.opts.OpenDuration {
+		return b.setState(StateHalfOpen, now)
+	}
+	return nil
+}
+
+func (b *Breaker) setState(state State, now time.Time) *transition {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.trials, b.successes = 0, 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.recorded, b.next, b.failures, b.slowCalls = 0, 0, 0, 0
+	}
+	if from == state {
+		return nil
+	}
+	return &transition{from: from, to: state}
+}
+
+func (b *Breaker) notify(t *transition) {
+	if t != nil && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.opts.Name, t.from, t.to)
+	}
+}
+
+func (b *Breaker) openErr() xerrors.RestErr {
+	message := "circuit breaker is open"
+	if b.opts.Name != "" {
+		message = "circuit breaker " + b.opts.Name + " is open"
+	}
+	return xerrors.New().
+		Status(http.StatusServiceUnavailable).
+		Code(CodeCircuitOpen).
+		Message(message).
+		Cause(ErrCircuitOpen).
+		Retryable(true).
+		Build()
+}
@@ -0,0 +1,172 @@
+package xresilience
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// CodeBulkheadFull is the error code of the calls rejected by a full bulkhead
+const CodeBulkheadFull = "bulkhead_full"
+
+// ErrBulkheadFull is the cause of the errors returned for rejected calls
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// BulkheadOptions configures a Bulkhead
+type BulkheadOptions struct {
+	// Name identifies the bulkhead in errors
+	Name string
+	// MaxConcurrent is the total weight of the calls running at once, 1 at least
+	MaxConcurrent int64
+	// MaxQueue is the number of calls waiting for capacity, others being
+	// rejected at once. Zero means no call waits.
+	MaxQueue int
+	// QueueTimeout rejects the calls still waiting after this long, zero
+	// meaning they wait as long as their context allows
+	QueueTimeout time.Duration
+}
+
+// BulkheadMetrics is a snapshot of a bulkhead
+type BulkheadMetrics struct {
+	// InFlight is the weight of the running calls
+	InFlight int64
+	// Queued is the number of waiting calls
+	Queued int
+	// Accepted, Rejected and TimedOut count the calls since the bulkhead was created
+	Accepted uint64
+	Rejected uint64
+	TimedOut uint64
+}
+
+// Bulkhead limits the concurrency of the calls to a dependency, so a slow
+// dependency cannot take every goroutine of a service. Calls have a weight,
+// eg. to count a batch as several calls.
+type Bulkhead struct {
+	opts BulkheadOptions
+	sem  *semaphore.Weighted
+
+	mu       sync.Mutex
+	queued   int
+	inFlight int64
+
+	accepted, rejected, timedOut atomic.Uint64
+}
+
+// NewBulkhead creates a bulkhead
+func NewBulkhead(opts BulkheadOptions) *Bulkhead {
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 1
+	}
+	return &Bulkhead{opts: opts, sem: semaphore.NewWeighted(opts.MaxConcurrent)}
+}
+
+// Execute calls fn with a weight of 1, see ExecuteWeighted
+func (b *Bulkhead) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	return b.ExecuteWeighted(ctx, 1, fn)
+}
+
+// ExecuteWeighted calls fn once weight is available, or returns a 503 with
+// the code CodeBulkheadFull and the cause ErrBulkheadFull when the queue is
+// full or the queue timeout elapses
+func (b *Bulkhead) ExecuteWeighted(ctx context.Context, weight int64, fn func(ctx context.Context) error) error {
+	release, err := b.Acquire(ctx, weight)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+// Acquire waits for weight to be available, for calls that cannot be
+// wrapped in a function. release must be called once the call is done.
+func (b *Bulkhead) Acquire(ctx context.Context, weight int64) (release func(), err error) {
+	if weight > b.opts.MaxConcurrent {
+		b.rejected.Add(1)
+		return nil, b.fullErr()
+	}
+	if !b.sem.TryAcquire(weight) {
+		if err := b.wait(ctx, weight); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	b.inFlight += weight
+	b.mu.Unlock()
+	b.accepted.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.inFlight -= weight
+			b.mu.Unlock()
+			b.sem.Release(weight)
+		})
+	}, nil
+}
+
+// wait queues the call, when the queue has room
+func (b *Bulkhead) wait(ctx context.Context, weight int64) error {
+	b.mu.Lock()
+	if b.queued >= b.opts.MaxQueue {
+		b.mu.Unlock()
+		b.rejected.Add(1)
+		return b.fullErr()
+	}
+	b.queued++
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.queued--
+		b.mu.Unlock()
+	}()
+
+	waitCtx := ctx
+	if b.opts.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, b.opts.QueueTimeout)
+		defer cancel()
+	}
+	if err := b.sem.Acquire(waitCtx, weight); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		b.timedOut.Add(1)
+		return b.fullErr()
+	}
+	return nil
+}
+
+// Metrics returns a snapshot of the bulkhead
+func (b *Bulkhead) Metrics() BulkheadMetrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BulkheadMetrics{
+		InFlight: b.inFlight,
+		Queued:   b.queued,
+		Accepted: b.accepted.Load(),
+		Rejected: b.rejected.Load(),
+		TimedOut: b.timedOut.Load(),
+	}
+}
+
+func (b *Bulkhead) fullErr() xerrors.RestErr {
+	message := "bulkhead is full"
+	if b.opts.Name != "" {
+		message = "bulkhead " + b.opts.Name + " is full"
+	}
+	return xerrors.New().
+		Status(http.StatusServiceUnavailable).
+		Code(CodeBulkheadFull).
+		Message(message).
+		Cause(ErrBulkheadFull).
+		Retryable(true).
+		Build()
+}
@@ -0,0 +1,159 @@
+package xrest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Strategy selects the endpoint a Balancer sends the next request to
+type Strategy int
+
+const (
+	// RoundRobin rotates through the healthy endpoints
+	RoundRobin Strategy = iota
+	// Weighted spreads requests over the healthy endpoints in proportion to their weight
+	Weighted
+	// FailOver uses the first healthy endpoint in the configured order
+	FailOver
+)
+
+// ErrNoEndpoints is returned when a Balancer has no endpoints configured
+var ErrNoEndpoints = errors.New("no endpoints configured")
+
+// Endpoint is a base URL served by a Balancer
+type Endpoint struct {
+	URL string
+	// Weight is only used by the Weighted strategy, values below 1 count as 1
+	Weight int
+}
+
+// Balancer spreads requests over several base URLs and ejects endpoints that
+// keep failing. Transport errors and 5xx responses count as failures.
+type Balancer struct {
+	// EjectAfter is the number of consecutive failures that ejects an endpoint
+	EjectAfter int
+	// EjectFor is how long an ejected endpoint stays out of rotation
+	EjectFor time.Duration
+
+	strategy  Strategy
+	mu        sync.Mutex
+	endpoints []*endpointState
+	next      int
+}
+
+type endpointState struct {
+	Endpoint
+	failures      int
+	ejectedUntil  time.Time
+	currentWeight int
+}
+
+// NewBalancer creates a balancer over the given endpoints. Endpoints are
+// ejected for 30 seconds after 3 consecutive failures unless configured otherwise.
+func NewBalancer(strategy Strategy, endpoints ...Endpoint) *Balancer {
+	b := &Balancer{
+		EjectAfter: 3,
+		EjectFor:   30 * time.Second,
+		strategy:   strategy,
+	}
+	for _, e := range endpoints {
+		if e.Weight < 1 {
+			e.Weight = 1
+		}
+		b.endpoints = append(b.endpoints, &endpointState{Endpoint: e})
+	}
+	return b
+}
+
+// NewBalancedClient creates a client that spreads its requests over the given base URLs
+func NewBalancedClient(strategy Strategy, baseURLs ...string) *Client {
+	endpoints := make([]Endpoint, len(baseURLs))
+	for i, u := range baseURLs {
+		endpoints[i] = Endpoint{URL: u, Weight: 1}
+	}
+	c := NewClient("")
+	c.Balancer = NewBalancer(strategy, endpoints...)
+	return c
+}
+
+// Healthy returns the URLs of the endpoints currently in rotation
+func (b *Balancer) Healthy() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var urls []string
+	for _, e := range b.endpoints {
+		if !e.ejected(now) {
+			urls = append(urls, e.URL)
+		}
+	}
+	return urls
+}
+
+func (b *Balancer) pick() (*endpointState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	now := time.Now()
+	candidates := make([]*endpointState, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if !e.ejected(now) {
+			candidates = append(candidates, e)
+		}
+	}
+	// With every endpoint ejected keep sending traffic to all of them rather than failing outright
+	if len(candidates) == 0 {
+		candidates = b.endpoints
+	}
+
+	switch b.strategy {
+	case FailOver:
+		return candidates[0], nil
+	case Weighted:
+		// Smooth weighted round robin
+		total := 0
+		var best *endpointState
+		for _, e := range candidates {
+			e.currentWeight += e.Weight
+			total += e.Weight
+			if best == nil || e.currentWeight > best.currentWeight {
+				best = e
+			}
+		}
+		best.currentWeight -= total
+		return best, nil
+	default:
+		e := candidates[b.next%len(candidates)]
+		b.next++
+		return e, nil
+	}
+}
+
+func (b *Balancer) report(e *endpointState, resp *http.Response, err error) {
+	failed := (err != nil && !errors.Is(err, context.Canceled)) || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if b.EjectAfter > 0 && e.failures >= b.EjectAfter {
+		e.ejectedUntil = time.Now().Add(b.EjectFor)
+		e.failures = 0
+	}
+}
+
+func (e *endpointState) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
@@ -0,0 +1,253 @@
+package xrest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Client executes requests against an upstream with shared base URL, headers and retry settings
+type Client struct {
+	// BaseURL is prepended to every request path
+	BaseURL string
+	// Balancer picks the base URL per attempt when set, taking precedence over BaseURL
+	Balancer *Balancer
+	// Headers are sent with every request unless overridden per call
+	Headers http.Header
+	// HTTPClient is the underlying client, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Retry enables retries when set
+	Retry *RetryPolicy
+	// Codec encodes request bodies and is advertised in the Accept header.
+	// When nil, proto.Message bodies use ProtobufCodec and anything else JSON.
+	Codec Codec
+	// Spec makes the client validate every request against an OpenAPI
+	// document and fail with a SpecViolationError instead of sending it
+	Spec *OpenAPISpec
+	// MaxResponseBytes limits the size of response bodies, reads past it fail
+	// with ErrResponseTooLarge. Zero means unlimited.
+	MaxResponseBytes int64
+	// ReadIdleTimeout aborts a response whose body sends no data for this long,
+	// reads then fail with ErrReadIdleTimeout. Zero disables it.
+	ReadIdleTimeout time.Duration
+	// OnFailure is called with a replayable copy of every request that ends in
+	// an error, a 5xx or another response the retry policy retries, eg. a
+	// 429, so it can be queued and executed again later
+	OnFailure func(request *ReplayableRequest, err error)
+}
+
+// NewClient creates a client for the given base URL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		Headers:    make(http.Header),
+		HTTPClient: &http.Client{},
+	}
+}
+
+// Do executes a request to the given path with the body. Strings and byte
+// slices are sent as they are, other values are encoded with the client codec
+// and a nil body sends no payload.
+func (c *Client) Do(ctx context.Context, method string, path string, body interface{}, headers http.Header) (*http.Response, error) {
+	payload, contentType, err := c.encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers = cloneHeader(headers)
+	if contentType != "" && headers.Get("Content-Type") == "" && c.Headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", contentType)
+	}
+	if c.Codec != nil && headers.Get("Accept") == "" && c.Headers.Get("Accept") == "" {
+		accept := c.Codec.ContentType()
+		if accept != MediaTypeJSON {
+			// Let upstreams that cannot produce the preferred type fall back to JSON
+			accept += ", " + MediaTypeJSON + ";q=0.5"
+		}
+		headers.Set("Accept", accept)
+	}
+
+	if c.Spec != nil {
+		merged := cloneHeader(c.Headers)
+		for name, values := range headers {
+			merged[name] = values
+		}
+		if err := c.Spec.ValidateRequest(method, path, merged, payload); err != nil {
+			return nil, err
+		}
+	}
+	return c.execute(ctx, method, path, payload, headers)
+}
+
+// Get issues a GET to the given path
+func (c *Client) Get(ctx context.Context, path string, headers http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodGet, path, nil, headers)
+}
+
+// Post issues a POST to the given path with the body
+func (c *Client) Post(ctx context.Context, path string, body interface{}, headers http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPost, path, body, headers)
+}
+
+func (c *Client) execute(ctx context.Context, method string, path string, payload []byte, headers http.Header) (*http.Response, error) {
+	return c.run(ctx, method, payload, headers, true, func() (string, *endpointState, error) {
+		if c.Balancer == nil {
+			return joinURL(c.BaseURL, path), nil, nil
+		}
+		endpoint, err := c.Balancer.pick()
+		if err != nil {
+			return "", nil, err
+		}
+		return joinURL(endpoint.URL, path), endpoint, nil
+	})
+}
+
+// run sends a request to the URL returned by target, once per attempt
+func (c *Client) run(ctx context.Context, method string, payload []byte, headers http.Header, notify bool, target func() (string, *endpointState, error)) (*http.Response, error) {
+	var last *http.Request
+	send := func(ctx context.Context) (*http.Response, error) {
+		url, endpoint, err := target()
+		if err != nil {
+			return nil, err
+		}
+
+		var cancel context.CancelFunc
+		if c.ReadIdleTimeout > 0 {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+
+		request, err := c.newRequest(ctx, method, url, payload, headers)
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+		last = request
+		resp, err := c.send(request)
+		if endpoint != nil {
+			c.Balancer.report(endpoint, resp, err)
+		}
+		if err != nil || resp == nil || resp.Body == nil {
+			if cancel != nil {
+				cancel()
+			}
+			return resp, err
+		}
+		return c.guardResponse(resp, cancel)
+	}
+
+	var resp *http.Response
+	var err error
+	if c.Retry == nil {
+		resp, err = send(ctx)
+	} else {
+		resp, err = c.Retry.do(ctx, send)
+	}
+
+	if notify && c.OnFailure != nil && last != nil {
+		failure := err
+		if failure == nil && resp != nil && c.failed(resp) {
+			failure = xerrors.NewRestError(resp.StatusCode, fmt.Sprintf("upstream responded with status %d", resp.StatusCode))
+		}
+		if failure != nil {
+			c.OnFailure(&ReplayableRequest{
+				Method:     method,
+				URL:        last.URL.String(),
+				Header:     last.Header.Clone(),
+				Body:       payload,
+				CapturedAt: time.Now(),
+			}, failure)
+		}
+	}
+	return resp, err
+}
+
+// failed reports whether a final response is a failure worth replaying: a
+// 5xx or a response the retry policy retries
+func (c *Client) failed(resp *http.Response) bool {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	shouldRetry := DefaultShouldRetry
+	if c.Retry != nil && c.Retry.ShouldRetry != nil {
+		shouldRetry = c.Retry.ShouldRetry
+	}
+	return shouldRetry(resp, nil)
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, url string, payload []byte, headers http.Header) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range c.Headers {
+		request.Header[name] = append([]string(nil), values...)
+	}
+	for name, values := range headers {
+		request.Header[name] = append([]string(nil), values...)
+	}
+	applyPropagatedHeaders(ctx, request.Header)
+	return request, nil
+}
+
+func (c *Client) send(request *http.Request) (*http.Response, error) {
+	if enabledMocks {
+		return getMock(request.Method, request.URL.String())
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(request)
+}
+
+// encodeBody converts a request body into its payload and content type
+func (c *Client) encodeBody(body interface{}) ([]byte, string, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, "", nil
+	case []byte:
+		return b, "", nil
+	case string:
+		return []byte(b), "", nil
+	}
+
+	codec := c.Codec
+	if codec == nil {
+		codec = JSONCodec
+		if _, ok := body.(proto.Message); ok {
+			codec = ProtobufCodec
+		}
+	}
+	payload, err := codec.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, codec.ContentType(), nil
+}
+
+func joinURL(base string, path string) string {
+	if base == "" {
+		return path
+	}
+	if path == "" {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
@@ -0,0 +1,127 @@
+package xrest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Media types handled by the built-in codecs
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes request bodies and decodes response bodies of a media type
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec encodes proto.Message values in the protobuf wire format
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		MediaTypeJSON:          JSONCodec,
+		MediaTypeJSONAPI:       JSONCodec,
+		MediaTypeHAL:           JSONCodec,
+		MediaTypeProtobuf:      ProtobufCodec,
+		"application/protobuf": ProtobufCodec,
+	}
+)
+
+// RegisterCodec makes a codec available to DecodeResponse for the given media types,
+// its own content type when none are given
+func RegisterCodec(codec Codec, mediaTypes ...string) {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{codec.ContentType()}
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, mediaType := range mediaTypes {
+		codecs[mediaType] = codec
+	}
+}
+
+// CodecFor returns the codec registered for a Content-Type header value
+func CodecFor(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[mediaType]
+	return codec, ok
+}
+
+// DecodeResponse decodes the body of resp into v with the codec matching its
+// Content-Type, JSON when the header is missing, and closes the body
+func DecodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	codec := JSONCodec
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		var ok bool
+		if codec, ok = CodecFor(contentType); !ok {
+			return fmt.Errorf("no codec registered for content type %q", contentType)
+		}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return MediaTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return MediaTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot marshal %T, it is not a proto.Message", v)
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return errors.New("protobuf codec can only unmarshal into a proto.Message")
+	}
+	return proto.Unmarshal(data, message)
+}
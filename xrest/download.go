@@ -0,0 +1,272 @@
+package xrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrResourceChanged is returned when the remote resource changes while it is being downloaded
+	ErrResourceChanged = errors.New("resource changed during download")
+	// ErrChecksumMismatch is returned when the downloaded content does not match the expected checksum
+	ErrChecksumMismatch = errors.New("downloaded content does not match the expected checksum")
+)
+
+// DownloadOptions configures Client.Download
+type DownloadOptions struct {
+	// ChunkSize is the size of the ranges fetched in parallel, 8MB by default
+	ChunkSize int64
+	// Concurrency is the number of ranges fetched at the same time, 4 by default
+	Concurrency int
+	// MaxResumes is how often a single range is resumed after its body was cut off, 3 by default
+	MaxResumes int
+	// Hash enables verification of the reassembled content, sha256 by
+	// default when Checksum is set. When Checksum is empty the digest is only
+	// reported in the result.
+	Hash     func() hash.Hash
+	Checksum []byte
+	// Headers are sent with every range request
+	Headers http.Header
+}
+
+// DownloadResult describes a completed download
+type DownloadResult struct {
+	Size     int64
+	ETag     string
+	Chunks   int
+	Resumes  int
+	Checksum []byte
+}
+
+type downloadRange struct {
+	start, end int64
+}
+
+// Download fetches the resource at path into dst. When the server supports
+// range requests the resource is fetched in parallel chunks and every chunk is
+// resumed where it stopped after a transient failure; If-Range makes sure the
+// pieces all belong to the same version of the resource. Verification with a
+// Hash requires dst to implement io.ReaderAt as well, like *os.File does.
+func (c *Client) Download(ctx context.Context, path string, dst io.WriterAt, opts DownloadOptions) (*DownloadResult, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 8 << 20
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.MaxResumes < 0 {
+		opts.MaxResumes = 0
+	} else if opts.MaxResumes == 0 {
+		opts.MaxResumes = 3
+	}
+	if opts.Hash == nil && len(opts.Checksum) > 0 {
+		opts.Hash = sha256.New
+	}
+
+	probeHeaders := cloneHeader(opts.Headers)
+	probeHeaders.Set("Range", "bytes=0-0")
+	resp, err := c.Get(ctx, path, probeHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DownloadResult{ETag: resp.Header.Get("ETag")}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// No range support, the whole resource is in this response
+		written, err := io.Copy(&offsetWriter{w: dst}, resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		result.Size = written
+		result.Chunks = 1
+		return result, verifyDownload(dst, result, opts)
+	case http.StatusPartialContent:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty resource has no byte 0
+		_ = resp.Body.Close()
+		if resp.Header.Get("Content-Range") != "bytes */0" {
+			return nil, fmt.Errorf("unexpected status %d probing %s", resp.StatusCode, path)
+		}
+		return result, verifyDownload(dst, result, opts)
+	default:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d probing %s", resp.StatusCode, path)
+	}
+
+	size, err := parseContentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	result.Size = size
+
+	// Weak validators are not allowed in If-Range
+	validator := result.ETag
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	var ranges []downloadRange
+	for start := int64(0); start < size; start += opts.ChunkSize {
+		end := start + opts.ChunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, downloadRange{start: start, end: end})
+	}
+	result.Chunks = len(ranges)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	queue := make(chan downloadRange)
+	for i := 0; i < opts.Concurrency && i < len(ranges); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range queue {
+				resumes, err := c.downloadRange(ctx, path, dst, r, validator, opts)
+				mu.Lock()
+				result.Resumes += resumes
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, r := range ranges {
+		select {
+		case queue <- r:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return result, verifyDownload(dst, result, opts)
+}
+
+// downloadRange fetches a single range, resuming it after the body was cut off
+func (c *Client) downloadRange(ctx context.Context, path string, dst io.WriterAt, r downloadRange, validator string, opts DownloadOptions) (int, error) {
+	offset := r.start
+	resumes := 0
+	for {
+		headers := cloneHeader(opts.Headers)
+		headers.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, r.end))
+		if validator != "" {
+			headers.Set("If-Range", validator)
+		}
+
+		resp, err := c.Get(ctx, path, headers)
+		if err != nil {
+			return resumes, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			// The If-Range validator no longer matches
+			_ = resp.Body.Close()
+			return resumes, ErrResourceChanged
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			_ = resp.Body.Close()
+			return resumes, fmt.Errorf("unexpected status %d downloading bytes %d-%d of %s", resp.StatusCode, offset, r.end, path)
+		}
+
+		remaining := r.end - offset + 1
+		written, err := io.Copy(&offsetWriter{w: dst, offset: offset}, io.LimitReader(resp.Body, remaining))
+		_ = resp.Body.Close()
+		offset += written
+		if offset > r.end {
+			return resumes, nil
+		}
+		if ctx.Err() != nil {
+			return resumes, ctx.Err()
+		}
+		if resumes >= opts.MaxResumes {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return resumes, fmt.Errorf("download of bytes %d-%d of %s stopped at %d: %w", r.start, r.end, path, offset, err)
+		}
+		resumes++
+	}
+}
+
+func verifyDownload(dst io.WriterAt, result *DownloadResult, opts DownloadOptions) error {
+	if opts.Hash == nil {
+		return nil
+	}
+	reader, ok := dst.(io.ReaderAt)
+	if !ok {
+		return errors.New("checksum verification requires a destination implementing io.ReaderAt")
+	}
+
+	h := opts.Hash()
+	if _, err := io.Copy(h, io.NewSectionReader(reader, 0, result.Size)); err != nil {
+		return err
+	}
+	result.Checksum = h.Sum(nil)
+	if len(opts.Checksum) > 0 && !bytes.Equal(result.Checksum, opts.Checksum) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// parseContentRangeSize returns the complete length from a "bytes 0-0/1234" header
+func parseContentRangeSize(contentRange string) (int64, error) {
+	slash := strings.LastIndexByte(contentRange, '/')
+	if slash < 0 || !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown resource size in Content-Range %q", contentRange)
+	}
+	return size, nil
+}
+
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return make(http.Header)
+	}
+	return h.Clone()
+}
+
+// offsetWriter turns an io.WriterAt into a sequential writer starting at offset
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,238 @@
+package xrest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// MediaTypeHAL is the media type of HAL documents
+const MediaTypeHAL = "application/hal+json"
+
+// HALLink is a link object of a HAL document
+type HALLink struct {
+	Href        string `json:"href"`
+	Templated   bool   `json:"templated,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Deprecation string `json:"deprecation,omitempty"`
+}
+
+// HALResource is a HAL resource split into its links, embedded resources and
+// remaining state properties
+type HALResource struct {
+	Links    map[string][]HALLink
+	Embedded map[string][]*HALResource
+	State    map[string]json.RawMessage
+}
+
+// ParseHAL parses a HAL document
+func ParseHAL(data []byte) (*HALResource, error) {
+	var r HALResource
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// UnmarshalJSON accepts both single objects and arrays for links and embedded resources
+func (r *HALResource) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	r.Links = make(map[string][]HALLink)
+	if raw, ok := members["_links"]; ok {
+		var rels map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &rels); err != nil {
+			return err
+		}
+		for rel, value := range rels {
+			var links []HALLink
+			if err := unmarshalOneOrMany(value, &links); err != nil {
+				return err
+			}
+			r.Links[rel] = links
+		}
+		delete(members, "_links")
+	}
+
+	r.Embedded = make(map[string][]*HALResource)
+	if raw, ok := members["_embedded"]; ok {
+		var rels map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &rels); err != nil {
+			return err
+		}
+		for rel, value := range rels {
+			var resources []*HALResource
+			if err := unmarshalOneOrMany(value, &resources); err != nil {
+				return err
+			}
+			r.Embedded[rel] = resources
+		}
+		delete(members, "_embedded")
+	}
+
+	r.State = members
+	return nil
+}
+
+// MarshalJSON serializes the resource back into a HAL document
+func (r HALResource) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(r.State)+2)
+	for k, v := range r.State {
+		members[k] = v
+	}
+	if len(r.Links) > 0 {
+		links := make(map[string]interface{}, len(r.Links))
+		for rel, list := range r.Links {
+			if len(list) == 1 {
+				links[rel] = list[0]
+			} else {
+				links[rel] = list
+			}
+		}
+		members["_links"] = links
+	}
+	if len(r.Embedded) > 0 {
+		embedded := make(map[string]interface{}, len(r.Embedded))
+		for rel, list := range r.Embedded {
+			embedded[rel] = list
+		}
+		members["_embedded"] = embedded
+	}
+	return json.Marshal(members)
+}
+
+// Link returns the first link of the given relation
+func (r *HALResource) Link(rel string) (HALLink, bool) {
+	links := r.Links[rel]
+	if len(links) == 0 {
+		return HALLink{}, false
+	}
+	return links[0], true
+}
+
+// Href returns the href of the first link of the given relation, or an empty string
+func (r *HALResource) Href(rel string) string {
+	link, _ := r.Link(rel)
+	return link.Href
+}
+
+// Find walks the embedded resources following the given relations and returns
+// every resource found at the end of the path. Eg. Find("orders", "items")
+func (r *HALResource) Find(rels ...string) []*HALResource {
+	current := []*HALResource{r}
+	for _, rel := range rels {
+		var next []*HALResource
+		for _, resource := range current {
+			next = append(next, resource.Embedded[rel]...)
+		}
+		current = next
+	}
+	return current
+}
+
+// Decode decodes the state properties of the resource into v
+func (r *HALResource) Decode(v interface{}) error {
+	state, err := json.Marshal(r.State)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(state, v)
+}
+
+// Expand fills the variables of a templated link. Simple {var} expressions are
+// replaced in place and {?var,...} expressions become a query string.
+func (l HALLink) Expand(params map[string]string) string {
+	if !l.Templated {
+		return l.Href
+	}
+
+	var out strings.Builder
+	href := l.Href
+	for {
+		start := strings.IndexByte(href, '{')
+		if start < 0 {
+			out.WriteString(href)
+			break
+		}
+		end := strings.IndexByte(href[start:], '}')
+		if end < 0 {
+			out.WriteString(href)
+			break
+		}
+		out.WriteString(href[:start])
+		expr := href[start+1 : start+end]
+		href = href[start+end+1:]
+
+		switch {
+		case strings.HasPrefix(expr, "?"), strings.HasPrefix(expr, "&"):
+			query := url.Values{}
+			for _, name := range strings.Split(expr[1:], ",") {
+				if value, ok := params[name]; ok {
+					query.Set(name, value)
+				}
+			}
+			if len(query) > 0 {
+				out.WriteByte(expr[0])
+				out.WriteString(query.Encode())
+			}
+		default:
+			out.WriteString(url.PathEscape(params[expr]))
+		}
+	}
+	return out.String()
+}
+
+// DecodeHAL decodes the state properties of a HAL document into v
+func DecodeHAL(data []byte, v interface{}) error {
+	r, err := ParseHAL(data)
+	if err != nil {
+		return err
+	}
+	return r.Decode(v)
+}
+
+// EncodeHAL serializes v as a HAL document with the given links and embedded
+// values. Slices in embedded are encoded as arrays of resources.
+func EncodeHAL(v interface{}, links map[string]HALLink, embedded map[string]interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return nil, err
+	}
+	if members == nil {
+		members = make(map[string]json.RawMessage)
+	}
+
+	if len(links) > 0 {
+		if members["_links"], err = json.Marshal(links); err != nil {
+			return nil, err
+		}
+	}
+	if len(embedded) > 0 {
+		if members["_embedded"], err = json.Marshal(embedded); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(members)
+}
+
+func unmarshalOneOrMany(data json.RawMessage, v interface{}) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		wrapped := make([]byte, 0, len(data)+2)
+		wrapped = append(wrapped, '[')
+		wrapped = append(wrapped, data...)
+		wrapped = append(wrapped, ']')
+		data = wrapped
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,311 @@
+package xrest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MediaTypeJSONAPI is the media type of JSON:API documents
+const MediaTypeJSONAPI = "application/vnd.api+json"
+
+// JSONAPIDocument is the top level object of a JSON:API response
+type JSONAPIDocument struct {
+	Data     json.RawMessage        `json:"data,omitempty"`
+	Included []JSONAPIResource      `json:"included,omitempty"`
+	Errors   JSONAPIErrors          `json:"errors,omitempty"`
+	Links    map[string]JSONAPILink `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+// JSONAPIResource is a single resource object of a JSON:API document
+type JSONAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id,omitempty"`
+	Attributes    json.RawMessage                `json:"attributes,omitempty"`
+	Relationships map[string]JSONAPIRelationship `json:"relationships,omitempty"`
+	Links         map[string]JSONAPILink         `json:"links,omitempty"`
+	Meta          map[string]interface{}         `json:"meta,omitempty"`
+}
+
+// JSONAPIIdentifier identifies a resource by type and id
+type JSONAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// JSONAPIRelationship is a relationship object. Data holds null, a single
+// resource identifier or an array of them.
+type JSONAPIRelationship struct {
+	Data  json.RawMessage        `json:"data,omitempty"`
+	Links map[string]JSONAPILink `json:"links,omitempty"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+}
+
+// JSONAPILink is a link that may be serialized either as a plain URL or as a link object
+type JSONAPILink struct {
+	Href string                 `json:"href"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// JSONAPIError is a single error object of a JSON:API document
+type JSONAPIError struct {
+	ID     string                 `json:"id,omitempty"`
+	Status string                 `json:"status,omitempty"`
+	Code   string                 `json:"code,omitempty"`
+	Title  string                 `json:"title,omitempty"`
+	Detail string                 `json:"detail,omitempty"`
+	Source *JSONAPIErrorSource    `json:"source,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// JSONAPIErrorSource points to the part of the request that caused an error
+type JSONAPIErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// JSONAPIErrors is returned when decoding a document that carries errors instead of data
+type JSONAPIErrors []JSONAPIError
+
+func (e JSONAPIError) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Title
+	}
+	if e.Status != "" {
+		return fmt.Sprintf("%s: %s", e.Status, msg)
+	}
+	return msg
+}
+
+func (e JSONAPIErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// UnmarshalJSON accepts both the string and the object form of a link
+func (l *JSONAPILink) UnmarshalJSON(data []byte) error {
+	var href string
+	if err := json.Unmarshal(data, &href); err == nil {
+		l.Href = href
+		return nil
+	}
+	type link JSONAPILink
+	return json.Unmarshal(data, (*link)(l))
+}
+
+// MarshalJSON serializes links without meta as plain URLs
+func (l JSONAPILink) MarshalJSON() ([]byte, error) {
+	if len(l.Meta) == 0 {
+		return json.Marshal(l.Href)
+	}
+	type link JSONAPILink
+	return json.Marshal(link(l))
+}
+
+// ParseJSONAPI parses a JSON:API document
+func ParseJSONAPI(data []byte) (*JSONAPIDocument, error) {
+	var doc JSONAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Resources returns the primary data of the document, which may hold a single resource or many
+func (d *JSONAPIDocument) Resources() ([]JSONAPIResource, error) {
+	data := bytes.TrimSpace(d.Data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var resources []JSONAPIResource
+		if err := json.Unmarshal(data, &resources); err != nil {
+			return nil, err
+		}
+		return resources, nil
+	}
+	var resource JSONAPIResource
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, err
+	}
+	return []JSONAPIResource{resource}, nil
+}
+
+// Find looks up a resource by type and id in the primary data and the included resources
+func (d *JSONAPIDocument) Find(resourceType, id string) (JSONAPIResource, bool) {
+	resources, _ := d.Resources()
+	for _, list := range [][]JSONAPIResource{resources, d.Included} {
+		for _, r := range list {
+			if r.Type == resourceType && r.ID == id {
+				return r, true
+			}
+		}
+	}
+	return JSONAPIResource{}, false
+}
+
+// Related resolves a relationship of the given resource against the document
+// and returns every related resource that is present in it
+func (d *JSONAPIDocument) Related(r JSONAPIResource, name string) ([]JSONAPIResource, error) {
+	rel, ok := r.Relationships[name]
+	if !ok {
+		return nil, nil
+	}
+	ids, err := rel.Identifiers()
+	if err != nil {
+		return nil, err
+	}
+	var related []JSONAPIResource
+	for _, id := range ids {
+		if resource, found := d.Find(id.Type, id.ID); found {
+			related = append(related, resource)
+		}
+	}
+	return related, nil
+}
+
+// Identifiers returns the resource identifiers of the relationship
+func (r JSONAPIRelationship) Identifiers() ([]JSONAPIIdentifier, error) {
+	data := bytes.TrimSpace(r.Data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var ids []JSONAPIIdentifier
+		if err := json.Unmarshal(data, &ids); err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+	var id JSONAPIIdentifier
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+	return []JSONAPIIdentifier{id}, nil
+}
+
+// Unmarshal decodes the attributes of the resource into v. The resource id and
+// type are exposed as "id" and "type" members next to the attributes.
+func (r JSONAPIResource) Unmarshal(v interface{}) error {
+	flat, err := r.flatten()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(flat, v)
+}
+
+func (r JSONAPIResource) flatten() ([]byte, error) {
+	members := make(map[string]json.RawMessage)
+	if len(r.Attributes) > 0 && !bytes.Equal(bytes.TrimSpace(r.Attributes), []byte("null")) {
+		if err := json.Unmarshal(r.Attributes, &members); err != nil {
+			return nil, err
+		}
+	}
+	id, _ := json.Marshal(r.ID)
+	resourceType, _ := json.Marshal(r.Type)
+	members["id"] = id
+	members["type"] = resourceType
+	return json.Marshal(members)
+}
+
+// DecodeJSONAPI unwraps the primary data of a JSON:API document into v, which
+// can be a struct for single resources or a slice for collections. Documents
+// carrying errors are returned as JSONAPIErrors.
+func DecodeJSONAPI(data []byte, v interface{}) error {
+	doc, err := ParseJSONAPI(data)
+	if err != nil {
+		return err
+	}
+	if len(doc.Errors) > 0 {
+		return doc.Errors
+	}
+	resources, err := doc.Resources()
+	if err != nil {
+		return err
+	}
+
+	trimmed := bytes.TrimSpace(doc.Data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		flat := make([]json.RawMessage, len(resources))
+		for i, r := range resources {
+			if flat[i], err = r.flatten(); err != nil {
+				return err
+			}
+		}
+		list, err := json.Marshal(flat)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(list, v)
+	}
+	if len(resources) == 0 {
+		return errors.New("json:api document has no primary data")
+	}
+	return resources[0].Unmarshal(v)
+}
+
+// EncodeJSONAPI wraps v into a JSON:API document of the given resource type.
+// The "id" member of v becomes the resource id and every other member becomes
+// an attribute. Slices are encoded as collections.
+func EncodeJSONAPI(resourceType string, v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		resources := make([]JSONAPIResource, len(items))
+		for i, item := range items {
+			if resources[i], err = newJSONAPIResource(resourceType, item); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(map[string]interface{}{"data": resources})
+	}
+
+	resource, err := newJSONAPIResource(resourceType, raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"data": resource})
+}
+
+func newJSONAPIResource(resourceType string, raw json.RawMessage) (JSONAPIResource, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return JSONAPIResource{}, errors.New("json:api resources must encode to a JSON object")
+	}
+
+	resource := JSONAPIResource{Type: resourceType}
+	if id, ok := members["id"]; ok {
+		var s string
+		if err := json.Unmarshal(id, &s); err != nil {
+			// Numeric ids are sent as strings as required by the spec
+			s = string(bytes.TrimSpace(id))
+		}
+		resource.ID = s
+		delete(members, "id")
+	}
+	delete(members, "type")
+
+	if len(members) > 0 {
+		attributes, err := json.Marshal(members)
+		if err != nil {
+			return JSONAPIResource{}, err
+		}
+		resource.Attributes = attributes
+	}
+	return resource, nil
+}
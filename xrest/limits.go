@@ -0,0 +1,97 @@
+package xrest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrResponseTooLarge is returned when a response body exceeds Client.MaxResponseBytes
+	ErrResponseTooLarge = errors.New("response body exceeds the maximum size")
+	// ErrReadIdleTimeout is returned when the upstream sends no data for Client.ReadIdleTimeout
+	ErrReadIdleTimeout = errors.New("response body read timed out")
+)
+
+// guardedBody enforces the size limit and the idle timeout of a response body
+type guardedBody struct {
+	body      io.ReadCloser
+	limited   bool
+	remaining int64
+	idle      time.Duration
+	timer     *time.Timer
+	timedOut  int32
+	cancel    context.CancelFunc
+}
+
+// guardResponse applies the client limits to a response. Responses announcing
+// a length above the limit are rejected before their body is read.
+func (c *Client) guardResponse(resp *http.Response, cancel context.CancelFunc) (*http.Response, error) {
+	if c.MaxResponseBytes > 0 && resp.ContentLength > c.MaxResponseBytes {
+		_ = resp.Body.Close()
+		if cancel != nil {
+			cancel()
+		}
+		return nil, ErrResponseTooLarge
+	}
+
+	g := &guardedBody{
+		body:      resp.Body,
+		limited:   c.MaxResponseBytes > 0,
+		remaining: c.MaxResponseBytes,
+		idle:      c.ReadIdleTimeout,
+		cancel:    cancel,
+	}
+	if g.idle > 0 && cancel != nil {
+		g.timer = time.AfterFunc(g.idle, func() {
+			atomic.StoreInt32(&g.timedOut, 1)
+			cancel()
+		})
+		// Only time reads, a slow consumer is not the upstream's fault
+		g.timer.Stop()
+	}
+	resp.Body = g
+	return resp, nil
+}
+
+func (g *guardedBody) Read(p []byte) (int, error) {
+	if g.limited && int64(len(p)) > g.remaining+1 {
+		// Read one byte past the limit to tell an exact fit from an overflow
+		p = p[:g.remaining+1]
+	}
+
+	if g.timer != nil {
+		g.timer.Reset(g.idle)
+	}
+	n, err := g.body.Read(p)
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+
+	if atomic.LoadInt32(&g.timedOut) == 1 {
+		return n, ErrReadIdleTimeout
+	}
+	if g.limited {
+		if int64(n) > g.remaining {
+			n = int(g.remaining)
+			g.remaining = 0
+			return n, ErrResponseTooLarge
+		}
+		g.remaining -= int64(n)
+	}
+	return n, err
+}
+
+func (g *guardedBody) Close() error {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	err := g.body.Close()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return err
+}
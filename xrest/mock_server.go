@@ -0,0 +1,234 @@
+package xrest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// MockRoute describes the canned response a MockServer sends for matching requests
+type MockRoute struct {
+	// Method matches any method when empty
+	Method string
+	// Path segments written as {name} capture path parameters and a trailing
+	// "*" matches any suffix. Eg. /users/{id}/orders/*
+	Path string
+	// Match optionally narrows the route down, eg. on headers or body content
+	Match func(r *http.Request) bool
+
+	// Status defaults to 200
+	Status  int
+	Headers http.Header
+	// Body is a text/template executed with the MockRequest being served
+	Body string
+
+	// Latency delays every response of the route
+	Latency time.Duration
+	// FailureRate is the fraction of requests, between 0 and 1, answered with FailureStatus
+	FailureRate float64
+	// FailureStatus defaults to 500
+	FailureStatus int
+}
+
+// MockRequest is a request received by a MockServer. It is also the data the
+// body templates are executed with.
+type MockRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+	// JSON holds the decoded body when it is valid JSON
+	JSON interface{}
+	// Params holds the path parameters captured by the matched route
+	Params map[string]string
+	// Route is the index of the matched route, -1 when none matched
+	Route int
+}
+
+// MockServer is an HTTP test server answering requests from declarative routes
+// and recording every request it receives
+type MockServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	routes   []mockRoute
+	requests []MockRequest
+	random   *rand.Rand
+}
+
+type mockRoute struct {
+	MockRoute
+	body *template.Template
+}
+
+// NewMockServer starts a server with the given routes. It panics if a body template is invalid.
+func NewMockServer(routes ...MockRoute) *MockServer {
+	s := &MockServer{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, route := range routes {
+		s.AddRoute(route)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddRoute registers a new route. Routes are matched in the order they were
+// added. It panics if the body template is invalid.
+func (s *MockServer) AddRoute(route MockRoute) {
+	body := template.Must(template.New(route.Method + " " + route.Path).Parse(route.Body))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, mockRoute{MockRoute: route, body: body})
+}
+
+// Requests returns every request received so far
+func (s *MockServer) Requests() []MockRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MockRequest(nil), s.requests...)
+}
+
+// Reset clears the recorded requests
+func (s *MockServer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// RestClient returns a Client pointed at the server
+func (s *MockServer) RestClient() *Client {
+	c := NewClient(s.URL)
+	c.HTTPClient = s.Client()
+	return c
+}
+
+func (s *MockServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	req := MockRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+		Route:  -1,
+	}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &req.JSON)
+	}
+
+	// Matched without the lock, since Match may call the server
+	s.mu.Lock()
+	routes := append([]mockRoute(nil), s.routes...)
+	s.mu.Unlock()
+	var route *mockRoute
+	for i := range routes {
+		params, ok := routes[i].matches(r)
+		if ok {
+			route = &routes[i]
+			req.Route = i
+			req.Params = params
+			break
+		}
+		// Match may have consumed the body
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	failed := route != nil && route.FailureRate > 0 && s.random.Float64() < route.FailureRate
+	s.mu.Unlock()
+
+	if route == nil {
+		xerrors.WriteJSON(w, xerrors.NewNotFoundError(fmt.Sprintf("no mock route for %s %s", r.Method, r.URL.Path)))
+		return
+	}
+
+	if route.Latency > 0 {
+		select {
+		case <-time.After(route.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if failed {
+		status := route.FailureStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		xerrors.WriteJSON(w, xerrors.NewRestError(status, "injected failure"))
+		return
+	}
+
+	var out bytes.Buffer
+	if err := route.body.Execute(&out, req); err != nil {
+		xerrors.WriteJSON(w, xerrors.NewInternalServerError(fmt.Sprintf("mock template error: %s", err.Error())))
+		return
+	}
+	for name, values := range route.Headers {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(out.Bytes())
+}
+
+func (r *mockRoute) matches(req *http.Request) (map[string]string, bool) {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return nil, false
+	}
+	params, ok := matchPath(r.Path, req.URL.Path)
+	if !ok {
+		return nil, false
+	}
+	if r.Match != nil && !r.Match(req) {
+		return nil, false
+	}
+	return params, true
+}
+
+func matchPath(pattern string, path string) (map[string]string, bool) {
+	if pattern == "" || pattern == "*" {
+		return nil, true
+	}
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+
+	params := make(map[string]string)
+	for i, part := range patternParts {
+		if part == "*" && i == len(patternParts)-1 {
+			return params, true
+		}
+		if i >= len(pathParts) {
+			return nil, false
+		}
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params[part[1:len(part)-1]] = pathParts[i]
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	if len(pathParts) != len(patternParts) {
+		return nil, false
+	}
+	return params, true
+}
@@ -0,0 +1,602 @@
+package xrest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPISpec is an OpenAPI 3 document that outgoing requests are validated
+// against. Only the parts needed to check paths, parameters and JSON bodies
+// are interpreted.
+type OpenAPISpec struct {
+	paths      []openAPIPath
+	components openAPIComponents
+	basePath   string
+}
+
+// SpecViolationError is returned by a Client bound to an OpenAPISpec when a request does not match it
+type SpecViolationError struct {
+	Method     string
+	Path       string
+	Violations []string
+}
+
+func (e *SpecViolationError) Error() string {
+	return fmt.Sprintf("%s %s does not match the OpenAPI spec: %s", e.Method, e.Path, strings.Join(e.Violations, "; "))
+}
+
+type openAPIDocument struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIComponents struct {
+	Schemas       map[string]*openAPISchema      `json:"schemas"`
+	Parameters    map[string]*openAPIParameter   `json:"parameters"`
+	RequestBodies map[string]*openAPIRequestBody `json:"requestBodies"`
+}
+
+type openAPIPathItem struct {
+	Parameters []*openAPIParameter `json:"parameters"`
+	Get        *openAPIOperation   `json:"get"`
+	Put        *openAPIOperation   `json:"put"`
+	Post       *openAPIOperation   `json:"post"`
+	Delete     *openAPIOperation   `json:"delete"`
+	Options    *openAPIOperation   `json:"options"`
+	Head       *openAPIOperation   `json:"head"`
+	Patch      *openAPIOperation   `json:"patch"`
+	Trace      *openAPIOperation   `json:"trace"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter `json:"parameters"`
+	RequestBody *openAPIRequestBody `json:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref"`
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+	Style    string         `json:"style"`
+	Explode  *bool          `json:"explode"`
+}
+
+type openAPIRequestBody struct {
+	Ref      string `json:"$ref"`
+	Required bool   `json:"required"`
+	Content  map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 json.RawMessage           `json:"type"`
+	Nullable             bool                      `json:"nullable"`
+	Enum                 []interface{}             `json:"enum"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	AdditionalProperties json.RawMessage           `json:"additionalProperties"`
+	Items                *openAPISchema            `json:"items"`
+	MinLength            *int                      `json:"minLength"`
+	MaxLength            *int                      `json:"maxLength"`
+	Pattern              string                    `json:"pattern"`
+	Minimum              *float64                  `json:"minimum"`
+	Maximum              *float64                  `json:"maximum"`
+	MinItems             *int                      `json:"minItems"`
+	MaxItems             *int                      `json:"maxItems"`
+	AllOf                []*openAPISchema          `json:"allOf"`
+	AnyOf                []*openAPISchema          `json:"anyOf"`
+	OneOf                []*openAPISchema          `json:"oneOf"`
+}
+
+type openAPIPath struct {
+	template string
+	segments []string
+	params   int
+	item     openAPIPathItem
+}
+
+// LoadOpenAPISpec parses an OpenAPI 3 document in JSON or YAML
+func LoadOpenAPISpec(data []byte) (*OpenAPISpec, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+		}
+		data = converted
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	spec := &OpenAPISpec{components: doc.Components}
+	if len(doc.Servers) > 0 {
+		if u, err := url.Parse(doc.Servers[0].URL); err == nil {
+			spec.basePath = strings.TrimRight(u.Path, "/")
+		}
+	}
+	for template, item := range doc.Paths {
+		p := openAPIPath{template: template, segments: splitPath(template), item: item}
+		for _, s := range p.segments {
+			if isPathParam(s) {
+				p.params++
+			}
+		}
+		spec.paths = append(spec.paths, p)
+	}
+	// Concrete paths take precedence over templated ones, eg. /users/me over /users/{id}
+	sort.Slice(spec.paths, func(i, j int) bool {
+		if spec.paths[i].params != spec.paths[j].params {
+			return spec.paths[i].params < spec.paths[j].params
+		}
+		return spec.paths[i].template < spec.paths[j].template
+	})
+	return spec, nil
+}
+
+// ValidateRequest checks a request against the spec. The path is relative to
+// the server URL of the spec and may carry a query string.
+func (s *OpenAPISpec) ValidateRequest(method string, path string, header http.Header, body []byte) error {
+	violation := &SpecViolationError{Method: method, Path: path}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		violation.Violations = append(violation.Violations, err.Error())
+		return violation
+	}
+	requestPath := u.Path
+	if s.basePath != "" && strings.HasPrefix(requestPath, s.basePath+"/") {
+		requestPath = strings.TrimPrefix(requestPath, s.basePath)
+	}
+
+	p, pathParams := s.match(requestPath)
+	if p == nil {
+		violation.Violations = append(violation.Violations, "path is not defined")
+		return violation
+	}
+	operation := p.item.operation(method)
+	if operation == nil {
+		violation.Violations = append(violation.Violations, fmt.Sprintf("method is not defined for %s", p.template))
+		return violation
+	}
+
+	query := u.Query()
+	for _, param := range s.parameters(p.item.Parameters, operation.Parameters) {
+		var values []string
+		switch param.In {
+		case "path":
+			if value, ok := pathParams[param.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = query[param.Name]
+		case "header":
+			values = header.Values(param.Name)
+		default:
+			continue
+		}
+		if len(values) == 0 {
+			if param.Required {
+				violation.Violations = append(violation.Violations, fmt.Sprintf("missing required %s parameter %q", param.In, param.Name))
+			}
+			continue
+		}
+		if param.Schema != nil {
+			for _, v := range s.validateValue(param.Schema, s.paramValue(param, values), param.Name) {
+				violation.Violations = append(violation.Violations, fmt.Sprintf("%s parameter %s", param.In, v))
+			}
+		}
+	}
+
+	if requestBody := s.requestBody(operation.RequestBody); requestBody != nil {
+		violation.Violations = append(violation.Violations, s.validateBody(requestBody, header.Get("Content-Type"), body)...)
+	}
+
+	if len(violation.Violations) > 0 {
+		return violation
+	}
+	return nil
+}
+
+func (s *OpenAPISpec) match(path string) (*openAPIPath, map[string]string) {
+	segments := splitPath(path)
+	for i := range s.paths {
+		p := &s.paths[i]
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for j, segment := range p.segments {
+			if isPathParam(segment) {
+				value, _ := url.PathUnescape(segments[j])
+				params[segment[1:len(segment)-1]] = value
+				continue
+			}
+			if segment != segments[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return p, params
+		}
+	}
+	return nil, nil
+}
+
+// parameters merges path level and operation level parameters, the latter overriding the former
+func (s *OpenAPISpec) parameters(pathLevel []*openAPIParameter, operationLevel []*openAPIParameter) []*openAPIParameter {
+	var merged []*openAPIParameter
+	index := make(map[string]int)
+	for _, list := range [][]*openAPIParameter{pathLevel, operationLevel} {
+		for _, param := range list {
+			if param.Ref != "" {
+				param = s.components.Parameters[refName(param.Ref)]
+				if param == nil {
+					continue
+				}
+			}
+			key := param.In + ":" + param.Name
+			if i, ok := index[key]; ok {
+				merged[i] = param
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, param)
+		}
+	}
+	return merged
+}
+
+func (s *OpenAPISpec) requestBody(body *openAPIRequestBody) *openAPIRequestBody {
+	if body != nil && body.Ref != "" {
+		return s.components.RequestBodies[refName(body.Ref)]
+	}
+	return body
+}
+
+func (s *OpenAPISpec) validateBody(requestBody *openAPIRequestBody, contentType string, body []byte) []string {
+	if len(body) == 0 {
+		if requestBody.Required {
+			return []string{"missing required request body"}
+		}
+		return nil
+	}
+
+	mediaType := MediaTypeJSON
+	if contentType != "" {
+		if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = parsed
+		}
+	}
+	content, ok := requestBody.Content[mediaType]
+	if !ok {
+		return []string{fmt.Sprintf("content type %q is not accepted", mediaType)}
+	}
+	if content.Schema == nil || !strings.HasSuffix(mediaType, "json") {
+		return nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("request body is not valid JSON: %s", err.Error())}
+	}
+	return s.validateValue(content.Schema, value, "body")
+}
+
+// validateValue checks a decoded JSON value against a schema and returns the violations found
+func (s *OpenAPISpec) validateValue(schema *openAPISchema, value interface{}, at string) []string {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	var violations []string
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, at+" "+fmt.Sprintf(format, args...))
+	}
+
+	for _, sub := range schema.AllOf {
+		violations = append(violations, s.validateValue(sub, value, at)...)
+	}
+	if len(schema.AnyOf) > 0 && s.countMatches(schema.AnyOf, value, at) == 0 {
+		fail("does not match any of the allowed schemas")
+	}
+	if len(schema.OneOf) > 0 && s.countMatches(schema.OneOf, value, at) != 1 {
+		fail("must match exactly one of the allowed schemas")
+	}
+
+	if value == nil {
+		if types := schema.types(); len(types) > 0 && !schema.Nullable && !contains(types, "null") {
+			fail("must not be null")
+		}
+		return violations
+	}
+
+	if types := schema.types(); len(types) > 0 && !matchesAnyType(value, types) {
+		fail("must be of type %s", strings.Join(types, " or "))
+		return violations
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(value, schema.Enum) {
+		fail("must be one of %v", schema.Enum)
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must be at most %d characters long", *schema.MaxLength)
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(v) {
+				fail("must match pattern %s", schema.Pattern)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if schema.Minimum != nil && f < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			fail("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			fail("must have at most %d items", *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range v {
+				violations = append(violations, s.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				fail("is missing required property %q", name)
+			}
+		}
+		additional := s.additionalProperties(schema)
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := schema.Properties[name]; ok {
+				violations = append(violations, s.validateValue(property, v[name], at+"."+name)...)
+				continue
+			}
+			switch a := additional.(type) {
+			case bool:
+				if !a {
+					fail("has unexpected property %q", name)
+				}
+			case *openAPISchema:
+				violations = append(violations, s.validateValue(a, v[name], at+"."+name)...)
+			}
+		}
+	}
+	return violations
+}
+
+func (s *OpenAPISpec) countMatches(schemas []*openAPISchema, value interface{}, at string) int {
+	matches := 0
+	for _, sub := range schemas {
+		if len(s.validateValue(sub, value, at)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func (s *OpenAPISpec) resolve(schema *openAPISchema) *openAPISchema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 32; depth++ {
+		schema = s.components.Schemas[refName(schema.Ref)]
+	}
+	return schema
+}
+
+// additionalProperties returns true, false or the schema of additional properties
+func (s *OpenAPISpec) additionalProperties(schema *openAPISchema) interface{} {
+	raw := bytes.TrimSpace(schema.AdditionalProperties)
+	switch {
+	case len(raw) == 0, bytes.Equal(raw, []byte("true")):
+		return true
+	case bytes.Equal(raw, []byte("false")):
+		return false
+	}
+	var sub openAPISchema
+	if err := json.Unmarshal(raw, &sub); err != nil {
+		return true
+	}
+	return &sub
+}
+
+// types returns the allowed types, which OpenAPI 3.1 allows to be a list
+func (schema *openAPISchema) types() []string {
+	raw := bytes.TrimSpace(schema.Type)
+	if len(raw) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+	var many []string
+	_ = json.Unmarshal(raw, &many)
+	return many
+}
+
+func (item openAPIPathItem) operation(method string) *openAPIOperation {
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		return item.Get
+	case http.MethodPut:
+		return item.Put
+	case http.MethodPost:
+		return item.Post
+	case http.MethodDelete:
+		return item.Delete
+	case http.MethodOptions:
+		return item.Options
+	case http.MethodHead:
+		return item.Head
+	case http.MethodPatch:
+		return item.Patch
+	case http.MethodTrace:
+		return item.Trace
+	}
+	return nil
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case string:
+			if t == "string" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			if f, err := v.Float64(); err == nil && t == "integer" && f == math.Trunc(f) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// paramValue converts the values of a parameter to the JSON type its schema
+// expects. Arrays are the repeated values of an exploded query parameter,
+// else delimited as per the style of the parameter, by commas by default.
+func (s *OpenAPISpec) paramValue(param *openAPIParameter, values []string) interface{} {
+	resolved := s.resolve(param.Schema)
+	if resolved == nil || !contains(resolved.types(), "array") {
+		return s.scalarValue(param.Schema, values[0])
+	}
+	explode := param.In == "query" && (param.Style == "" || param.Style == "form")
+	if param.Explode != nil {
+		explode = *param.Explode
+	}
+	delimiter := ","
+	switch param.Style {
+	case "spaceDelimited":
+		delimiter = " "
+	case "pipeDelimited":
+		delimiter = "|"
+	}
+	var items []interface{}
+	for _, value := range values {
+		parts := []string{value}
+		if !explode || param.In != "query" {
+			parts = strings.Split(value, delimiter)
+		}
+		for _, part := range parts {
+			if param.In == "header" {
+				part = strings.TrimSpace(part)
+			}
+			items = append(items, s.scalarValue(resolved.Items, part))
+		}
+	}
+	return items
+}
+
+// scalarValue converts a parameter string to the JSON type its schema expects
+func (s *OpenAPISpec) scalarValue(schema *openAPISchema, value string) interface{} {
+	resolved := s.resolve(schema)
+	if resolved == nil {
+		return value
+	}
+	types := resolved.types()
+	switch {
+	case contains(types, "string"):
+		return value
+	case contains(types, "integer"), contains(types, "number"):
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case contains(types, "boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func isPathParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndexByte(ref, '/')+1:]
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
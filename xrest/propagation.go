@@ -0,0 +1,111 @@
+package xrest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ContextKey is the type of the context keys carrying propagated header values
+type ContextKey string
+
+// Context keys of the headers registered by PropagateDefaultHeaders
+const (
+	RequestIDKey      ContextKey = "X-Request-Id"
+	TenantIDKey       ContextKey = "X-Tenant-Id"
+	TraceParentKey    ContextKey = "traceparent"
+	TraceStateKey     ContextKey = "tracestate"
+	B3Key             ContextKey = "b3"
+	B3TraceIDKey      ContextKey = "X-B3-TraceId"
+	B3SpanIDKey       ContextKey = "X-B3-SpanId"
+	B3ParentSpanIDKey ContextKey = "X-B3-ParentSpanId"
+	B3SampledKey      ContextKey = "X-B3-Sampled"
+)
+
+type propagation struct {
+	key    interface{}
+	header string
+}
+
+var (
+	propagationMu sync.RWMutex
+	propagations  []propagation
+)
+
+// PropagateHeader registers a context key whose value is sent as the given
+// header on every Client request made with that context. Values must be
+// strings or implement fmt.Stringer.
+func PropagateHeader(key interface{}, header string) {
+	propagationMu.Lock()
+	defer propagationMu.Unlock()
+
+	header = http.CanonicalHeaderKey(header)
+	for i, p := range propagations {
+		if p.key == key {
+			propagations[i].header = header
+			return
+		}
+	}
+	propagations = append(propagations, propagation{key: key, header: header})
+}
+
+// PropagateDefaultHeaders registers the request id, tenant id, W3C trace context and B3 headers
+func PropagateDefaultHeaders() {
+	for _, key := range []ContextKey{
+		RequestIDKey, TenantIDKey,
+		TraceParentKey, TraceStateKey,
+		B3Key, B3TraceIDKey, B3SpanIDKey, B3ParentSpanIDKey, B3SampledKey,
+	} {
+		PropagateHeader(key, string(key))
+	}
+}
+
+// FlushPropagation removes every registered header
+func FlushPropagation() {
+	propagationMu.Lock()
+	defer propagationMu.Unlock()
+	propagations = nil
+}
+
+// WithPropagatedHeaders returns a context carrying the registered headers present on the incoming request
+func WithPropagatedHeaders(ctx context.Context, r *http.Request) context.Context {
+	propagationMu.RLock()
+	defer propagationMu.RUnlock()
+
+	for _, p := range propagations {
+		if value := r.Header.Get(p.header); value != "" {
+			ctx = context.WithValue(ctx, p.key, value)
+		}
+	}
+	return ctx
+}
+
+// PropagationHandler stores the registered headers of incoming requests in
+// their context so outgoing Client requests carry them along
+func PropagationHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithPropagatedHeaders(r.Context(), r)))
+	})
+}
+
+// applyPropagatedHeaders copies the registered context values onto the
+// headers. Headers that are already set are left untouched.
+func applyPropagatedHeaders(ctx context.Context, headers http.Header) {
+	propagationMu.RLock()
+	defer propagationMu.RUnlock()
+
+	for _, p := range propagations {
+		if headers.Get(p.header) != "" {
+			continue
+		}
+		switch value := ctx.Value(p.key).(type) {
+		case string:
+			if value != "" {
+				headers.Set(p.header, value)
+			}
+		case fmt.Stringer:
+			headers.Set(p.header, value.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package xrest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ReplayableRequest is a captured request with a buffered body. It can be
+// serialized as JSON, queued and executed again later with Client.Replay.
+type ReplayableRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	CapturedAt time.Time   `json:"captured_at"`
+	Replays    int         `json:"replays"`
+}
+
+// CaptureRequest buffers a request into a ReplayableRequest. The body is read
+// through GetBody when available so the original request stays usable.
+func CaptureRequest(r *http.Request) (*ReplayableRequest, error) {
+	captured := &ReplayableRequest{
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Header:     r.Header.Clone(),
+		CapturedAt: time.Now(),
+	}
+
+	var body io.ReadCloser
+	switch {
+	case r.GetBody != nil:
+		var err error
+		if body, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	case r.Body != nil && r.Body != http.NoBody:
+		// Without GetBody the body can only be read once, put a copy back
+		data, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		captured.Body = data
+		return captured, nil
+	default:
+		return captured, nil
+	}
+
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	captured.Body = data
+	return captured, nil
+}
+
+// CaptureResponse captures the request that produced the response
+func CaptureResponse(resp *http.Response) (*ReplayableRequest, error) {
+	return CaptureRequest(resp.Request)
+}
+
+// Request rebuilds the captured request
+func (r *ReplayableRequest) Request(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+	request, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header = r.Header.Clone()
+	if request.Header == nil {
+		request.Header = make(http.Header)
+	}
+	return request, nil
+}
+
+// Replay executes a captured request again with the client's HTTP client,
+// retry policy and limits. The captured URL is used as it is, ignoring the
+// base URL and balancer, and OnFailure is not called for replays.
+func (c *Client) Replay(ctx context.Context, r *ReplayableRequest) (*http.Response, error) {
+	r.Replays++
+	return c.run(ctx, r.Method, r.Body, r.Header, false, func() (string, *endpointState, error) {
+		return r.URL, nil, nil
+	})
+}
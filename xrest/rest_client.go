@@ -47,17 +47,22 @@ func AddMock(mock Mock) {
 	mocks[getMockID(mock.HTTPMethod, mock.URL)] = &mock
 }
 
+// getMock returns the mocked outcome of a request
+func getMock(httpMethod, url string) (*http.Response, error) {
+	mock := mocks[getMockID(httpMethod, url)]
+	if mock == nil {
+		return nil, errors.New("no mock found for given request")
+	}
+	return mock.Response, mock.Err
+}
+
 // MakeRequest execute a request to a given URL with the body
 func MakeRequest(method string, url string, body interface{}, headers http.Header) (*http.Response, error) {
 	var jsonBytes []byte
 	var err error
 
 	if enabledMocks {
-		mock := mocks[getMockID(method, url)]
-		if mock != nil {
-			return nil, errors.New("no mock found for given request")
-		}
-		return mock.Response, mock.Err
+		return getMock(method, url)
 	}
 
 	// Check if the body is already a string (Eg. JSON string)
@@ -83,11 +88,7 @@ func MakeRequest(method string, url string, body interface{}, headers http.Heade
 // PostForm issues a POST to the specified URL, with data's keys and values URL-encoded as the request body.
 func PostForm(url string, data url.Values, headers http.Header) (*http.Response, error) {
 	if enabledMocks {
-		mock := mocks[getMockID(http.MethodPost, url)]
-		if mock != nil {
-			return nil, errors.New("no mock found for given request")
-		}
-		return mock.Response, mock.Err
+		return getMock(http.MethodPost, url)
 	}
 
 	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(data.Encode()))
@@ -0,0 +1,271 @@
+package xrest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// ErrRetryBudgetExhausted is returned when the remaining budget or context
+// deadline is too short for another attempt to finish
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryPolicy configures how a Client retries failed requests
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int
+	// Backoff is the wait before the first retry. It doubles after every
+	// attempt, with jitter, up to MaxBackoff. A longer Retry-After announced
+	// by the response takes precedence.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Budget caps the total time a call may spend across attempts and waits.
+	// When zero only the context deadline applies.
+	Budget time.Duration
+	// MinAttemptTime is the time an attempt is expected to need at least. An
+	// attempt is only started when the remaining budget leaves room for this or
+	// for the average duration of the previous attempts, whichever is larger.
+	MinAttemptTime time.Duration
+	// ShouldRetry decides whether the outcome of an attempt is retried,
+	// DefaultShouldRetry when nil
+	ShouldRetry func(resp *http.Response, err error) bool
+}
+
+// Attempt records how much of the budget a single attempt consumed
+type Attempt struct {
+	Number     int
+	Wait       time.Duration
+	Duration   time.Duration
+	StatusCode int
+	Err        error
+}
+
+// RetryError is returned when a call with retries fails without a response.
+// It keeps the accounting of every attempt made.
+type RetryError struct {
+	Attempts []Attempt
+	// Budget is the time that was available when the call started, zero if unbounded
+	Budget time.Duration
+	Err    error
+}
+
+// DefaultRetryPolicy returns a policy with three attempts and a short exponential backoff
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+	}
+}
+
+// DefaultShouldRetry retries transport errors and the responses whose status
+// xerrors classifies as retryable (429, 502, 503 and 504). A RestErr returned
+// instead of a response is retried when xerrors.IsRetryable reports so.
+// Responses too large are not retried, they would be too large again.
+func DefaultShouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, ErrResponseTooLarge) {
+			return false
+		}
+		var restErr xerrors.RestErr
+		if errors.As(err, &restErr) {
+			return xerrors.IsRetryable(err)
+		}
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return xerrors.IsRetryableStatus(resp.StatusCode)
+}
+
+// Consumed returns the part of the budget used by the attempt, including the wait before it
+func (a Attempt) Consumed() time.Duration {
+	return a.Wait + a.Duration
+}
+
+func (e *RetryError) Error() string {
+	if e.Budget > 0 {
+		return fmt.Sprintf("request failed after %d attempt(s), %s of %s budget used: %v", len(e.Attempts), e.Consumed(), e.Budget, e.Err)
+	}
+	return fmt.Sprintf("request failed after %d attempt(s) in %s: %v", len(e.Attempts), e.Consumed(), e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Consumed returns the total time spent on the call
+func (e *RetryError) Consumed() time.Duration {
+	var total time.Duration
+	for _, a := range e.Attempts {
+		total += a.Consumed()
+	}
+	return total
+}
+
+func (p *RetryPolicy) do(ctx context.Context, send func(context.Context) (*http.Response, error)) (*http.Response, error) {
+	start := time.Now()
+	deadline, bounded := p.deadline(ctx, start)
+	var budget time.Duration
+	if bounded {
+		budget = deadline.Sub(start)
+	}
+
+	shouldRetry := p.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = DefaultShouldRetry
+	}
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var attempts []Attempt
+	var spent time.Duration
+	var lastErr error
+	fail := func(err error) (*http.Response, error) {
+		return nil, &RetryError{Attempts: attempts, Budget: budget, Err: err}
+	}
+
+	var wait time.Duration
+	for n := 1; ; n++ {
+		if bounded && time.Until(deadline) < wait+p.expected(spent, len(attempts)) {
+			if lastErr != nil {
+				return fail(fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, lastErr))
+			}
+			return fail(ErrRetryBudgetExhausted)
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fail(ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		attemptStart := time.Now()
+		resp, err := p.send(ctx, send, deadline, bounded)
+		attempt := Attempt{Number: n, Wait: wait, Duration: time.Since(attemptStart), Err: err}
+		if resp != nil {
+			attempt.StatusCode = resp.StatusCode
+		}
+		attempts = append(attempts, attempt)
+		spent += attempt.Duration
+		lastErr = err
+
+		if n >= maxAttempts || ctx.Err() != nil || !shouldRetry(resp, err) {
+			if err != nil {
+				return fail(err)
+			}
+			return resp, nil
+		}
+
+		wait = p.backoff(n)
+		if after := retryAfter(resp); after > wait {
+			// The server knows better when capacity frees up
+			wait = after
+		}
+		if resp != nil {
+			// Hand back the last response rather than an error when no retry fits
+			if bounded && time.Until(deadline) < wait+p.expected(spent, len(attempts)) {
+				return resp, nil
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	}
+}
+
+// send runs an attempt, bounded by the deadline of the budget. The context
+// of the attempt is cancelled once the response body is closed.
+func (p *RetryPolicy) send(ctx context.Context, send func(context.Context) (*http.Response, error), deadline time.Time, bounded bool) (*http.Response, error) {
+	if !bounded {
+		return send(ctx)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	resp, err := send(ctx)
+	if resp == nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, err
+}
+
+// cancelBody cancels the context of an attempt once closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryAfter returns the wait announced by the Retry-After header of a
+// response, in seconds or as a date, or zero
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// deadline returns the earliest of the context deadline and the end of the budget
+func (p *RetryPolicy) deadline(ctx context.Context, start time.Time) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if p.Budget > 0 {
+		end := start.Add(p.Budget)
+		if !ok || end.Before(deadline) {
+			deadline, ok = end, true
+		}
+	}
+	return deadline, ok
+}
+
+// expected estimates how long the next attempt will take
+func (p *RetryPolicy) expected(spent time.Duration, attempts int) time.Duration {
+	expected := p.MinAttemptTime
+	if attempts > 0 {
+		if average := spent / time.Duration(attempts); average > expected {
+			expected = average
+		}
+	}
+	return expected
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	backoff := p.Backoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			backoff = p.MaxBackoff
+			break
+		}
+	}
+	// Jitter between half and the full backoff to spread retries of concurrent callers
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,57 @@
+// Package xsched runs jobs on cron schedules or fixed intervals
+package xsched
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Schedule tells when a job runs next
+type Schedule interface {
+	// Next returns the first run time after t, or a zero time when the job
+	// never runs again
+	Next(t time.Time) time.Time
+}
+
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Cron parses a cron expression: 5 fields, or 6 with leading seconds, or a
+// descriptor such as @hourly or @every 90s. A CRON_TZ=Europe/Paris prefix
+// sets the time zone of the expression, which else is the one of the job.
+func Cron(expr string) (Schedule, error) {
+	schedule, err := cronParser.Parse(expr)
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusBadRequest, fmt.Sprintf("invalid cron expression %q", expr))
+	}
+	return schedule, nil
+}
+
+// MustCron is Cron panicking on invalid expressions, for package level schedules
+func MustCron(expr string) Schedule {
+	schedule, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+// Every runs a job at a fixed interval, at the multiples of interval since
+// the zero time, eg. on the hour for time.Hour, so every instance computes
+// the same runs
+func Every(interval time.Duration) Schedule {
+	return every(interval)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	if e <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
@@ -0,0 +1,275 @@
+package xsched
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Overlap tells what happens when a job is due while its previous run is
+// still running
+type Overlap int
+
+const (
+	// Skip drops the run
+	Skip Overlap = iota
+	// Queue runs it once the previous run finishes, several due runs
+	// collapsing into one
+	Queue
+	// Allow runs it concurrently
+	Allow
+)
+
+// Locker makes sure a single instance runs a job, eg. with a Redis SET NX
+// or a database advisory lock. Lock reports false when another instance
+// holds the lock. The scheduler locks each run, the key being the job name
+// and its scheduled time, and keeps the lock until its ttl so the instances
+// firing late skip the run.
+type Locker interface {
+	Lock(ctx context.Context, job string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
+
+// JobFunc is the function run by a job
+type JobFunc func(ctx context.Context) error
+
+// JobOptions configures a job
+type JobOptions struct {
+	// Overlap defaults to Skip
+	Overlap Overlap
+	// Jitter delays each run by a random duration up to Jitter, to spread the
+	// load of jobs scheduled at the same time. Distributed runs are delayed
+	// once their lock is taken.
+	Jitter time.Duration
+	// Location is the time zone of the schedule, time.Local by default
+	Location *time.Location
+	// Timeout cancels the context of a run lasting longer, zero meaning no timeout
+	Timeout time.Duration
+	// Distributed takes the lock of the scheduler Locker before each run,
+	// skipping the run when another instance holds it
+	Distributed bool
+}
+
+// Scheduler runs jobs on their schedule
+type Scheduler struct {
+	// Locker is used by the Distributed jobs
+	Locker Locker
+	// LockTTL is how long the lock of a run is held, longer than the clock
+	// skew of the instances, the job Timeout or else 1m by default
+	LockTTL time.Duration
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	fn       JobFunc
+	opts     JobOptions
+
+	mu      sync.Mutex
+	running int
+	pending bool
+	// pendingAt is the scheduled time of the pending run
+	pendingAt time.Time
+}
+
+// New creates a scheduler
+func New() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*job)}
+}
+
+// Add registers a job, started at once when the scheduler runs
+func (s *Scheduler) Add(name string, schedule Schedule, fn JobFunc, opts JobOptions) error {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return xerrors.NewConflictError(fmt.Sprintf("job %s is already scheduled", name))
+	}
+	if opts.Distributed && s.Locker == nil {
+		return xerrors.Wrap(fmt.Errorf("no Locker"), http.StatusInternalServerError, fmt.Sprintf("job %s is distributed but the scheduler has no Locker", name))
+	}
+	j := &job{name: name, schedule: schedule, fn: fn, opts: opts}
+	s.jobs[name] = j
+	if s.started {
+		s.start(j)
+	}
+	return nil
+}
+
+// AddCron registers a job scheduled by a cron expression, see Cron
+func (s *Scheduler) AddCron(name string, expr string, fn JobFunc, opts JobOptions) error {
+	schedule, err := Cron(expr)
+	if err != nil {
+		return err
+	}
+	return s.Add(name, schedule, fn, opts)
+}
+
+// Start runs the jobs in the background until ctx is done or Stop is called
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.start(j)
+	}
+}
+
+// Stop stops scheduling runs and waits for the running ones. When ctx is done
+// first, it returns ctx.Err() and the runs keep their canceled context.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start runs the loop of a job, s.mu being held
+func (s *Scheduler) start(j *job) {
+	ctx := s.ctx
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop(ctx, j)
+	}()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	for {
+		now := time.Now().In(j.opts.Location)
+		next := j.schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+			s.trigger(ctx, j, next)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// trigger starts the run scheduled at at unless the overlap policy prevents
+// it
+func (s *Scheduler) trigger(ctx context.Context, j *job, at time.Time) {
+	j.mu.Lock()
+	if j.running > 0 && j.opts.Overlap != Allow {
+		if j.opts.Overlap == Queue {
+			j.pending, j.pendingAt = true, at
+		} else {
+			xlogger.Info("xsched: run skipped, the previous one is still running", zap.String("job", j.name))
+		}
+		j.mu.Unlock()
+		return
+	}
+	j.running++
+	j.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			s.run(ctx, j, at)
+			j.mu.Lock()
+			if !j.pending || ctx.Err() != nil {
+				j.pending = false
+				j.running--
+				j.mu.Unlock()
+				return
+			}
+			j.pending, at = false, j.pendingAt
+			j.mu.Unlock()
+		}
+	}()
+}
+
+// run runs a job once for its scheduled time at, taking the lock of the run
+// when distributed
+func (s *Scheduler) run(ctx context.Context, j *job, at time.Time) {
+	if j.opts.Distributed {
+		ttl := s.LockTTL
+		if ttl <= 0 {
+			ttl = j.opts.Timeout
+		}
+		if ttl <= 0 {
+			ttl = time.Minute
+		}
+		// The lock is not released once the run ends, an instance firing
+		// later would run it again
+		_, ok, err := s.Locker.Lock(ctx, j.name+"@"+at.UTC().Format(time.RFC3339Nano), ttl)
+		if err != nil {
+			xlogger.Error("xsched: cannot take the lock of the job", err, zap.String("job", j.name))
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	if j.opts.Jitter > 0 {
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(j.opts.Jitter))))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	if j.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.opts.Timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	if err := call(ctx, j.fn); err != nil {
+		xlogger.Error("xsched: job failed", err, zap.String("job", j.name), zap.String("duration", time.Since(started).String()))
+	}
+}
+
+// call runs fn, turning its panics into errors
+func call(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	return fn(ctx)
+}
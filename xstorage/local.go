@@ -0,0 +1,377 @@
+package xstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// metaDir holds the content type, metadata and ETag of the objects of a
+// Local storage, as JSON files mirroring the objects
+const metaDir = ".xstorage"
+
+// LocalOptions configures a Local storage
+type LocalOptions struct {
+	// Dir is the directory of the objects, created if missing
+	Dir string
+	// Sync flushes the files and their directory to the disk on Put, so the
+	// objects put survive a crash
+	Sync bool
+	// BaseURL is the URL Handler is mounted at, eg.
+	// "http://localhost:8080/files", the base of the presigned URLs
+	BaseURL string
+	// SigningKey signs the presigned URLs, which need it
+	SigningKey []byte
+}
+
+// Local stores the objects as files of a directory, the key being their
+// path, for local development and on-premises deployments. Files are
+// written to a temporary file renamed once complete, so readers never see a
+// partial object.
+type Local struct {
+	dir string
+	// realDir is dir with its symbolic links resolved
+	realDir string
+	opts    LocalOptions
+}
+
+var _ Storage = (*Local)(nil)
+
+type localMeta struct {
+	ContentType string            `json:"content_type"`
+	ETag        string            `json:"etag"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// NewLocal creates a storage in opts.Dir
+func NewLocal(opts LocalOptions) (*Local, error) {
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "invalid storage directory "+opts.Dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot create the storage directory "+dir)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot resolve the storage directory "+dir)
+	}
+	return &Local{dir: dir, realDir: realDir, opts: opts}, nil
+}
+
+// Put writes the content of r to a temporary file renamed to the file of
+// key
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error) {
+	file, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = DefaultContentType
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot create the directory of "+key)
+	}
+
+	hash := md5.New()
+	err = l.writeFile(file, func(w io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(w, hash), readerWithContext{ctx: ctx, r: r})
+		return err
+	})
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot write the object "+key)
+	}
+
+	meta := localMeta{ContentType: opts.ContentType, ETag: hex.EncodeToString(hash.Sum(nil)), Metadata: opts.Metadata}
+	metaFile := filepath.Join(l.dir, metaDir, filepath.FromSlash(key)+".json")
+	if err := os.MkdirAll(filepath.Dir(metaFile), 0o755); err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot create the metadata directory of "+key)
+	}
+	err = l.writeFile(metaFile, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(meta)
+	})
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot write the metadata of "+key)
+	}
+	return l.Stat(ctx, key)
+}
+
+// Get opens the file of key
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	file, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, l.wrap(err, key, "cannot open the object "+key)
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, nil, NotFound(key)
+	}
+	return f, l.object(key, info, true), nil
+}
+
+// Delete removes the file of key and its empty parent directories
+func (l *Local) Delete(ctx context.Context, key string) error {
+	file, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return xerrors.WrapInternalServerError(err, "cannot delete the object "+key)
+	}
+	metaFile := filepath.Join(l.dir, metaDir, filepath.FromSlash(key)+".json")
+	if err := os.Remove(metaFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return xerrors.WrapInternalServerError(err, "cannot delete the metadata of "+key)
+	}
+	l.removeEmptyDirs(filepath.Dir(file), l.dir)
+	l.removeEmptyDirs(filepath.Dir(metaFile), filepath.Join(l.dir, metaDir))
+	return nil
+}
+
+// List walks the directory of prefix and calls fn with the files in key
+// order
+func (l *Local) List(ctx context.Context, prefix string, fn func(*Object) error) error {
+	root := l.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := l.path(prefix[:i])
+		if err != nil {
+			return err
+		}
+		root = dir
+	}
+
+	type file struct {
+		key  string
+		info fs.FileInfo
+	}
+	var files []file
+	err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(l.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if key == metaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isTemp(entry.Name()) || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		files = append(files, file{key: key, info: info})
+		return nil
+	})
+	if err != nil {
+		return xerrors.WrapInternalServerError(err, "cannot list the objects of "+prefix)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].key < files[j].key })
+	for _, f := range files {
+		if err := fn(l.object(f.key, f.info, false)); err != nil {
+			return EndList(err)
+		}
+	}
+	return nil
+}
+
+// Stat returns the attributes of the file of key
+func (l *Local) Stat(ctx context.Context, key string) (*Object, error) {
+	file, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, l.wrap(err, key, "cannot get the attributes of the object "+key)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, NotFound(key)
+	}
+	return l.object(key, info, true), nil
+}
+
+// path returns the file of key, rejecting the keys escaping the directory,
+// through ".." or symbolic links
+func (l *Local) path(key string) (string, error) {
+	invalid := xerrors.NewBadRequestError("invalid object key " + strconv.Quote(key))
+	if key == "" || strings.ContainsAny(key, "\\\x00") || path.Clean(key) != key || path.IsAbs(key) {
+		return "", invalid
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || part == metaDir || isTemp(part) {
+			return "", invalid
+		}
+	}
+	file := filepath.Join(l.dir, filepath.FromSlash(key))
+	if !l.within(file) {
+		return "", invalid
+	}
+	return file, nil
+}
+
+// within reports whether file resolves under the directory once the
+// symbolic links of its existing ancestors are resolved
+func (l *Local) within(file string) bool {
+	existing, missing := file, ""
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			real = filepath.Join(real, missing)
+			return real != l.realDir && strings.HasPrefix(real, l.realDir+string(filepath.Separator))
+		}
+		if !errors.Is(err, fs.ErrNotExist) || existing == l.dir {
+			return false
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			// A dangling symbolic link
+			return false
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = filepath.Dir(existing)
+	}
+}
+
+// writeFile writes file atomically through a temporary file of its
+// directory
+func (l *Local) writeFile(file string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if l.opts.Sync {
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	if l.opts.Sync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// object returns the attributes of the file of key, with its metadata
+// when withMeta
+func (l *Local) object(key string, info fs.FileInfo, withMeta bool) *Object {
+	object := &Object{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		// Files put by other means have a weak ETag
+		ETag: strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16),
+	}
+	if object.ContentType == "" {
+		object.ContentType = DefaultContentType
+	}
+	data, err := os.ReadFile(filepath.Join(l.dir, metaDir, filepath.FromSlash(key)+".json"))
+	if err != nil {
+		return object
+	}
+	var meta localMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return object
+	}
+	object.ContentType, object.ETag = meta.ContentType, meta.ETag
+	if withMeta {
+		object.Metadata = meta.Metadata
+	}
+	return object
+}
+
+// removeEmptyDirs removes dir and its parents up to root while empty
+func (l *Local) removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (l *Local) wrap(err error, key, message string) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return NotFound(key)
+	}
+	return xerrors.WrapInternalServerError(err, message)
+}
+
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-")
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readerWithContext stops reading once ctx is done
+type readerWithContext struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerWithContext) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,153 @@
+package xstorage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+var _ Presigner = (*Local)(nil)
+
+// PresignGet returns a URL of BaseURL downloading the object of key,
+// served by Handler
+func (l *Local) PresignGet(ctx context.Context, key string, opts PresignOptions) (*PresignedURL, error) {
+	return l.presign(http.MethodGet, key, PresignOptions{Expires: opts.Expires})
+}
+
+// PresignPut returns a URL of BaseURL uploading the object of key, served
+// by Handler
+func (l *Local) PresignPut(ctx context.Context, key string, opts PresignOptions) (*PresignedURL, error) {
+	return l.presign(http.MethodPut, key, opts)
+}
+
+func (l *Local) presign(method, key string, opts PresignOptions) (*PresignedURL, error) {
+	if l.opts.BaseURL == "" || len(l.opts.SigningKey) == 0 {
+		return nil, xerrors.NewNotImplementedError("the local storage needs a BaseURL and a SigningKey to presign URLs")
+	}
+	if _, err := l.path(key); err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(opts.ExpiresIn()).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	headers := make(http.Header)
+	if opts.ContentType != "" {
+		query.Set("content_type", opts.ContentType)
+		headers.Set("Content-Type", opts.ContentType)
+	}
+	if opts.ContentLength > 0 {
+		query.Set("content_length", strconv.FormatInt(opts.ContentLength, 10))
+		headers.Set("Content-Length", strconv.FormatInt(opts.ContentLength, 10))
+	}
+	query.Set("signature", l.sign(method, key, query))
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return &PresignedURL{
+		Method:  method,
+		URL:     strings.TrimSuffix(l.opts.BaseURL, "/") + "/" + strings.Join(segments, "/") + "?" + query.Encode(),
+		Headers: headers,
+		Expires: expires,
+	}, nil
+}
+
+// sign returns the signature of the request of key with the query
+// parameters of its constraints
+func (l *Local) sign(method, key string, query url.Values) string {
+	mac := hmac.New(sha256.New, l.opts.SigningKey)
+	for _, value := range []string{method, key, query.Get("expires"), query.Get("content_type"), query.Get("content_length")} {
+		mac.Write([]byte(value))
+		mac.Write([]byte{'\n'})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Handler serves the presigned URLs: GET and HEAD download the objects and
+// PUT uploads them. Mount it at the path of BaseURL, without stripping it.
+func (l *Local) Handler() http.Handler {
+	var prefix string
+	if base, err := url.Parse(l.opts.BaseURL); err == nil {
+		prefix = strings.TrimSuffix(base.Path, "/") + "/"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || len(l.opts.SigningKey) == 0 {
+			xerrors.WriteJSON(w, xerrors.NewNotFoundError("no object at "+r.URL.Path))
+			return
+		}
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if method != http.MethodGet && method != http.MethodPut {
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			xerrors.WriteJSON(w, xerrors.NewRestError(http.StatusMethodNotAllowed, "method "+r.Method+" not allowed"))
+			return
+		}
+
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || !hmac.Equal([]byte(query.Get("signature")), []byte(l.sign(method, key, query))) {
+			xerrors.WriteJSON(w, xerrors.NewForbiddenError("invalid signature"))
+			return
+		}
+		if time.Now().Unix() > expires {
+			xerrors.WriteJSON(w, xerrors.NewForbiddenError("the URL has expired"))
+			return
+		}
+		if method == http.MethodGet {
+			l.serveGet(w, r, key)
+			return
+		}
+		l.servePut(w, r, key, query)
+	})
+}
+
+func (l *Local) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	f, object, err := l.Get(r.Context(), key)
+	if err != nil {
+		xerrors.WriteJSON(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("ETag", strconv.Quote(object.ETag))
+	if seeker, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", object.LastModified, seeker)
+		return
+	}
+	xerrors.WriteJSON(w, xerrors.NewInternalServerError("the object "+key+" cannot be served"))
+}
+
+func (l *Local) servePut(w http.ResponseWriter, r *http.Request, key string, query url.Values) {
+	contentType := r.Header.Get("Content-Type")
+	if required := query.Get("content_type"); required != "" && contentType != required {
+		xerrors.WriteJSON(w, xerrors.NewForbiddenError("the content type must be "+required))
+		return
+	}
+	if required := query.Get("content_length"); required != "" {
+		if strconv.FormatInt(r.ContentLength, 10) != required {
+			xerrors.WriteJSON(w, xerrors.NewForbiddenError("the content length must be "+required))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, r.ContentLength)
+	}
+	object, err := l.Put(r.Context(), key, r.Body, PutOptions{ContentType: contentType, Size: r.ContentLength})
+	if err != nil {
+		xerrors.WriteJSON(w, err)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(object.ETag))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,67 @@
+package xstorage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Presigner creates URLs granting a temporary access to an object, so
+// browsers download and upload it directly rather than through the service
+type Presigner interface {
+	// PresignGet returns a URL downloading the object of key
+	PresignGet(ctx context.Context, key string, opts PresignOptions) (*PresignedURL, error)
+	// PresignPut returns a URL uploading the object of key, replacing it if
+	// any
+	PresignPut(ctx context.Context, key string, opts PresignOptions) (*PresignedURL, error)
+}
+
+// PresignOptions configures PresignGet and PresignPut
+type PresignOptions struct {
+	// Expires is the validity of the URL, 15m by default
+	Expires time.Duration
+	// ContentType is the content type the upload must have
+	ContentType string
+	// ContentLength is the size in bytes the upload must have
+	ContentLength int64
+}
+
+// PresignedURL is a request granted by a presigned URL
+type PresignedURL struct {
+	Method string
+	URL    string
+	// Headers are the headers the request must send, eg. Content-Type
+	Headers http.Header
+	// Expires is the time the URL expires at
+	Expires time.Time
+}
+
+// ExpiresIn returns the validity of the URL, 15m by default
+func (o PresignOptions) ExpiresIn() time.Duration {
+	if o.Expires <= 0 {
+		return 15 * time.Minute
+	}
+	return o.Expires
+}
+
+// PresignGet returns a URL downloading the object of key from storage, a
+// Presigner
+func PresignGet(ctx context.Context, storage Storage, key string, opts PresignOptions) (*PresignedURL, error) {
+	presigner, ok := storage.(Presigner)
+	if !ok {
+		return nil, xerrors.NewNotImplementedError("the storage cannot presign URLs")
+	}
+	return presigner.PresignGet(ctx, key, opts)
+}
+
+// PresignPut returns a URL uploading the object of key to storage, a
+// Presigner
+func PresignPut(ctx context.Context, storage Storage, key string, opts PresignOptions) (*PresignedURL, error) {
+	presigner, ok := storage.(Presigner)
+	if !ok {
+		return nil, xerrors.NewNotImplementedError("the storage cannot presign URLs")
+	}
+	return presigner.PresignPut(ctx, key, opts)
+}
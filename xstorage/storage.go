@@ -0,0 +1,113 @@
+// Package xstorage stores blobs behind a Storage interface, implemented by
+// Local for a directory and for S3, GCS and Azure Blob Storage by its
+// subpackages, which all presign URLs for browsers through Presigner
+package xstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Storage stores objects by key, eg. "invoices/2024/42.pdf"
+type Storage interface {
+	// Put stores the content of r under key, replacing the object if any.
+	// Large contents are uploaded in parts.
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error)
+	// Get returns the content of the object of key, to be closed, and its
+	// attributes. A missing object is a 404 error.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete deletes the object of key. Deleting a missing object succeeds.
+	Delete(ctx context.Context, key string) error
+	// List calls fn with the objects whose key starts with prefix, in key
+	// order, until fn returns an error, which List returns unless it is
+	// ErrStop
+	List(ctx context.Context, prefix string, fn func(*Object) error) error
+	// Stat returns the attributes of the object of key. A missing object is a
+	// 404 error.
+	Stat(ctx context.Context, key string) (*Object, error)
+}
+
+// Object are the attributes of a stored object
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	// Metadata are the user metadata of the object, not filled by List
+	Metadata map[string]string
+}
+
+// PutOptions configures Put
+type PutOptions struct {
+	// ContentType defaults to application/octet-stream
+	ContentType string
+	// Metadata are user metadata stored with the object
+	Metadata map[string]string
+	// Size is the size of the content when known, sparing the backends to
+	// buffer it
+	Size int64
+}
+
+// Encryption configures the server-side encryption of the objects of a
+// backend. The zero value keeps the default encryption of the bucket.
+type Encryption struct {
+	// KMSKey encrypts with a managed key: a KMS key id for S3, a Cloud KMS
+	// key name for GCS and an encryption scope for Azure
+	KMSKey string
+	// Key is a customer provided AES-256 key, needed to read the objects
+	// back. It excludes KMSKey.
+	Key []byte
+}
+
+// DefaultContentType is the content type of the objects put without one
+const DefaultContentType = "application/octet-stream"
+
+// ErrStop stops List without error when returned by its function
+var ErrStop = errors.New("xstorage: stop listing")
+
+// IsNotFound reports whether err is the 404 error of a missing object
+func IsNotFound(err error) bool {
+	var restErr xerrors.RestErr
+	return errors.As(err, &restErr) && restErr.StatusCode() == http.StatusNotFound
+}
+
+// NotFound returns the 404 error of the missing object of key
+func NotFound(key string) error {
+	return xerrors.NewNotFoundError("object " + key + " not found")
+}
+
+// EndList returns the error of List for the error of its function, nil for
+// ErrStop
+func EndList(err error) error {
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+// PutBytes stores data under key
+func PutBytes(ctx context.Context, storage Storage, key string, data []byte, opts PutOptions) (*Object, error) {
+	opts.Size = int64(len(data))
+	return storage.Put(ctx, key, bytes.NewReader(data), opts)
+}
+
+// GetBytes returns the content of the object of key
+func GetBytes(ctx context.Context, storage Storage, key string) ([]byte, error) {
+	r, _, err := storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot read the object "+key)
+	}
+	return data, nil
+}
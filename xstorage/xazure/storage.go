@@ -0,0 +1,262 @@
+// Package xazure implements xstorage.Storage with an Azure Blob Storage
+// container
+package xazure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xstorage"
+)
+
+// Options configures New
+type Options struct {
+	// ConnectionString and Container address the container, eg. with the
+	// connection string of Azurite for local development
+	ConnectionString string
+	Container        string
+	// ContainerURL addresses the container without a connection string, eg.
+	// "https://account.blob.core.windows.net/container", with a SAS token or
+	// the AccountName and AccountKey
+	ContainerURL string
+	AccountName  string
+	AccountKey   string
+	// PartSize is the size of the blocks of the uploads, above which
+	// contents are uploaded in blocks, 8MiB by default
+	PartSize int64
+	// Concurrency is the number of blocks uploaded at once, 4 by default
+	Concurrency int
+	// Encryption is the server-side encryption of the blobs: an encryption
+	// scope or a customer provided key
+	Encryption xstorage.Encryption
+}
+
+// Storage stores the objects as block blobs of a container
+type Storage struct {
+	client *container.Client
+	opts   Options
+}
+
+var (
+	_ xstorage.Storage   = (*Storage)(nil)
+	_ xstorage.Presigner = (*Storage)(nil)
+)
+
+// New creates a storage
+func New(opts Options) (*Storage, error) {
+	var client *container.Client
+	var err error
+	switch {
+	case opts.ConnectionString != "":
+		client, err = container.NewClientFromConnectionString(opts.ConnectionString, opts.Container, nil)
+	case opts.AccountKey != "":
+		var credential *container.SharedKeyCredential
+		if credential, err = container.NewSharedKeyCredential(opts.AccountName, opts.AccountKey); err == nil {
+			client, err = container.NewClientWithSharedKeyCredential(opts.ContainerURL, credential, nil)
+		}
+	default:
+		client, err = container.NewClientWithNoCredential(opts.ContainerURL, nil)
+	}
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot create the Azure Blob Storage client")
+	}
+	return NewFromClient(client, opts), nil
+}
+
+// NewFromClient creates a storage with client, eg. authenticated with
+// azidentity, ignoring the connection options
+func NewFromClient(client *container.Client, opts Options) *Storage {
+	if opts.PartSize <= 0 {
+		opts.PartSize = 8 << 20
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	return &Storage{client: client, opts: opts}
+}
+
+// Client returns the underlying container client
+func (s *Storage) Client() *container.Client {
+	return s.client
+}
+
+// Put uploads the content of r as a block blob, in blocks above PartSize
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts xstorage.PutOptions) (*xstorage.Object, error) {
+	if opts.ContentType == "" {
+		opts.ContentType = xstorage.DefaultContentType
+	}
+	options := &blockblob.UploadStreamOptions{
+		BlockSize:   s.opts.PartSize,
+		Concurrency: s.opts.Concurrency,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(opts.ContentType)},
+		Metadata:    make(map[string]*string, len(opts.Metadata)),
+		CPKInfo:     s.cpkInfo(),
+	}
+	for name, value := range opts.Metadata {
+		options.Metadata[name] = to.Ptr(value)
+	}
+	if s.opts.Encryption.KMSKey != "" {
+		options.CPKScopeInfo = &blob.CPKScopeInfo{EncryptionScope: to.Ptr(s.opts.Encryption.KMSKey)}
+	}
+	if _, err := s.client.NewBlockBlobClient(key).UploadStream(ctx, r, options); err != nil {
+		return nil, wrap(err, key, "cannot upload the object "+key)
+	}
+	return s.Stat(ctx, key)
+}
+
+// Get downloads the blob of key
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, *xstorage.Object, error) {
+	resp, err := s.client.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{CPKInfo: s.cpkInfo()})
+	if err != nil {
+		return nil, nil, wrap(err, key, "cannot get the object "+key)
+	}
+	object := &xstorage.Object{
+		Key:          key,
+		Size:         deref(resp.ContentLength),
+		ContentType:  deref(resp.ContentType),
+		LastModified: deref(resp.LastModified),
+		Metadata:     metadata(resp.Metadata),
+	}
+	if resp.ETag != nil {
+		object.ETag = string(*resp.ETag)
+	}
+	return resp.Body, object, nil
+}
+
+// Delete deletes the blob of key with its snapshots
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.NewBlobClient(key).Delete(ctx, &blob.DeleteOptions{DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude)})
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return xerrors.WrapServiceUnavailable(err, "cannot delete the object "+key)
+	}
+	return nil
+}
+
+// List lists the blobs of prefix, by pages of 5000
+func (s *Storage) List(ctx context.Context, prefix string, fn func(*xstorage.Object) error) error {
+	pager := s.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: to.Ptr(prefix)})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot list the objects of "+prefix)
+		}
+		for _, item := range page.Segment.BlobItems {
+			object := &xstorage.Object{Key: deref(item.Name)}
+			if properties := item.Properties; properties != nil {
+				object.Size = deref(properties.ContentLength)
+				object.ContentType = deref(properties.ContentType)
+				object.LastModified = deref(properties.LastModified)
+				if properties.ETag != nil {
+					object.ETag = string(*properties.ETag)
+				}
+			}
+			if err := fn(object); err != nil {
+				return xstorage.EndList(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Stat returns the attributes of the blob of key
+func (s *Storage) Stat(ctx context.Context, key string) (*xstorage.Object, error) {
+	resp, err := s.client.NewBlobClient(key).GetProperties(ctx, &blob.GetPropertiesOptions{CPKInfo: s.cpkInfo()})
+	if err != nil {
+		return nil, wrap(err, key, "cannot get the attributes of the object "+key)
+	}
+	object := &xstorage.Object{
+		Key:          key,
+		Size:         deref(resp.ContentLength),
+		ContentType:  deref(resp.ContentType),
+		LastModified: deref(resp.LastModified),
+		Metadata:     metadata(resp.Metadata),
+	}
+	if resp.ETag != nil {
+		object.ETag = string(*resp.ETag)
+	}
+	return object, nil
+}
+
+// PresignGet returns a SAS URL reading the blob of key. The client must
+// authenticate with a shared key, eg. a connection string.
+func (s *Storage) PresignGet(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	return s.presign(http.MethodGet, key, sas.BlobPermissions{Read: true}, xstorage.PresignOptions{Expires: opts.Expires})
+}
+
+// PresignPut returns a SAS URL creating or replacing the block blob of key.
+// SAS cannot enforce a content type or length, so it fails with a 501 when
+// opts constrain them rather than granting any upload.
+func (s *Storage) PresignPut(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	if opts.ContentType != "" || opts.ContentLength > 0 {
+		return nil, xerrors.NewNotImplementedError("Azure SAS URLs cannot enforce the content type or length of uploads")
+	}
+	return s.presign(http.MethodPut, key, sas.BlobPermissions{Create: true, Write: true}, opts)
+}
+
+func (s *Storage) presign(method, key string, permissions sas.BlobPermissions, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	if s.opts.Encryption.Key != nil {
+		return nil, xerrors.NewNotImplementedError("cannot presign the URLs of blobs encrypted with a customer provided key")
+	}
+	expires := time.Now().Add(opts.ExpiresIn())
+	u, err := s.client.NewBlobClient(key).GetSASURL(permissions, expires, nil)
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot presign the URL of "+key)
+	}
+	headers := make(http.Header)
+	if method == http.MethodPut {
+		headers.Set("X-Ms-Blob-Type", string(blob.BlobTypeBlockBlob))
+		if s.opts.Encryption.KMSKey != "" {
+			headers.Set("X-Ms-Encryption-Scope", s.opts.Encryption.KMSKey)
+		}
+	}
+	return &xstorage.PresignedURL{Method: method, URL: u, Headers: headers, Expires: expires}, nil
+}
+
+// cpkInfo returns the customer provided key of the requests, if any
+func (s *Storage) cpkInfo() *blob.CPKInfo {
+	if s.opts.Encryption.Key == nil {
+		return nil
+	}
+	sum := sha256.Sum256(s.opts.Encryption.Key)
+	return &blob.CPKInfo{
+		EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+		EncryptionKey:       to.Ptr(base64.StdEncoding.EncodeToString(s.opts.Encryption.Key)),
+		EncryptionKeySHA256: to.Ptr(base64.StdEncoding.EncodeToString(sum[:])),
+	}
+}
+
+func metadata(values map[string]*string) map[string]string {
+	m := make(map[string]string, len(values))
+	for name, value := range values {
+		m[name] = deref(value)
+	}
+	return m
+}
+
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// wrap returns the 404 error of key for the missing blobs, else a 503 error
+func wrap(err error, key, message string) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return xstorage.NotFound(key)
+	}
+	return xerrors.WrapServiceUnavailable(err, message)
+}
@@ -0,0 +1,243 @@
+// Package xgcs implements xstorage.Storage with a Google Cloud Storage
+// bucket
+package xgcs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xstorage"
+)
+
+// Options configures New
+type Options struct {
+	Bucket string
+	// CredentialsFile is the path of a service account key. The application
+	// default credentials are used without it.
+	CredentialsFile string
+	// Endpoint overrides the endpoint of GCS, without authentication, eg.
+	// "http://localhost:4443/storage/v1/" for fake-gcs-server
+	Endpoint string
+	// PartSize is the size of the chunks of the resumable uploads, above
+	// which contents are uploaded in chunks, 8MiB by default
+	PartSize int
+	// Encryption is the server-side encryption of the objects: a Cloud KMS
+	// key or a customer supplied key
+	Encryption xstorage.Encryption
+}
+
+// Storage stores the objects in a bucket
+type Storage struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	opts   Options
+}
+
+var (
+	_ xstorage.Storage   = (*Storage)(nil)
+	_ xstorage.Presigner = (*Storage)(nil)
+)
+
+// New creates a storage. Close it to release the client.
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	var options []option.ClientOption
+	if opts.CredentialsFile != "" {
+		options = append(options, option.WithCredentialsFile(opts.CredentialsFile))
+	}
+	if opts.Endpoint != "" {
+		options = append(options, option.WithEndpoint(opts.Endpoint), option.WithoutAuthentication())
+	}
+	client, err := storage.NewClient(ctx, options...)
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot create the GCS client")
+	}
+	return NewFromClient(client, opts), nil
+}
+
+// NewFromClient creates a storage with client, ignoring the connection
+// options
+func NewFromClient(client *storage.Client, opts Options) *Storage {
+	if opts.PartSize <= 0 {
+		opts.PartSize = 8 << 20
+	}
+	return &Storage{client: client, bucket: client.Bucket(opts.Bucket), opts: opts}
+}
+
+// Client returns the underlying client
+func (s *Storage) Client() *storage.Client {
+	return s.client
+}
+
+// Close closes the client
+func (s *Storage) Close() error {
+	return s.client.Close()
+}
+
+// Put uploads the content of r, with a resumable upload above PartSize
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts xstorage.PutOptions) (*xstorage.Object, error) {
+	if opts.ContentType == "" {
+		opts.ContentType = xstorage.DefaultContentType
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := s.object(key).NewWriter(ctx)
+	w.ContentType = opts.ContentType
+	w.Metadata = opts.Metadata
+	w.ChunkSize = s.opts.PartSize
+	if s.opts.Encryption.KMSKey != "" {
+		w.KMSKeyName = s.opts.Encryption.KMSKey
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		// Cancelling the context aborts the upload
+		cancel()
+		_ = w.Close()
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot upload the object "+key)
+	}
+	if err := w.Close(); err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot upload the object "+key)
+	}
+	return fromAttrs(w.Attrs()), nil
+}
+
+// Get downloads the object of key, the generation whose attributes it
+// returns
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, *xstorage.Object, error) {
+	attrs, err := s.object(key).Attrs(ctx)
+	if err != nil {
+		return nil, nil, wrap(err, key, "cannot get the object "+key)
+	}
+	r, err := s.object(key).Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, nil, wrap(err, key, "cannot get the object "+key)
+	}
+	return r, fromAttrs(attrs), nil
+}
+
+// Delete deletes the object of key
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.bucket.Object(key).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return xerrors.WrapServiceUnavailable(err, "cannot delete the object "+key)
+	}
+	return nil
+}
+
+// List lists the objects of prefix
+func (s *Storage) List(ctx context.Context, prefix string, fn func(*xstorage.Object) error) error {
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "ContentType", "Etag", "Updated"}); err != nil {
+		return xerrors.WrapInternalServerError(err, "cannot select the attributes of the objects")
+	}
+	it := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot list the objects of "+prefix)
+		}
+		object := fromAttrs(attrs)
+		object.Metadata = nil
+		if err := fn(object); err != nil {
+			return xstorage.EndList(err)
+		}
+	}
+}
+
+// Stat returns the attributes of the object of key
+func (s *Storage) Stat(ctx context.Context, key string) (*xstorage.Object, error) {
+	attrs, err := s.object(key).Attrs(ctx)
+	if err != nil {
+		return nil, wrap(err, key, "cannot get the attributes of the object "+key)
+	}
+	return fromAttrs(attrs), nil
+}
+
+// PresignGet returns a V4 signed URL downloading the object of key. The
+// client must sign with a service account: its key or the IAM API.
+func (s *Storage) PresignGet(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	return s.presign(http.MethodGet, key, xstorage.PresignOptions{Expires: opts.Expires})
+}
+
+// PresignPut returns a V4 signed URL uploading the object of key, signing
+// its content type and length
+func (s *Storage) PresignPut(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	return s.presign(http.MethodPut, key, opts)
+}
+
+func (s *Storage) presign(method, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	if s.opts.Encryption.Key != nil {
+		return nil, xerrors.NewNotImplementedError("cannot presign the URLs of objects encrypted with a customer supplied key")
+	}
+	expires := time.Now().Add(opts.ExpiresIn())
+	headers := make(http.Header)
+	if opts.ContentType != "" {
+		headers.Set("Content-Type", opts.ContentType)
+	}
+	if opts.ContentLength > 0 {
+		length := strconv.FormatInt(opts.ContentLength, 10)
+		headers.Set("X-Goog-Content-Length-Range", length+","+length)
+	}
+	if method == http.MethodPut && s.opts.Encryption.KMSKey != "" {
+		headers.Set("X-Goog-Encryption-Kms-Key-Name", s.opts.Encryption.KMSKey)
+	}
+	var signed []string
+	for name := range headers {
+		if name != "Content-Type" {
+			signed = append(signed, strings.ToLower(name)+":"+headers.Get(name))
+		}
+	}
+	sort.Strings(signed)
+	u, err := s.bucket.SignedURL(key, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      method,
+		Expires:     expires,
+		ContentType: opts.ContentType,
+		Headers:     signed,
+	})
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot presign the URL of "+key)
+	}
+	return &xstorage.PresignedURL{Method: method, URL: u, Headers: headers, Expires: expires}, nil
+}
+
+// object returns the handle of the object of key, with the customer
+// supplied key if any
+func (s *Storage) object(key string) *storage.ObjectHandle {
+	object := s.bucket.Object(key)
+	if s.opts.Encryption.Key != nil {
+		object = object.Key(s.opts.Encryption.Key)
+	}
+	return object
+}
+
+func fromAttrs(attrs *storage.ObjectAttrs) *xstorage.Object {
+	return &xstorage.Object{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		ContentType:  attrs.ContentType,
+		ETag:         attrs.Etag,
+		LastModified: attrs.Updated,
+		Metadata:     attrs.Metadata,
+	}
+}
+
+// wrap returns the 404 error of key for the missing objects, else a 503
+// error
+func wrap(err error, key, message string) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return xstorage.NotFound(key)
+	}
+	return xerrors.WrapServiceUnavailable(err, message)
+}
@@ -0,0 +1,276 @@
+// Package xs3 implements xstorage.Storage with an AWS S3 bucket, or an S3
+// compatible one such as MinIO
+package xs3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xstorage"
+)
+
+// Options configures New
+type Options struct {
+	Bucket string
+	// Region defaults to the one of the environment, us-east-1 with an
+	// Endpoint
+	Region string
+	// Endpoint overrides the endpoint of S3, eg. "http://localhost:9000"
+	// for MinIO
+	Endpoint string
+	// PathStyle addresses the bucket in the path rather than the host name,
+	// as most S3 compatible services need
+	PathStyle bool
+	// AccessKeyID and SecretAccessKey are static credentials. The default
+	// credential chain is used without them.
+	AccessKeyID     string
+	SecretAccessKey string
+	// PartSize is the size of the parts of the multipart uploads, above
+	// which contents are uploaded in parts, 8MiB by default
+	PartSize int64
+	// Concurrency is the number of parts uploaded at once, 4 by default
+	Concurrency int
+	// Encryption is the server-side encryption of the objects: SSE-KMS or
+	// SSE-C
+	Encryption xstorage.Encryption
+}
+
+// Storage stores the objects in a bucket
+type Storage struct {
+	client    *s3.Client
+	uploader  *manager.Uploader
+	presigner *s3.PresignClient
+	opts      Options
+}
+
+var (
+	_ xstorage.Storage   = (*Storage)(nil)
+	_ xstorage.Presigner = (*Storage)(nil)
+)
+
+// New creates a storage from the AWS configuration of the environment
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	var options []func(*config.LoadOptions) error
+	if opts.Region == "" && opts.Endpoint != "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Region != "" {
+		options = append(options, config.WithRegion(opts.Region))
+	}
+	if opts.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, xerrors.WrapServiceUnavailable(err, "cannot load the AWS configuration")
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.PathStyle
+	})
+	return NewFromClient(client, opts), nil
+}
+
+// NewFromClient creates a storage with client, ignoring the connection
+// options
+func NewFromClient(client *s3.Client, opts Options) *Storage {
+	if opts.PartSize < manager.MinUploadPartSize {
+		opts.PartSize = 8 << 20
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = opts.PartSize
+		u.Concurrency = opts.Concurrency
+	})
+	return &Storage{client: client, uploader: uploader, presigner: s3.NewPresignClient(client), opts: opts}
+}
+
+// Client returns the underlying client
+func (s *Storage) Client() *s3.Client {
+	return s.client
+}
+
+// Put uploads the content of r, in parts above PartSize
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts xstorage.PutOptions) (*xstorage.Object, error) {
+	if opts.ContentType == "" {
+		opts.ContentType = xstorage.DefaultContentType
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.opts.Bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(opts.ContentType),
+		Metadata:    opts.Metadata,
+	}
+	if opts.Size > 0 {
+		input.ContentLength = aws.Int64(opts.Size)
+	}
+	switch {
+	case s.opts.Encryption.Key != nil:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(s.opts.Encryption.Key)
+	case s.opts.Encryption.KMSKey != "":
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(s.opts.Encryption.KMSKey)
+	}
+	if _, err := s.uploader.Upload(ctx, input); err != nil {
+		return nil, wrap(err, key, "cannot upload the object "+key)
+	}
+	return s.Stat(ctx, key)
+}
+
+// Get downloads the object of key
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, *xstorage.Object, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(s.opts.Bucket), Key: aws.String(key)}
+	if s.opts.Encryption.Key != nil {
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(s.opts.Encryption.Key)
+	}
+	out, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, nil, wrap(err, key, "cannot get the object "+key)
+	}
+	return out.Body, &xstorage.Object{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+// Delete deletes the object of key
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.opts.Bucket), Key: aws.String(key)}); err != nil {
+		if err = wrap(err, key, "cannot delete the object "+key); xstorage.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// List lists the objects of prefix, by pages of 1000
+func (s *Storage) List(ctx context.Context, prefix string, fn func(*xstorage.Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: aws.String(s.opts.Bucket), Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return xerrors.WrapServiceUnavailable(err, "cannot list the objects of "+prefix)
+		}
+		for _, object := range page.Contents {
+			err := fn(&xstorage.Object{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				ETag:         aws.ToString(object.ETag),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+			if err != nil {
+				return xstorage.EndList(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Stat returns the attributes of the object of key
+func (s *Storage) Stat(ctx context.Context, key string) (*xstorage.Object, error) {
+	input := &s3.HeadObjectInput{Bucket: aws.String(s.opts.Bucket), Key: aws.String(key)}
+	if s.opts.Encryption.Key != nil {
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(s.opts.Encryption.Key)
+	}
+	out, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		return nil, wrap(err, key, "cannot get the attributes of the object "+key)
+	}
+	return &xstorage.Object{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+// PresignGet returns a URL downloading the object of key, signed with the
+// credentials of the client. Objects encrypted with a customer provided key
+// cannot be, since the key would be handed out.
+func (s *Storage) PresignGet(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	if s.opts.Encryption.Key != nil {
+		return nil, xerrors.NewNotImplementedError("cannot presign the URLs of objects encrypted with a customer provided key")
+	}
+	expires := time.Now().Add(opts.ExpiresIn())
+	request, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.opts.Bucket), Key: aws.String(key)}, s3.WithPresignExpires(opts.ExpiresIn()))
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot presign the download of "+key)
+	}
+	return presigned(request, expires), nil
+}
+
+// PresignPut returns a URL uploading the object of key, signing its content
+// type and length, with the encryption of the storage
+func (s *Storage) PresignPut(ctx context.Context, key string, opts xstorage.PresignOptions) (*xstorage.PresignedURL, error) {
+	if s.opts.Encryption.Key != nil {
+		return nil, xerrors.NewNotImplementedError("cannot presign the URLs of objects encrypted with a customer provided key")
+	}
+	input := &s3.PutObjectInput{Bucket: aws.String(s.opts.Bucket), Key: aws.String(key)}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentLength > 0 {
+		input.ContentLength = aws.Int64(opts.ContentLength)
+	}
+	if s.opts.Encryption.KMSKey != "" {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(s.opts.Encryption.KMSKey)
+	}
+	expires := time.Now().Add(opts.ExpiresIn())
+	request, err := s.presigner.PresignPutObject(ctx, input, s3.WithPresignExpires(opts.ExpiresIn()))
+	if err != nil {
+		return nil, xerrors.WrapInternalServerError(err, "cannot presign the upload of "+key)
+	}
+	return presigned(request, expires), nil
+}
+
+// presigned returns the URL of request, with the signed headers the client
+// must send
+func presigned(request *v4.PresignedHTTPRequest, expires time.Time) *xstorage.PresignedURL {
+	headers := request.SignedHeader.Clone()
+	headers.Del("Host")
+	return &xstorage.PresignedURL{Method: request.Method, URL: request.URL, Headers: headers, Expires: expires}
+}
+
+// customerKey returns the SSE-C algorithm, key and key MD5 headers of key
+func customerKey(key []byte) (*string, *string, *string) {
+	sum := md5.Sum(key)
+	return aws.String("AES256"), aws.String(base64.StdEncoding.EncodeToString(key)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// wrap returns the 404 error of key for the responses not found, else a
+// 503 error
+func wrap(err error, key, message string) error {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound {
+		return xstorage.NotFound(key)
+	}
+	return xerrors.WrapServiceUnavailable(err, message)
+}
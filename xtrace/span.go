@@ -0,0 +1,83 @@
+package xtrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xrest"
+)
+
+// instrumentation is the name of the tracer of Start
+const instrumentation = "github.com/XandaLtd/xutils-go/xtrace"
+
+// Start starts a span of the global tracer provider with attrs, the calling
+// function as code.function.name and the request id of ctx. End it with End:
+//
+//	func (s *Service) CreateOrder(ctx context.Context, order Order) (err error) {
+//		ctx, span := xtrace.Start(ctx, "CreateOrder", attribute.String("order.id", order.ID))
+//		defer xtrace.End(span, &err)
+//		...
+//	}
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		if function := runtime.FuncForPC(pc); function != nil {
+			attrs = append(attrs, attribute.String("code.function.name", function.Name()))
+		}
+	}
+	if requestID, ok := ctx.Value(xrest.RequestIDKey).(string); ok && requestID != "" {
+		attrs = append(attrs, attribute.String("request.id", requestID))
+	}
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording the error err points to, if any. xerrors errors
+// add their status code and error code; only 5xx and the errors that are
+// not a RestErr set the span status to Error, as 4xx are the caller's.
+func End(span trace.Span, err *error) {
+	defer span.End()
+	if err == nil || *err == nil {
+		return
+	}
+	Error(span, *err)
+}
+
+// Error records err on span, see End
+func Error(span trace.Span, err error) {
+	span.RecordError(err)
+	var restErr xerrors.RestErr
+	if !errors.As(err, &restErr) {
+		span.SetAttributes(attribute.String("error.type", fmt.Sprintf("%T", err)))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	errorType := restErr.Code()
+	if errorType == "" {
+		errorType = strconv.Itoa(restErr.StatusCode())
+	}
+	span.SetAttributes(
+		attribute.String("error.type", errorType),
+		attribute.Int("http.response.status_code", restErr.StatusCode()),
+	)
+	if restErr.StatusCode() >= 500 {
+		span.SetStatus(codes.Error, restErr.Message())
+	}
+}
+
+// TraceID returns the trace id of the span of ctx, eg. to log it, or an
+// empty string without span
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
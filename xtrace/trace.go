@@ -0,0 +1,217 @@
+// Package xtrace sets up OpenTelemetry tracing in one call and starts spans
+// with the standard attributes of the services
+package xtrace
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Exporters of the spans
+const (
+	// OTLPGRPC exports with OTLP over gRPC, to localhost:4317 by default
+	OTLPGRPC = "otlp-grpc"
+	// OTLPHTTP exports with OTLP over HTTP, to localhost:4318 by default
+	OTLPHTTP = "otlp-http"
+	// Jaeger exports to Jaeger with OTLP over HTTP, which it receives since
+	// 1.35, the dedicated Jaeger exporter being deprecated
+	Jaeger = "jaeger"
+	// None exports nothing, eg. in tests, spans still being created and
+	// propagated
+	None = "none"
+)
+
+// Options configures Setup. Empty values are read from the standard
+// OTEL_* environment variables.
+type Options struct {
+	// ServiceName defaults to OTEL_SERVICE_NAME, then to the executable name
+	ServiceName    string
+	ServiceVersion string
+	// Environment is the deployment.environment.name, eg. "production"
+	Environment string
+	// Attributes are added to the resource, over OTEL_RESOURCE_ATTRIBUTES
+	Attributes map[string]string
+	// Exporter defaults to OTLPHTTP when OTEL_EXPORTER_OTLP_PROTOCOL is
+	// http/protobuf, OTLPGRPC otherwise
+	Exporter string
+	// Endpoint is the host:port of the collector, by default read by the
+	// exporter from OTEL_EXPORTER_OTLP_ENDPOINT
+	Endpoint string
+	// Insecure disables TLS, eg. for a collector on localhost
+	Insecure bool
+	// Headers are sent with the exports, eg. an API key
+	Headers map[string]string
+	// SampleRatio is the ratio of the traces started by the service that
+	// are sampled, the others following the decision of their parent. Zero
+	// reads OTEL_TRACES_SAMPLER, sampling everything by default, negative
+	// samples nothing.
+	SampleRatio float64
+	// ShutdownTimeout bounds the flush of the spans on Shutdown, 5s by
+	// default
+	ShutdownTimeout time.Duration
+}
+
+// Provider is the tracer provider set up by Setup
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	timeout  time.Duration
+}
+
+// Setup creates the tracer provider of the service and installs it, with
+// the W3C trace context and baggage propagators, as the global one. Spans
+// are exported in batches until Shutdown:
+//
+//	provider, err := xtrace.Setup(ctx, xtrace.Options{ServiceVersion: version})
+//	if err != nil {
+//		xlogger.Fatal("cannot set up tracing", err)
+//	}
+//	defer provider.Shutdown(context.Background())
+func Setup(ctx context.Context, opts Options) (*Provider, error) {
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = 5 * time.Second
+	}
+	res, err := newResource(ctx, opts)
+	if err != nil {
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot create the tracing resource")
+	}
+
+	providerOptions := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	switch {
+	case opts.SampleRatio < 0:
+		providerOptions = append(providerOptions, sdktrace.WithSampler(sdktrace.NeverSample()))
+	case opts.SampleRatio > 0:
+		providerOptions = append(providerOptions, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))))
+	}
+	if opts.Exporter != None {
+		exporter, err := newExporter(ctx, opts)
+		if err != nil {
+			return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot create the span exporter")
+		}
+		providerOptions = append(providerOptions, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(providerOptions...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		xlogger.Warning("xtrace: "+err.Error(), zap.String("service", serviceName(opts)))
+	}))
+	return &Provider{provider: provider, timeout: opts.ShutdownTimeout}, nil
+}
+
+func newResource(ctx context.Context, opts Options) (*resource.Resource, error) {
+	attributes := []attribute.KeyValue{semconv.ServiceName(serviceName(opts))}
+	if opts.ServiceVersion != "" {
+		attributes = append(attributes, semconv.ServiceVersion(opts.ServiceVersion))
+	}
+	if opts.Environment != "" {
+		attributes = append(attributes, semconv.DeploymentEnvironmentName(opts.Environment))
+	}
+	for key, value := range opts.Attributes {
+		attributes = append(attributes, attribute.String(key, value))
+	}
+	// The options are applied in order, the attributes overriding the
+	// environment
+	return resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithFromEnv(),
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(attributes...),
+	)
+}
+
+func serviceName(opts Options) string {
+	if opts.ServiceName != "" {
+		return opts.ServiceName
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		return name
+	}
+	if executable, err := os.Executable(); err == nil {
+		return filepath.Base(executable)
+	}
+	return "unknown_service"
+}
+
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, error) {
+	exporter := opts.Exporter
+	if exporter == "" {
+		exporter = OTLPGRPC
+		if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol == "http/protobuf" || protocol == "http/json" {
+			exporter = OTLPHTTP
+		}
+	}
+
+	switch exporter {
+	case OTLPGRPC:
+		var options []otlptracegrpc.Option
+		if opts.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			options = append(options, otlptracegrpc.WithHeaders(opts.Headers))
+		}
+		return otlptracegrpc.New(ctx, options...)
+	case OTLPHTTP, Jaeger:
+		var options []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			options = append(options, otlptracehttp.WithHeaders(opts.Headers))
+		}
+		return otlptracehttp.New(ctx, options...)
+	}
+	return nil, xerrors.NewInternalServerError("unknown exporter " + exporter)
+}
+
+// Tracer returns the tracer name of the provider, eg. the import path of
+// the instrumented package
+func (p *Provider) Tracer(name string) trace.Tracer {
+	return p.provider.Tracer(name)
+}
+
+// ForceFlush exports the spans ended and not yet exported
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	if err := p.provider.ForceFlush(ctx); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot flush the spans")
+	}
+	return nil
+}
+
+// Shutdown exports the remaining spans, within the ShutdownTimeout, and
+// stops the provider
+func (p *Provider) Shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if err := p.provider.Shutdown(ctx); err != nil {
+		return xerrors.WrapServiceUnavailable(err, "cannot flush the spans at shutdown")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package xvalidate
+
+import (
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Checker validates input with programmatic rules, collecting every violation
+//
+//	err := xvalidate.Check().
+//		Field("email", req.Email, "required,email").
+//		Field("phone", req.Phone, "omitempty,phone").
+//		Rule(req.End.After(req.Start), "end", "after", "end must be after start").
+//		Err()
+type Checker struct {
+	validator  *Validator
+	violations xerrors.Violations
+	err        xerrors.RestErr
+}
+
+// Check starts a programmatic validation
+func (v *Validator) Check() *Checker {
+	v.started()
+	return &Checker{validator: v}
+}
+
+// Check starts a programmatic validation with the Default validator
+func Check() *Checker {
+	return Default().Check()
+}
+
+// Field checks value against rules, eg. "required,min=3"
+func (c *Checker) Field(field string, value interface{}, rules string) *Checker {
+	c.record(c.validator.collect(&c.violations, field, c.validator.validate.Var(value, rules)))
+	return c
+}
+
+// Struct checks s against its struct tags, its fields being prefixed with
+// field unless empty
+func (c *Checker) Struct(field string, s interface{}) *Checker {
+	c.record(c.validator.collectStruct(&c.violations, field, s))
+	return c
+}
+
+// Rule records a violation of rule unless ok, eg. for a rule across fields
+func (c *Checker) Rule(ok bool, field string, rule string, message string) *Checker {
+	if !ok {
+		c.violations.Add(field, rule, message, nil)
+	}
+	return c
+}
+
+// RequiredIf records a violation of field unless it is set when condition holds
+func (c *Checker) RequiredIf(condition bool, field string, value interface{}) *Checker {
+	if condition {
+		c.Field(field, value, "required")
+	}
+	return c
+}
+
+// Violations returns the violations recorded so far
+func (c *Checker) Violations() xerrors.Violations {
+	return c.violations
+}
+
+// Err returns a ValidationError holding every violation, or nil when there
+// are none. Values that cannot be validated, eg. a nil struct, are reported
+// as a 500 while undefined rules panic.
+func (c *Checker) Err() xerrors.RestErr {
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.violations.Err(c.validator.opts.Message); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Checker) record(err xerrors.RestErr) {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
@@ -0,0 +1,191 @@
+package xvalidate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Rules and rule sets are registered once, typically in init functions, and
+// referenced from struct tags across services:
+//
+//	func init() {
+//		xvalidate.MustRegisterRule("tenant_slug", xvalidate.Pattern(`^[a-z][a-z0-9-]{2,62}$`), "must be a valid tenant slug")
+//		xvalidate.RegisterRuleSet("request", "validate")
+//		xvalidate.RegisterRuleSet("persistence", "request", "persist")
+//	}
+//
+//	type Tenant struct {
+//		Slug string `json:"slug" validate:"required,tenant_slug"`
+//		Name string `json:"name" validate:"required" persist:"max=255"`
+//	}
+//
+//	err := xvalidate.RuleSet("persistence").Struct(tenant)
+//
+// Validators created with New know the rules registered before; Default and
+// the rule set validators learn the ones registered later as well, until
+// either of them validates a first value. go-playground validators cannot be
+// modified while in use, so rules are no longer accepted from then on.
+
+var (
+	registryMu    sync.Mutex
+	registered    = map[string]validator.Func{}
+	aliases       = map[string]string{}
+	ruleSets      = map[string][]string{}
+	setValidators = map[string]*Validator{}
+	// validating is set by the first validation of a managed validator
+	validating atomic.Bool
+)
+
+// RegisterRule registers a rule named name, used as name or name=param in
+// struct tags, with the message of its violations, see SetMessage. It fails
+// when the name is taken or Default or a rule set validator has validated a
+// value already.
+func RegisterRule(name string, rule validator.Func, message string) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if err := registrable(name); err != nil {
+		return err
+	}
+	for _, engine := range managedEngines() {
+		if err := engine.RegisterValidation(name, rule, true); err != nil {
+			return fmt.Errorf("xvalidate: cannot register validation rule %s: %w", name, err)
+		}
+	}
+	registered[name] = rule
+	if message != "" {
+		SetMessage(name, message)
+	}
+	return nil
+}
+
+// MustRegisterRule is RegisterRule panicking on errors, for init functions
+func MustRegisterRule(name string, rule validator.Func, message string) {
+	if err := RegisterRule(name, rule, message); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterAlias registers name as a shorthand of rules, eg. "password" for
+// "min=12,max=128". Violations are reported under name. It fails as
+// RegisterRule does.
+func RegisterAlias(name string, rules string, message string) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if err := registrable(name); err != nil {
+		return err
+	}
+	for _, engine := range managedEngines() {
+		engine.RegisterAlias(name, rules)
+	}
+	aliases[name] = rules
+	if message != "" {
+		SetMessage(name, message)
+	}
+	return nil
+}
+
+// RegisterRuleSet registers a rule set checking the rules of several struct
+// tags, in order. An entry naming a registered rule set stands for its tags,
+// so sets compose, eg. "persistence" checking the tags of "request" and a
+// persist tag.
+func RegisterRuleSet(name string, tags ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		included, ok := ruleSets[tag]
+		if !ok {
+			included = []string{tag}
+		}
+		for _, t := range included {
+			if !seen[t] {
+				seen[t] = true
+				expanded = append(expanded, t)
+			}
+		}
+	}
+	ruleSets[name] = expanded
+	delete(setValidators, name)
+}
+
+// RuleSet returns the validator of a registered rule set. It panics when the
+// set is unknown, as an undefined rule does.
+func RuleSet(name string) *Validator {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if v, ok := setValidators[name]; ok {
+		return v
+	}
+	tags, ok := ruleSets[name]
+	if !ok || len(tags) == 0 {
+		panic(fmt.Sprintf("xvalidate: unknown rule set %s", name))
+	}
+	v := newValidator(Options{TagName: tags[0], Tags: tags[1:]})
+	v.managed = true
+	setValidators[name] = v
+	return v
+}
+
+// Pattern returns a rule accepting the strings matching expr. It panics when
+// expr is invalid.
+func Pattern(expr string) validator.Func {
+	pattern := regexp.MustCompile(expr)
+	return String(pattern.MatchString)
+}
+
+// String returns a rule accepting the strings for which valid returns true
+func String(valid func(s string) bool) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return fl.Field().Kind() == reflect.String && valid(fl.Field().String())
+	}
+}
+
+// register teaches engine the registered rules, registryMu being held
+func register(engine *validator.Validate) {
+	for name, rule := range registered {
+		// The rules were registered successfully on other engines
+		_ = engine.RegisterValidation(name, rule, true)
+	}
+	for name, rules := range aliases {
+		engine.RegisterAlias(name, rules)
+	}
+}
+
+// registrable fails when name is taken or the managed engines are in use,
+// registryMu being held
+func registrable(name string) error {
+	if taken(name) {
+		return fmt.Errorf("xvalidate: validation rule %s is already registered", name)
+	}
+	if validating.Load() {
+		return fmt.Errorf("xvalidate: validation rule %s registered after the first validation, register it in an init function", name)
+	}
+	return nil
+}
+
+// taken reports whether a rule is named name, registryMu being held
+func taken(name string) bool {
+	_, builtin := builtinRules[name]
+	_, rule := registered[name]
+	_, alias := aliases[name]
+	return builtin || rule || alias
+}
+
+// managedEngines returns the engines of Default and the rule set validators
+// created so far, registryMu being held
+func managedEngines() []*validator.Validate {
+	var engines []*validator.Validate
+	if defaultValidator != nil {
+		engines = append(engines, defaultValidator.engines...)
+	}
+	for _, v := range setValidators {
+		engines = append(engines, v.engines...)
+	}
+	return engines
+}
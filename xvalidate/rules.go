@@ -0,0 +1,124 @@
+package xvalidate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Enum is implemented by enumerated types checked by the enum rule
+type Enum interface {
+	IsValid() bool
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
+// builtinRules are the rules this package adds to go-playground/validator
+var builtinRules = map[string]validator.Func{
+	// phone accepts international numbers, spaces, dots, dashes and
+	// parentheses aside. The trunk prefix "(0)" is rejected, as it must not
+	// be dialled after the country code, eg. "+33 (0)1 23 45 67 89" is not
+	// valid but "+33 1 23 45 67 89" is. Use e164 for the strict format.
+	"phone": func(fl validator.FieldLevel) bool {
+		if fl.Field().Kind() != reflect.String || strings.Contains(fl.Field().String(), "(0)") {
+			return false
+		}
+		number := strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '.', '-', '(', ')':
+				return -1
+			}
+			return r
+		}, fl.Field().String())
+		return phonePattern.MatchString(number)
+	},
+	// enum accepts values of a type implementing Enum, on a value or pointer
+	// receiver, for which IsValid returns true
+	"enum": func(fl validator.FieldLevel) bool {
+		field := fl.Field()
+		if !field.CanInterface() {
+			return false
+		}
+		if enum, ok := field.Interface().(Enum); ok {
+			return enum.IsValid()
+		}
+		pointer := reflect.New(field.Type())
+		pointer.Elem().Set(field)
+		if enum, ok := pointer.Interface().(Enum); ok {
+			return enum.IsValid()
+		}
+		return false
+	},
+}
+
+var (
+	messagesMu sync.RWMutex
+	messages   = map[string]string{
+		"phone":            "must be a valid phone number",
+		"e164":             "must be a valid phone number in the E.164 format",
+		"enum":             "must be one of the allowed values",
+		"uuid4":            "must be a valid UUID",
+		"alpha":            "must contain letters only",
+		"alphanum":         "must contain letters and digits only",
+		"numeric":          "must be numeric",
+		"eqfield":          "must be equal to %s",
+		"nefield":          "must be different from %s",
+		"gtfield":          "must be greater than %s",
+		"gtefield":         "must be greater than or equal to %s",
+		"ltfield":          "must be less than %s",
+		"ltefield":         "must be less than or equal to %s",
+		"required_if":      "is required when %s",
+		"required_unless":  "is required unless %s",
+		"required_with":    "is required when %s is set",
+		"required_without": "is required when %s is not set",
+		"excluded_with":    "must not be set when %s is set",
+		"excluded_without": "must not be set when %s is not set",
+	}
+)
+
+// SetMessage sets the message of the violations of a rule. The format may
+// contain a %s verb, replaced by the rule parameter. Rules without a message
+// here use the one of xerrors, see xerrors.SetValidationMessage.
+func SetMessage(rule string, format string) {
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+	messages[rule] = format
+}
+
+// Message returns the message of a violation of rule, without the field
+// name, eg. "must be a valid phone number"
+func Message(rule string, param string) string {
+	messagesMu.RLock()
+	format, ok := messages[rule]
+	messagesMu.RUnlock()
+	if !ok {
+		return xerrors.ValidationMessage(rule, param)
+	}
+	if strings.Contains(format, "%s") {
+		if rule == "required_if" || rule == "required_unless" {
+			param = conditions(param)
+		}
+		return fmt.Sprintf(format, param)
+	}
+	return format
+}
+
+// conditions spells the "Field value" pairs of required_if and
+// required_unless, eg. "Kind is b and Plan is pro"
+func conditions(param string) string {
+	words := strings.Fields(param)
+	var pairs []string
+	for i := 0; i+1 < len(words); i += 2 {
+		pairs = append(pairs, words[i]+" is "+words[i+1])
+	}
+	if len(pairs) == 0 {
+		return param
+	}
+	return strings.Join(pairs, " and ")
+}
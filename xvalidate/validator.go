@@ -0,0 +1,192 @@
+// Package xvalidate validates structs and values against validate tags and
+// programmatic rules, returning xerrors validation errors
+package xvalidate
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Options configures a Validator
+type Options struct {
+	// TagName is the struct tag holding the rules, "validate" by default
+	TagName string
+	// Tags are more struct tags checked after TagName, eg. "persist" for
+	// rules applying only before saving. See RegisterRuleSet.
+	Tags []string
+	// Message is the message of the validation errors, "validation failed" by default
+	Message string
+}
+
+// Validator checks values against rules such as "required,email". Besides the
+// rules of go-playground/validator, it knows the rules of this package, see
+// rules.go, and the registered ones, see RegisterRule. Field names are taken
+// from the json tags.
+type Validator struct {
+	opts Options
+	// validate checks TagName and the explicit rules, engines every tag
+	validate *validator.Validate
+	engines  []*validator.Validate
+	// managed is set for Default and the rule set validators, whose engines
+	// learn the rules registered after their creation
+	managed bool
+}
+
+var (
+	defaultOnce      sync.Once
+	defaultValidator *Validator
+)
+
+// New creates a validator
+func New(opts Options) *Validator {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return newValidator(opts)
+}
+
+// newValidator creates a validator, registryMu being held
+func newValidator(opts Options) *Validator {
+	if opts.TagName == "" {
+		opts.TagName = "validate"
+	}
+	if opts.Message == "" {
+		opts.Message = "validation failed"
+	}
+	v := &Validator{opts: opts}
+	for _, tag := range append([]string{opts.TagName}, opts.Tags...) {
+		engine := newEngine(tag)
+		register(engine)
+		v.engines = append(v.engines, engine)
+	}
+	v.validate = v.engines[0]
+	return v
+}
+
+// newEngine creates a go-playground validator of the rules of tag
+func newEngine(tag string) *validator.Validate {
+	validate := validator.New()
+	validate.SetTagName(tag)
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return field.Name
+		}
+		return name
+	})
+	for name, rule := range builtinRules {
+		// The names are unique and the functions not nil, so this cannot fail
+		_ = validate.RegisterValidation(name, rule, true)
+	}
+	return validate
+}
+
+// Default returns the validator used by the package level functions
+func Default() *Validator {
+	defaultOnce.Do(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		defaultValidator = newValidator(Options{})
+		defaultValidator.managed = true
+	})
+	return defaultValidator
+}
+
+// Engine returns the go-playground validator of TagName, eg. to register
+// struct level rules
+func (v *Validator) Engine() *validator.Validate {
+	return v.validate
+}
+
+// Struct checks s against its struct tags, returning a ValidationError
+// listing every violation or nil when s is valid. Values are not echoed
+// back, as they may be secrets.
+func (v *Validator) Struct(s interface{}) xerrors.RestErr {
+	v.started()
+	var violations xerrors.Violations
+	if err := v.collectStruct(&violations, "", s); err != nil {
+		return err
+	}
+	return violations.Err(v.opts.Message)
+}
+
+// Var checks a single value against rules, naming field its violations
+func (v *Validator) Var(field string, value interface{}, rules string) xerrors.RestErr {
+	v.started()
+	var violations xerrors.Violations
+	if err := v.collect(&violations, field, v.validate.Var(value, rules)); err != nil {
+		return err
+	}
+	return violations.Err(v.opts.Message)
+}
+
+// started stops the registration of rules once a managed validator is in use
+func (v *Validator) started() {
+	if v.managed && !validating.Load() {
+		validating.Store(true)
+	}
+}
+
+// collectStruct records the violations of the rules of every tag of s
+func (v *Validator) collectStruct(violations *xerrors.Violations, prefix string, s interface{}) xerrors.RestErr {
+	for _, engine := range v.engines {
+		if err := v.collect(violations, prefix, engine.Struct(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect records the violations of err, the error of a go-playground
+// validation. Fields are prefixed with prefix. Errors other than violations,
+// eg. an invalid rule, are returned as a 500.
+func (v *Validator) collect(violations *xerrors.Violations, prefix string, err error) xerrors.RestErr {
+	if err == nil {
+		return nil
+	}
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot validate")
+	}
+	for _, fieldError := range fieldErrors {
+		field := fieldError.Namespace()
+		if dot := strings.IndexByte(field, '.'); dot >= 0 {
+			// Drop the name of the validated struct
+			field = field[dot+1:]
+		} else {
+			field = ""
+		}
+		field = join(prefix, field)
+		violations.Add(field, fieldError.Tag(), strings.TrimSpace(field+" "+Message(fieldError.Tag(), fieldError.Param())), nil)
+	}
+	return nil
+}
+
+func join(prefix string, field string) string {
+	switch {
+	case prefix == "":
+		return field
+	case field == "":
+		return prefix
+	}
+	return prefix + "." + field
+}
+
+// Struct checks s with the Default validator
+func Struct(s interface{}) xerrors.RestErr {
+	return Default().Struct(s)
+}
+
+// Var checks value with the Default validator
+func Var(field string, value interface{}, rules string) xerrors.RestErr {
+	return Default().Var(field, value, rules)
+}
@@ -0,0 +1,283 @@
+package xworker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Pipeline ties streaming stages together: the first error of a stage
+// cancels the context of every stage, which then stop and close their output.
+//
+//	p, ctx := xworker.NewPipeline(ctx)
+//	ids := xworker.Source(p, userIDs)
+//	users := xworker.Map(p, ids, loadUser, xworker.StageOptions{Workers: 8, Ordered: true})
+//	err := xworker.Sink(p, users, saveUser)
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+// StageOptions configures a stage
+type StageOptions struct {
+	// Workers is the number of items processed at once, 1 at least
+	Workers int
+	// Buffer is the capacity of the output channel
+	Buffer int
+	// Ordered keeps the order of the input in the output. Items processed
+	// ahead of a slow one wait for it, at most Workers + Buffer of them.
+	Ordered bool
+}
+
+// NewPipeline creates a pipeline, returning the context canceled on the
+// first error of a stage
+func NewPipeline(ctx context.Context) (*Pipeline, context.Context) {
+	p := &Pipeline{}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p, p.ctx
+}
+
+// Context returns the context of the stages
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Fail records err, when it is the first error, and cancels every stage
+func (p *Pipeline) Fail(err error) {
+	if err == nil {
+		return
+	}
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+// Wait waits for every stage to return and reports the first error, or the
+// error of the parent context when it was canceled
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.once.Do(func() {
+		p.err = p.ctx.Err()
+	})
+	p.cancel()
+	return p.err
+}
+
+// Go runs fn as a stage of the pipeline, recording its error. Panics are
+// recorded as a 500 xerrors error.
+func (p *Pipeline) Go(fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				p.Fail(xerrors.FromPanic(recovered))
+			}
+		}()
+		p.Fail(fn(p.ctx))
+	}()
+}
+
+// send sends value unless the pipeline is canceled, reporting whether it did
+func send[T any](ctx context.Context, out chan<- T, value T) bool {
+	select {
+	case out <- value:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Source emits items
+func Source[T any](p *Pipeline, items []T) <-chan T {
+	out := make(chan T)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for _, item := range items {
+			if !send(ctx, out, item) {
+				return nil
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// Map applies fn to every item of in with opts.Workers workers
+func Map[In any, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, item In) (Out, error), opts StageOptions) <-chan Out {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Ordered {
+		return mapOrdered(p, in, fn, opts)
+	}
+
+	out := make(chan Out, opts.Buffer)
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		workers.Add(1)
+		p.Go(func(ctx context.Context) error {
+			defer workers.Done()
+			for item := range receive(ctx, in) {
+				value, err := fn(ctx, item)
+				if err != nil {
+					return err
+				}
+				if !send(ctx, out, value) {
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	go func() {
+		workers.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// mapOrdered gives each item a slot, emitted in input order once filled
+func mapOrdered[In any, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, item In) (Out, error), opts StageOptions) <-chan Out {
+	type task struct {
+		item In
+		slot chan Out
+	}
+	out := make(chan Out, opts.Buffer)
+	tasks := make(chan task)
+	slots := make(chan chan Out, opts.Workers+opts.Buffer)
+
+	p.Go(func(ctx context.Context) error {
+		defer close(tasks)
+		defer close(slots)
+		for item := range receive(ctx, in) {
+			t := task{item: item, slot: make(chan Out, 1)}
+			if !send(ctx, slots, t.slot) || !send(ctx, tasks, t) {
+				return nil
+			}
+		}
+		return nil
+	})
+	for i := 0; i < opts.Workers; i++ {
+		p.Go(func(ctx context.Context) error {
+			for t := range receive(ctx, tasks) {
+				value, err := fn(ctx, t.item)
+				if err != nil {
+					return err
+				}
+				t.slot <- value
+			}
+			return nil
+		})
+	}
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for slot := range receive(ctx, slots) {
+			select {
+			case value := <-slot:
+				if !send(ctx, out, value) {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// Filter keeps the items of in for which keep returns true
+func Filter[T any](p *Pipeline, in <-chan T, keep func(ctx context.Context, item T) (bool, error), opts StageOptions) <-chan T {
+	type kept struct {
+		item T
+		keep bool
+	}
+	checked := Map(p, in, func(ctx context.Context, item T) (kept, error) {
+		ok, err := keep(ctx, item)
+		return kept{item: item, keep: ok}, err
+	}, opts)
+	out := make(chan T, opts.Buffer)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for k := range receive(ctx, checked) {
+			if k.keep && !send(ctx, out, k.item) {
+				return nil
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// Merge fans several channels into one, in no particular order
+func Merge[T any](p *Pipeline, buffer int, ins ...<-chan T) <-chan T {
+	out := make(chan T, buffer)
+	var inputs sync.WaitGroup
+	for _, in := range ins {
+		inputs.Add(1)
+		p.Go(func(ctx context.Context) error {
+			defer inputs.Done()
+			for item := range receive(ctx, in) {
+				if !send(ctx, out, item) {
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	go func() {
+		inputs.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Sink calls fn for every item of in, then waits for the pipeline
+func Sink[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context, item T) error) error {
+	p.Go(func(ctx context.Context) error {
+		for item := range receive(ctx, in) {
+			if err := fn(ctx, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return p.Wait()
+}
+
+// Collect gathers the items of in, then waits for the pipeline. The items
+// gathered before an error are returned with it.
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var items []T
+	err := Sink(p, in, func(_ context.Context, item T) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// receive relays in until it is closed or ctx is done, so ranging over it
+// stops on cancellation
+func receive[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case item, ok := <-in:
+				if !ok || !send(ctx, out, item) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,157 @@
+// Package xworker runs jobs concurrently: worker pools, a Redis backed job
+// queue and streaming pipelines
+package xworker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// Handler processes a job, returning its result
+type Handler[T any, R any] func(ctx context.Context, job T) (R, error)
+
+// Result is the outcome of a job. Panics of the handler are reported as a
+// 500 xerrors error.
+type Result[T any, R any] struct {
+	Job   T
+	Value R
+	Err   error
+}
+
+// PoolOptions configures a Pool
+type PoolOptions struct {
+	// Workers is the number of jobs processed at once, 1 at least
+	Workers int
+	// ResultBuffer is the capacity of the results channel
+	ResultBuffer int
+}
+
+// Pool processes the jobs of a channel with a fixed number of workers
+type Pool[T any, R any] struct {
+	opts    PoolOptions
+	handler Handler[T, R]
+
+	mu      sync.Mutex
+	started bool
+	stop    chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewPool creates a pool running handler
+func NewPool[T any, R any](opts PoolOptions, handler Handler[T, R]) *Pool[T, R] {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	return &Pool[T, R]{opts: opts, handler: handler, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Run starts the workers, which process jobs until it is closed, ctx is done
+// or Shutdown is called. The results channel is closed once every worker
+// returned and must be consumed, else the workers block. A pool runs once.
+func (p *Pool[T, R]) Run(ctx context.Context, jobs <-chan T) <-chan Result[T, R] {
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		panic("xworker: pool already started")
+	}
+	p.started = true
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.mu.Unlock()
+
+	results := make(chan Result[T, R], p.opts.ResultBuffer)
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx, jobs, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		p.cancel()
+		close(results)
+		close(p.done)
+	}()
+	return results
+}
+
+// Process runs every job and returns their results, in no particular order
+func (p *Pool[T, R]) Process(ctx context.Context, jobs []T) []Result[T, R] {
+	queue := make(chan T, len(jobs))
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	collected := make([]Result[T, R], 0, len(jobs))
+	for result := range p.Run(ctx, queue) {
+		collected = append(collected, result)
+	}
+	return collected
+}
+
+// Shutdown stops taking jobs and waits for the running ones to finish. When
+// ctx is done first, the context of the running jobs is canceled and
+// Shutdown returns ctx.Err() without waiting further.
+func (p *Pool[T, R]) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	started := p.started
+	p.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
+
+func (p *Pool[T, R]) work(ctx context.Context, jobs <-chan T, results chan<- Result[T, R]) {
+	for {
+		// Stopping wins over pending jobs
+		select {
+		case <-p.stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobs:
+			if !ok {
+				return
+			}
+			results <- p.run(ctx, job)
+		}
+	}
+}
+
+// run calls the handler, recovering its panics
+func (p *Pool[T, R]) run(ctx context.Context, job T) (result Result[T, R]) {
+	result.Job = job
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.Err = xerrors.FromPanic(recovered)
+			xlogger.Error("xworker: job panicked", result.Err)
+		}
+	}()
+	result.Value, result.Err = p.handler(ctx, job)
+	return result
+}
@@ -0,0 +1,342 @@
+// Package xredis is a persistent job queue stored in Redis: delayed jobs,
+// retries with backoff, a dead letter list and a worker runner
+package xredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+)
+
+// Job is a unit of work of a queue
+type Job struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Attempts is the number of failed runs
+	Attempts   int       `json:"attempts"`
+	MaxRetries int       `json:"max_retries"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	LastError  string    `json:"last_error,omitempty"`
+
+	// raw is the stored form of the job, identifying it in Redis
+	raw string
+	// deadline is when the job is considered lost once dequeued
+	deadline time.Time
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return xerrors.WrapBadRequest(err, fmt.Sprintf("invalid payload for job %s", j.Name))
+	}
+	return nil
+}
+
+// QueueOptions configures a Queue
+type QueueOptions struct {
+	// Name of the queue, "default" by default
+	Name string
+	// Prefix of the Redis keys, "xworker" by default
+	Prefix string
+	// MaxRetries is the number of retries of a failed job before it is dead,
+	// 5 by default. A negative value disables retries.
+	MaxRetries int
+	// Backoff returns the delay before retrying a job failed attempt times,
+	// ExponentialBackoff by default
+	Backoff func(attempt int) time.Duration
+	// VisibilityTimeout is how long a dequeued job may run before it is
+	// considered lost, eg. its worker crashed, and is run again. 5m by default.
+	// Runner cancels the jobs running longer.
+	VisibilityTimeout time.Duration
+}
+
+// Queue stores jobs in Redis. The keys of a queue share a hash tag, so a
+// queue works with Redis Cluster.
+type Queue struct {
+	client redis.UniversalClient
+	opts   QueueOptions
+	keys   []string // ready, scheduled, inflight, dead
+}
+
+const (
+	keyReady = iota
+	keyScheduled
+	keyInflight
+	keyDead
+)
+
+// NewQueue creates a queue
+func NewQueue(client redis.UniversalClient, opts QueueOptions) *Queue {
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "xworker"
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 5 * time.Minute
+	}
+	base := opts.Prefix + ":{" + opts.Name + "}:"
+	return &Queue{
+		client: client,
+		opts:   opts,
+		keys:   []string{base + "ready", base + "scheduled", base + "inflight", base + "dead"},
+	}
+}
+
+// ExponentialBackoff waits 1s, 2s, 4s... up to an hour, with 20% of jitter
+func ExponentialBackoff(attempt int) time.Duration {
+	delay := time.Duration(math.Min(math.Pow(2, float64(attempt-1)), 3600)) * time.Second
+	jitter := time.Duration(mathrand.Int63n(int64(delay)/5 + 1))
+	return delay - delay/10 + jitter
+}
+
+// Name returns the name of the queue
+func (q *Queue) Name() string {
+	return q.opts.Name
+}
+
+// Enqueue adds a job to run as soon as possible. payload is marshaled to JSON.
+func (q *Queue) Enqueue(ctx context.Context, name string, payload interface{}) (*Job, error) {
+	return q.EnqueueAt(ctx, name, payload, time.Time{})
+}
+
+// EnqueueIn adds a job to run after delay
+func (q *Queue) EnqueueIn(ctx context.Context, name string, payload interface{}, delay time.Duration) (*Job, error) {
+	return q.EnqueueAt(ctx, name, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt adds a job to run at the given time, a zero time meaning now
+func (q *Queue) EnqueueAt(ctx context.Context, name string, payload interface{}, at time.Time) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, xerrors.WrapBadRequest(err, fmt.Sprintf("cannot marshal the payload of job %s", name))
+	}
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	job := &Job{ID: hex.EncodeToString(id), Name: name, Payload: data, MaxRetries: q.opts.MaxRetries, EnqueuedAt: time.Now().UTC()}
+	if err := job.encode(); err != nil {
+		return nil, err
+	}
+
+	if at.IsZero() || !at.After(time.Now()) {
+		err = q.client.LPush(ctx, q.keys[keyReady], job.raw).Err()
+	} else {
+		err = q.client.ZAdd(ctx, q.keys[keyScheduled], redis.Z{Score: score(at), Member: job.raw}).Err()
+	}
+	if err != nil {
+		return nil, q.redisErr(err)
+	}
+	return job, nil
+}
+
+// dequeueScript moves the due scheduled jobs and the lost ones to the ready
+// list, then moves the oldest ready job to the inflight set
+var dequeueScript = redis.NewScript(`
+for _, key in ipairs({KEYS[2], KEYS[3]}) do
+	local jobs = redis.call('ZRANGEBYSCORE', key, '-inf', ARGV[1], 'LIMIT', 0, 100)
+	for _, job in ipairs(jobs) do
+		redis.call('ZREM', key, job)
+		redis.call('LPUSH', KEYS[1], job)
+	end
+end
+local job = redis.call('RPOP', KEYS[1])
+if job then
+	redis.call('ZADD', KEYS[3], ARGV[2], job)
+end
+return job
+`)
+
+// Dequeue takes the next job, returning nil when there is none. The job must
+// then be passed to Ack or Fail before the visibility timeout elapses.
+func (q *Queue) Dequeue(ctx context.Context) (*Job, error) {
+	now := time.Now()
+	raw, err := dequeueScript.Run(ctx, q.client, q.keys[:keyDead], score(now), score(now.Add(q.opts.VisibilityTimeout))).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, q.redisErr(err)
+	}
+	job := &Job{raw: raw, deadline: now.Add(q.opts.VisibilityTimeout)}
+	if err := json.Unmarshal([]byte(raw), job); err != nil {
+		// Not a job, keep it aside rather than failing every dequeue
+		_, _ = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, q.keys[keyInflight], raw)
+			pipe.LPush(ctx, q.keys[keyDead], raw)
+			return nil
+		})
+		return nil, xerrors.Wrap(err, http.StatusInternalServerError, "cannot decode job of queue "+q.opts.Name)
+	}
+	return job, nil
+}
+
+// Ack removes a job that succeeded
+func (q *Queue) Ack(ctx context.Context, job *Job) error {
+	return q.redisErr(q.client.ZRem(ctx, q.keys[keyInflight], job.raw).Err())
+}
+
+// failScript removes a job from the inflight set then schedules it again
+// when ARGV[3] is set, else moves it to the dead list, unless it was
+// reclaimed already: its deadline ARGV[4] is then gone or another one
+var failScript = redis.NewScript(`
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) ~= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+if ARGV[3] ~= '' then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+else
+	redis.call('LPUSH', KEYS[3], ARGV[2])
+end
+return 1
+`)
+
+// Fail schedules a retry of a job that failed with cause, or moves it to the
+// dead letter list when it ran out of retries or cause is permanent, see
+// IsPermanent. It reports whether the job is dead. A job that outlived the
+// visibility timeout was reclaimed for another run and fails with a 409.
+func (q *Queue) Fail(ctx context.Context, job *Job, cause error) (bool, error) {
+	previous := job.raw
+	job.Attempts++
+	if cause != nil {
+		job.LastError = cause.Error()
+	}
+	if err := job.encode(); err != nil {
+		return false, err
+	}
+
+	dead := job.Attempts > job.MaxRetries || IsPermanent(cause)
+	var at string
+	if !dead {
+		at = strconv.FormatFloat(score(time.Now().Add(q.opts.Backoff(job.Attempts))), 'f', -1, 64)
+	}
+	removed, err := failScript.Run(ctx, q.client, []string{q.keys[keyInflight], q.keys[keyScheduled], q.keys[keyDead]}, previous, job.raw, at, score(job.deadline)).Int()
+	if err != nil {
+		return false, q.redisErr(err)
+	}
+	if removed == 0 {
+		return false, xerrors.NewConflictError(fmt.Sprintf("job %s was reclaimed after its visibility timeout", job.ID))
+	}
+	return dead, nil
+}
+
+// DeadJobs returns up to limit dead jobs, the most recent first
+func (q *Queue) DeadJobs(ctx context.Context, limit int) ([]*Job, error) {
+	raws, err := q.client.LRange(ctx, q.keys[keyDead], 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, q.redisErr(err)
+	}
+	jobs := make([]*Job, 0, len(raws))
+	for _, raw := range raws {
+		job := &Job{raw: raw}
+		if json.Unmarshal([]byte(raw), job) == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// RequeueDead moves a dead job back to the ready list with its attempts reset
+func (q *Queue) RequeueDead(ctx context.Context, job *Job) error {
+	previous := job.raw
+	job.Attempts = 0
+	if err := job.encode(); err != nil {
+		return err
+	}
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.keys[keyDead], 1, previous)
+		pipe.LPush(ctx, q.keys[keyReady], job.raw)
+		return nil
+	})
+	return q.redisErr(err)
+}
+
+// Stats returns the number of ready, scheduled, running and dead jobs
+func (q *Queue) Stats(ctx context.Context) (ready, scheduled, inflight, dead int64, err error) {
+	cmds, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LLen(ctx, q.keys[keyReady])
+		pipe.ZCard(ctx, q.keys[keyScheduled])
+		pipe.ZCard(ctx, q.keys[keyInflight])
+		pipe.LLen(ctx, q.keys[keyDead])
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, 0, q.redisErr(err)
+	}
+	counts := make([]int64, len(cmds))
+	for i, cmd := range cmds {
+		counts[i] = cmd.(*redis.IntCmd).Val()
+	}
+	return counts[0], counts[1], counts[2], counts[3], nil
+}
+
+func (j *Job) encode() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return xerrors.Wrap(err, http.StatusInternalServerError, "cannot encode job "+j.Name)
+	}
+	j.raw = string(data)
+	return nil
+}
+
+func (q *Queue) redisErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return xerrors.WrapServiceUnavailable(err, "job queue "+q.opts.Name+" is unavailable")
+}
+
+// score returns the sorted set score of t, in milliseconds
+func score(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// permanentError marks an error not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err so the job failing with it is dead at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether a job failing with err should not be retried:
+// errors marked with Permanent and client xerrors errors other than 429 are
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	var restErr xerrors.RestErr
+	return errors.As(err, &restErr) && xerrors.IsClientError(restErr) && restErr.StatusCode() != http.StatusTooManyRequests
+}
@@ -0,0 +1,129 @@
+package xredis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/XandaLtd/xutils-go/xerrors"
+	"github.com/XandaLtd/xutils-go/xlogger"
+)
+
+// JobHandler runs a job. Returning an error retries the job, see Queue.Fail.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// Runner dequeues the jobs of a queue and runs the handler of their name
+type Runner struct {
+	queue *Queue
+	// Concurrency is the number of jobs run at once, 1 by default
+	Concurrency int
+	// PollInterval is how long a worker sleeps when the queue is empty, 1s by default
+	PollInterval time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+}
+
+// NewRunner creates a runner of the jobs of queue
+func NewRunner(queue *Queue, concurrency int) *Runner {
+	return &Runner{queue: queue, Concurrency: concurrency, handlers: make(map[string]JobHandler)}
+}
+
+// Handle sets the handler of the jobs named name
+func (r *Runner) Handle(name string, handler JobHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = handler
+}
+
+// Run runs jobs until ctx is done, then waits for the running jobs. Jobs
+// without handler are dead at once. Failures and panics are logged with
+// xlogger.
+func (r *Runner) Run(ctx context.Context) error {
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	poll := r.PollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, poll)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (r *Runner) work(ctx context.Context, poll time.Duration) {
+	for ctx.Err() == nil {
+		job, err := r.queue.Dequeue(ctx)
+		if err != nil && ctx.Err() == nil {
+			xlogger.Error("xworker: cannot dequeue", err, zap.String("queue", r.queue.Name()))
+		}
+		if job == nil {
+			select {
+			case <-time.After(poll):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		// A job taken runs to completion, so its outcome is recorded
+		r.run(context.WithoutCancel(ctx), job)
+	}
+}
+
+func (r *Runner) run(ctx context.Context, job *Job) {
+	tags := []zap.Field{zap.String("queue", r.queue.Name()), zap.String("job", job.Name), zap.String("job_id", job.ID)}
+	r.mu.RLock()
+	handler, ok := r.handlers[job.Name]
+	r.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = Permanent(xerrors.NewNotImplementedError(fmt.Sprintf("no handler for job %s", job.Name)))
+	} else {
+		// The job is cancelled once it outlives the visibility timeout, since
+		// it is then run again
+		jobCtx, cancel := context.WithDeadline(ctx, job.deadline)
+		err = call(jobCtx, handler, job)
+		cancel()
+	}
+
+	if err == nil {
+		if ackErr := r.queue.Ack(ctx, job); ackErr != nil {
+			xlogger.Error("xworker: cannot acknowledge job", ackErr, tags...)
+		}
+		return
+	}
+	dead, failErr := r.queue.Fail(ctx, job, err)
+	if failErr != nil {
+		xlogger.Error("xworker: cannot record job failure", failErr, tags...)
+		return
+	}
+	tags = append(tags, zap.Int("attempts", job.Attempts))
+	if dead {
+		xlogger.Error("xworker: job is dead", err, tags...)
+	} else {
+		xlogger.Warning("xworker: job failed, retrying: "+err.Error(), tags...)
+	}
+}
+
+// call runs handler, turning its panics into errors
+func call(ctx context.Context, handler JobHandler, job *Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = xerrors.FromPanic(recovered)
+		}
+	}()
+	return handler(ctx, job)
+}